@@ -19,7 +19,7 @@ package cmd
 import (
 	"fmt"
 	//"strconv"
-	//"strings"
+	"strings"
 
 	"github.com/lieb/postdove/maildb"
 	"github.com/spf13/cobra"
@@ -61,7 +61,7 @@ select a set of recipient restrictions.`,
 var deleteAccess = &cobra.Command{
 	Use:   "access name",
 	Short: "Delete the named recipient access rule from the database.",
-	Long:  "Delete the named rule from the database so long as no address or domain references it.",
+	Long:  "Delete the named rule from the database so long as no address, domain or access check references it.",
 	Args:  cobra.ExactArgs(1),
 	RunE:  accessDelete,
 }
@@ -185,3 +185,59 @@ func accessShow(cmd *cobra.Command, args []string) error {
 	cmd.Printf("Name:\t%s\nAction:\t%s\n", ac.Name(), ac.Action())
 	return nil
 }
+
+// accessSet
+// finds or makes the access rule for an action in an access(5) table
+// like "REJECT no spam here" for the imports of other systems' tables.
+// The patterns with the same action share a rule named for its first
+// word, reject, reject-2 and so on.
+type accessSet struct {
+	names   map[string]bool   // rule names in use
+	actions map[string]string // action -> rule name
+}
+
+// newAccessSet
+// start with the rules already in the database. This is
+// done before the import's transaction.
+func newAccessSet() (*accessSet, error) {
+	as := &accessSet{
+		names:   make(map[string]bool),
+		actions: make(map[string]string),
+	}
+	al, err := mdb.FindAccess("*")
+	if err != nil {
+		if err == maildb.ErrMdbAccessNotFound {
+			err = nil
+		}
+		return as, err
+	}
+	for _, ac := range al {
+		as.names[ac.Name()] = true
+		as.actions[ac.Action()] = ac.Name()
+	}
+	return as, nil
+}
+
+// get
+// the name of the rule for action, inserting it if it is new
+func (as *accessSet) get(action string) (string, error) {
+	if name, ok := as.actions[action]; ok {
+		return name, nil
+	}
+	base := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(strings.Fields(action)[0]))
+	if base == "" {
+		base = "rule"
+	}
+	name := uniqueName(as.names, base)
+	if _, err := mdb.InsertAccess(name, action); err != nil {
+		return "", err
+	}
+	as.names[name] = true
+	as.actions[action] = name
+	return name, nil
+}
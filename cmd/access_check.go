@@ -0,0 +1,384 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkAccess string
+	checkCidr   bool
+)
+
+// importClientAccess do import of a client access file
+var importClientAccess = &cobra.Command{
+	Use:   "client-access",
+	Short: "Import client IP addresses, networks and host names in the postfix access(5) format",
+	Long: `Import client IP addresses, networks and host names in the postfix access(5) format
+from the file named by the -i flag (default stdin '-').
+Each line is a pattern followed by the name of an access rule.
+This is the data for the postfix check_client_access restriction`,
+	Args: cobra.NoArgs,
+	RunE: checkImport,
+}
+
+// exportClientAccess do export of client access checks
+var exportClientAccess = &cobra.Command{
+	Use:   "client-access [pattern]",
+	Short: "Export client IP addresses, networks and host names in postfix access(5) format",
+	Long: `Export client IP addresses, networks and host names in postfix access(5) format to
+the file named by the -o flag (default stdout '-'). The optional pattern
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: checkExport,
+}
+
+// addClientAccess add a client access check
+var addClientAccess = &cobra.Command{
+	Use:   "client-access pattern access",
+	Short: "Apply an access rule to client IP addresses, networks and host names",
+	Long: `Apply the named access rule to pattern in the check_client_access restriction.
+The pattern is an IP address, a CIDR network like 192.168.0.0/16, a short IPv4 prefix like 10.1,
+or a host or domain name.`,
+	Args: cobra.ExactArgs(2),
+	RunE: checkAdd,
+}
+
+// deleteClientAccess remove a client access check
+var deleteClientAccess = &cobra.Command{
+	Use:   "client-access pattern",
+	Short: "Delete a client access check",
+	Long:  `Delete the client access check for pattern from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkDelete,
+}
+
+// editClientAccess change a client access check
+var editClientAccess = &cobra.Command{
+	Use:   "client-access pattern",
+	Short: "Change the access rule of a client access check",
+	Long:  `Change the access rule applied to the client pattern.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkEdit,
+}
+
+// showClientAccess display a client access check
+var showClientAccess = &cobra.Command{
+	Use:   "client-access pattern",
+	Short: "Display a client access check",
+	Long:  `Display the client access check for pattern to the standard output`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkShow,
+}
+
+// importSenderAccess do import of a sender access file
+var importSenderAccess = &cobra.Command{
+	Use:   "sender-access",
+	Short: "Import sender addresses and domains in the postfix access(5) format",
+	Long: `Import sender addresses and domains in the postfix access(5) format
+from the file named by the -i flag (default stdin '-').
+Each line is a pattern followed by the name of an access rule.
+This is the data for the postfix check_sender_access restriction`,
+	Args: cobra.NoArgs,
+	RunE: checkImport,
+}
+
+// exportSenderAccess do export of sender access checks
+var exportSenderAccess = &cobra.Command{
+	Use:   "sender-access [pattern]",
+	Short: "Export sender addresses and domains in postfix access(5) format",
+	Long: `Export sender addresses and domains in postfix access(5) format to
+the file named by the -o flag (default stdout '-'). The optional pattern
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: checkExport,
+}
+
+// addSenderAccess add a sender access check
+var addSenderAccess = &cobra.Command{
+	Use:   "sender-access pattern access",
+	Short: "Apply an access rule to sender addresses and domains",
+	Long: `Apply the named access rule to pattern in the check_sender_access restriction.
+The pattern is an address, 'user@' for any domain, or a domain name.`,
+	Args: cobra.ExactArgs(2),
+	RunE: checkAdd,
+}
+
+// deleteSenderAccess remove a sender access check
+var deleteSenderAccess = &cobra.Command{
+	Use:   "sender-access pattern",
+	Short: "Delete a sender access check",
+	Long:  `Delete the sender access check for pattern from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkDelete,
+}
+
+// editSenderAccess change a sender access check
+var editSenderAccess = &cobra.Command{
+	Use:   "sender-access pattern",
+	Short: "Change the access rule of a sender access check",
+	Long:  `Change the access rule applied to the sender pattern.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkEdit,
+}
+
+// showSenderAccess display a sender access check
+var showSenderAccess = &cobra.Command{
+	Use:   "sender-access pattern",
+	Short: "Display a sender access check",
+	Long:  `Display the sender access check for pattern to the standard output`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkShow,
+}
+
+// importHeloAccess do import of a helo access file
+var importHeloAccess = &cobra.Command{
+	Use:   "helo-access",
+	Short: "Import HELO/EHLO host names in the postfix access(5) format",
+	Long: `Import HELO/EHLO host names in the postfix access(5) format
+from the file named by the -i flag (default stdin '-').
+Each line is a pattern followed by the name of an access rule.
+This is the data for the postfix check_helo_access restriction`,
+	Args: cobra.NoArgs,
+	RunE: checkImport,
+}
+
+// exportHeloAccess do export of helo access checks
+var exportHeloAccess = &cobra.Command{
+	Use:   "helo-access [pattern]",
+	Short: "Export HELO/EHLO host names in postfix access(5) format",
+	Long: `Export HELO/EHLO host names in postfix access(5) format to
+the file named by the -o flag (default stdout '-'). The optional pattern
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: checkExport,
+}
+
+// addHeloAccess add a helo access check
+var addHeloAccess = &cobra.Command{
+	Use:   "helo-access pattern access",
+	Short: "Apply an access rule to HELO/EHLO host names",
+	Long: `Apply the named access rule to pattern in the check_helo_access restriction.
+The pattern is a host or domain name or an IP address.`,
+	Args: cobra.ExactArgs(2),
+	RunE: checkAdd,
+}
+
+// deleteHeloAccess remove a helo access check
+var deleteHeloAccess = &cobra.Command{
+	Use:   "helo-access pattern",
+	Short: "Delete a helo access check",
+	Long:  `Delete the helo access check for pattern from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkDelete,
+}
+
+// editHeloAccess change a helo access check
+var editHeloAccess = &cobra.Command{
+	Use:   "helo-access pattern",
+	Short: "Change the access rule of a helo access check",
+	Long:  `Change the access rule applied to the helo pattern.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkEdit,
+}
+
+// showHeloAccess display a helo access check
+var showHeloAccess = &cobra.Command{
+	Use:   "helo-access pattern",
+	Short: "Display a helo access check",
+	Long:  `Display the helo access check for pattern to the standard output`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  checkShow,
+}
+
+// linkage to top level commands
+func init() {
+	importCmd.AddCommand(importClientAccess)
+	exportCmd.AddCommand(exportClientAccess)
+	addCmd.AddCommand(addClientAccess)
+	deleteCmd.AddCommand(deleteClientAccess)
+	editCmd.AddCommand(editClientAccess)
+	editClientAccess.Flags().StringVarP(&checkAccess, "access", "a", "",
+		"Access rule to apply to this pattern")
+	showCmd.AddCommand(showClientAccess)
+	importCmd.AddCommand(importSenderAccess)
+	exportCmd.AddCommand(exportSenderAccess)
+	addCmd.AddCommand(addSenderAccess)
+	deleteCmd.AddCommand(deleteSenderAccess)
+	editCmd.AddCommand(editSenderAccess)
+	editSenderAccess.Flags().StringVarP(&checkAccess, "access", "a", "",
+		"Access rule to apply to this pattern")
+	showCmd.AddCommand(showSenderAccess)
+	importCmd.AddCommand(importHeloAccess)
+	exportCmd.AddCommand(exportHeloAccess)
+	addCmd.AddCommand(addHeloAccess)
+	deleteCmd.AddCommand(deleteHeloAccess)
+	editCmd.AddCommand(editHeloAccess)
+	editHeloAccess.Flags().StringVarP(&checkAccess, "access", "a", "",
+		"Access rule to apply to this pattern")
+	showCmd.AddCommand(showHeloAccess)
+	exportClientAccess.Flags().BoolVarP(&checkCidr, "cidr", "c", false,
+		"Only export IP addresses and networks for a postfix cidr table")
+}
+
+// checkClass
+// the subcommands are named for their class, "client-access" etc.
+func checkClass(cmd *cobra.Command) string {
+	return strings.TrimSuffix(cmd.Name(), "-access")
+}
+
+// checkImport the access checks in access(5) format
+func checkImport(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	switch checkClass(cmd) {
+	case "client":
+		err = procImport(cmd, SIMPLE, procClientAccess)
+	case "sender":
+		err = procImport(cmd, SIMPLE, procSenderAccess)
+	default:
+		err = procImport(cmd, SIMPLE, procHeloAccess)
+	}
+	if err == nil {
+		for _, c := range cidrOnly {
+			cidrWarn(cmd, c)
+		}
+	}
+	cidrOnly = nil
+	return err
+}
+
+// client networks seen by procCheck that a sqlite lookup cannot match
+var cidrOnly []*maildb.AccessCheck
+
+// cidrWarn
+// postfix only tries the whole address and its leading octets in a
+// sqlite table. Anything else needs the cidr table from export --cidr.
+func cidrWarn(cmd *cobra.Command, c *maildb.AccessCheck) {
+	cmd.PrintErrf("Warning: %s can only be matched by a cidr table\n", c.Pattern())
+}
+
+// procClientAccess
+func procClientAccess(tokens []string) error {
+	return procCheck("client", tokens)
+}
+
+// procSenderAccess
+func procSenderAccess(tokens []string) error {
+	return procCheck("sender", tokens)
+}
+
+// procHeloAccess
+func procHeloAccess(tokens []string) error {
+	return procCheck("helo", tokens)
+}
+
+// procCheck
+func procCheck(class string, tokens []string) error {
+	if len(tokens) != 2 {
+		return fmt.Errorf("An access check must be 'pattern access'")
+	}
+	c, err := mdb.InsertAccessCheck(class, tokens[0], tokens[1])
+	if err == nil && c.Lookup() == "--" {
+		cidrOnly = append(cidrOnly, c)
+	}
+	return err
+}
+
+// checkExport the access checks in access(5) format
+func checkExport(cmd *cobra.Command, args []string) error {
+	var (
+		err     error
+		pattern string = "*"
+		clist   []*maildb.AccessCheck
+	)
+
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	if clist, err = mdb.FindAccessCheck(checkClass(cmd), pattern); err == nil {
+		for _, c := range clist {
+			if checkCidr && !c.IsAddress() {
+				continue
+			}
+			cmd.Printf("%s\n", c.Export())
+		}
+	}
+	return err
+}
+
+// checkAdd the access check
+func checkAdd(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	cidrOnly = nil
+	if err = procCheck(checkClass(cmd), args); err == nil && len(cidrOnly) > 0 {
+		cidrWarn(cmd, cidrOnly[0])
+	}
+	cidrOnly = nil
+	return err
+}
+
+// checkDelete the access check for the pattern in the first arg
+func checkDelete(cmd *cobra.Command, args []string) error {
+	return mdb.DeleteAccessCheck(checkClass(cmd), args[0])
+}
+
+// checkEdit the access check for the pattern in the first arg
+func checkEdit(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		c   *maildb.AccessCheck
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if c, err = mdb.GetAccessCheck(checkClass(cmd), args[0]); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("access") {
+		err = c.SetAccess(checkAccess)
+	}
+	return err
+}
+
+// checkShow the access check for the pattern in the first arg
+func checkShow(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		c   *maildb.AccessCheck
+	)
+
+	if c, err = mdb.LookupAccessCheck(checkClass(cmd), args[0]); err == nil {
+		cmd.Printf("Pattern:\t%s\nClass:\t\t%s\nLookup Key:\t%s\n",
+			c.Pattern(), c.Class(), c.Lookup())
+		cmd.Printf("Access:\t\t%s\nAction:\t\t%s\n", c.Access(), c.Action())
+	}
+	return err
+}
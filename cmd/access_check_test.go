@@ -0,0 +1,184 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestCheckAccessCmd
+func TestCheckAccessCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		checkFile   string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestCheckAccessCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestCheckAccessCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = filepath.Join(dir, "test.db")
+
+	args = []string{"create", "-d", dbfile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "import", "access", "-i", "./test_access.txt"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import of access: Unexpected error, %s", err)
+	}
+
+	checkFile = filepath.Join(dir, "client_access")
+	err = ioutil.WriteFile(checkFile, []byte(`# client blocks
+10		reject
+192.168.4.0/22	reject
+192.168.5.7	permit
+spam.example.com	DUMP
+`), 0644)
+	if err != nil {
+		t.Errorf("Write of %s: Unexpected error, %s", checkFile, err)
+		return
+	}
+	args = []string{"-d", dbfile, "import", "client-access", "-i", checkFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import client-access: Unexpected error, %s", err)
+	}
+	if errout != "Warning: 192.168.4.0/22 can only be matched by a cidr table\n" {
+		t.Errorf("Import client-access: did not get expected warning, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "add", "client-access", "192.168.1.300", "reject"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add client-access 192.168.1.300: should have failed")
+	} else if err != maildb.ErrMdbBadCIDR {
+		t.Errorf("Add client-access 192.168.1.300: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "sender-access", "example.org", "permit"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add sender-access example.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "helo-access", "localhost", "reject"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add helo-access localhost: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "edit", "helo-access", "localhost", "-a", "STALL"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit helo-access localhost: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "helo-access", "localhost"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show helo-access localhost: Unexpected error, %s", err)
+	}
+	if out != "Pattern:\tlocalhost\nClass:\t\thelo\nLookup Key:\tlocalhost\nAccess:\t\tSTALL\nAction:\t\tx-stall\n" {
+		t.Errorf("Show helo-access localhost: did not get expected output, got %s", out)
+	}
+
+	args = []string{"-d", dbfile, "export", "client-access"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export client-access: Unexpected error, %s", err)
+	}
+	if out != "10.0.0.0/8 reject\n192.168.4.0/22 reject\n192.168.5.7 permit\nspam.example.com DUMP\n" {
+		t.Errorf("Export client-access: did not get expected output, got %s", out)
+	}
+	args = []string{"-d", dbfile, "export", "client-access", "--cidr"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export client-access --cidr: Unexpected error, %s", err)
+	}
+	if out != "10.0.0.0/8 reject\n192.168.4.0/22 reject\n192.168.5.7 permit\n" {
+		t.Errorf("Export client-access --cidr: did not get expected output, got %s", out)
+	}
+
+	// Open the database directly so we can test views.
+	// These are the lookups postfix makes with the access queries
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT client, access_key FROM client_access ORDER BY client
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"client":     "10",
+			"access_key": "x-reject",
+		},
+		{
+			"client":     "192.168.5.7",
+			"access_key": "x-permit",
+		},
+		{
+			"client":     "spam.example.com",
+			"access_key": "x-dump",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup client_access: %s", err)
+	}
+	q = `
+SELECT access_key FROM sender_access WHERE sender = 'example.org'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"access_key": "x-permit",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup sender example.org: %s", err)
+	}
+	mdb.Close()
+
+	// an access rule in use cannot go
+	args = []string{"-d", dbfile, "delete", "access", "STALL"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Delete access STALL: should have failed")
+	} else if err != maildb.ErrMdbAccessBusy {
+		t.Errorf("Delete access STALL: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "helo-access", "localhost"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete helo-access localhost: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "access", "STALL"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete access STALL: Unexpected error, %s", err)
+	}
+}
@@ -134,10 +134,9 @@ func TestAccess(t *testing.T) {
 		t.Errorf("Add spam: did not expect error output, got %s", errout)
 	}
 
-	// edit spam but forget the action option
-	// doctor accessAction to reset state. Use empty string to force an empty string error
-	accessAction = ""
-	args = []string{"-d", dbfile, "edit", "access", "spam"}
+	// edit spam with an empty action option
+	// Use empty string to force an empty string error
+	args = []string{"-d", dbfile, "edit", "access", "spam", "-r", ""}
 	out, errout, err = doTest(rootCmd, "", args)
 	if err == nil {
 		t.Errorf("Edit spam without action option should have failed")
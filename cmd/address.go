@@ -31,6 +31,8 @@ var (
 	aNoRclass    bool
 	aTransport   string
 	aNoTransport bool
+	aSTransport  string
+	aNoSTrans    bool
 )
 
 // importAddress do import of a addresss file
@@ -98,6 +100,8 @@ func init() {
 		"Restriction class for this address")
 	addAddress.Flags().StringVarP(&aTransport, "transport", "t", "",
 		"Transport to be used for this address")
+	addAddress.Flags().StringVarP(&aSTransport, "sender-transport", "s", "",
+		"Transport to relay mail sent from this address")
 	deleteCmd.AddCommand(deleteAddress)
 	editCmd.AddCommand(editAddress)
 	editAddress.Flags().StringVarP(&arClass, "rclass", "r", "",
@@ -108,6 +112,10 @@ func init() {
 		"Transport to be used for this address")
 	editAddress.Flags().BoolVarP(&aNoTransport, "no-transport", "T", false,
 		"Clear transport used by this address")
+	editAddress.Flags().StringVarP(&aSTransport, "sender-transport", "s", "",
+		"Transport to relay mail sent from this address")
+	editAddress.Flags().BoolVarP(&aNoSTrans, "no-sender-transport", "S", false,
+		"Clear the sender transport for this address")
 	showCmd.AddCommand(showAddress)
 }
 
@@ -144,6 +152,8 @@ func procAddress(tokens []string) error {
 				err = a.SetRclass(kv[1])
 			case "transport":
 				err = a.SetTransport(kv[1])
+			case "sender_transport":
+				err = a.SetSenderTransport(kv[1])
 			default:
 				return fmt.Errorf("Unknown address field %s", kv[0])
 			}
@@ -193,6 +203,9 @@ func addressAdd(cmd *cobra.Command, args []string) error {
 	if err == nil && cmd.Flags().Changed("transport") {
 		err = a.SetTransport(aTransport)
 	}
+	if err == nil && cmd.Flags().Changed("sender-transport") {
+		err = a.SetSenderTransport(aSTransport)
+	}
 	return err
 }
 
@@ -226,6 +239,13 @@ func addressEdit(cmd *cobra.Command, args []string) error {
 			err = a.SetTransport(aTransport)
 		}
 	}
+	if err == nil {
+		if cmd.Flags().Changed("no-sender-transport") {
+			err = a.ClearSenderTransport()
+		} else if cmd.Flags().Changed("sender-transport") {
+			err = a.SetSenderTransport(aSTransport)
+		}
+	}
 	return err
 }
 
@@ -241,5 +261,8 @@ func addressShow(cmd *cobra.Command, args []string) error {
 	}
 	cmd.Printf("Address:\t%s\nTransport:\t%s\nRestrictions:\t%s\n",
 		a.Address(), a.Transport(), a.Rclass())
+	if a.SenderTransport() != "--" {
+		cmd.Printf("Sender Relay:\t%s\n", a.SenderTransport())
+	}
 	return nil
 }
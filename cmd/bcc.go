@@ -0,0 +1,323 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bccTarget string
+)
+
+// importSenderBcc do import of a sender_bcc file
+var importSenderBcc = &cobra.Command{
+	Use:   "sender-bcc",
+	Short: "Import sender blind copy addresses in postfix map format",
+	Long: `Import sender blind copy addresses in postfix map format
+from the file named by the -i flag (default stdin '-').
+Each line is an address, 'user', 'user@domain', or '@domain', followed by the copy address.
+This is the postfix file associated with $sender_bcc_maps`,
+	Args: cobra.NoArgs,
+	RunE: bccImport,
+}
+
+// importRecipientBcc do import of a recipient_bcc file
+var importRecipientBcc = &cobra.Command{
+	Use:   "recipient-bcc",
+	Short: "Import recipient blind copy addresses in postfix map format",
+	Long: `Import recipient blind copy addresses in postfix map format
+from the file named by the -i flag (default stdin '-').
+Each line is an address, 'user', 'user@domain', or '@domain', followed by the copy address.
+This is the postfix file associated with $recipient_bcc_maps`,
+	Args: cobra.NoArgs,
+	RunE: bccImport,
+}
+
+// exportSenderBcc do export of sender blind copies
+var exportSenderBcc = &cobra.Command{
+	Use:   "sender-bcc [address]",
+	Short: "Export sender blind copy addresses in postfix map format",
+	Long: `Export sender blind copy addresses in postfix map format to
+the file named by the -o flag (default stdout '-'). The optional address
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: bccExport,
+}
+
+// exportRecipientBcc do export of recipient blind copies
+var exportRecipientBcc = &cobra.Command{
+	Use:   "recipient-bcc [address]",
+	Short: "Export recipient blind copy addresses in postfix map format",
+	Long: `Export recipient blind copy addresses in postfix map format to
+the file named by the -o flag (default stdout '-'). The optional address
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: bccExport,
+}
+
+// addSenderBcc add a sender blind copy
+var addSenderBcc = &cobra.Command{
+	Use:   "sender-bcc address target",
+	Short: "Send a blind copy of mail from address to target",
+	Long: `Send a blind copy of all mail from address to target. The address is 'user',
+'user@domain' or '@domain' for every address in the domain. The target is a single
+RFC2822 address, typically an archive mailbox.`,
+	Args: cobra.ExactArgs(2),
+	RunE: bccAdd,
+}
+
+// addRecipientBcc add a recipient blind copy
+var addRecipientBcc = &cobra.Command{
+	Use:   "recipient-bcc address target",
+	Short: "Send a blind copy of mail to address to target",
+	Long: `Send a blind copy of all mail to address to target. The address is 'user',
+'user@domain' or '@domain' for every address in the domain. The target is a single
+RFC2822 address, typically an archive mailbox.`,
+	Args: cobra.ExactArgs(2),
+	RunE: bccAdd,
+}
+
+// deleteSenderBcc remove a sender blind copy
+var deleteSenderBcc = &cobra.Command{
+	Use:   "sender-bcc address",
+	Short: "Delete a sender blind copy",
+	Long:  `Delete the sender blind copy for address from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  bccDelete,
+}
+
+// deleteRecipientBcc remove a recipient blind copy
+var deleteRecipientBcc = &cobra.Command{
+	Use:   "recipient-bcc address",
+	Short: "Delete a recipient blind copy",
+	Long:  `Delete the recipient blind copy for address from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  bccDelete,
+}
+
+// editSenderBcc change a sender blind copy
+var editSenderBcc = &cobra.Command{
+	Use:   "sender-bcc address",
+	Short: "Change the target of a sender blind copy",
+	Long:  `Change where the sender blind copy for address is sent.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  bccEdit,
+}
+
+// editRecipientBcc change a recipient blind copy
+var editRecipientBcc = &cobra.Command{
+	Use:   "recipient-bcc address",
+	Short: "Change the target of a recipient blind copy",
+	Long:  `Change where the recipient blind copy for address is sent.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  bccEdit,
+}
+
+// showSenderBcc display a sender blind copy
+var showSenderBcc = &cobra.Command{
+	Use:   "sender-bcc address",
+	Short: "Display a sender blind copy",
+	Long:  `Display the sender blind copy for address to the standard output`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  bccShow,
+}
+
+// showRecipientBcc display a recipient blind copy
+var showRecipientBcc = &cobra.Command{
+	Use:   "recipient-bcc address",
+	Short: "Display a recipient blind copy",
+	Long:  `Display the recipient blind copy for address to the standard output`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  bccShow,
+}
+
+// showArchived display the addresses that are copied
+var showArchived = &cobra.Command{
+	Use:   "archived [address]",
+	Short: "Display the mailboxes and virtual aliases that are archived",
+	Long: `Display the mailboxes and virtual aliases that have a sender or recipient
+blind copy, either their own or their domain's, and where the copies go.
+The optional address can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: archivedShow,
+}
+
+// linkage to top level commands
+func init() {
+	importCmd.AddCommand(importSenderBcc)
+	importCmd.AddCommand(importRecipientBcc)
+	exportCmd.AddCommand(exportSenderBcc)
+	exportCmd.AddCommand(exportRecipientBcc)
+	addCmd.AddCommand(addSenderBcc)
+	addCmd.AddCommand(addRecipientBcc)
+	deleteCmd.AddCommand(deleteSenderBcc)
+	deleteCmd.AddCommand(deleteRecipientBcc)
+	editCmd.AddCommand(editSenderBcc)
+	editSenderBcc.Flags().StringVarP(&bccTarget, "target", "t", "",
+		"New blind copy address")
+	editCmd.AddCommand(editRecipientBcc)
+	editRecipientBcc.Flags().StringVarP(&bccTarget, "target", "t", "",
+		"New blind copy address")
+	showCmd.AddCommand(showSenderBcc)
+	showCmd.AddCommand(showRecipientBcc)
+	showCmd.AddCommand(showArchived)
+}
+
+// bccClass
+// the subcommands are named for their class, "sender-bcc" etc.
+func bccClass(cmd *cobra.Command) string {
+	return strings.TrimSuffix(cmd.Name(), "-bcc")
+}
+
+// bccImport the blind copies in postfix map format
+func bccImport(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if bccClass(cmd) == "sender" {
+		err = procImport(cmd, POSTFIX, procSenderBcc)
+	} else {
+		err = procImport(cmd, POSTFIX, procRecipientBcc)
+	}
+	return err
+}
+
+// procSenderBcc
+func procSenderBcc(tokens []string) error {
+	return procBcc("sender", tokens)
+}
+
+// procRecipientBcc
+func procRecipientBcc(tokens []string) error {
+	return procBcc("recipient", tokens)
+}
+
+// procBcc
+func procBcc(class string, tokens []string) error {
+	if len(tokens) != 2 {
+		return fmt.Errorf("A bcc entry must be 'address target'")
+	}
+	_, err := mdb.InsertBcc(class, tokens[0], tokens[1])
+	return err
+}
+
+// bccExport the blind copies in postfix map format
+func bccExport(cmd *cobra.Command, args []string) error {
+	var (
+		err     error
+		pattern string = "*"
+		blist   []*maildb.Bcc
+	)
+
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	if blist, err = mdb.FindBcc(bccClass(cmd), pattern); err == nil {
+		for _, b := range blist {
+			cmd.Printf("%s\n", b.Export())
+		}
+	}
+	return err
+}
+
+// bccAdd the blind copy
+func bccAdd(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	err = procBcc(bccClass(cmd), args)
+	return err
+}
+
+// bccDelete the blind copy for the address in the first arg
+func bccDelete(cmd *cobra.Command, args []string) error {
+	return mdb.DeleteBcc(bccClass(cmd), args[0])
+}
+
+// bccEdit the blind copy for the address in the first arg
+func bccEdit(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		b   *maildb.Bcc
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if b, err = mdb.GetBcc(bccClass(cmd), args[0]); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("target") {
+		err = b.SetTarget(bccTarget)
+	}
+	return err
+}
+
+// bccShow the blind copy for the address in the first arg
+func bccShow(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		b   *maildb.Bcc
+	)
+
+	if b, err = mdb.LookupBcc(bccClass(cmd), args[0]); err == nil {
+		cmd.Printf("Address:\t%s\nClass:\t\t%s\nTarget:\t\t%s\n",
+			b.Address(), b.Class(), b.Target())
+	}
+	return err
+}
+
+// archivedShow the addresses that are copied and where to
+func archivedShow(cmd *cobra.Command, args []string) error {
+	var (
+		err     error
+		pattern string = "*"
+		blist   []*maildb.Bcc
+		last    string
+	)
+
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	if blist, err = mdb.FindArchived(pattern); err != nil {
+		return err
+	}
+	for _, b := range blist {
+		if b.Address() != last {
+			if last != "" {
+				cmd.Printf("=====================\n")
+			}
+			cmd.Printf("Address:\t%s\n", b.Address())
+			last = b.Address()
+		}
+		if b.Class() == "sender" {
+			cmd.Printf("Sender BCC:\t%s\n", b.Target())
+		} else {
+			cmd.Printf("Recipient BCC:\t%s\n", b.Target())
+		}
+	}
+	return nil
+}
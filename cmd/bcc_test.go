@@ -0,0 +1,154 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestBccCmd
+func TestBccCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		bccFile     string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestBccCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestBccCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	bccFile = filepath.Join(dir, "recipient_bcc")
+	err = ioutil.WriteFile(bccFile, []byte(`# compliance archive
+@pobox.org	archive@dish.net
+`), 0644)
+	if err != nil {
+		t.Errorf("Write of %s: Unexpected error, %s", bccFile, err)
+		return
+	}
+	args = []string{"-d", dbfile, "import", "recipient-bcc", "-i", bccFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import recipient-bcc: Unexpected error, %s", err)
+	}
+	if errout != "" {
+		t.Errorf("Import recipient-bcc: did not expect error output, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "add", "sender-bcc", "dave@pobox.org", "sent@dish.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add sender-bcc dave@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "sender-bcc", "@run.com", "@dish.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add sender-bcc @run.com: should have failed")
+	} else if err != maildb.ErrMdbBccTarget {
+		t.Errorf("Add sender-bcc @run.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "edit", "sender-bcc", "dave@pobox.org", "-t", "outbound@dish.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit sender-bcc dave@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "sender-bcc", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show sender-bcc dave@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "Address:\tdave@pobox.org\nClass:\t\tsender\nTarget:\t\toutbound@dish.net\n" {
+		t.Errorf("Show sender-bcc dave@pobox.org: did not get expected output, got %s", out)
+	}
+	args = []string{"-d", dbfile, "export", "recipient-bcc"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export recipient-bcc: Unexpected error, %s", err)
+	}
+	if out != "@pobox.org archive@dish.net\n" {
+		t.Errorf("Export recipient-bcc: did not get expected output, got %s", out)
+	}
+
+	// every pobox.org mailbox is archived
+	args = []string{"-d", dbfile, "show", "archived", "*@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show archived: Unexpected error, %s", err)
+	}
+	if out != "Address:\tdave@pobox.org\nSender BCC:\toutbound@dish.net\nRecipient BCC:\tarchive@dish.net\n"+
+		"=====================\n"+
+		"Address:\tjeff@pobox.org\nRecipient BCC:\tarchive@dish.net\n" {
+		t.Errorf("Show archived: did not get expected output, got %s", out)
+	}
+
+	// Open the database directly so we can test views.
+	// These are the lookups postfix makes with the bcc queries
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT target FROM recipient_bcc WHERE address = '@pobox.org'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"target": "archive@dish.net",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup recipient @pobox.org: %s", err)
+	}
+	q = `
+SELECT target FROM sender_bcc WHERE address = 'dave@pobox.org'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"target": "outbound@dish.net",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup sender dave@pobox.org: %s", err)
+	}
+	mdb.Close()
+
+	args = []string{"-d", dbfile, "delete", "recipient-bcc", "@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete recipient-bcc @pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "archived"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show archived after delete: Unexpected error, %s", err)
+	}
+	if out != "Address:\tdave@pobox.org\nSender BCC:\toutbound@dish.net\n" {
+		t.Errorf("Show archived after delete: did not get expected output, got %s", out)
+	}
+}
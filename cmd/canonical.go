@@ -0,0 +1,279 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	canonTarget string
+)
+
+// importSenderCanonical do import of a sender_canonical file
+var importSenderCanonical = &cobra.Command{
+	Use:   "sender-canonical",
+	Short: "Import sender address rewrites in the postfix canonical(5) format",
+	Long: `Import sender address rewrites in the postfix canonical(5) format
+from the file named by the -i flag (default stdin '-').
+Each line is an address, 'user', 'user@domain', or '@domain', followed by its rewrite.
+This is the postfix file associated with $sender_canonical_maps`,
+	Args: cobra.NoArgs,
+	RunE: canonicalImport,
+}
+
+// importRecipientCanonical do import of a recipient_canonical file
+var importRecipientCanonical = &cobra.Command{
+	Use:   "recipient-canonical",
+	Short: "Import recipient address rewrites in the postfix canonical(5) format",
+	Long: `Import recipient address rewrites in the postfix canonical(5) format
+from the file named by the -i flag (default stdin '-').
+Each line is an address, 'user', 'user@domain', or '@domain', followed by its rewrite.
+This is the postfix file associated with $recipient_canonical_maps`,
+	Args: cobra.NoArgs,
+	RunE: canonicalImport,
+}
+
+// exportSenderCanonical do export of sender rewrites
+var exportSenderCanonical = &cobra.Command{
+	Use:   "sender-canonical [address]",
+	Short: "Export sender address rewrites in postfix canonical(5) format",
+	Long: `Export sender address rewrites in postfix canonical(5) format to
+the file named by the -o flag (default stdout '-'). The optional address
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: canonicalExport,
+}
+
+// exportRecipientCanonical do export of recipient rewrites
+var exportRecipientCanonical = &cobra.Command{
+	Use:   "recipient-canonical [address]",
+	Short: "Export recipient address rewrites in postfix canonical(5) format",
+	Long: `Export recipient address rewrites in postfix canonical(5) format to
+the file named by the -o flag (default stdout '-'). The optional address
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: canonicalExport,
+}
+
+// addSenderCanonical add a sender rewrite
+var addSenderCanonical = &cobra.Command{
+	Use:   "sender-canonical address target",
+	Short: "Rewrite a sender address to target",
+	Long: `Rewrite the sender address to target. The address is 'user', 'user@domain'
+or '@domain' for every address in the domain. The target is an RFC2822 address
+or '@domain' to replace only the domain part.`,
+	Args: cobra.ExactArgs(2),
+	RunE: canonicalAdd,
+}
+
+// addRecipientCanonical add a recipient rewrite
+var addRecipientCanonical = &cobra.Command{
+	Use:   "recipient-canonical address target",
+	Short: "Rewrite a recipient address to target",
+	Long: `Rewrite the recipient address to target. The address is 'user', 'user@domain'
+or '@domain' for every address in the domain. The target is an RFC2822 address
+or '@domain' to replace only the domain part.`,
+	Args: cobra.ExactArgs(2),
+	RunE: canonicalAdd,
+}
+
+// deleteSenderCanonical remove a sender rewrite
+var deleteSenderCanonical = &cobra.Command{
+	Use:   "sender-canonical address",
+	Short: "Delete a sender address rewrite",
+	Long:  `Delete the sender address rewrite for address from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  canonicalDelete,
+}
+
+// deleteRecipientCanonical remove a recipient rewrite
+var deleteRecipientCanonical = &cobra.Command{
+	Use:   "recipient-canonical address",
+	Short: "Delete a recipient address rewrite",
+	Long:  `Delete the recipient address rewrite for address from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  canonicalDelete,
+}
+
+// editSenderCanonical change a sender rewrite
+var editSenderCanonical = &cobra.Command{
+	Use:   "sender-canonical address",
+	Short: "Change the target of a sender address rewrite",
+	Long:  `Change the target of the sender address rewrite for address.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  canonicalEdit,
+}
+
+// editRecipientCanonical change a recipient rewrite
+var editRecipientCanonical = &cobra.Command{
+	Use:   "recipient-canonical address",
+	Short: "Change the target of a recipient address rewrite",
+	Long:  `Change the target of the recipient address rewrite for address.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  canonicalEdit,
+}
+
+// showSenderCanonical display a sender rewrite
+var showSenderCanonical = &cobra.Command{
+	Use:   "sender-canonical address",
+	Short: "Display a sender address rewrite",
+	Long:  `Display the sender address rewrite for address to the standard output`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  canonicalShow,
+}
+
+// showRecipientCanonical display a recipient rewrite
+var showRecipientCanonical = &cobra.Command{
+	Use:   "recipient-canonical address",
+	Short: "Display a recipient address rewrite",
+	Long:  `Display the recipient address rewrite for address to the standard output`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  canonicalShow,
+}
+
+// linkage to top level commands
+func init() {
+	importCmd.AddCommand(importSenderCanonical)
+	importCmd.AddCommand(importRecipientCanonical)
+	exportCmd.AddCommand(exportSenderCanonical)
+	exportCmd.AddCommand(exportRecipientCanonical)
+	addCmd.AddCommand(addSenderCanonical)
+	addCmd.AddCommand(addRecipientCanonical)
+	deleteCmd.AddCommand(deleteSenderCanonical)
+	deleteCmd.AddCommand(deleteRecipientCanonical)
+	editCmd.AddCommand(editSenderCanonical)
+	editSenderCanonical.Flags().StringVarP(&canonTarget, "target", "t", "",
+		"New rewrite target for this address")
+	editCmd.AddCommand(editRecipientCanonical)
+	editRecipientCanonical.Flags().StringVarP(&canonTarget, "target", "t", "",
+		"New rewrite target for this address")
+	showCmd.AddCommand(showSenderCanonical)
+	showCmd.AddCommand(showRecipientCanonical)
+}
+
+// canonClass
+// the subcommands are named for their class, "sender-canonical" etc.
+func canonClass(cmd *cobra.Command) string {
+	return strings.TrimSuffix(cmd.Name(), "-canonical")
+}
+
+// canonicalImport the rewrites in canonical(5) format
+func canonicalImport(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if canonClass(cmd) == "sender" {
+		err = procImport(cmd, POSTFIX, procSenderCanonical)
+	} else {
+		err = procImport(cmd, POSTFIX, procRecipientCanonical)
+	}
+	return err
+}
+
+// procSenderCanonical
+func procSenderCanonical(tokens []string) error {
+	return procCanonical("sender", tokens)
+}
+
+// procRecipientCanonical
+func procRecipientCanonical(tokens []string) error {
+	return procCanonical("recipient", tokens)
+}
+
+// procCanonical
+func procCanonical(class string, tokens []string) error {
+	if len(tokens) != 2 {
+		return fmt.Errorf("A canonical entry must be 'address target'")
+	}
+	_, err := mdb.InsertCanonical(class, tokens[0], tokens[1])
+	return err
+}
+
+// canonicalExport the rewrites in canonical(5) format
+func canonicalExport(cmd *cobra.Command, args []string) error {
+	var (
+		err     error
+		pattern string = "*"
+		clist   []*maildb.Canonical
+	)
+
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	if clist, err = mdb.FindCanonical(canonClass(cmd), pattern); err == nil {
+		for _, c := range clist {
+			cmd.Printf("%s\n", c.Export())
+		}
+	}
+	return err
+}
+
+// canonicalAdd the rewrite
+func canonicalAdd(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	err = procCanonical(canonClass(cmd), args)
+	return err
+}
+
+// canonicalDelete the rewrite for the address in the first arg
+func canonicalDelete(cmd *cobra.Command, args []string) error {
+	return mdb.DeleteCanonical(canonClass(cmd), args[0])
+}
+
+// canonicalEdit the rewrite for the address in the first arg
+func canonicalEdit(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		c   *maildb.Canonical
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if c, err = mdb.GetCanonical(canonClass(cmd), args[0]); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("target") {
+		err = c.SetTarget(canonTarget)
+	}
+	return err
+}
+
+// canonicalShow the rewrite for the address in the first arg
+func canonicalShow(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		c   *maildb.Canonical
+	)
+
+	if c, err = mdb.LookupCanonical(canonClass(cmd), args[0]); err == nil {
+		cmd.Printf("Address:\t%s\nClass:\t\t%s\nTarget:\t\t%s\n",
+			c.Address(), c.Class(), c.Target())
+	}
+	return err
+}
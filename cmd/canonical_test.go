@@ -0,0 +1,158 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestCanonicalCmd
+func TestCanonicalCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		canonFile   string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestCanonicalCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestCanonicalCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	canonFile = filepath.Join(dir, "sender_canonical")
+	err = ioutil.WriteFile(canonFile, []byte(`# sender rewrites
+@run.com	@pobox.org
+bill@zip.com	william@zip.com
+root		postmaster@pobox.org
+`), 0644)
+	if err != nil {
+		t.Errorf("Write of %s: Unexpected error, %s", canonFile, err)
+		return
+	}
+	args = []string{"-d", dbfile, "import", "sender-canonical", "-i", canonFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import sender-canonical: Unexpected error, %s", err)
+	}
+	if errout != "" {
+		t.Errorf("Import sender-canonical: did not expect error output, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "add", "recipient-canonical", "bill@zip.com", "bill@dish.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add recipient-canonical bill@zip.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "recipient-canonical", "bill@zip.com", "bill@run.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add recipient-canonical bill@zip.com again: should have failed")
+	} else if err != maildb.ErrMdbDupCanonical {
+		t.Errorf("Add recipient-canonical bill@zip.com again: Unexpected error, %s", err)
+	}
+
+	args = []string{"-d", dbfile, "export", "sender-canonical"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export sender-canonical: Unexpected error, %s", err)
+	}
+	if out != "@run.com @pobox.org\nbill@zip.com william@zip.com\nroot postmaster@pobox.org\n" {
+		t.Errorf("Export sender-canonical: did not get expected output, got %s", out)
+	}
+	args = []string{"-d", dbfile, "export", "recipient-canonical"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export recipient-canonical: Unexpected error, %s", err)
+	}
+	if out != "bill@zip.com bill@dish.net\n" {
+		t.Errorf("Export recipient-canonical: did not get expected output, got %s", out)
+	}
+
+	args = []string{"-d", dbfile, "edit", "sender-canonical", "root", "-t", "admin@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit sender-canonical root: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "sender-canonical", "root"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show sender-canonical root: Unexpected error, %s", err)
+	}
+	if out != "Address:\troot\nClass:\t\tsender\nTarget:\t\tadmin@pobox.org\n" {
+		t.Errorf("Show sender-canonical root: did not get expected output, got %s", out)
+	}
+
+	// Open the database directly so we can test views.
+	// These are the lookups postfix makes with the canonical queries
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT target FROM sender_canonical WHERE address = '@run.com'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"target": "@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup sender @run.com: %s", err)
+	}
+	q = `
+SELECT target FROM recipient_canonical WHERE address = 'bill@zip.com'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"target": "bill@dish.net",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup recipient bill@zip.com: %s", err)
+	}
+	mdb.Close()
+
+	args = []string{"-d", dbfile, "delete", "sender-canonical", "bill@zip.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete sender-canonical bill@zip.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "sender-canonical", "bill@zip.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Show sender-canonical bill@zip.com: should have failed")
+	} else if err != maildb.ErrMdbCanonicalNotFound {
+		t.Errorf("Show sender-canonical bill@zip.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "recipient-canonical", "bill@zip.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show recipient-canonical bill@zip.com: Unexpected error, %s", err)
+	}
+}
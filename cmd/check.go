@@ -0,0 +1,88 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var checkFix bool // delete what is safe to delete
+
+// cmdCheck
+// Look for things in the database that postfix will trip over at runtime.
+// The exit status lets monitoring tell warnings (1) from errors (2).
+func cmdCheck(cmd *cobra.Command, args []string) error {
+	var (
+		err    error
+		flist  []*maildb.Finding
+		status exitStatus
+	)
+
+	if flist, err = mdb.Check(); err != nil {
+		return err
+	}
+	if checkFix {
+		if err = checkFixAll(cmd, flist); err != nil {
+			return err
+		}
+		// a fix can take other findings with it
+		if flist, err = mdb.Check(); err != nil {
+			return err
+		}
+	}
+	for _, f := range flist {
+		cmd.Println(f.String())
+		switch f.Severity() {
+		case maildb.SevError:
+			status = 2
+		case maildb.SevWarning:
+			if status < 1 {
+				status = 1
+			}
+		}
+	}
+	if status > 0 {
+		return status
+	}
+	return nil
+}
+
+// checkFixAll
+// Fix the findings that can be fixed
+func checkFixAll(cmd *cobra.Command, flist []*maildb.Finding) (err error) {
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	for _, f := range flist {
+		if !f.Fixable() {
+			continue
+		}
+		if err = mdb.Fix(f); err != nil {
+			return fmt.Errorf("%s: %s", f.Entity(), err)
+		}
+		cmd.Printf("fixed: %s: deleted\n", f.Entity())
+	}
+	return nil
+}
+
+func init() {
+	checkCmd.Flags().BoolVarP(&checkFix, "fix", "f", false,
+		"Delete the unused entries that are safe to delete")
+}
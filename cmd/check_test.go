@@ -0,0 +1,126 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestConsistencyCmd
+func TestConsistencyCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestConsistencyCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestConsistencyCmd-*")
+	defer os.RemoveAll(dir)
+	empty := filepath.Join(dir, "empty.db")
+
+	// a new database is clean
+	args = []string{"create", "-d", empty}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+	args = []string{"-d", empty, "check", "--fix=false"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Check new DB: Unexpected error, %s", err)
+	}
+	if out != "" {
+		t.Errorf("Check new DB: did not expect output, got %s", out)
+	}
+	dbfile = makeTestDB(t, dir)
+
+	// the access rules need their classes to be clean
+	inFile := filepath.Join(dir, "classes.cf")
+	if err = ioutil.WriteFile(inFile, []byte(`smtpd_restriction_classes = x-dump, x-stall, x-permit, x-reject
+x-dump = discard
+x-stall = defer_if_permit
+x-permit = permit
+x-reject = reject
+`), 0644); err != nil {
+		t.Errorf("Write of classes.cf: Unexpected error, %s", err)
+		return
+	}
+	args = []string{"-d", dbfile, "import", "restriction-classes", "-i", inFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import of restriction-classes: Unexpected error, %s", err)
+	}
+
+	// only warnings
+	args = []string{"-d", dbfile, "check", "--fix=false"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != exitStatus(1) {
+		t.Errorf("Check imports: expected status 1, got %v", err)
+	}
+	if out != "warning: Mailbox jeff@pobox.org: no password, it cannot log in\n"+
+		"notice: Transport relay: not used by any address or domain\n"+
+		"notice: Access STALL: not used by any address, domain or access check\n"+
+		"notice: Access reject: not used by any address, domain or access check\n" {
+		t.Errorf("Check imports: did not get expected output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Check imports: did not expect error output, got %s", errout)
+	}
+
+	// now make a loop
+	for _, al := range [][]string{
+		{"sales@pobox.org", "orders@pobox.org"},
+		{"orders@pobox.org", "sales@pobox.org"},
+	} {
+		args = []string{"-d", dbfile, "add", "virtual", al[0], al[1]}
+		out, errout, err = doTest(rootCmd, "", args)
+		if err != nil {
+			t.Errorf("Add virtual %s: Unexpected error, %s", al[0], err)
+		}
+	}
+	args = []string{"-d", dbfile, "check", "--fix"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != exitStatus(2) {
+		t.Errorf("Check and fix: expected status 2, got %v", err)
+	}
+	if out != "fixed: Transport relay: deleted\n"+
+		"fixed: Access STALL: deleted\n"+
+		"fixed: Access reject: deleted\n"+
+		"error: Alias orders@pobox.org: loops, orders@pobox.org -> sales@pobox.org -> orders@pobox.org\n"+
+		"warning: Mailbox jeff@pobox.org: no password, it cannot log in\n" {
+		t.Errorf("Check and fix: did not get expected output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Check and fix: did not expect error output, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "show", "transport", "relay"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != maildb.ErrMdbTransNotFound {
+		t.Errorf("Show transport relay: expected not found, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cpFd     int  // read the login from this descriptor
+	cpSetuid bool // become the mailbox's owner before running prog
+	cpUserdb bool // trust AUTHORIZED=1, for dovecot's userdb lookups only
+)
+
+// the checkpassword interface exit status
+const (
+	cpBadLogin  exitStatus = 1   // wrong password or no such user
+	cpMisuse    exitStatus = 2   // not called the way the interface says
+	cpTemporary exitStatus = 111 // try again later
+)
+
+// cpMaxInput
+// the most the interface lets the caller write to us
+const cpMaxInput = 512
+
+func init() {
+	// everything after prog is its own
+	checkpasswordCmd.Flags().SetInterspersed(false)
+	checkpasswordCmd.Flags().IntVar(&cpFd, "fd", 3,
+		"Read the user, password and timestamp from this file descriptor")
+	checkpasswordCmd.Flags().StringVar(&mailHome, "mail-home", defaultMailHome,
+		"dovecot mail_home for mailboxes with no home of their own")
+	checkpasswordCmd.Flags().BoolVar(&cpSetuid, "setuid", false,
+		"Change to the mailbox's uid, gid and home before running prog")
+	checkpasswordCmd.Flags().BoolVar(&cpUserdb, "userdb-only", false,
+		"Do not check the password if AUTHORIZED=1, for a dovecot userdb only")
+}
+
+// cmdCheckpassword
+func cmdCheckpassword(cmd *cobra.Command, args []string) error {
+	var (
+		env    []string
+		au     *maildb.AuthUser
+		status exitStatus
+		err    error
+	)
+
+	in := os.NewFile(uintptr(cpFd), "checkpassword input")
+	if in == nil {
+		cmd.PrintErrf("checkpassword: bad descriptor %d\n", cpFd)
+		return cpMisuse
+	}
+	au, env, status, err = checkLogin(in, os.Environ())
+	in.Close()
+	if err != nil {
+		cmd.PrintErrf("checkpassword: %s\n", err)
+		return status
+	}
+	prog, err := exec.LookPath(args[0])
+	if err != nil {
+		cmd.PrintErrf("checkpassword: %s\n", err)
+		return cpTemporary
+	}
+	if cpSetuid {
+		if err = becomeOwner(au); err != nil {
+			cmd.PrintErrf("checkpassword: %s: %s\n", au.User(), err)
+			return cpTemporary
+		}
+	}
+	mdb.Close()
+	err = syscall.Exec(prog, args, env)
+	cmd.PrintErrf("checkpassword: %s: %s\n", prog, err)
+	return cpTemporary
+}
+
+// checkLogin
+// Read the login from in and check it against the database. The
+// returned environment is environ with the user's variables set for
+// prog. The exit status says why it failed.
+func checkLogin(in io.Reader, environ []string) (*maildb.AuthUser, []string, exitStatus, error) {
+	var (
+		au *maildb.AuthUser
+		ok bool
+	)
+
+	input, err := ioutil.ReadAll(io.LimitReader(in, cpMaxInput+1))
+	if err != nil {
+		return nil, nil, cpMisuse, err
+	}
+	if len(input) > cpMaxInput {
+		return nil, nil, cpMisuse, fmt.Errorf("input longer than %d bytes", cpMaxInput)
+	}
+	fields := bytes.SplitN(input, []byte{0}, 3)
+	if len(fields) < 3 {
+		return nil, nil, cpMisuse, fmt.Errorf("input is not user, password and timestamp")
+	}
+	user, password := string(fields[0]), string(fields[1])
+
+	// dovecot's userdb lookups only want the user's variables. Anyone who
+	// can run us can set the environment so it is only trusted when the
+	// userdb is all this is used for.
+	authorized := cpUserdb && getEnv(environ, "AUTHORIZED") == "1"
+	if _, err = maildb.DecodeRFC822(user); err != nil {
+		return nil, nil, cpBadLogin, fmt.Errorf("%q: %s", user, err)
+	}
+	if au, err = mdb.LookupAuthUser(user); err != nil {
+		if err == maildb.ErrMdbNotMbox {
+			return nil, nil, cpBadLogin, fmt.Errorf("%s: no such user", user)
+		}
+		return nil, nil, cpTemporary, err
+	}
+	if au.IsDenied() {
+		return nil, nil, cpBadLogin, fmt.Errorf("%s: login denied", au.User())
+	}
+	if !authorized {
+		if ok, err = au.Verify(password); err != nil {
+			return nil, nil, cpTemporary, fmt.Errorf("%s: %s", au.User(), err)
+		} else if !ok {
+			return nil, nil, cpBadLogin, fmt.Errorf("%s: wrong password", au.User())
+		}
+	}
+
+	env := setEnv(environ, "USER", au.User())
+	env = setEnv(env, "HOME", au.Path(mailHome))
+	var extra []string
+	uid, gid := au.Owner()
+	if uid >= 0 {
+		env = setEnv(env, "userdb_uid", strconv.Itoa(uid))
+		extra = append(extra, "userdb_uid")
+	}
+	if gid >= 0 {
+		env = setEnv(env, "userdb_gid", strconv.Itoa(gid))
+		extra = append(extra, "userdb_gid")
+	}
+	env = setEnv(env, "userdb_quota_rule", au.QuotaRule())
+	extra = append(extra, "userdb_quota_rule")
+	env = setEnv(env, "EXTRA", strings.Join(extra, " "))
+	if authorized {
+		env = setEnv(env, "AUTHORIZED", "2")
+	}
+	return au, env, 0, nil
+}
+
+// becomeOwner
+// what the original checkpassword does for services that run as root
+func becomeOwner(au *maildb.AuthUser) error {
+	uid, gid := au.Owner()
+	if uid < 0 || gid < 0 {
+		return fmt.Errorf("mailbox has no uid or gid")
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return err
+	}
+	return os.Chdir(au.Path(mailHome))
+}
+
+// getEnv
+// the value of name in env
+func getEnv(env []string, name string) string {
+	for _, e := range env {
+		if strings.HasPrefix(e, name+"=") {
+			return e[len(name)+1:]
+		}
+	}
+	return ""
+}
+
+// setEnv
+// env with name set to value, replacing the one already there
+func setEnv(env []string, name string, value string) []string {
+	var out []string
+
+	for _, e := range env {
+		if !strings.HasPrefix(e, name+"=") {
+			out = append(out, e)
+		}
+	}
+	return append(out, name+"="+value)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestCheckpasswordCmd
+func TestCheckpasswordCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+		env         []string
+		status      exitStatus
+	)
+
+	fmt.Println("TestCheckpasswordCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestCheckpasswordCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	mboxes := filepath.Join(dir, "mailboxes.txt")
+	if err = ioutil.WriteFile(mboxes, []byte(
+		"ann@pobox.org:{PLAIN}xwing::::::mbox_enabled=true\n"+
+			"bill@pobox.org:{CRYPT}$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG."+
+			":5000:5001::/home/bill::userdb_quota_rule=*:bytes=1G mbox_enabled=true\n"), 0644); err != nil {
+		t.Errorf("Write mailboxes: %s", err)
+		return
+	}
+	args = []string{"-d", dbfile, "import", "mailbox", "-i", mboxes}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import of %s: Unexpected error, %s", mboxes, err)
+	}
+
+	// the command up to where it runs prog
+	input := filepath.Join(dir, "input")
+	logins := []struct {
+		input  string
+		status exitStatus
+		errout string
+	}{
+		{"ann@pobox.org\x00xWing\x00123\x00", cpBadLogin, "checkpassword: ann@pobox.org: wrong password\n"},
+		{"dave@pobox.org\x00HJJJYGB\x00123\x00", cpBadLogin, "checkpassword: dave@pobox.org: login denied\n"},
+		{"jeff@pobox.org\x00*\x00123\x00", cpBadLogin, "checkpassword: jeff@pobox.org: wrong password\n"},
+		{"yoda@pobox.org\x00xwing\x00", cpBadLogin, "checkpassword: yoda@pobox.org: no such user\n"},
+		{"ann@pobox.org\x00xwing", cpMisuse, "checkpassword: input is not user, password and timestamp\n"},
+		{"ann@pobox.org\x00xwing\x00" + strings.Repeat("x", cpMaxInput), cpMisuse,
+			"checkpassword: input longer than 512 bytes\n"},
+	}
+	for _, l := range logins {
+		if err = ioutil.WriteFile(input, []byte(l.input), 0600); err != nil {
+			t.Errorf("Write input: %s", err)
+			return
+		}
+		f, err := os.Open(input)
+		if err != nil {
+			t.Errorf("Open input: %s", err)
+			return
+		}
+		args = []string{"-d", dbfile, "checkpassword", "--fd", strconv.Itoa(int(f.Fd())),
+			"/bin/false", "-x"}
+		out, errout, err = doTest(rootCmd, "", args)
+		f.Close()
+		if err != l.status {
+			t.Errorf("Checkpassword %q: expected status %d, got %v", l.input, l.status, err)
+		}
+		if out != "" || errout != l.errout {
+			t.Errorf("Checkpassword %q: did not get expected output, got %s%s", l.input, out, errout)
+		}
+	}
+
+	// and the environment prog gets
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Open DB: %s", err)
+		return
+	}
+	defer func() {
+		mdb.Close()
+		mdb = nil
+	}()
+	mailHome = "/srv/dovecot/%d/%n"
+	environ := []string{"PATH=/bin", "USER=dovecot", "HOME=/var/run/dovecot"}
+	_, env, status, err = checkLogin(strings.NewReader("ann@pobox.org\x00xwing\x00123\x00"), environ)
+	if err != nil || status != 0 {
+		t.Errorf("Checkpassword ann: unexpected %d, %s", status, err)
+	} else if strings.Join(env, "\n") != "PATH=/bin\nUSER=ann@pobox.org\nHOME=/srv/dovecot/pobox.org/ann\n"+
+		"userdb_uid=65534\nuserdb_gid=65534\nuserdb_quota_rule=*:bytes=300M\n"+
+		"EXTRA=userdb_uid userdb_gid userdb_quota_rule" {
+		t.Errorf("Checkpassword ann: unexpected environment, got %v", env)
+	}
+	_, env, status, err = checkLogin(strings.NewReader("Bill@POBOX.org\x00secret\x00123\x00"), environ)
+	if err != nil || status != 0 {
+		t.Errorf("Checkpassword bill: unexpected %d, %s", status, err)
+	} else if strings.Join(env, "\n") != "PATH=/bin\nUSER=bill@pobox.org\nHOME=/home/bill\n"+
+		"userdb_uid=5000\nuserdb_gid=5001\nuserdb_quota_rule=*:bytes=1G\n"+
+		"EXTRA=userdb_uid userdb_gid userdb_quota_rule" {
+		t.Errorf("Checkpassword bill: unexpected environment, got %v", env)
+	}
+
+	// dovecot's userdb lookup has no password to check but
+	// AUTHORIZED=1 is only trusted with --userdb-only
+	environ = append(environ, "AUTHORIZED=1")
+	_, env, status, err = checkLogin(strings.NewReader("bill@pobox.org\x00\x00\x00"), environ)
+	if status != cpBadLogin {
+		t.Errorf("Checkpassword bill AUTHORIZED=1: expected bad login, got %d, %v", status, err)
+	}
+	_, env, status, err = checkLogin(strings.NewReader("bill@pobox.org\x00wrong\x00\x00"), environ)
+	if status != cpBadLogin {
+		t.Errorf("Checkpassword bill AUTHORIZED=1 wrong: expected bad login, got %d, %v", status, err)
+	}
+	cpUserdb = true
+	defer func() { cpUserdb = false }()
+	_, env, status, err = checkLogin(strings.NewReader("bill@pobox.org\x00\x00\x00"), environ)
+	if err != nil || status != 0 {
+		t.Errorf("Checkpassword bill userdb: unexpected %d, %s", status, err)
+	} else if getEnv(env, "AUTHORIZED") != "2" || getEnv(env, "USER") != "bill@pobox.org" {
+		t.Errorf("Checkpassword bill userdb: unexpected environment, got %v", env)
+	}
+	_, _, status, err = checkLogin(strings.NewReader("dave@pobox.org\x00\x00\x00"), environ)
+	if status != cpBadLogin {
+		t.Errorf("Checkpassword dave userdb: expected bad login, got %d, %v", status, err)
+	}
+}
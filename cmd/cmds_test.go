@@ -27,8 +27,30 @@ import (
 
 	//"github.com/lieb/postdove/maildb"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// resetFlags
+// Put every flag of cmd and its subcommands back to its default and
+// clear Changed. The flag variables are package globals and a command
+// only sets the ones it is given so one test's flags would leak into
+// the next.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, c := range cmd.Commands() {
+		resetFlags(c)
+	}
+}
+
 // doTest
 func doTest(cmd *cobra.Command, stdIn string, args []string) (string, string, error) {
 	var (
@@ -43,6 +65,7 @@ func doTest(cmd *cobra.Command, stdIn string, args []string) (string, string, er
 	cmd.SetErr(errbuf)
 	cmd.SetArgs(args)
 	err = cmd.Execute()
+	resetFlags(cmd)
 	out, e := ioutil.ReadAll(outbuf)
 	if e != nil {
 		return "", "", fmt.Errorf("doTest: ReadAll out failed, %s", e)
@@ -54,6 +77,36 @@ func doTest(cmd *cobra.Command, stdIn string, args []string) (string, string, er
 	return string(out), string(errout), err
 }
 
+// makeTestDB
+// Create test.db in dir and import the test access, transport, domain
+// and mailbox files into it. Returns the database file.
+func makeTestDB(t *testing.T, dir string) string {
+	dbfile := filepath.Join(dir, "test.db")
+	args := []string{"create", "-d", dbfile}
+	if _, _, err := doTest(rootCmd, "", args); err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+	for _, imp := range [][]string{
+		{"access", "./test_access.txt"},
+		{"transport", "./test_transports.txt"},
+		{"domain", "./test_domains.txt"},
+		{"mailbox", "./test_mailboxes.txt"},
+	} {
+		args = []string{"-d", dbfile, "import", imp[0], "-i", imp[1]}
+		out, errout, err := doTest(rootCmd, "", args)
+		if err != nil {
+			t.Errorf("Import of %s: Unexpected error, %s", imp[0], err)
+		}
+		if out != "" {
+			t.Errorf("Import of %s: Expected no output, got %s", imp[0], out)
+		}
+		if errout != "" {
+			t.Errorf("Import of %s: Expected no error output, got %s", imp[0], errout)
+		}
+	}
+	return dbfile
+}
+
 // Test_Cmds
 // Test basic commmands infrastructure and database creation
 func Test_Cmds(t *testing.T) {
@@ -0,0 +1,219 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultPostfixDir = "/etc/postfix"
+	defaultDovecotDir = "/etc/dovecot"
+)
+
+var (
+	postfixDir string
+	dovecotDir string
+	configDiff bool
+)
+
+// generateConfig write the config files
+var generateConfig = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate the postfix and dovecot configuration files",
+	Long: `Generate the postfix query files, the main.cf parameters that use them and
+the dovecot SQL passdb, userdb and deny configuration for the database. The
+query files go in the query directory of the postfix config directory. The
+main.cf parameters are in postdove.cf there, to be pasted into main.cf. The
+files are made from templates for the current schema and point at the
+database file given with --dbfile. With --diff, nothing is written and the
+differences between the installed files and the generated ones are shown.`,
+	Args: cobra.NoArgs,
+	RunE: configGenerate,
+}
+
+func init() {
+	configCmd.AddCommand(generateConfig)
+	generateConfig.Flags().StringVarP(&postfixDir, "postfix-dir", "p", defaultPostfixDir,
+		"postfix config directory")
+	generateConfig.Flags().StringVarP(&dovecotDir, "dovecot-dir", "D", defaultDovecotDir,
+		"dovecot config directory")
+	generateConfig.Flags().BoolVarP(&configDiff, "diff", "", false,
+		"Show the differences from the installed files instead of writing them")
+}
+
+// configGenerate
+func configGenerate(cmd *cobra.Command, args []string) error {
+	var (
+		err    error
+		dbPath string
+		cflist []*maildb.ConfigFile
+	)
+
+	if dbPath, err = filepath.Abs(dbFile); err != nil {
+		return err
+	}
+	cflist, err = maildb.ConfigFiles(dbPath, filepath.Join(postfixDir, "query"))
+	if err != nil {
+		return err
+	}
+	for _, cf := range cflist {
+		dir := postfixDir
+		if cf.Dir() == "dovecot" {
+			dir = dovecotDir
+		}
+		file := filepath.Join(dir, cf.Name())
+		if configDiff {
+			old, err := ioutil.ReadFile(file)
+			if os.IsNotExist(err) {
+				cmd.Printf("%s: not installed\n", file)
+				continue
+			} else if err != nil {
+				return err
+			}
+			cmd.Print(unifiedDiff(file, string(old), cf.Content()))
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		tmp := file + ".tmp"
+		if err = ioutil.WriteFile(tmp, []byte(cf.Content()), 0644); err != nil {
+			return err
+		}
+		if err = os.Rename(tmp, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffContext is the number of unchanged lines around a change
+const diffContext = 3
+
+// unifiedDiff
+// The differences between the installed file and its new content in
+// the unified format of diff -u. Nothing if they are the same.
+func unifiedDiff(file string, old string, new string) string {
+	if old == new {
+		return ""
+	}
+	a := splitLines(old)
+	b := splitLines(new)
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// walk it for the edits, one per line, with where each is in a and b
+	type edit struct {
+		op     byte
+		ai, bi int
+		line   string
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', i, j, a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', i, j, a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', i, j, b[j]})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s (generated)\n", file, file)
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// a hunk runs until there are more unchanged lines than
+		// the context on both sides of the next change
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for n := k; n < len(edits) && n-end <= 2*diffContext; n++ {
+			if edits[n].op != ' ' {
+				end = n
+			}
+		}
+		k = end + 1
+		end += diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+		alen, blen := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				alen++
+			}
+			if e.op != '-' {
+				blen++
+			}
+		}
+		astart, bstart := edits[start].ai+1, edits[start].bi+1
+		if alen == 0 {
+			astart--
+		}
+		if blen == 0 {
+			bstart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", astart, alen, bstart, blen)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", e.op, e.line)
+		}
+	}
+	return out.String()
+}
+
+// splitLines
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
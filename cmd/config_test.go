@@ -0,0 +1,117 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestConfigGenerateCmd
+func TestConfigGenerateCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestConfigGenerateCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestConfigGenerateCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = filepath.Join(dir, "test.db")
+	pfDir := filepath.Join(dir, "postfix")
+	dcDir := filepath.Join(dir, "dovecot")
+
+	args = []string{"create", "-d", dbfile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+
+	// nothing is installed yet
+	args = []string{"-d", dbfile, "config", "generate", "-p", pfDir, "-D", dcDir, "--diff"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Config diff before generate: Unexpected error, %s", err)
+	}
+	if !strings.Contains(out, filepath.Join(pfDir, "query/virtual_alias.query")+": not installed\n") ||
+		!strings.Contains(out, filepath.Join(dcDir, "sql-deny.conf.ext")+": not installed\n") {
+		t.Errorf("Config diff before generate: did not get expected output, got %s", out)
+	}
+
+	args = []string{"-d", dbfile, "config", "generate", "-p", pfDir, "-D", dcDir, "--diff=false"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Config generate: Unexpected error, %s", err)
+	}
+	if out != "" || errout != "" {
+		t.Errorf("Config generate: did not expect output, got %s%s", out, errout)
+	}
+	alias := filepath.Join(pfDir, "query/alias_maps.query")
+	c, err := ioutil.ReadFile(alias)
+	if err != nil {
+		t.Errorf("Config generate: alias_maps.query, %s", err)
+	} else if !strings.Contains(string(c), "dbpath = "+dbfile+"\n") {
+		t.Errorf("Config generate: alias_maps.query has the wrong dbpath, got %s", c)
+	}
+	c, err = ioutil.ReadFile(filepath.Join(dcDir, "dovecot-sql.conf.ext"))
+	if err != nil {
+		t.Errorf("Config generate: dovecot-sql.conf.ext, %s", err)
+	} else if !strings.Contains(string(c), "connect = "+dbfile+"\n") {
+		t.Errorf("Config generate: dovecot-sql.conf.ext has the wrong connect, got %s", c)
+	}
+
+	// now they are the same
+	args = []string{"-d", dbfile, "config", "generate", "-p", pfDir, "-D", dcDir, "--diff"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Config diff after generate: Unexpected error, %s", err)
+	}
+	if out != "" {
+		t.Errorf("Config diff after generate: did not expect output, got %s", out)
+	}
+
+	// a local edit
+	c, _ = ioutil.ReadFile(alias)
+	edited := strings.Replace(string(c), "WHERE local_user = '%u'", "WHERE local_user = '%s'", 1)
+	if err = ioutil.WriteFile(alias, []byte(edited), 0644); err != nil {
+		t.Errorf("Config edit: %s", err)
+	}
+	args = []string{"-d", dbfile, "config", "generate", "-p", pfDir, "-D", dcDir, "--diff"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Config diff after edit: Unexpected error, %s", err)
+	}
+	if out != "--- "+alias+"\n"+
+		"+++ "+alias+" (generated)\n"+
+		"@@ -7,4 +7,4 @@\n"+
+		" \n"+
+		" dbpath = "+dbfile+"\n"+
+		" \n"+
+		"-query = SELECT recipient FROM etc_aliases WHERE local_user = '%s'\n"+
+		"+query = SELECT recipient FROM etc_aliases WHERE local_user = '%u'\n" {
+		t.Errorf("Config diff after edit: did not get expected output, got %s", out)
+	}
+}
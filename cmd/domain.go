@@ -35,6 +35,10 @@ var (
 	noRClass     bool
 	dTransport   string
 	noDTransport bool
+	dAliasOf     string
+	noDAliasOf   bool
+	dSTransport  string
+	noDSTrans    bool
 )
 
 // importDomain do import of a domains file
@@ -109,6 +113,10 @@ func init() {
 		"Restriction class for this domain")
 	addDomain.Flags().StringVarP(&dTransport, "transport", "t", "",
 		"Transport to use for this domain")
+	addDomain.Flags().StringVarP(&dAliasOf, "alias-of", "a", "",
+		"Make this domain an alias of another domain")
+	addDomain.Flags().StringVarP(&dSTransport, "sender-transport", "s", "",
+		"Transport to relay mail sent from this domain")
 	deleteCmd.AddCommand(deleteDomain)
 	editCmd.AddCommand(editDomain)
 	editDomain.Flags().StringVarP(&dClass, "class", "c", "",
@@ -129,6 +137,14 @@ func init() {
 		"Transport to use for this domain")
 	editDomain.Flags().BoolVarP(&noDTransport, "no-transport", "T", false,
 		"Clear the transport for this domain")
+	editDomain.Flags().StringVarP(&dAliasOf, "alias-of", "a", "",
+		"Make this domain an alias of another domain")
+	editDomain.Flags().BoolVarP(&noDAliasOf, "no-alias-of", "A", false,
+		"Clear the alias domain target for this domain")
+	editDomain.Flags().StringVarP(&dSTransport, "sender-transport", "s", "",
+		"Transport to relay mail sent from this domain")
+	editDomain.Flags().BoolVarP(&noDSTrans, "no-sender-transport", "S", false,
+		"Clear the sender transport for this domain")
 	showCmd.AddCommand(showDomain)
 }
 
@@ -180,6 +196,10 @@ func procDomain(tokens []string) error {
 				err = d.SetRclass(kv[1])
 			case "transport":
 				err = d.SetTransport(kv[1])
+			case "alias_of":
+				err = d.SetAliasOf(kv[1])
+			case "sender_transport":
+				err = d.SetSenderTransport(kv[1])
 			default:
 				return fmt.Errorf("Unknown domain import option %s", kv[0])
 			}
@@ -238,6 +258,12 @@ func domainAdd(cmd *cobra.Command, args []string) error {
 	if err == nil && cmd.Flags().Changed("transport") {
 		err = d.SetTransport(dTransport)
 	}
+	if err == nil && cmd.Flags().Changed("alias-of") {
+		err = d.SetAliasOf(dAliasOf)
+	}
+	if err == nil && cmd.Flags().Changed("sender-transport") {
+		err = d.SetSenderTransport(dSTransport)
+	}
 	return err
 }
 
@@ -288,6 +314,20 @@ func domainEdit(cmd *cobra.Command, args []string) error {
 			err = d.SetTransport(dTransport)
 		}
 	}
+	if err == nil {
+		if cmd.Flags().Changed("no-alias-of") {
+			err = d.ClearAliasOf()
+		} else if cmd.Flags().Changed("alias-of") {
+			err = d.SetAliasOf(dAliasOf)
+		}
+	}
+	if err == nil {
+		if cmd.Flags().Changed("no-sender-transport") {
+			err = d.ClearSenderTransport()
+		} else if cmd.Flags().Changed("sender-transport") {
+			err = d.SetSenderTransport(dSTransport)
+		}
+	}
 	return err
 }
 
@@ -305,5 +345,11 @@ func domainShow(cmd *cobra.Command, args []string) error {
 		d.Name(), d.Class(), d.Transport())
 	cmd.Printf("UserID:\t\t%s\nGroup ID:\t%s\nRestrictions:\t%s\n",
 		d.Vuid(), d.Vgid(), d.Rclass())
+	if d.IsAlias() {
+		cmd.Printf("Alias Of:\t%s\n", d.AliasOf())
+	}
+	if d.SenderTransport() != "--" {
+		cmd.Printf("Sender Relay:\t%s\n", d.SenderTransport())
+	}
 	return nil
 }
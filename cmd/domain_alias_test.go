@@ -0,0 +1,280 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestDomainAlias
+func TestDomainAlias(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestDomainAlias")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestDomainAlias-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "add", "virtual", "abuse@pobox.org", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual abuse@pobox.org: Unexpected error, %s", err)
+	}
+
+	// alias of a domain that does not exist
+	args = []string{"-d", dbfile, "add", "domain", "pobox.net", "-c", "virtual",
+		"--alias-of", "nowhere.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add pobox.net alias of nowhere.org: should have failed")
+	} else if err != maildb.ErrMdbDomainNotFound {
+		t.Errorf("Add pobox.net alias of nowhere.org: Unexpected error, %s", err)
+	}
+
+	// now the real one
+	args = []string{"-d", dbfile, "add", "domain", "pobox.net", "-c", "virtual",
+		"--alias-of", "pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add pobox.net alias of pobox.org: Unexpected error, %s", err)
+	}
+	if out != "" {
+		t.Errorf("Add pobox.net: did not expect output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Add pobox.net: did not expect error output, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "show", "domain", "pobox.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show of pobox.net: Unexpected error, %s", err)
+	}
+	if out != "Name:\t\tpobox.net\nClass:\t\tvirtual\nTransport:\t--\n"+
+		"UserID:\t\t--\nGroup ID:\t--\nRestrictions:\t--\nAlias Of:\tpobox.org\n" {
+		t.Errorf("Show of pobox.net: did not get expected output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Show of pobox.net: did not expect error output, got %s", errout)
+	}
+
+	// import the same thing for another vanity domain
+	bizFile := filepath.Join(dir, "biz.txt")
+	if err = ioutil.WriteFile(bizFile,
+		[]byte("pobox.biz class=virtual, alias_of=pobox.org\n"), 0644); err != nil {
+		t.Errorf("Could not write %s, %s", bizFile, err)
+	}
+	args = []string{"-d", dbfile, "import", "domain", "-i", bizFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import of pobox.biz: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "export", "domain", "pobox.*"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export pobox.*: Unexpected error, %s", err)
+	}
+	exportList := "pobox.biz class=virtual, alias_of=pobox.org\n" +
+		"pobox.net class=virtual, alias_of=pobox.org\n" +
+		"pobox.org class=vmailbox, transport=local\n"
+	if out != exportList {
+		t.Errorf("Export pobox.*: Expected (%s), got (%s)", exportList, out)
+	}
+
+	// an alias domain cannot be the target of another alias domain
+	args = []string{"-d", dbfile, "edit", "domain", "pobox.biz", "--alias-of", "pobox.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Edit pobox.biz alias of pobox.net: should have failed")
+	} else if err != maildb.ErrMdbDomainAliasLoop {
+		t.Errorf("Edit pobox.biz alias of pobox.net: Unexpected error, %s", err)
+	}
+
+	// only a virtual domain, or one with no class, can be an alias
+	args = []string{"-d", dbfile, "add", "domain", "pobox.com", "-c", "vmailbox",
+		"--alias-of", "pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add vmailbox pobox.com alias of pobox.org: should have failed")
+	} else if err != maildb.ErrMdbDomainAliasClass {
+		t.Errorf("Add vmailbox pobox.com alias of pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "edit", "domain", "pobox.net", "-c", "relay"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Edit pobox.net to relay: should have failed")
+	} else if err != maildb.ErrMdbDomainAliasClass {
+		t.Errorf("Edit pobox.net to relay: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "domain", "pobox.com", "--alias-of", "pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add pobox.com alias of pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "domain", "pobox.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete pobox.com: Unexpected error, %s", err)
+	}
+
+	// pobox.net gets its own abuse alias which overrides the domain alias
+	args = []string{"-d", dbfile, "add", "virtual", "abuse@pobox.net", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual abuse@pobox.net: Unexpected error, %s", err)
+	}
+
+	// dave@pobox.net is only a recipient, it is still mapped to pobox.org
+	args = []string{"-d", dbfile, "add", "virtual", "team@pobox.org", "dave@pobox.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual team@pobox.org: Unexpected error, %s", err)
+	}
+	// and the alias domains fall back to the catch-all of pobox.org
+	args = []string{"-d", dbfile, "add", "virtual", "@pobox.org", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual @pobox.org: Unexpected error, %s", err)
+	}
+
+	// Open the database directly so we can test views
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox = 'jeff' AND domain_name = 'pobox.net'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "jeff@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup jeff@pobox.net: %s", err)
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox = 'abuse' AND domain_name = 'pobox.biz'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "abuse@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup abuse@pobox.biz: %s", err)
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox = 'abuse' AND domain_name = 'pobox.net'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "dave@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup abuse@pobox.net: %s", err)
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox = 'nobody' AND domain_name = 'pobox.net'
+`
+	expectedRes = []maildb.QueryRes{}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup nobody@pobox.net: %s", err)
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox = 'dave' AND domain_name = 'pobox.net'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "dave@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup dave@pobox.net: %s", err)
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox = '' AND domain_name = 'pobox.net'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "jeff@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup @pobox.net: %s", err)
+	}
+	mdb.Close()
+
+	// pobox.net gets a catch-all of its own
+	args = []string{"-d", dbfile, "add", "virtual", "@pobox.net", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual @pobox.net: Unexpected error, %s", err)
+	}
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox = '' AND domain_name = 'pobox.net'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "dave@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup @pobox.net with its own catch-all: %s", err)
+	}
+	mdb.Close()
+
+	// clear the alias and it all goes away
+	args = []string{"-d", dbfile, "edit", "domain", "pobox.biz", "--no-alias-of"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit pobox.biz --no-alias-of: Unexpected error, %s", err)
+	}
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	defer mdb.Close()
+	q = `
+SELECT recipient FROM virt_alias WHERE domain_name = 'pobox.biz'
+`
+	expectedRes = []maildb.QueryRes{}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup *@pobox.biz after clear: %s", err)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+// cmdExplain
+func cmdExplain(cmd *cobra.Command, args []string) error {
+	dl, err := mdb.Explain(args[0])
+	if err != nil {
+		return err
+	}
+	printDelivery(cmd, dl, 0)
+	return nil
+}
+
+// printDelivery
+// the address, what was found for it and then its targets indented
+// under it
+func printDelivery(cmd *cobra.Command, dl *maildb.Delivery, depth int) {
+	indent := strings.Repeat("  ", depth)
+	cmd.Printf("%s%s\n", indent, dl.Address())
+	for _, n := range dl.Notes() {
+		cmd.Printf("%s  %s\n", indent, n)
+	}
+	for _, p := range dl.Problems() {
+		cmd.Printf("%s  ** %s\n", indent, p)
+	}
+	for _, t := range dl.Targets() {
+		printDelivery(cmd, t, depth+1)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestExplainCmd
+func TestExplainCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestExplainCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestExplainCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "add", "virtual", "sales@pobox.org", "jeff@pobox.org", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual sales@pobox.org: Unexpected error, %s", err)
+	}
+
+	args = []string{"-d", dbfile, "explain", "@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Explain @pobox.org: should have failed")
+	} else if err != maildb.ErrMdbNotRecipient {
+		t.Errorf("Explain @pobox.org: Unexpected error, %s", err)
+	}
+
+	// dave's mailbox is disabled in the import
+	args = []string{"-d", dbfile, "explain", "sales+orders@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Explain sales@pobox.org: Unexpected error, %s", err)
+	}
+	if errout != "" {
+		t.Errorf("Explain sales@pobox.org: did not expect error output, got %s", errout)
+	}
+	if out != `sales+orders@pobox.org
+  virtual alias sales@pobox.org: jeff@pobox.org, dave@pobox.org
+  jeff+orders@pobox.org
+    domain pobox.org: vmailbox
+    mailbox jeff@pobox.org: home mail_home, uid/gid 65534/65534, quota *:bytes=300M
+    transport: lmtp:localhost:24
+  dave+orders@pobox.org
+    domain pobox.org: vmailbox
+    mailbox dave@pobox.org: home dave, uid/gid 56/83, quota *:bytes=40G
+    transport: lmtp:localhost:24
+    ** mailbox dave@pobox.org is disabled, dovecot refuses delivery
+` {
+		t.Errorf("Explain sales@pobox.org: did not get expected output, got %s", out)
+	}
+}
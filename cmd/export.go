@@ -24,10 +24,10 @@ import (
 )
 
 var (
-	outFilePath string
-	outFile     *os.File
-	outWriter   io.Writer
-	savedOut    io.Writer
+	outFilePath   string
+	outFile       *os.File
+	outWriter     io.Writer
+	redirectedOut bool
 )
 
 // exportRedirect
@@ -48,7 +48,7 @@ func exportRedirect(cmd *cobra.Command, args []string) error {
 			}
 		}
 		outWriter = outFile
-		savedOut = cmd.OutOrStdout()
+		redirectedOut = true
 		cmd.SetOut(outWriter)
 	}
 	return nil
@@ -63,13 +63,13 @@ func exportClose(cmd *cobra.Command, args []string) error {
 	if mdb == nil {
 		panic("Export close: mbd is nil")
 	}
-	if savedOut != nil {
-		outWriter = cmd.OutOrStdout()
+	if redirectedOut {
+		// back to the parent's output, as importClose does
+		cmd.SetOut(nil)
+		redirectedOut = false
 		if err = outFile.Close(); err != nil {
 			return err
 		}
-		cmd.SetOut(savedOut)
-		savedOut = nil
 	}
 	return nil
 }
@@ -21,16 +21,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	inFilePath string
-	inFile     *os.File
-	inReader   io.Reader
-	savedIn    io.Reader
+	inFilePath   string
+	inFile       *os.File
+	inReader     io.Reader
+	redirectedIn bool
 )
 
 // importRedirect
@@ -48,7 +49,7 @@ func importRedirect(cmd *cobra.Command, args []string) error {
 			}
 		}
 		inReader = inFile
-		savedIn = cmd.InOrStdin()
+		redirectedIn = true
 		cmd.SetIn(inReader)
 	}
 	return nil
@@ -60,12 +61,14 @@ func importClose(cmd *cobra.Command, args []string) error {
 		err error
 	)
 
-	if savedIn != nil {
+	if redirectedIn {
+		// back to the parent's input, setting the one it had would
+		// keep it even after the parent's changes
+		cmd.SetIn(nil)
+		redirectedIn = false
 		if err = inFile.Close(); err != nil {
 			return err
 		}
-		cmd.SetIn(savedIn)
-		savedIn = nil
 	}
 	return nil
 }
@@ -201,3 +204,13 @@ func procLine(line string, use ImportType, worker func([]string) error) error {
 	}
 	return err
 }
+
+// uniqueName
+// base, or base-2, base-3 and so on if names already has it
+func uniqueName(names map[string]bool, base string) string {
+	name := base
+	for n := 2; names[name]; n++ {
+		name = base + "-" + strconv.Itoa(n)
+	}
+	return name
+}
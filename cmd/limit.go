@@ -0,0 +1,157 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"time"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	limHourly     int64
+	limRecipients int64
+	limDaily      int64
+	limAction     string
+	limDisable    bool
+)
+
+// setLimit add or change send limits
+var setLimit = &cobra.Command{
+	Use:   "set user@domain|@domain",
+	Short: "Set or change the send limits of a mailbox or domain",
+	Long: `Set or change the send limits of the mailbox user@domain or, for @domain, of
+each mailbox in the domain that does not have limits of its own. A limit of 0
+is no limit. Flags that are not given keep their current values.`,
+	Args: cobra.ExactArgs(1),
+	RunE: limitSet,
+}
+
+// clearLimit remove send limits
+var clearLimit = &cobra.Command{
+	Use:   "clear user@domain|@domain",
+	Short: "Remove the send limits of a mailbox or domain",
+	Long: `Remove the send limits of the mailbox user@domain or of @domain. A mailbox
+with no limits of its own has those of its domain.`,
+	Args: cobra.ExactArgs(1),
+	RunE: limitClear,
+}
+
+// showLimit display send limits
+var showLimit = &cobra.Command{
+	Use:   "show [user@domain|@domain]",
+	Short: "Display send limits",
+	Long: `Display the send limits of the mailboxes and domains that match the
+argument, all of them if there is none. A '*' matches anything. The
+mail each mailbox sent in the last hour and day is shown with its limits.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: limitShow,
+}
+
+// linkage to the limit command
+func init() {
+	limitCmd.AddCommand(setLimit)
+	setLimit.Flags().Int64VarP(&limHourly, "hourly", "H", 0,
+		"Messages per hour, 0 for no limit")
+	setLimit.Flags().Int64VarP(&limRecipients, "recipients", "r", 0,
+		"Recipients per message, 0 for no limit")
+	setLimit.Flags().Int64VarP(&limDaily, "daily", "D", 0,
+		"Recipients per day, 0 for no limit")
+	setLimit.Flags().StringVarP(&limAction, "action", "a", "defer",
+		"What to answer when over a limit, defer or reject")
+	setLimit.Flags().BoolVar(&limDisable, "disable", false,
+		"Also disable the mailbox when it goes over a limit")
+	limitCmd.AddCommand(clearLimit)
+	limitCmd.AddCommand(showLimit)
+}
+
+// limitSet
+func limitSet(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		l   *maildb.SendLimit
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if l, err = mdb.GetSendLimit(args[0]); err == maildb.ErrMdbLimitNotFound {
+		l, err = mdb.InsertSendLimit(args[0])
+	}
+	if err == nil && cmd.Flags().Changed("hourly") {
+		err = l.SetHourly(limHourly)
+	}
+	if err == nil && cmd.Flags().Changed("recipients") {
+		err = l.SetRecipients(limRecipients)
+	}
+	if err == nil && cmd.Flags().Changed("daily") {
+		err = l.SetDaily(limDaily)
+	}
+	if err == nil && cmd.Flags().Changed("action") {
+		err = l.SetAction(limAction)
+	}
+	if err == nil && cmd.Flags().Changed("disable") {
+		err = l.SetDisable(limDisable)
+	}
+	return err
+}
+
+// limitClear
+func limitClear(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	err = mdb.DeleteSendLimit(args[0])
+	return err
+}
+
+// limitShow
+func limitShow(cmd *cobra.Command, args []string) error {
+	var (
+		err   error
+		llist []*maildb.SendLimit
+	)
+
+	owner := "*"
+	if len(args) > 0 {
+		owner = args[0]
+	}
+	if llist, err = mdb.FindSendLimit(owner); err != nil {
+		return err
+	}
+	now := time.Now()
+	for i, l := range llist {
+		if i > 0 {
+			cmd.Println()
+		}
+		cmd.Printf("Owner:\t\t%s\nHourly:\t\t%s\nRecipients:\t%s\nDaily:\t\t%s\n",
+			l.Owner(), l.Hourly(), l.Recipients(), l.Daily())
+		cmd.Printf("Action:\t\t%s\nDisable:\t%v\n", l.Action(), l.Disables())
+		if !l.IsDomain() {
+			hourly, daily, err := mdb.SendCounts(l.Owner(), now)
+			if err != nil {
+				return err
+			}
+			cmd.Printf("Sent:\t\t%d messages in the last hour, %d recipients in the last day\n",
+				hourly, daily)
+		}
+	}
+	return nil
+}
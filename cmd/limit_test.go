@@ -0,0 +1,121 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestLimitCmd
+func TestLimitCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestLimitCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestLimitCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "limit", "show"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != maildb.ErrMdbLimitNotFound {
+		t.Errorf("Show no limits: expected ErrMdbLimitNotFound, got %v", err)
+	}
+	args = []string{"-d", dbfile, "limit", "set", "@pobox.org", "--hourly", "100", "--daily", "1000"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Set pobox.org: Unexpected error, %s", err)
+	}
+	// flags stay changed from one run to the next here so give them all
+	args = []string{"-d", dbfile, "limit", "set", "jeff@pobox.org", "-H", "0", "-r", "20", "-D", "0",
+		"-a", "reject", "--disable"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Set jeff: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "limit", "set", "@pobox.org", "-H", "100", "-r", "0", "-D", "0",
+		"-a", "defer", "--disable=false"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Change pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "limit", "set", "jeff@pobox.org", "-a", "drop"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != maildb.ErrMdbLimitAction {
+		t.Errorf("Set jeff drop: expected ErrMdbLimitAction, got %v", err)
+	}
+	args = []string{"-d", dbfile, "limit", "set", "sales@pobox.org", "-H", "1"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != maildb.ErrMdbNotMbox {
+		t.Errorf("Set sales: expected ErrMdbNotMbox, got %v", err)
+	}
+
+	args = []string{"-d", dbfile, "limit", "show"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show: Unexpected error, %s", err)
+	}
+	if out != `Owner:		@pobox.org
+Hourly:		100
+Recipients:	--
+Daily:		--
+Action:		DEFER
+Disable:	false
+
+Owner:		jeff@pobox.org
+Hourly:		--
+Recipients:	20
+Daily:		--
+Action:		REJECT
+Disable:	true
+Sent:		0 messages in the last hour, 0 recipients in the last day
+` {
+		t.Errorf("Show: did not get expected output, got %s", out)
+	}
+
+	args = []string{"-d", dbfile, "limit", "clear", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Clear jeff: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "limit", "clear", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != maildb.ErrMdbLimitNotFound {
+		t.Errorf("Clear jeff again: expected ErrMdbLimitNotFound, got %v", err)
+	}
+	args = []string{"-d", dbfile, "limit", "show", "*@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show pobox.org: Unexpected error, %s", err)
+	}
+	if !strings.HasPrefix(out, "Owner:\t\t@pobox.org\n") || strings.Contains(out, "jeff") || errout != "" {
+		t.Errorf("Show pobox.org: did not get expected output, got %s%s", out, errout)
+	}
+}
@@ -0,0 +1,330 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listOwner string
+	listInput string
+)
+
+// createList make a new list
+var createList = &cobra.Command{
+	Use:   "create list [member ...]",
+	Short: "Create a distribution list",
+	Long: `Create the distribution list with the address list, either a local name
+or user@domain, and any members given. A member is a local name or a
+full address.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: listCreate,
+}
+
+// deleteList remove a list
+var deleteList = &cobra.Command{
+	Use:   "delete list",
+	Short: "Delete a distribution list",
+	Long:  `Delete the distribution list and all its members from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  listDelete,
+}
+
+// editList change the owner
+var editList = &cobra.Command{
+	Use:   "edit list",
+	Short: "Change the owner of a distribution list",
+	Long: `Change the owner of the distribution list. The owner gets the bounces
+for mail sent to the list.`,
+	Args: cobra.ExactArgs(1),
+	RunE: listEdit,
+}
+
+// addMember add members
+var addMember = &cobra.Command{
+	Use:   "add-member list member ...",
+	Short: "Add members to a distribution list",
+	Long:  `Add one or more members to the distribution list.`,
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  listAddMember,
+}
+
+// removeMember remove members
+var removeMember = &cobra.Command{
+	Use:   "remove-member list member ...",
+	Short: "Remove members from a distribution list",
+	Long:  `Remove one or more members from the distribution list.`,
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  listRemoveMember,
+}
+
+// showList display a list
+var showList = &cobra.Command{
+	Use:   "show list",
+	Short: "Display a distribution list",
+	Long:  `Display the owner and members of the distribution list to the standard output.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  listShow,
+}
+
+// importList load an include file
+var importList = &cobra.Command{
+	Use:   "import list",
+	Short: "Import an :include: file into a distribution list",
+	Long: `Import the members of a distribution list from an :include: file, named
+by the -i flag (default stdin '-'). The addresses in the file are separated
+by commas or white space. The list is created if it does not exist and
+members it already has are skipped.`,
+	Args: cobra.ExactArgs(1),
+	RunE: listImport,
+}
+
+// exportList write an include file
+var exportList = &cobra.Command{
+	Use:   "export list",
+	Short: "Export a distribution list as an :include: file",
+	Long: `Export the members of a distribution list to the standard output in the
+:include: file format, one address per line.`,
+	Args: cobra.ExactArgs(1),
+	RunE: listExport,
+}
+
+// linkage to the list command
+func init() {
+	listCmd.AddCommand(createList)
+	createList.Flags().StringVarP(&listOwner, "owner", "o", "",
+		"Address that gets the bounces for the list")
+	listCmd.AddCommand(deleteList)
+	listCmd.AddCommand(editList)
+	editList.Flags().StringVarP(&listOwner, "owner", "o", "",
+		"Address that gets the bounces for the list, empty for none")
+	listCmd.AddCommand(addMember)
+	listCmd.AddCommand(removeMember)
+	listCmd.AddCommand(showList)
+	listCmd.AddCommand(importList)
+	importList.Flags().StringVarP(&listInput, "input", "i", "-",
+		"Input file in :include: format")
+	importList.Flags().StringVarP(&listOwner, "owner", "o", "",
+		"Address that gets the bounces for a new list")
+	listCmd.AddCommand(exportList)
+}
+
+// listCreate
+func listCreate(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		l   *maildb.DistList
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if l, err = mdb.InsertList(args[0], listOwner); err != nil {
+		return err
+	}
+	for _, m := range args[1:] {
+		if err = l.AddMember(m); err != nil {
+			return fmt.Errorf("%s: %s", m, err)
+		}
+	}
+	return nil
+}
+
+// listDelete
+func listDelete(cmd *cobra.Command, args []string) error {
+	return mdb.DeleteList(args[0])
+}
+
+// listEdit
+func listEdit(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		l   *maildb.DistList
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if l, err = mdb.GetList(args[0]); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("owner") {
+		err = l.SetOwner(listOwner)
+	}
+	return err
+}
+
+// listAddMember
+func listAddMember(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		l   *maildb.DistList
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if l, err = mdb.GetList(args[0]); err != nil {
+		return err
+	}
+	for _, m := range args[1:] {
+		if err = l.AddMember(m); err != nil {
+			err = fmt.Errorf("%s: %s", m, err)
+			break
+		}
+	}
+	return err
+}
+
+// listRemoveMember
+func listRemoveMember(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		l   *maildb.DistList
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if l, err = mdb.GetList(args[0]); err != nil {
+		return err
+	}
+	for _, m := range args[1:] {
+		if err = l.RemoveMember(m); err != nil {
+			err = fmt.Errorf("%s: %s", m, err)
+			break
+		}
+	}
+	return err
+}
+
+// listShow
+func listShow(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		l   *maildb.DistList
+	)
+
+	if l, err = mdb.LookupList(args[0]); err != nil {
+		return err
+	}
+	cmd.Printf("List:\t\t%s\nOwner:\t\t%s\n", l.Address(), l.Owner())
+	if len(l.Members()) == 0 {
+		cmd.Printf("Members:\t--\n")
+	}
+	for i, m := range l.Members() {
+		if i == 0 {
+			cmd.Printf("Members:\t%s\n", m)
+		} else {
+			cmd.Printf("\t\t%s\n", m)
+		}
+	}
+	return nil
+}
+
+// listImport
+func listImport(cmd *cobra.Command, args []string) error {
+	var (
+		err     error
+		in      io.Reader = cmd.InOrStdin()
+		l       *maildb.DistList
+		members []string
+	)
+
+	if listInput != "-" {
+		f, err := os.Open(listInput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if members, err = readInclude(in); err != nil {
+		return err
+	}
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if l, err = mdb.GetList(args[0]); err == maildb.ErrMdbListNotFound {
+		l, err = mdb.InsertList(args[0], listOwner)
+	}
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err = l.AddMember(m); err == maildb.ErrMdbDupMember {
+			err = nil
+		} else if err != nil {
+			err = fmt.Errorf("%s: %s", m, err)
+			break
+		}
+	}
+	return err
+}
+
+// readInclude
+// Read the addresses of an :include: file. They are separated by commas
+// or white space and a '#' starts a comment. Only addresses can be list
+// members, not the commands and files an include could also have.
+func readInclude(in io.Reader) ([]string, error) {
+	var (
+		lines   = bufio.NewScanner(in)
+		lineno  int
+		members []string
+	)
+
+	sep := func(c rune) bool { return c == ',' || c == ' ' || c == '\t' }
+	for lines.Scan() {
+		line := lines.Text()
+		lineno++
+		if com := strings.IndexByte(line, '#'); com != -1 {
+			line = line[0:com]
+		}
+		for _, m := range strings.FieldsFunc(line, sep) {
+			if strings.ContainsAny(m[0:1], "|/:\"") {
+				return nil, fmt.Errorf("At line %d: %s is not an address", lineno, m)
+			}
+			members = append(members, m)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("At line %d: nothing found to import", lineno)
+	}
+	return members, nil
+}
+
+// listExport
+func listExport(cmd *cobra.Command, args []string) error {
+	l, err := mdb.LookupList(args[0])
+	if err != nil {
+		return err
+	}
+	cmd.Print(l.Export())
+	return nil
+}
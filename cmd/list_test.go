@@ -0,0 +1,191 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestDistListCmd
+func TestDistListCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		incfile     string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestDistListCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestDistListCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	// a mailbox cannot be a list
+	args = []string{"-d", dbfile, "list", "create", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("List create jeff@pobox.org: should have failed")
+	} else if err != maildb.ErrMdbListAddress {
+		t.Errorf("List create jeff@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "list", "create", "staff@pobox.org",
+		"jeff@pobox.org", "dave@pobox.org", "-o", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("List create staff@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "" {
+		t.Errorf("List create staff@pobox.org: did not expect output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("List create staff@pobox.org: did not expect error output, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "list", "add-member", "staff@pobox.org",
+		"bill@example.com", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("List add-member dave@pobox.org again: should have failed")
+	} else if err.Error() != "dave@pobox.org: list member already exists" {
+		t.Errorf("List add-member dave@pobox.org again: Unexpected error, %s", err)
+	}
+	// nothing was added
+	args = []string{"-d", dbfile, "list", "show", "staff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("List show staff@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "List:\t\tstaff@pobox.org\nOwner:\t\tjeff@pobox.org\n"+
+		"Members:\tdave@pobox.org\n\t\tjeff@pobox.org\n" {
+		t.Errorf("List show staff@pobox.org: did not get expected output, got %s", out)
+	}
+	args = []string{"-d", dbfile, "list", "add-member", "staff@pobox.org", "bill@example.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("List add-member bill@example.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "list", "remove-member", "staff@pobox.org", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("List remove-member dave@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "list", "edit", "staff@pobox.org", "-o", ""}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("List edit staff@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "list", "export", "staff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("List export staff@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "bill@example.com\njeff@pobox.org\n" {
+		t.Errorf("List export staff@pobox.org: did not get expected output, got %s", out)
+	}
+
+	// import an include file into a new local list
+	incfile = filepath.Join(dir, "admins")
+	if err = ioutil.WriteFile(incfile, []byte(`# the admins
+root, jeff@pobox.org
+	bill@example.com  dave@pobox.org
+`), 0644); err != nil {
+		t.Errorf("Write include file: %s", err)
+	}
+	args = []string{"-d", dbfile, "list", "import", "admins", "-i", incfile, "-o", "root"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("List import admins: Unexpected error, %s", err)
+	}
+	// a second time only adds what is new
+	args = []string{"-d", dbfile, "list", "import", "admins", "-i", "-"}
+	out, errout, err = doTest(rootCmd, "jeff@pobox.org, mary@example.com\n", args)
+	if err != nil {
+		t.Errorf("List import admins again: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "list", "import", "admins", "-i", "-"}
+	out, errout, err = doTest(rootCmd, "jeff@pobox.org\n|/usr/bin/vacation\n", args)
+	if err == nil {
+		t.Errorf("List import of a pipe: should have failed")
+	} else if err.Error() != "At line 2: |/usr/bin/vacation is not an address" {
+		t.Errorf("List import of a pipe: Unexpected error, %s", err)
+	}
+
+	// Open the database directly so we can test views.
+	// These are the lookups postfix makes with the alias queries
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT recipient FROM etc_aliases WHERE local_user = 'admins' ORDER BY recipient
+`
+	expectedRes = []maildb.QueryRes{
+		{"recipient": "bill@example.com"},
+		{"recipient": "dave@pobox.org"},
+		{"recipient": "jeff@pobox.org"},
+		{"recipient": "mary@example.com"},
+		{"recipient": "root"},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup admins: %s", err)
+	}
+	q = `
+SELECT recipient FROM etc_aliases WHERE local_user = 'owner-admins'
+`
+	expectedRes = []maildb.QueryRes{
+		{"recipient": "root"},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup owner-admins: %s", err)
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox || '@' || domain_name = 'staff@pobox.org'
+ ORDER BY recipient
+`
+	expectedRes = []maildb.QueryRes{
+		{"recipient": "bill@example.com"},
+		{"recipient": "jeff@pobox.org"},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup staff@pobox.org: %s", err)
+	}
+	mdb.Close()
+
+	args = []string{"-d", dbfile, "list", "delete", "staff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("List delete staff@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "list", "show", "staff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("List show deleted staff@pobox.org: should have failed")
+	} else if err != maildb.ErrMdbListNotFound {
+		t.Errorf("List show deleted staff@pobox.org: Unexpected error, %s", err)
+	}
+}
@@ -64,7 +64,7 @@ var addMailbox = &cobra.Command{
 	Use:   "mailbox address [ flags ]",
 	Short: "Add an mailbox and its address into the database",
 	Long: `Add an mailbox into the database. The address must be in an already
-existing vmailbox domain. The flags set the various login parameters such as password and
+existing vmailbox or local domain. The flags set the various login parameters such as password and
 quota.`,
 	Args: cobra.ExactArgs(1), // mailbox recipient ...
 	RunE: mailboxAdd,
@@ -75,7 +75,8 @@ var deleteMailbox = &cobra.Command{
 	Use:   "mailbox address",
 	Short: "Delete an mailbox and its address from the database.",
 	Long: `Delete an address mailbox and its address from the database.
-All of the aliases that point to it must be changed or deleted first`,
+All of the aliases that point to it must be changed or deleted first.
+The --relocated flag leaves a relocated entry so senders are told where the user went.`,
 	Args: cobra.ExactArgs(1), // mailbox name
 	RunE: mailboxDelete,
 }
@@ -120,6 +121,8 @@ func init() {
 	addMailbox.Flags().BoolVarP(&enable, "no-enable", "E", false,
 		"Enable this mailbox for access")
 	deleteCmd.AddCommand(deleteMailbox)
+	deleteMailbox.Flags().StringVarP(&relocTarget, "relocated", "r", "",
+		"Leave a relocated entry with this new location")
 	editCmd.AddCommand(editMailbox)
 	editMailbox.Flags().StringVarP(&pw_type, "type", "t", "PLAIN",
 		"Password encoding type")
@@ -330,7 +333,18 @@ func mailboxAdd(cmd *cobra.Command, args []string) error {
 
 // mailboxDelete the mailbox and address in the first arg
 func mailboxDelete(cmd *cobra.Command, args []string) error {
-	return mdb.DeleteVMailbox(args[0])
+	var err error
+
+	if err = relocatedCheck(cmd); err != nil {
+		return err
+	}
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if err = mdb.DeleteVMailbox(args[0]); err == nil {
+		err = relocatedLeave(cmd, args[0])
+	}
+	return err
 }
 
 // mailboxEdit the mailbox of the address in the first arg
@@ -0,0 +1,717 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var mcHostname string
+
+// importMainCf
+var importMainCf = &cobra.Command{
+	Use:   "maincf",
+	Short: "Import the domains and tables of a postfix main.cf",
+	Long: `Import the domains and lookup tables of a postfix main.cf from the file named
+by the -i flag (default stdin '-'). The domains in mydestination, virtual_alias_domains,
+virtual_mailbox_domains and relay_domains are local, virtual, vmailbox and relay class
+domains. Parameters are expanded the way postfix does, with its defaults for the ones
+main.cf does not set. The source files of the hash, btree, cdb, lmdb, dbm and texthash
+tables used by virtual_alias_maps, transport_maps, the canonical, relocated and bcc maps
+and the check_*_access restrictions are imported. So are the restriction classes.
+Anything that cannot be followed is reported. The whole import is one transaction.`,
+	Args: cobra.NoArgs,
+	RunE: maincfImport,
+}
+
+func init() {
+	importCmd.AddCommand(importMainCf)
+	importMainCf.Flags().StringVarP(&mcHostname, "myhostname", "H", "",
+		"myhostname if main.cf does not set it (default this host's name)")
+}
+
+// mainCf
+// the parameters of a main.cf
+type mainCf struct {
+	params   map[string]string
+	defaults map[string]string
+}
+
+// mainCfDefaults
+// the postfix defaults of the parameters we use
+var mainCfDefaults = map[string]string{
+	"config_directory":        "/etc/postfix",
+	"mydestination":           "$myhostname, localhost.$mydomain, localhost",
+	"relay_domains":           "",
+	"virtual_alias_domains":   "$virtual_alias_maps",
+	"virtual_alias_maps":      "$virtual_maps",
+	"virtual_mailbox_domains": "$virtual_mailbox_maps",
+	"canonical_maps":          "",
+	"transport_maps":          "",
+}
+
+// readMainCf
+// A line that starts with white space continues the one before.
+// Blank lines and lines that start with '#' are skipped.
+func readMainCf(r io.Reader, hostname string) (*mainCf, error) {
+	var (
+		lines  = bufio.NewScanner(r)
+		lineno int
+		name   string
+	)
+
+	mc := &mainCf{
+		params:   make(map[string]string),
+		defaults: make(map[string]string),
+	}
+	for k, v := range mainCfDefaults {
+		mc.defaults[k] = v
+	}
+	mc.defaults["myhostname"] = hostname
+	if dot := strings.IndexByte(hostname, '.'); dot >= 0 {
+		mc.defaults["mydomain"] = hostname[dot+1:]
+	} else {
+		mc.defaults["mydomain"] = "localdomain"
+	}
+	for lines.Scan() {
+		line := lines.Text()
+		lineno++
+		t := strings.TrimSpace(line)
+		if t == "" || t[0] == '#' {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if name == "" {
+				return nil, fmt.Errorf("At line %d: continuation line without a parameter", lineno)
+			}
+			if mc.params[name] == "" {
+				mc.params[name] = t
+			} else {
+				mc.params[name] += " " + t
+			}
+			continue
+		}
+		eq := strings.IndexByte(t, '=')
+		if eq < 1 {
+			return nil, fmt.Errorf("At line %d: not a 'name = value' parameter", lineno)
+		}
+		name = strings.TrimSpace(t[:eq])
+		mc.params[name] = strings.TrimSpace(t[eq+1:])
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return mc, nil
+}
+
+// isSet
+func (mc *mainCf) isSet(name string) bool {
+	_, ok := mc.params[name]
+	return ok
+}
+
+// raw
+// the value before expansion
+func (mc *mainCf) raw(name string) string {
+	if v, ok := mc.params[name]; ok {
+		return v
+	}
+	return mc.defaults[name]
+}
+
+// value
+// the expanded value of a parameter
+func (mc *mainCf) value(name string) (string, error) {
+	return mc.expand(mc.raw(name), 0)
+}
+
+// expand
+// $name, ${name} and $(name) are the expanded value of name. ${name?text}
+// is text if name is not empty and ${name:text} is text if it is.
+// $$ is a '$'.
+func (mc *mainCf) expand(s string, depth int) (string, error) {
+	var out strings.Builder
+
+	if depth > 100 {
+		return "", fmt.Errorf("parameter expansion loop in %q", s)
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		var name, op, text string
+		switch c := s[i]; {
+		case c == '$':
+			out.WriteByte('$')
+			continue
+		case c == '{' || c == '(':
+			end := matchingClose(s, i)
+			if end < 0 {
+				return "", fmt.Errorf("unbalanced %c in %q", c, s)
+			}
+			inner := s[i+1 : end]
+			i = end
+			if n := strings.IndexAny(inner, "?:"); n >= 0 {
+				name, op, text = inner[:n], inner[n:n+1], inner[n+1:]
+				if len(text) > 1 && text[0] == '{' && text[len(text)-1] == '}' {
+					text = text[1 : len(text)-1]
+				}
+			} else {
+				name = inner
+			}
+		default:
+			start := i
+			for i < len(s) && isParamByte(s[i]) {
+				i++
+			}
+			name = s[start:i]
+			i--
+			if name == "" { // just a '$'
+				out.WriteByte('$')
+				continue
+			}
+		}
+		v, err := mc.expand(mc.raw(name), depth+1)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case op == "?" && v != "", op == ":" && v == "":
+			if v, err = mc.expand(text, depth+1); err != nil {
+				return "", err
+			}
+		case op != "":
+			v = ""
+		}
+		out.WriteString(v)
+	}
+	return out.String(), nil
+}
+
+func isParamByte(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// matchingClose
+// the index of the ')' or '}' that closes the one at s[open]
+func matchingClose(s string, open int) int {
+	var depth int
+
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{', '(':
+			depth++
+		case '}', ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitList
+// split a postfix list on commas and white space. A {...} is one element.
+func splitList(s string) []string {
+	var (
+		elems []string
+		start = -1
+		depth int
+	)
+
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch c := s[i]; {
+			case c == '{':
+				depth++
+			case c == '}' && depth > 0:
+				depth--
+			}
+			if depth > 0 || (s[i] != ',' && s[i] != ' ' && s[i] != '\t') {
+				if start < 0 {
+					start = i
+				}
+				continue
+			}
+		}
+		if start >= 0 {
+			elems = append(elems, s[start:i])
+			start = -1
+		}
+	}
+	return elems
+}
+
+// sourceTypes
+// the table types made by postmap from a source file of the same name
+var sourceTypes = map[string]bool{
+	"hash": true, "btree": true, "cdb": true, "lmdb": true,
+	"dbm": true, "sdbm": true, "texthash": true,
+}
+
+// tableSource
+// the source file of a "type:name" table or an error saying why we
+// cannot follow it
+func tableSource(table string) (string, error) {
+	kv := strings.SplitN(table, ":", 2)
+	if len(kv) != 2 {
+		return "", fmt.Errorf("not a lookup table")
+	}
+	if kv[0] == "proxy" {
+		return tableSource(kv[1])
+	}
+	if !sourceTypes[kv[0]] {
+		return "", fmt.Errorf("%s tables cannot be followed", kv[0])
+	}
+	return kv[1], nil
+}
+
+// mainCfImport
+// the state of one import
+type mainCfImport struct {
+	cmd        *cobra.Command
+	mc         *mainCf
+	transports *transportSet
+	access     *accessSet
+	classes    map[string]string // domain -> class given by this import
+	seen       map[string]bool   // the tables already imported
+}
+
+// report
+// something that did not make it into the database
+func (mi *mainCfImport) report(param, elem, format string, args ...interface{}) {
+	mi.cmd.PrintErrf("Not imported: %s: %s: %s\n", param, elem, fmt.Sprintf(format, args...))
+}
+
+// maincfImport
+func maincfImport(cmd *cobra.Command, args []string) error {
+	var (
+		mc  *mainCf
+		err error
+	)
+
+	hostname := mcHostname
+	if hostname == "" {
+		if hostname, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	if mc, err = readMainCf(cmd.InOrStdin(), hostname); err != nil {
+		return err
+	}
+	mi := &mainCfImport{
+		cmd:     cmd,
+		mc:      mc,
+		classes: make(map[string]string),
+		seen:    make(map[string]bool),
+	}
+	if mi.transports, err = newTransportSet(); err != nil {
+		return err
+	}
+	if mi.access, err = newAccessSet(); err != nil {
+		return err
+	}
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	catchalls = nil
+	cidrOnly = nil
+	// in the order postfix decides the address class
+	for _, dl := range []struct{ param, class string }{
+		{"mydestination", "local"},
+		{"virtual_alias_domains", "virtual"},
+		{"virtual_mailbox_domains", "vmailbox"},
+		{"relay_domains", "relay"},
+	} {
+		if err = mi.domains(dl.param, dl.class); err != nil {
+			return err
+		}
+	}
+	if err = mi.restrictionClasses(); err != nil {
+		return err
+	}
+	if err = mi.maps(); err != nil {
+		return err
+	}
+	if err = mi.accessChecks(); err != nil {
+		return err
+	}
+	for _, p := range []string{"alias_maps", "virtual_mailbox_maps",
+		"smtpd_sender_login_maps", "relay_recipient_maps"} {
+		if mc.isSet(p) {
+			mi.report(p, mc.raw(p), "there is no import for this map")
+		}
+	}
+	for _, c := range catchalls {
+		catchallWarn(cmd, c)
+	}
+	for _, c := range cidrOnly {
+		cidrWarn(cmd, c)
+	}
+	cidrOnly = nil
+	return err
+}
+
+// domains
+// the domains of a domain list parameter
+func (mi *mainCfImport) domains(param, class string) error {
+	list, err := mi.mc.value(param)
+	if err != nil {
+		return fmt.Errorf("%s: %s", param, err)
+	}
+	for _, e := range splitList(list) {
+		var names []string
+		switch {
+		case strings.HasPrefix(e, "!"):
+			mi.report(param, e, "exclusions are not supported")
+			continue
+		case strings.HasPrefix(e, "/"):
+			// a file of names
+			buf, err := ioutil.ReadFile(e)
+			if err != nil {
+				mi.report(param, e, "%s", err)
+				continue
+			}
+			for _, line := range strings.Split(string(buf), "\n") {
+				if t := strings.TrimSpace(line); t != "" && t[0] != '#' {
+					names = append(names, splitList(t)...)
+				}
+			}
+		case strings.Contains(e, ":"):
+			// a table with the domains as keys
+			src, err := tableSource(e)
+			if err == nil {
+				names, err = tableKeys(src)
+			}
+			if err != nil {
+				mi.report(param, e, "%s", err)
+				continue
+			}
+		default:
+			names = []string{e}
+		}
+		for _, n := range names {
+			if err = mi.domain(param, strings.ToLower(n), class); err != nil {
+				return fmt.Errorf("%s: %s: %s", param, n, err)
+			}
+		}
+	}
+	return nil
+}
+
+// tableKeys
+// the keys of a table source file that are domain names
+func tableKeys(path string) ([]string, error) {
+	var keys []string
+
+	err := mainCfTable(path, SIMPLE, func(tokens []string) error {
+		if !strings.ContainsAny(tokens[0], "@:/") && !strings.HasPrefix(tokens[0], ".") {
+			keys = append(keys, tokens[0])
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// domain
+// make the domain or check the one that is already there
+func (mi *mainCfImport) domain(param, name, class string) error {
+	if c, ok := mi.classes[name]; ok {
+		if c != class {
+			mi.report(param, name, "already a %s domain", c)
+		}
+		return nil
+	}
+	d, err := mdb.GetDomain(name)
+	switch err {
+	case maildb.ErrMdbDomainNotFound:
+		if d, err = mdb.InsertDomain(name); err != nil {
+			return err
+		}
+	case nil:
+		if d.Class() != class && d.Class() != "internet" {
+			mi.report(param, name, "already a %s domain", d.Class())
+			mi.classes[name] = d.Class()
+			return nil
+		}
+	default:
+		return err
+	}
+	if d.Class() != class {
+		if err = d.SetClass(class); err != nil {
+			return err
+		}
+	}
+	mi.classes[name] = class
+	return nil
+}
+
+// restrictionClasses
+// the definitions of smtpd_restriction_classes go in as they are
+func (mi *mainCfImport) restrictionClasses() error {
+	list, err := mi.mc.value("smtpd_restriction_classes")
+	if err != nil {
+		return fmt.Errorf("smtpd_restriction_classes: %s", err)
+	}
+	for _, name := range splitList(list) {
+		if !mi.mc.isSet(name) {
+			mi.report("smtpd_restriction_classes", name, "it is not defined")
+			continue
+		}
+		if _, err = mdb.InsertRestrictionClass(name, mi.mc.raw(name)); err != nil {
+			return fmt.Errorf("smtpd_restriction_classes: %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// maps
+// the tables of the map parameters postdove has imports for
+func (mi *mainCfImport) maps() error {
+	canonical := func(tokens []string) error {
+		if err := procSenderCanonical(tokens); err != nil {
+			return err
+		}
+		return procRecipientCanonical(tokens)
+	}
+	for _, m := range []struct {
+		param  string
+		use    ImportType
+		worker func([]string) error
+	}{
+		{"virtual_alias_maps", POSTFIX, mainCfVirtual},
+		{"transport_maps", SIMPLE, mi.transport},
+		{"canonical_maps", POSTFIX, canonical},
+		{"sender_canonical_maps", POSTFIX, procSenderCanonical},
+		{"recipient_canonical_maps", POSTFIX, procRecipientCanonical},
+		{"relocated_maps", SIMPLE, procRelocated},
+		{"sender_bcc_maps", POSTFIX, procSenderBcc},
+		{"recipient_bcc_maps", POSTFIX, procRecipientBcc},
+	} {
+		list, err := mi.mc.value(m.param)
+		if err != nil {
+			return fmt.Errorf("%s: %s", m.param, err)
+		}
+		for _, table := range splitList(list) {
+			if err = mi.table(m.param, table, m.use, m.worker); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// table
+// import the source file of a table, once
+func (mi *mainCfImport) table(param, table string, use ImportType, worker func([]string) error) error {
+	src, err := tableSource(table)
+	if err != nil {
+		mi.report(param, table, "%s", err)
+		return nil
+	}
+	if mi.seen[param+" "+src] {
+		return nil
+	}
+	mi.seen[param+" "+src] = true
+	if err = mainCfTable(src, use, worker); err != nil {
+		if os.IsNotExist(err) {
+			mi.report(param, table, "%s", err)
+			return nil
+		}
+		return fmt.Errorf("%s: %s: %s", param, src, err)
+	}
+	return nil
+}
+
+// mainCfTable
+// run the source file of a table through an import worker. An empty
+// file is not an error here, the table may just not be used yet.
+func mainCfTable(path string, use ImportType, worker func([]string) error) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	empty := true
+	for _, line := range strings.Split(string(buf), "\n") {
+		if t := strings.TrimSpace(line); t != "" && t[0] != '#' {
+			empty = false
+			break
+		}
+	}
+	if empty {
+		return nil
+	}
+	cmd := &cobra.Command{}
+	cmd.SetIn(bytes.NewReader(buf))
+	return procImport(cmd, use, worker)
+}
+
+// mainCfVirtual
+// A key without an '@' in a virtual(5) table makes it a virtual alias
+// domain. That was done with the domains.
+func mainCfVirtual(tokens []string) error {
+	if !strings.Contains(tokens[0], "@") {
+		return nil
+	}
+	return procVirtual(tokens)
+}
+
+// transport
+// a transport(5) entry sets the transport of its domain or address
+func (mi *mainCfImport) transport(tokens []string) error {
+	if len(tokens) != 2 {
+		return fmt.Errorf("A transport entry must be 'pattern transport:nexthop'")
+	}
+	key := strings.ToLower(tokens[0])
+	if key == "*" || strings.HasPrefix(key, ".") || strings.HasSuffix(key, "@") {
+		mi.report("transport_maps", key, "only domains and addresses can have a transport")
+		return nil
+	}
+	name, err := mi.transports.get(tokens[1])
+	if err != nil {
+		return err
+	}
+	if strings.Contains(key, "@") {
+		a, err := mdb.GetOrInsAddress(key)
+		if err != nil {
+			return err
+		}
+		return a.SetTransport(name)
+	}
+	d, err := mainCfGetDomain(key)
+	if err != nil {
+		return err
+	}
+	return d.SetTransport(name)
+}
+
+// mainCfGetDomain
+// the domain, a new one has the default class
+func mainCfGetDomain(name string) (*maildb.Domain, error) {
+	d, err := mdb.GetDomain(name)
+	if err == maildb.ErrMdbDomainNotFound {
+		d, err = mdb.InsertDomain(name)
+	}
+	return d, err
+}
+
+// accessChecks
+// the access tables of the check_*_access restrictions in the
+// smtpd_*_restrictions parameters and the restriction classes
+func (mi *mainCfImport) accessChecks() error {
+	var params []string
+
+	for p := range mi.mc.params {
+		if strings.HasSuffix(p, "_restrictions") {
+			params = append(params, p)
+		}
+	}
+	sort.Strings(params)
+	if list, err := mi.mc.value("smtpd_restriction_classes"); err == nil {
+		params = append(params, splitList(list)...)
+	}
+	for _, p := range params {
+		list, err := mi.mc.value(p)
+		if err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+		elems := splitList(list)
+		for i := 0; i+1 < len(elems); i++ {
+			check := elems[i]
+			if !strings.HasPrefix(check, "check_") || !strings.HasSuffix(check, "_access") {
+				continue
+			}
+			i++
+			var worker func([]string) error
+			switch check {
+			case "check_client_access":
+				worker = mi.accessCheck("client")
+			case "check_sender_access":
+				worker = mi.accessCheck("sender")
+			case "check_helo_access":
+				worker = mi.accessCheck("helo")
+			case "check_recipient_access":
+				worker = mi.recipientAccess
+			default:
+				mi.report(p, check+" "+elems[i], "there is no import for %s", check)
+				continue
+			}
+			if err = mi.table(check, elems[i], SIMPLE, worker); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// accessCheck
+// an access(5) entry is a check with a rule for its action
+func (mi *mainCfImport) accessCheck(class string) func([]string) error {
+	return func(tokens []string) error {
+		if len(tokens) != 2 {
+			return fmt.Errorf("An access entry must be 'pattern action'")
+		}
+		name, err := mi.access.get(tokens[1])
+		if err != nil {
+			return err
+		}
+		return procCheck(class, []string{tokens[0], name})
+	}
+}
+
+// recipientAccess
+// the recipient checks are the rclass of the domain or address
+func (mi *mainCfImport) recipientAccess(tokens []string) error {
+	if len(tokens) != 2 {
+		return fmt.Errorf("An access entry must be 'pattern action'")
+	}
+	key := strings.ToLower(tokens[0])
+	if strings.HasPrefix(key, ".") || strings.HasSuffix(key, "@") {
+		mi.report("check_recipient_access", key, "only domains and addresses can have an access rule")
+		return nil
+	}
+	name, err := mi.access.get(tokens[1])
+	if err != nil {
+		return err
+	}
+	if strings.Contains(key, "@") {
+		a, err := mdb.GetOrInsAddress(key)
+		if err != nil {
+			return err
+		}
+		return a.SetRclass(name)
+	}
+	d, err := mainCfGetDomain(key)
+	if err != nil {
+		return err
+	}
+	return d.SetRclass(name)
+}
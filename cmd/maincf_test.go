@@ -0,0 +1,247 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMainCfExpand
+func TestMainCfExpand(t *testing.T) {
+	fmt.Println("TestMainCfExpand")
+
+	mc, err := readMainCf(strings.NewReader(`# a comment
+mydestination = $myhostname, localhost.$mydomain,
+	# a comment inside
+    localhost
+relay = ${relayhost?{smtp:$relayhost}} $(relayhost:none) ${nothing:empty}
+relayhost = [mx.example.net]
+loop = $loop
+cost = $$5 and $ alone
+`), "mail.example.com")
+	if err != nil {
+		t.Errorf("readMainCf: Unexpected error, %s", err)
+		return
+	}
+	for _, c := range []struct {
+		name, value string
+	}{
+		{"mydestination", "mail.example.com, localhost.example.com, localhost"},
+		{"relay", "smtp:[mx.example.net]  empty"},
+		{"cost", "$5 and $ alone"},
+		{"virtual_alias_domains", ""},
+		{"config_directory", "/etc/postfix"},
+	} {
+		v, err := mc.value(c.name)
+		if err != nil {
+			t.Errorf("%s: Unexpected error, %s", c.name, err)
+		} else if v != c.value {
+			t.Errorf("%s: expected %q, got %q", c.name, c.value, v)
+		}
+	}
+	if _, err = mc.value("loop"); err == nil || !strings.HasPrefix(err.Error(), "parameter expansion loop") {
+		t.Errorf("loop: expected an expansion loop, got %v", err)
+	}
+	if l := splitList("a, b,c\td  inline:{ x=y, z }"); strings.Join(l, "|") != "a|b|c|d|inline:{ x=y, z }" {
+		t.Errorf("splitList: unexpected %q", l)
+	}
+	_, err = readMainCf(strings.NewReader("  indented = first\n"), "mail")
+	if err == nil || err.Error() != "At line 1: continuation line without a parameter" {
+		t.Errorf("continuation first: expected error, got %v", err)
+	}
+	_, err = readMainCf(strings.NewReader("myhostname\n"), "mail")
+	if err == nil || err.Error() != "At line 1: not a 'name = value' parameter" {
+		t.Errorf("no value: expected error, got %v", err)
+	}
+}
+
+// TestMainCfCmd
+func TestMainCfCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestMainCfCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestMainCfCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = filepath.Join(dir, "test.db")
+
+	args = []string{"create", "-d", dbfile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+
+	files := map[string]string{
+		"main.cf": `# main.cf of a hand configured server
+mydomain = example.com
+mydestination = $myhostname, localhost.$mydomain,
+	localhost, $mydomain
+virtual_alias_maps = hash:$config_directory/virtual, mysql:/etc/postfix/mysql-virtual.cf
+virtual_mailbox_domains = vmail.org, !bad.org,
+    $config_directory/vdomains
+virtual_mailbox_maps = hash:$config_directory/vmailbox
+relay_domains = ${relay_list?{backup.net}} example.com
+relay_list = yes
+transport_maps = hash:$config_directory/transport
+config_directory = DIR
+sender_canonical_maps = hash:DIR/sender_canonical
+relocated_maps = hash:DIR/nosuch
+smtpd_restriction_classes = vips
+vips = check_sender_access hash:DIR/vips, reject
+smtpd_recipient_restrictions = permit_mynetworks,
+    check_client_access cidr:DIR/clients.cidr,
+    check_client_access hash:DIR/clients,
+    check_recipient_access hash:DIR/recipients,
+    check_sender_mx_access hash:DIR/mx,
+    reject_unauth_destination
+`,
+		"virtual": `valias.com anything
+sales@valias.com bob@vmail.org, carol@vmail.org
+@valias.com bob@vmail.org
+`,
+		"vdomains": "other.net\n",
+		"transport": `vmail.org lmtp:unix:private/dovecot-lmtp
+special@example.com smtp:[smarthost.net]:587
+.sub.example.com smtp:[relay.net]
+backup.net relay:[mx1.backup.net]
+other.net lmtp:unix:private/dovecot-lmtp
+`,
+		"sender_canonical": "bob@vmail.org robert@vmail.org\n",
+		"vips":             "# nobody yet\n",
+		"clients": `10.1 OK
+spam.example.org REJECT go away
+192.168.4.0/22 OK
+`,
+		"recipients": `abuse@example.com OK
+vmail.org REJECT no such user
+`,
+	}
+	for name, text := range files {
+		text = strings.ReplaceAll(text, "DIR", dir)
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Errorf("Write %s: %s", name, err)
+			return
+		}
+	}
+
+	args = []string{"-d", dbfile, "import", "maincf", "-i", filepath.Join(dir, "main.cf"),
+		"--myhostname", "mail.example.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import main.cf: Unexpected error, %s", err)
+	}
+	reports := strings.ReplaceAll(`Not imported: virtual_alias_domains: mysql:/etc/postfix/mysql-virtual.cf: mysql tables cannot be followed
+Not imported: virtual_mailbox_domains: !bad.org: exclusions are not supported
+Not imported: relay_domains: example.com: already a local domain
+Not imported: virtual_alias_maps: mysql:/etc/postfix/mysql-virtual.cf: mysql tables cannot be followed
+Not imported: transport_maps: .sub.example.com: only domains and addresses can have a transport
+Not imported: relocated_maps: hash:DIR/nosuch: open DIR/nosuch: no such file or directory
+Not imported: check_client_access: cidr:DIR/clients.cidr: cidr tables cannot be followed
+Not imported: smtpd_recipient_restrictions: check_sender_mx_access hash:DIR/mx: there is no import for check_sender_mx_access
+Not imported: virtual_mailbox_maps: hash:$config_directory/vmailbox: there is no import for this map
+Warning: 192.168.4.0/22 can only be matched by a cidr table
+`, "DIR", dir)
+	if errout != reports {
+		t.Errorf("Import main.cf: expected reports\n%s got\n%s", reports, errout)
+	}
+
+	for _, c := range []struct {
+		export, expected string
+	}{
+		{"domain", `backup.net class=relay, transport=relay
+example.com class=local
+localhost class=local, vuid=65534, vgid=65534
+localhost.example.com class=local
+localhost.localdomain class=local
+mail.example.com class=local
+other.net class=vmailbox, transport=lmtp
+valias.com class=virtual
+vmail.org class=vmailbox, transport=lmtp, rclass=reject-2
+`},
+		{"transport", `lmtp lmtp:unix:private/dovecot-lmtp
+relay relay:[mx1.backup.net]
+smtp smtp:[smarthost.net]:587
+`},
+		{"virtual", `@valias.com bob@vmail.org
+sales@valias.com bob@vmail.org, carol@vmail.org
+`},
+		{"client-access", `10.1.0.0/16 ok
+192.168.4.0/22 ok
+spam.example.org reject
+`},
+		{"access", `ok OK
+reject REJECT go away
+reject-2 REJECT no such user
+`},
+		{"restriction-classes", `smtpd_restriction_classes = vips
+vips = check_sender_access hash:` + dir + `/vips, reject
+`},
+		{"sender-canonical", "bob@vmail.org robert@vmail.org\n"},
+	} {
+		args = []string{"-d", dbfile, "export", c.export}
+		out, errout, err = doTest(rootCmd, "", args)
+		if err != nil {
+			t.Errorf("Export %s: Unexpected error, %s", c.export, err)
+		} else if out != c.expected {
+			t.Errorf("Export %s: expected\n%s got\n%s", c.export, c.expected, out)
+		}
+	}
+	args = []string{"-d", dbfile, "show", "address", "special@example.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil || !strings.Contains(out, "Transport:\tsmtp\n") {
+		t.Errorf("Show special@example.com: unexpected %s %v", out, err)
+	}
+	args = []string{"-d", dbfile, "show", "address", "abuse@example.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil || !strings.Contains(out, "Restrictions:\tok\n") {
+		t.Errorf("Show abuse@example.com: unexpected %s %v", out, err)
+	}
+
+	// A table that fails to import takes everything back with it
+	bad := filepath.Join(dir, "bad.cf")
+	if err = ioutil.WriteFile(bad, []byte("mydestination = new.org\nvirtual_alias_maps = hash:"+
+		filepath.Join(dir, "badvirtual")+"\n"), 0644); err != nil {
+		t.Errorf("Write bad.cf: %s", err)
+		return
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "badvirtual"), []byte("bob@new.org |/bin/cat\n"), 0644); err != nil {
+		t.Errorf("Write badvirtual: %s", err)
+		return
+	}
+	args = []string{"-d", dbfile, "import", "maincf", "-i", bad, "--myhostname", "mail.example.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil || !strings.HasPrefix(err.Error(), "virtual_alias_maps: "+filepath.Join(dir, "badvirtual")+": ") {
+		t.Errorf("Import bad.cf: expected a virtual error, got %v", err)
+	}
+	args = []string{"-d", dbfile, "show", "domain", "new.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Show new.org: expected not found, got %s", out)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mapsFormat string
+	mapsDir    string
+)
+
+// postmapCommand
+// makes the lmdb tables. There is no pure Go writer for them
+var postmapCommand = "postmap"
+
+// exportMaps write the maps as lookup tables
+var exportMaps = &cobra.Command{
+	Use:   "maps [map ...]",
+	Short: "Export the postfix maps as cdb or lmdb lookup tables",
+	Long: `Export the postfix maps as lookup tables postfix can use when the database
+cannot be read. Each map is written from the same views its query file uses
+to a table in the --dir directory named for the map, virtual_alias.cdb for
+example. All of them are written if no maps are given. The maps are:
+  ` + strings.Join(maildb.MapExports(), ", ") + `.
+A table is replaced only once the new one is complete. The cdb tables are
+written directly. The lmdb tables are made by postmap.`,
+	RunE: mapsExport,
+}
+
+func init() {
+	exportCmd.AddCommand(exportMaps)
+	exportMaps.Flags().StringVarP(&mapsFormat, "format", "f", "cdb",
+		"Table type, cdb or lmdb")
+	exportMaps.Flags().StringVarP(&mapsDir, "dir", "D", "/etc/postfix/postdove",
+		"Directory to write the tables to")
+}
+
+// mapsExport
+func mapsExport(cmd *cobra.Command, args []string) error {
+	var (
+		err     error
+		entries []*maildb.MapEntry
+	)
+
+	if mapsFormat != "cdb" && mapsFormat != "lmdb" {
+		return fmt.Errorf("table format must be cdb or lmdb")
+	}
+	names := args
+	if len(names) == 0 {
+		names = maildb.MapExports()
+	}
+	if err = os.MkdirAll(mapsDir, 0755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if entries, err = mdb.MapEntries(name); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if mapsFormat == "cdb" {
+			err = writeCDBTable(mapsDir, name, entries)
+		} else {
+			err = writeLMDBTable(mapsDir, name, entries)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// writeCDBTable
+// Write dir/name.cdb next to it and rename it into place
+func writeCDBTable(dir string, name string, entries []*maildb.MapEntry) error {
+	var buf bytes.Buffer
+
+	if err := maildb.WriteCDB(&buf, entries); err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(dir, name+".cdb"), buf.Bytes())
+}
+
+// replaceFile
+// the file is whole, old or new, for anyone reading it
+func replaceFile(file string, data []byte) error {
+	tmp := file + ".tmp"
+	os.Remove(tmp)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// writeLMDBTable
+// postmap makes the table from a source file in a directory of its own
+// next to dir/name.lmdb, which it is then renamed to
+func writeLMDBTable(dir string, name string, entries []*maildb.MapEntry) error {
+	var buf bytes.Buffer
+
+	postmap, err := exec.LookPath(postmapCommand)
+	if err != nil {
+		return fmt.Errorf("lmdb tables are made by postmap, %s", err)
+	}
+	w := bufio.NewWriter(&buf)
+	for _, e := range entries {
+		if strings.ContainsAny(e.Key(), " \t") {
+			return fmt.Errorf("key %q has white space", e.Key())
+		}
+		fmt.Fprintf(w, "%s\t%s\n", e.Key(), e.Value())
+	}
+	w.Flush()
+	tmpDir, err := ioutil.TempDir(dir, "."+name+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	src := filepath.Join(tmpDir, name)
+	if err = ioutil.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if out, err := exec.Command(postmap, "lmdb:"+src).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(src+".lmdb", filepath.Join(dir, name+".lmdb"))
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestExportMapsCmd
+func TestExportMapsCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestExportMapsCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestExportMapsCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "add", "virtual", "sales@pobox.org", "jeff@pobox.org", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual sales@pobox.org: Unexpected error, %s", err)
+	}
+
+	tables := filepath.Join(dir, "postdove")
+	args = []string{"-d", dbfile, "export", "maps", "-f", "cdb", "-D", tables}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export maps: Unexpected error, %s", err)
+	}
+	if out != "" || errout != "" {
+		t.Errorf("Export maps: did not expect output, got %s%s", out, errout)
+	}
+	for _, m := range maildb.MapExports() {
+		c, err := ioutil.ReadFile(filepath.Join(tables, m+".cdb"))
+		if err != nil {
+			t.Errorf("Export maps: %s", err)
+		} else if len(c) < 2048 {
+			t.Errorf("Export maps: %s.cdb is too short", m)
+		}
+	}
+	c, err := ioutil.ReadFile(filepath.Join(tables, "virtual_alias.cdb"))
+	if err != nil || !bytes.Contains(c, []byte("sales@pobox.orgjeff@pobox.org,dave@pobox.org")) {
+		t.Errorf("Export maps: virtual_alias.cdb does not have sales@pobox.org, %v", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(tables, "*.tmp")); len(tmp) > 0 {
+		t.Errorf("Export maps: left behind %v", tmp)
+	}
+
+	args = []string{"-d", dbfile, "export", "maps", "-f", "hash", "-D", tables}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil || err.Error() != "table format must be cdb or lmdb" {
+		t.Errorf("Export maps hash: expected bad format, got %v", err)
+	}
+	args = []string{"-d", dbfile, "export", "maps", "-f", "cdb", "-D", tables, "relocated"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil || err.Error() != "relocated: "+maildb.ErrMdbUnknownMap.Error() {
+		t.Errorf("Export maps relocated: expected unknown map, got %v", err)
+	}
+
+	// lmdb is made by postmap, here one that copies the source
+	saved := postmapCommand
+	defer func() { postmapCommand = saved }()
+	postmapCommand = filepath.Join(dir, "postmap")
+	if err = ioutil.WriteFile(postmapCommand,
+		[]byte("#!/bin/sh\nsrc=${1#lmdb:}\ncp \"$src\" \"$src.lmdb\"\n"), 0755); err != nil {
+		t.Errorf("Write postmap: %s", err)
+		return
+	}
+	args = []string{"-d", dbfile, "export", "maps", "-f", "lmdb", "-D", tables, "virtual_alias", "transport_maps"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export maps lmdb: Unexpected error, %s", err)
+	}
+	if c, err = ioutil.ReadFile(filepath.Join(tables, "virtual_alias.lmdb")); err != nil {
+		t.Errorf("Export maps lmdb: %s", err)
+	} else if string(c) != "sales@pobox.org\tjeff@pobox.org,dave@pobox.org\n" {
+		t.Errorf("Export maps lmdb: unexpected source, got %s", c)
+	}
+	if c, err = ioutil.ReadFile(filepath.Join(tables, "transport_maps.lmdb")); err != nil {
+		t.Errorf("Export maps lmdb: %s", err)
+	} else if !strings.Contains(string(c), "dave@pobox.org\tlmtp:localhost:24\n") {
+		t.Errorf("Export maps lmdb: unexpected transport source, got %s", c)
+	}
+	if left, _ := filepath.Glob(filepath.Join(tables, ".*")); len(left) > 0 {
+		t.Errorf("Export maps lmdb: left behind %v", left)
+	}
+	postmapCommand = filepath.Join(dir, "nosuch")
+	args = []string{"-d", dbfile, "export", "maps", "-f", "lmdb", "-D", tables, "virtual_alias"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil || !strings.HasPrefix(err.Error(), "virtual_alias: lmdb tables are made by postmap") {
+		t.Errorf("Export maps no postmap: expected error, got %v", err)
+	}
+}
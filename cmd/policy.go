@@ -0,0 +1,268 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+// policyMax is the most of a request we take before giving up on it
+const policyMax = 64 * 1024
+
+// servePolicy the policy delegation server
+var servePolicy = &cobra.Command{
+	Use:   "policy",
+	Short: "Answer postfix policy requests with the send limits of mailboxes",
+	Long: `Answer postfix SMTP access policy delegation requests by checking mail sent
+by a SASL authenticated mailbox against its send limits, or those of its
+domain, and counting it. A message over a limit gets the DEFER or REJECT of
+the limits and, if they say so, the mailbox is disabled. Anything else gets
+DUNNO. Use it in smtpd_end_of_data_restrictions, where the message is counted,
+for example
+
+  smtpd_end_of_data_restrictions = check_policy_service unix:private/postdove-policy
+
+It can also be in smtpd_data_restrictions to refuse the message before it
+is sent but it is only checked there, not counted.
+
+The database is opened again on SIGHUP.`,
+	Args: cobra.NoArgs,
+	RunE: servePolicyCmd,
+}
+
+func init() {
+	serveCmd.AddCommand(servePolicy)
+	servePolicy.Flags().StringVarP(&serveListen, "listen", "l", "",
+		"Listen on unix:/path/to/socket or inet:host:port, a loopback host")
+	servePolicy.MarkFlagRequired("listen")
+	servePolicy.Flags().StringVarP(&serveMode, "mode", "M", "",
+		"Octal permissions of a unix socket instead of those from the umask")
+	servePolicy.Flags().IntVarP(&serveMaxConns, "max-conns", "m", 20,
+		"Most connections served at once, the rest wait")
+	servePolicy.Flags().DurationVarP(&serveTimeout, "timeout", "t", 5*time.Minute,
+		"Close a connection idle for this long")
+}
+
+// servePolicyCmd
+// Serve until SIGINT or SIGTERM
+func servePolicyCmd(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		ln  net.Listener
+		srv *policyServer
+	)
+
+	logger := log.New(cmd.ErrOrStderr(), "postdove: ", 0)
+	if srv, err = newPolicyServer(dbFile, logger); err != nil {
+		return err
+	}
+	defer srv.close()
+	if ln, err = listenOn(serveListen, serveMode); err != nil {
+		return err
+	}
+	defer handleSignals(ln, srv.reload, logger)()
+	return srv.serve(ln, serveMaxConns, serveTimeout)
+}
+
+// policyServer
+// The database the limits and counts are in. A MailDB has only the one
+// transaction so the requests take turns with it.
+type policyServer struct {
+	mu     sync.Mutex
+	dbPath string
+	mdb    *maildb.MailDB
+	log    *log.Logger
+	now    func() time.Time
+}
+
+// newPolicyServer
+func newPolicyServer(dbPath string, logger *log.Logger) (*policyServer, error) {
+	srv := &policyServer{
+		dbPath: dbPath,
+		log:    logger,
+		now:    time.Now,
+	}
+	if err := srv.reload(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// reload
+// open the database again
+func (srv *policyServer) reload() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	mdb, err := maildb.NewMailDB(srv.dbPath)
+	if err != nil {
+		return err
+	}
+	if srv.mdb != nil {
+		srv.log.Printf("reloading %s", srv.dbPath)
+		srv.mdb.Close()
+	}
+	srv.mdb = mdb
+	return nil
+}
+
+// close
+func (srv *policyServer) close() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.mdb != nil {
+		srv.mdb.Close()
+		srv.mdb = nil
+	}
+}
+
+// serve
+// Accept connections until the listener is closed
+func (srv *policyServer) serve(ln net.Listener, maxConns int, timeout time.Duration) error {
+	return acceptConns(ln, maxConns, func(conn net.Conn) {
+		srv.handle(conn, timeout)
+	})
+}
+
+// handle
+// Answer the requests on one connection until postfix closes it
+func (srv *policyServer) handle(conn net.Conn, timeout time.Duration) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		attrs, err := readPolicyRequest(r, policyMax)
+		if err != nil {
+			if err != errPolicyEOF {
+				srv.log.Printf("policy: %s", err)
+			}
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err = fmt.Fprintf(conn, "action=%s\n\n", srv.check(attrs)); err != nil {
+			srv.log.Printf("policy: %s", err)
+			return
+		}
+	}
+}
+
+// errPolicyEOF
+// the connection closed between requests
+var errPolicyEOF = fmt.Errorf("policy: end of requests")
+
+// readPolicyRequest
+// The "name=value" lines of a request up to the empty line that ends it
+func readPolicyRequest(r *bufio.Reader, max int) (map[string]string, error) {
+	attrs := make(map[string]string)
+	n := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if line == "" && n == 0 {
+				return nil, errPolicyEOF
+			}
+			return nil, fmt.Errorf("request cut short, %s", err)
+		}
+		if n += len(line); n > max {
+			return nil, fmt.Errorf("request longer than %d bytes", max)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return attrs, nil
+		}
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("bad attribute %q", line)
+		}
+		attrs[line[:i]] = line[i+1:]
+	}
+}
+
+// check
+// The action for a request. Only mail from an authenticated mailbox in
+// the DATA or END-OF-MESSAGE state, when postfix knows all the
+// recipients, is checked and counted.
+func (srv *policyServer) check(attrs map[string]string) string {
+	var (
+		sc  *maildb.SendCheck
+		mb  *maildb.VMailbox
+		err error
+	)
+
+	state, login := attrs["protocol_state"], attrs["sasl_username"]
+	if (state != "DATA" && state != "END-OF-MESSAGE") || login == "" {
+		return "DUNNO"
+	}
+	rcpts, err := strconv.ParseInt(attrs["recipient_count"], 10, 64)
+	if err != nil || rcpts < 1 {
+		rcpts = 1
+	}
+	now := srv.now()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	mdb := srv.mdb
+	mdb.Begin()
+	defer func() {
+		mdb.End(&err)
+		if err != nil {
+			srv.log.Printf("%s: %s", login, err)
+		}
+	}()
+
+	if sc, err = mdb.CheckSend(login, rcpts, now); err != nil {
+		if err == maildb.ErrMdbNotMbox {
+			err = nil // not one of ours to limit
+			return "DUNNO"
+		}
+		return "DEFER_IF_PERMIT Service temporarily unavailable"
+	}
+	if !sc.IsOver() {
+		// only counted once, at the end where the recipients are final,
+		// DATA is only checked in case both restrictions use us
+		if state == "END-OF-MESSAGE" {
+			if err = mdb.RecordSend(sc, rcpts, now); err != nil {
+				return "DEFER_IF_PERMIT Service temporarily unavailable"
+			}
+		}
+		return "DUNNO"
+	}
+	l := sc.Limit()
+	srv.log.Printf("%s: over the limit of %s from %s, %s", sc.User(), sc.Reason(),
+		l.Owner(), l.Action())
+	if l.Disables() {
+		if mb, err = mdb.GetVMailbox(sc.User()); err == nil && mb.IsEnabled() {
+			if err = mb.Disable(); err == nil {
+				srv.log.Printf("%s: mailbox disabled", sc.User())
+			}
+		}
+	}
+	return l.Action() + " Sending limit of " + sc.Reason() + " reached"
+}
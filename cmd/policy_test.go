@@ -0,0 +1,202 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// policyAsk
+// what postfix sends and reads back for one request
+func policyAsk(conn net.Conn, r *bufio.Reader, attrs ...string) (string, error) {
+	if _, err := fmt.Fprintf(conn, "%s\n\n", strings.Join(attrs, "\n")); err != nil {
+		return "", err
+	}
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if blank, err := r.ReadString('\n'); err != nil {
+		return "", err
+	} else if blank != "\n" {
+		return "", fmt.Errorf("reply not ended by an empty line, %q", blank)
+	}
+	return strings.TrimSuffix(reply, "\n"), nil
+}
+
+// TestPolicyServer
+func TestPolicyServer(t *testing.T) {
+	var (
+		err    error
+		dir    string
+		dbfile string
+		args   []string
+		out    string
+		reply  string
+	)
+
+	fmt.Println("TestPolicyServer")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestPolicyServer-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "limit", "set", "@pobox.org", "-H", "2", "-r", "10", "-D", "0",
+		"-a", "defer", "--disable=false"}
+	if _, _, err = doTest(rootCmd, "", args); err != nil {
+		t.Errorf("Set pobox.org: Unexpected error, %s", err)
+	}
+
+	var logbuf bytes.Buffer
+	srv, err := newPolicyServer(dbfile, log.New(&logbuf, "", 0))
+	if err != nil {
+		t.Errorf("New server: Unexpected error, %s", err)
+		return
+	}
+	defer srv.close()
+	now := time.Date(2024, 5, 4, 12, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+	sock := filepath.Join(dir, "postdove-policy")
+	ln, err := listenOn("unix:"+sock, "")
+	if err != nil {
+		t.Errorf("Listen: Unexpected error, %s", err)
+		return
+	}
+	done := make(chan error)
+	go func() {
+		done <- srv.serve(ln, 2, time.Minute)
+	}()
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Errorf("Dial: Unexpected error, %s", err)
+		ln.Close()
+		return
+	}
+	r := bufio.NewReader(conn)
+
+	data := []string{"request=smtpd_access_policy", "protocol_state=DATA",
+		"sasl_username=jeff@pobox.org", "recipient_count=3"}
+	eom := []string{"request=smtpd_access_policy", "protocol_state=END-OF-MESSAGE",
+		"sasl_username=jeff@pobox.org", "recipient_count=3"}
+	for _, q := range []struct {
+		attrs []string
+		reply string
+	}{
+		{[]string{"request=smtpd_access_policy", "protocol_state=RCPT",
+			"sasl_username=jeff@pobox.org", "recipient_count=0"}, "action=DUNNO"},
+		{[]string{"request=smtpd_access_policy", "protocol_state=DATA",
+			"sasl_username=", "recipient_count=3"}, "action=DUNNO"},
+		{[]string{"request=smtpd_access_policy", "protocol_state=DATA",
+			"sasl_username=root", "recipient_count=3"}, "action=DUNNO"},
+		{[]string{"request=smtpd_access_policy", "protocol_state=DATA",
+			"sasl_username=jeff@pobox.org", "recipient_count=11"},
+			"action=DEFER Sending limit of 10 recipients per message reached"},
+		{data, "action=DUNNO"},
+		{eom, "action=DUNNO"},
+	} {
+		if reply, err = policyAsk(conn, r, q.attrs...); err != nil {
+			t.Errorf("Ask %v: Unexpected error, %s", q.attrs, err)
+		} else if reply != q.reply {
+			t.Errorf("Ask %v: expected %q, got %q", q.attrs, q.reply, reply)
+		}
+	}
+
+	// a message checked in both DATA and END-OF-MESSAGE is counted once
+	srv.mu.Lock()
+	hourly, daily, err := srv.mdb.SendCounts("jeff@pobox.org", now)
+	srv.mu.Unlock()
+	if err != nil {
+		t.Errorf("Send counts of jeff: Unexpected error, %s", err)
+	} else if hourly != 1 || daily != 3 {
+		t.Errorf("Send counts of jeff: expected 1 message and 3 recipients, got %d and %d", hourly, daily)
+	}
+	for _, q := range []struct {
+		attrs []string
+		reply string
+	}{
+		{data, "action=DUNNO"},
+		{eom, "action=DUNNO"},
+		{data, "action=DEFER Sending limit of 2 messages per hour reached"},
+		{eom, "action=DEFER Sending limit of 2 messages per hour reached"},
+	} {
+		if reply, err = policyAsk(conn, r, q.attrs...); err != nil {
+			t.Errorf("Ask %v: Unexpected error, %s", q.attrs, err)
+		} else if reply != q.reply {
+			t.Errorf("Ask %v: expected %q, got %q", q.attrs, q.reply, reply)
+		}
+	}
+
+	// an hour later jeff can send again until he goes over and is disabled
+	args = []string{"-d", dbfile, "limit", "set", "jeff@pobox.org", "-H", "0", "-r", "0", "-D", "8",
+		"-a", "reject", "--disable"}
+	if _, _, err = doTest(rootCmd, "", args); err != nil {
+		t.Errorf("Set jeff: Unexpected error, %s", err)
+	}
+	srv.mu.Lock()
+	now = now.Add(61 * time.Minute)
+	srv.mu.Unlock()
+	for _, q := range []struct {
+		attrs []string
+		reply string
+	}{
+		{data, "action=REJECT Sending limit of 8 recipients per day reached"},
+		{[]string{"request=smtpd_access_policy", "protocol_state=END-OF-MESSAGE",
+			"sasl_username=jeff@pobox.org", "recipient_count=2"}, "action=DUNNO"},
+	} {
+		if reply, err = policyAsk(conn, r, q.attrs...); err != nil {
+			t.Errorf("Ask %v: Unexpected error, %s", q.attrs, err)
+		} else if reply != q.reply {
+			t.Errorf("Ask %v: expected %q, got %q", q.attrs, q.reply, reply)
+		}
+	}
+	if _, err = fmt.Fprintf(conn, "no attribute\n\n"); err == nil {
+		if _, err = r.ReadString('\n'); err == nil {
+			t.Errorf("Bad request: connection should have been closed")
+		}
+	}
+	conn.Close()
+	ln.Close()
+	if err = <-done; err != nil {
+		t.Errorf("Serve: Unexpected error, %s", err)
+	}
+
+	args = []string{"-d", dbfile, "show", "mailbox", "jeff@pobox.org"}
+	if out, _, err = doTest(rootCmd, "", args); err != nil {
+		t.Errorf("Show jeff: Unexpected error, %s", err)
+	} else if !strings.Contains(out, "Enabled:\tfalse\n") {
+		t.Errorf("Show jeff: expected the mailbox disabled, got %s", out)
+	}
+	if logbuf.String() != "jeff@pobox.org: over the limit of 10 recipients per message from @pobox.org, DEFER\n"+
+		"jeff@pobox.org: over the limit of 2 messages per hour from @pobox.org, DEFER\n"+
+		"jeff@pobox.org: over the limit of 2 messages per hour from @pobox.org, DEFER\n"+
+		"jeff@pobox.org: over the limit of 8 recipients per day from jeff@pobox.org, REJECT\n"+
+		"jeff@pobox.org: mailbox disabled\n"+
+		"policy: bad attribute \"no attribute\"\n" {
+		t.Errorf("Server log: did not get expected output, got %s", logbuf.String())
+	}
+}
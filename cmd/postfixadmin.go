@@ -0,0 +1,382 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pfaFrom     string
+	pfaMailBase string
+)
+
+// importPostfixAdmin
+var importPostfixAdmin = &cobra.Command{
+	Use:   "postfixadmin",
+	Short: "Import the domains, aliases and mailboxes of a PostfixAdmin database",
+	Long: `Import a PostfixAdmin (or ViMbAdmin) database from the file named by --from.
+The file is either an sqlite database or a SQL dump made by mysqldump or pg_dump.
+If --from is not used, a SQL dump is read from the -i input (default stdin '-').
+The domain, alias_domain, mailbox and alias tables are mapped to domains,
+alias domains, mailboxes, virtual aliases and transports. Anything that cannot
+be mapped is reported and left out. The whole import is one transaction.`,
+	Args: cobra.NoArgs,
+	RunE: postfixadminImport,
+}
+
+func init() {
+	importCmd.AddCommand(importPostfixAdmin)
+	importPostfixAdmin.Flags().StringVarP(&pfaFrom, "from", "f", "",
+		"SQL dump or sqlite database file to import")
+	importPostfixAdmin.Flags().StringVarP(&pfaMailBase, "mail-base", "b", "/var/vmail",
+		"Directory the PostfixAdmin maildir paths are relative to")
+}
+
+// the tables we read. vacation and fetchmail are only reported
+var pfaTables = []string{"domain", "alias_domain", "mailbox", "alias", "vacation", "fetchmail"}
+
+// pfaImport
+// the state of one import
+type pfaImport struct {
+	cmd        *cobra.Command
+	transports *transportSet
+	mailboxes  map[string]bool
+	inactive   map[string]bool // domains that are not active
+}
+
+// postfixadminImport
+func postfixadminImport(cmd *cobra.Command, args []string) error {
+	var (
+		tables map[string][]sqlRow
+		err    error
+	)
+
+	if tables, err = readSqlTables(pfaFrom, cmd.InOrStdin(), pfaTables); err != nil {
+		return err
+	}
+	p := &pfaImport{
+		cmd:       cmd,
+		mailboxes: make(map[string]bool),
+		inactive:  make(map[string]bool),
+	}
+	if p.transports, err = newTransportSet(); err != nil {
+		return err
+	}
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if err = p.domains(tables["domain"], tables["alias_domain"], tables["mailbox"]); err != nil {
+		return err
+	}
+	if err = p.aliasDomains(tables["alias_domain"]); err != nil {
+		return err
+	}
+	if err = p.mailboxRows(tables["mailbox"]); err != nil {
+		return err
+	}
+	if err = p.aliases(tables["alias"]); err != nil {
+		return err
+	}
+	for _, r := range tables["vacation"] {
+		if pfaBool(r["active"]) {
+			p.report("vacation for %s, use 'postdove vacation set'", r["email"])
+		}
+	}
+	for _, r := range tables["fetchmail"] {
+		p.report("fetchmail from %s@%s for %s", r["src_user"], r["src_server"], r["mailbox"])
+	}
+	return err
+}
+
+// report
+// something that did not make it into the database
+func (p *pfaImport) report(format string, args ...interface{}) {
+	p.cmd.PrintErrf("Not imported: "+format+"\n", args...)
+}
+
+// pfaBool
+// mysql has 1/0, postgres true/false or t/f
+func pfaBool(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "t", "true", "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// domains
+// A domain holds mailboxes unless PostfixAdmin has them disabled
+// (mailboxes = -1) or it is an alias domain. A backup MX is a relay domain.
+func (p *pfaImport) domains(domains, aliasDomains, mailboxes []sqlRow) error {
+	var (
+		d   *maildb.Domain
+		err error
+	)
+
+	isAlias := make(map[string]bool)
+	for _, r := range aliasDomains {
+		if pfaBool(r["active"]) {
+			isAlias[strings.ToLower(r["alias_domain"])] = true
+		}
+	}
+	hasMbox := make(map[string]bool)
+	for _, r := range mailboxes {
+		hasMbox[strings.ToLower(r["domain"])] = true
+	}
+	for _, r := range domains {
+		name := strings.ToLower(r["domain"])
+		if name == "all" { // the superadmin pseudo domain
+			continue
+		}
+		if d, err = mdb.InsertDomain(name); err != nil {
+			return fmt.Errorf("domain %s: %s", name, err)
+		}
+		class := "vmailbox"
+		transport := r["transport"]
+		if pfaBool(r["backupmx"]) {
+			class = "relay"
+			if transport == "relay" {
+				transport = ""
+			}
+		} else if isAlias[name] || (r["mailboxes"] == "-1" && !hasMbox[name]) {
+			class = "virtual"
+		}
+		if err = d.SetClass(class); err != nil {
+			return fmt.Errorf("domain %s: %s", name, err)
+		}
+		if transport != "" && transport != "virtual" {
+			var tname string
+			if tname, err = p.transports.get(transport); err == nil {
+				err = d.SetTransport(tname)
+			}
+			if err != nil {
+				return fmt.Errorf("domain %s: %s", name, err)
+			}
+		}
+		if !pfaBool(r["active"]) {
+			p.inactive[name] = true
+			p.cmd.PrintErrf("Warning: domain %s is not active, its mailboxes are disabled\n", name)
+		}
+	}
+	return nil
+}
+
+// aliasDomains
+func (p *pfaImport) aliasDomains(aliasDomains []sqlRow) error {
+	var (
+		d   *maildb.Domain
+		err error
+	)
+
+	for _, r := range aliasDomains {
+		alias := strings.ToLower(r["alias_domain"])
+		target := strings.ToLower(r["target_domain"])
+		if !pfaBool(r["active"]) {
+			p.report("alias domain %s of %s is not active", alias, target)
+			continue
+		}
+		if d, err = mdb.GetDomain(alias); err == maildb.ErrMdbDomainNotFound {
+			if d, err = mdb.InsertDomain(alias); err == nil {
+				err = d.SetClass("virtual")
+			}
+		}
+		if err == nil {
+			err = d.SetAliasOf(target)
+		}
+		if err != nil {
+			return fmt.Errorf("alias domain %s: %s", alias, err)
+		}
+	}
+	return nil
+}
+
+// mailboxRows
+// ViMbAdmin adds homedir, uid and gid columns. We use them if they are there.
+func (p *pfaImport) mailboxRows(mailboxes []sqlRow) error {
+	for _, r := range mailboxes {
+		user := strings.ToLower(r["username"])
+		if err := p.mailbox(user, r); err != nil {
+			return fmt.Errorf("mailbox %s: %s", user, err)
+		}
+		p.mailboxes[user] = true
+	}
+	return nil
+}
+
+// mailbox
+func (p *pfaImport) mailbox(user string, r sqlRow) error {
+	var (
+		mb  *maildb.VMailbox
+		err error
+	)
+
+	if mb, err = mdb.InsertVMailbox(user); err != nil {
+		return err
+	}
+	if pwType, password := pfaPassword(r["password"]); pwType == "" {
+		p.report("password of %s, its scheme is not supported, set a new one", user)
+	} else {
+		if err = mb.SetPwType(pwType); err != nil {
+			return err
+		}
+		if err = mb.SetPassword(password); err != nil {
+			return err
+		}
+	}
+	// quota is in bytes. 0 is no limit
+	if q, e := strconv.ParseInt(r["quota"], 10, 64); e == nil && q > 0 {
+		err = mb.SetQuota(fmt.Sprintf("*:bytes=%d", q))
+	} else {
+		err = mb.ClearQuota()
+	}
+	if err != nil {
+		return err
+	}
+	if home := pfaHome(r); home != "" {
+		if err = mb.SetHome(home); err != nil {
+			return err
+		}
+	}
+	for _, col := range []string{"uid", "gid"} {
+		id, e := strconv.ParseInt(r[col], 10, 64)
+		if e != nil || id <= 0 {
+			continue
+		}
+		if col == "uid" {
+			err = mb.SetUid(id)
+		} else {
+			err = mb.SetGid(id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !pfaBool(r["active"]) || p.inactive[strings.ToLower(r["domain"])] {
+		err = mb.Disable()
+	}
+	return err
+}
+
+// pfaPassword
+// split a PostfixAdmin password into a postdove pw_type and hash.
+// dovecot style hashes carry a {SCHEME} prefix, the crypt(3) ones are
+// bare. The crypt(3) hashes are CRYPT, as import system-users has
+// them. An empty pw_type means we can't use it.
+func pfaPassword(pw string) (string, string) {
+	if strings.HasPrefix(pw, "{") {
+		end := strings.IndexByte(pw, '}')
+		if end < 0 {
+			return "", ""
+		}
+		hash := pw[end+1:]
+		switch strings.ToUpper(pw[1:end]) {
+		case "PLAIN", "CLEARTEXT":
+			return "PLAIN", hash
+		case "CRYPT", "MD5-CRYPT", "SHA256-CRYPT", "SHA512-CRYPT", "BLF-CRYPT":
+			if maildb.IsCryptHash(hash) {
+				return "CRYPT", hash
+			}
+		case "SHA256":
+			return "SHA256", hash
+		}
+		return "", ""
+	}
+	if maildb.IsCryptHash(pw) {
+		return "CRYPT", pw
+	}
+	return "", ""
+}
+
+// pfaHome
+// ViMbAdmin has a homedir. PostfixAdmin's maildir is relative to the
+// virtual_mailbox_base, "example.com/bob/", unless it was made absolute.
+func pfaHome(r sqlRow) string {
+	if home := r["homedir"]; home != "" {
+		return home
+	}
+	maildir := r["maildir"]
+	if maildir == "" || strings.Contains(maildir, ":") { // a dovecot mail_location
+		return ""
+	}
+	if !filepath.IsAbs(maildir) {
+		maildir = filepath.Join(pfaMailBase, maildir)
+	}
+	return filepath.Clean(maildir)
+}
+
+// aliases
+// PostfixAdmin keeps an alias for each mailbox that points to itself.
+// That is just the mailbox here. An address cannot be both a mailbox and
+// an alias so a mailbox that also forwards has the forwarding reported.
+func (p *pfaImport) aliases(aliases []sqlRow) error {
+	var (
+		a   *maildb.Address
+		err error
+	)
+
+	catchalls = nil
+	for _, r := range aliases {
+		addr := strings.ToLower(r["address"])
+		var targets []string
+		for _, t := range strings.Split(r["goto"], ",") {
+			if t = strings.TrimSpace(t); t != "" && !strings.EqualFold(t, addr) {
+				targets = append(targets, t)
+			}
+		}
+		switch {
+		case p.mailboxes[addr]:
+			if len(targets) > 0 && pfaBool(r["active"]) {
+				sort.Strings(targets)
+				p.report("mailbox %s also forwards to %s", addr, strings.Join(targets, ","))
+			}
+			continue
+		case !pfaBool(r["active"]):
+			p.report("alias %s is not active", addr)
+			continue
+		case len(targets) == 0:
+			p.report("alias %s has no targets", addr)
+			continue
+		}
+		if a, err = mdb.GetOrInsAddress(addr); err == nil {
+			for _, t := range targets {
+				if err = a.AttachAlias(t); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("alias %s: %s", addr, err)
+		}
+		if a.IsCatchall() {
+			catchalls = append(catchalls, a)
+		}
+	}
+	for _, c := range catchalls {
+		catchallWarn(p.cmd, c)
+	}
+	return nil
+}
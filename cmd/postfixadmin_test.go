@@ -0,0 +1,269 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// pg_dump --inserts style, booleans and no backslash escapes
+var pfaPgDump = `
+SET standard_conforming_strings = on;
+CREATE TABLE public.domain (
+    domain character varying(255) NOT NULL,
+    mailboxes integer DEFAULT 0 NOT NULL,
+    transport character varying(255) DEFAULT NULL::character varying,
+    backupmx boolean DEFAULT false NOT NULL,
+    active boolean DEFAULT true NOT NULL
+);
+COPY public.domain (domain, mailboxes, transport, backupmx, active) FROM stdin;
+ALL	0	\N	f	t
+pg.org	0	lmtp:unix:private/dovecot-lmtp	f	t
+\.
+
+INSERT INTO public.mailbox (username, password, maildir, quota, domain, active) VALUES ('joe@pg.org', '{PLAIN}a\b', 'pg.org/joe/', 0, 'pg.org', true);
+COPY public.alias (address, goto, domain, active) FROM stdin;
+joe@pg.org	joe@pg.org	pg.org	t
+all@pg.org	joe@pg.org,bob@example.com	pg.org	t
+\.
+`
+
+// TestPostfixAdminCmd
+func TestPostfixAdminCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestPostfixAdminCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestPostfixAdminCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = filepath.Join(dir, "test.db")
+
+	args = []string{"create", "-d", dbfile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+
+	// A mysqldump of PostfixAdmin
+	args = []string{"-d", dbfile, "import", "postfixadmin", "--from", "./test_postfixadmin.sql",
+		"--mail-base", "/var/vmail"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import mysqldump: Unexpected error, %s", err)
+	}
+	if out != "" {
+		t.Errorf("Import mysqldump: did not expect output, got %s", out)
+	}
+	reports := `Warning: domain old.org is not active, its mailboxes are disabled
+Not imported: alias domain gone.com of example.com is not active
+Not imported: password of dan@example.com, its scheme is not supported, set a new one
+Not imported: mailbox carol@example.com also forwards to carol@gmail.com
+Not imported: alias info@example.com is not active
+Not imported: vacation for bob@example.com, use 'postdove vacation set'
+`
+	if errout != reports {
+		t.Errorf("Import mysqldump: expected reports\n%s got\n%s", reports, errout)
+	}
+
+	// Domains
+	for _, d := range []struct {
+		name, class, transport, aliasOf string
+	}{
+		{"example.com", "vmailbox", "--", "--"},
+		{"lists.example.com", "virtual", "smtp", "--"},
+		{"backup.net", "relay", "--", "--"},
+		{"old.org", "vmailbox", "lmtp", "--"},
+		{"brand.com", "virtual", "--", "example.com"},
+	} {
+		dom, err := mdb.LookupDomain(d.name)
+		if err != nil {
+			t.Errorf("Lookup domain %s: Unexpected error, %s", d.name, err)
+			continue
+		}
+		if dom.Class() != d.class || dom.Transport() != d.transport || dom.AliasOf() != d.aliasOf {
+			t.Errorf("Domain %s: expected %s/%s/%s, got %s/%s/%s", d.name,
+				d.class, d.transport, d.aliasOf, dom.Class(), dom.Transport(), dom.AliasOf())
+		}
+	}
+	if _, err = mdb.LookupDomain("ALL"); err != maildb.ErrMdbDomainNotFound {
+		t.Errorf("Lookup domain ALL: expected not found, got %v", err)
+	}
+	if _, err = mdb.LookupDomain("gone.com"); err != maildb.ErrMdbDomainNotFound {
+		t.Errorf("Lookup domain gone.com: expected not found, got %v", err)
+	}
+	if tr, err := mdb.LookupTransport("smtp"); err != nil {
+		t.Errorf("Lookup transport smtp: Unexpected error, %s", err)
+	} else if tr.Export() != "smtp smtp:[lists.example.net]" {
+		t.Errorf("Transport smtp: unexpected %s", tr.Export())
+	}
+
+	// Mailboxes
+	for _, m := range []struct {
+		user, pwType, password, home, quota string
+		enabled                             bool
+	}{
+		{"bob@example.com", "CRYPT", "$6$saltsalt$Zk5n.Xx0",
+			"/var/vmail/example.com/bob", "*:bytes=1073741824", true},
+		{"carol@example.com", "CRYPT", "$1$abcdefgh$Jd0uYl6Mz3",
+			"/var/vmail/example.com/carol", "none", false},
+		{"dan@example.com", "PLAIN", "--", "/srv/mail/example.com/dan", "none", true},
+		{"eve@old.org", "PLAIN", "it's secret", "/var/vmail/old.org/eve", "*:bytes=5242880", false},
+	} {
+		mb, err := mdb.LookupVMailbox(m.user)
+		if err != nil {
+			t.Errorf("Lookup mailbox %s: Unexpected error, %s", m.user, err)
+			continue
+		}
+		if mb.PwType() != m.pwType || mb.Password() != m.password {
+			t.Errorf("Mailbox %s: expected password {%s}%s, got {%s}%s", m.user,
+				m.pwType, m.password, mb.PwType(), mb.Password())
+		}
+		if mb.Home() != m.home || mb.Quota() != m.quota || mb.IsEnabled() != m.enabled {
+			t.Errorf("Mailbox %s: expected %s %s %v, got %s %s %v", m.user,
+				m.home, m.quota, m.enabled, mb.Home(), mb.Quota(), mb.IsEnabled())
+		}
+	}
+
+	// the same crypt(3) hashes as import system-users
+	for _, p := range []struct {
+		pw, pwType, hash string
+	}{
+		{"{BLF-CRYPT}$2y$05$abcdefghijklmnopqrstuv", "CRYPT", "$2y$05$abcdefghijklmnopqrstuv"},
+		{"$y$j9T$salt$hash", "CRYPT", "$y$j9T$salt$hash"},
+		{"ab01FAX.bQRSU", "CRYPT", "ab01FAX.bQRSU"},
+		{"{CRYPT}not a hash", "", ""},
+		{"{SSHA512}c2VjcmV0", "", ""},
+	} {
+		if pwType, hash := pfaPassword(p.pw); pwType != p.pwType || hash != p.hash {
+			t.Errorf("Password %s: expected {%s}%s, got {%s}%s", p.pw, p.pwType, p.hash, pwType, hash)
+		}
+	}
+
+	// Aliases, the self aliases of mailboxes are not aliases here
+	args = []string{"-d", dbfile, "show", "virtual", "sales@example.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show virtual sales@example.com: Unexpected error, %s", err)
+	} else if out != "Virtual Alias:\tsales@example.com\nTargets:\tbob@example.com\n\t\tcarol@example.com\n" {
+		t.Errorf("Show virtual sales@example.com: unexpected %s", out)
+	}
+	args = []string{"-d", dbfile, "show", "virtual", "@lists.example.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil || !strings.Contains(out, "bob@example.com") {
+		t.Errorf("Show virtual @lists.example.com: unexpected %s, %v", out, err)
+	}
+	for _, a := range []string{"bob@example.com", "info@example.com"} {
+		args = []string{"-d", dbfile, "show", "virtual", a}
+		out, errout, err = doTest(rootCmd, "", args)
+		if err == nil {
+			t.Errorf("Show virtual %s: expected no alias, got %s", a, out)
+		}
+	}
+
+	// Importing it again collides with the first
+	args = []string{"-d", dbfile, "import", "postfixadmin", "--from", "./test_postfixadmin.sql",
+		"--mail-base", "/var/vmail"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil || err.Error() != "domain example.com: "+maildb.ErrMdbDupDomain.Error() {
+		t.Errorf("Import again: expected duplicate domain, got %v", err)
+	}
+
+	// A pg_dump from the input. The import can use what is already there
+	args = []string{"-d", dbfile, "import", "postfixadmin", "--from", "", "--mail-base", "/srv/vmail"}
+	out, errout, err = doTest(rootCmd, pfaPgDump, args)
+	if err != nil {
+		t.Errorf("Import pg_dump: Unexpected error, %s", err)
+	}
+	if errout != "" {
+		t.Errorf("Import pg_dump: did not expect reports, got %s", errout)
+	}
+	if mb, err := mdb.LookupVMailbox("joe@pg.org"); err != nil {
+		t.Errorf("Lookup joe@pg.org: Unexpected error, %s", err)
+	} else if mb.Password() != "a\\b" || mb.Home() != "/srv/vmail/pg.org/joe" || !mb.IsEnabled() {
+		t.Errorf("Mailbox joe@pg.org: unexpected %s %s %v", mb.Password(), mb.Home(), mb.IsEnabled())
+	}
+	if dom, err := mdb.LookupDomain("pg.org"); err != nil {
+		t.Errorf("Lookup domain pg.org: Unexpected error, %s", err)
+	} else if dom.Transport() != "lmtp" {
+		t.Errorf("Domain pg.org: expected the existing lmtp transport, got %s", dom.Transport())
+	}
+
+	// A bad dump imports nothing
+	args = []string{"-d", dbfile, "import", "postfixadmin", "--from", "", "--mail-base", "/srv/vmail"}
+	out, errout, err = doTest(rootCmd,
+		"INSERT INTO domain (domain, active) VALUES ('bad.org', 1);\nINSERT INTO domain VALUES ('worse.org", args)
+	if err == nil || err.Error() != "At line 2: Unterminated quoted string" {
+		t.Errorf("Import bad dump: expected unterminated string, got %v", err)
+	}
+	if _, err = mdb.LookupDomain("bad.org"); err != maildb.ErrMdbDomainNotFound {
+		t.Errorf("Lookup domain bad.org: expected not found, got %v", err)
+	}
+
+	// A ViMbAdmin sqlite database with its home and ids
+	sqlfile := filepath.Join(dir, "vimbadmin.sqlite")
+	vdb, err := sql.Open("sqlite3", sqlfile)
+	if err != nil {
+		t.Errorf("Open sqlite: %s", err)
+		return
+	}
+	for _, q := range []string{
+		"CREATE TABLE domain (id INTEGER PRIMARY KEY, domain TEXT, transport TEXT, backupmx INTEGER, active INTEGER)",
+		"CREATE TABLE mailbox (id INTEGER PRIMARY KEY, username TEXT, password TEXT, quota INTEGER, active INTEGER," +
+			" homedir TEXT, maildir TEXT, uid INTEGER, gid INTEGER, domain TEXT)",
+		"INSERT INTO domain VALUES (1, 'vim.net', 'virtual', 0, 1)",
+		"INSERT INTO mailbox VALUES (1, 'sue@vim.net', '{SHA256}Zm9v', 0, 1," +
+			" '/srv/vmail/vim.net/sue', 'maildir:/srv/vmail/vim.net/sue/mail:LAYOUT=fs', 2000, 2000, 'vim.net')",
+	} {
+		if _, err = vdb.Exec(q); err != nil {
+			t.Errorf("Make sqlite: %s", err)
+		}
+	}
+	vdb.Close()
+	args = []string{"-d", dbfile, "import", "postfixadmin", "--from", sqlfile, "--mail-base", "/var/vmail"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import sqlite: Unexpected error, %s", err)
+	}
+	if mb, err := mdb.LookupVMailbox("sue@vim.net"); err != nil {
+		t.Errorf("Lookup sue@vim.net: Unexpected error, %s", err)
+	} else if mb.PwType() != "SHA256" || mb.Password() != "Zm9v" || mb.Home() != "/srv/vmail/vim.net/sue" ||
+		mb.Uid() != "2000" || mb.Gid() != "2000" {
+		t.Errorf("Mailbox sue@vim.net: unexpected %s", mb.Export())
+	}
+	// and stdin can't be an sqlite database
+	c, _ := ioutil.ReadFile(sqlfile)
+	args = []string{"-d", dbfile, "import", "postfixadmin", "--from", "", "--mail-base", "/var/vmail"}
+	out, errout, err = doTest(rootCmd, string(c), args)
+	if err == nil || err.Error() != "An sqlite database must be named with --from" {
+		t.Errorf("Import sqlite from stdin: expected error, got %v", err)
+	}
+}
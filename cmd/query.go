@@ -0,0 +1,93 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	queryDir   string // read the query files here instead
+	queryExact bool   // only the key, like postmap -q
+	queryTrace bool   // show each key and its query
+)
+
+func init() {
+	queryCmd.Flags().StringVarP(&queryDir, "query-dir", "q", "",
+		"Read the map's query file from this directory instead of the built in one")
+	queryCmd.Flags().BoolVarP(&queryExact, "exact", "x", false,
+		"Only look up the key as it is given, as postmap -q does")
+	queryCmd.Flags().BoolVarP(&queryTrace, "trace", "t", false,
+		"Show each key tried, its query and what it found")
+}
+
+// cmdQuery
+func cmdQuery(cmd *cobra.Command, args []string) error {
+	var (
+		err  error
+		pq   *maildb.PostfixQuery
+		keys []string
+	)
+
+	mapName, key := args[0], args[1]
+	if keys, err = maildb.RetryKeys(mapName, key); err != nil {
+		return err
+	}
+	if queryExact {
+		keys = []string{key}
+	}
+	if queryDir != "" {
+		file := filepath.Join(queryDir, mapName+".query")
+		c, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if pq, err = maildb.ParsePostfixQuery(file, string(c)); err != nil {
+			return err
+		}
+	} else if pq, err = maildb.BuiltinQuery(mapName); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		res, found, err := mdb.PostfixLookup(pq, k)
+		if err != nil {
+			return err
+		}
+		if queryTrace {
+			if q, suppressed := pq.Expand(k); suppressed {
+				cmd.Printf("%s: query suppressed\n", k)
+			} else if found {
+				cmd.Printf("%s: %s\n  found %s\n", k, q, res)
+			} else {
+				cmd.Printf("%s: %s\n  not found\n", k, q)
+			}
+			if found {
+				return nil
+			}
+			continue
+		}
+		if found {
+			cmd.Println(res)
+			return nil
+		}
+	}
+	return exitStatus(1)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestQueryCmd
+func TestQueryCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestQueryCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestQueryCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "add", "virtual", "sales@pobox.org", "jeff@pobox.org", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual sales@pobox.org: Unexpected error, %s", err)
+	}
+
+	// the extension is dropped on the retry
+	args = []string{"-d", dbfile, "query", "virtual_alias", "Sales+Orders@pobox.org",
+		"-x=false", "-t=false", "-q", ""}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Query sales: Unexpected error, %s", err)
+	}
+	if out != "jeff@pobox.org,dave@pobox.org\n" {
+		t.Errorf("Query sales: did not get expected output, got %s", out)
+	}
+
+	// but not for postmap -q
+	args = []string{"-d", dbfile, "query", "virtual_alias", "sales+orders@pobox.org", "-x"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != exitStatus(1) {
+		t.Errorf("Query sales exact: expected status 1, got %v", err)
+	}
+	if out != "" || errout != "" {
+		t.Errorf("Query sales exact: did not expect output, got %s%s", out, errout)
+	}
+
+	args = []string{"-d", dbfile, "query", "transport_maps", "dave+x@pobox.org", "-x=false", "-t"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Query dave transport: Unexpected error, %s", err)
+	}
+	if out != "dave+x@pobox.org: SELECT transport FROM address_transport "+
+		"WHERE username = 'dave+x' AND domain_name = 'pobox.org'\n"+
+		"  not found\n"+
+		"dave@pobox.org: SELECT transport FROM address_transport "+
+		"WHERE username = 'dave' AND domain_name = 'pobox.org'\n"+
+		"  found lmtp:localhost:24\n" {
+		t.Errorf("Query dave transport: did not get expected output, got %s", out)
+	}
+
+	// a domain key leaves the %d of this query with nothing
+	args = []string{"-d", dbfile, "query", "transport_maps", "pobox.org", "-t"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != exitStatus(1) {
+		t.Errorf("Query pobox.org transport: expected status 1, got %v", err)
+	}
+	if out != "pobox.org: query suppressed\n.org: query suppressed\n*: query suppressed\n" {
+		t.Errorf("Query pobox.org transport: did not get expected output, got %s", out)
+	}
+
+	// an installed query file
+	qdir := filepath.Join(dir, "query")
+	if err = os.Mkdir(qdir, 0755); err != nil {
+		t.Errorf("Mkdir query: %s", err)
+		return
+	}
+	if err = ioutil.WriteFile(filepath.Join(qdir, "vmailbox_domain.query"),
+		[]byte("dbpath = "+dbfile+"\nquery = SELECT upper(name) FROM vmailbox_domain\n"+
+			"  WHERE name = '%s'\n"), 0644); err != nil {
+		t.Errorf("Write vmailbox_domain.query: %s", err)
+		return
+	}
+	args = []string{"-d", dbfile, "query", "vmailbox_domain", "pobox.org", "-t=false", "-q", qdir}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Query pobox.org in query dir: Unexpected error, %s", err)
+	}
+	if out != "POBOX.ORG\n" {
+		t.Errorf("Query pobox.org in query dir: did not get expected output, got %s", out)
+	}
+	args = []string{"-d", dbfile, "query", "virtual_domain", "pobox.org", "-q", qdir}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Query virtual_domain in query dir: should have failed")
+	}
+
+	args = []string{"-d", dbfile, "query", "aliases", "root", "-q", ""}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != maildb.ErrMdbUnknownMap {
+		t.Errorf("Query aliases: expected unknown map, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	relocTarget string
+)
+
+// importRelocated do import of a relocated file
+var importRelocated = &cobra.Command{
+	Use:   "relocated",
+	Short: "Import departed user addresses in the postfix relocated(5) format",
+	Long: `Import departed user addresses in the postfix relocated(5) format
+from the file named by the -i flag (default stdin '-').
+Each line is an address, 'user', 'user@domain', or '@domain', followed by its new location.
+This is the postfix file associated with $relocated_maps`,
+	Args: cobra.NoArgs,
+	RunE: relocatedImport,
+}
+
+// exportRelocated do export of departed users
+var exportRelocated = &cobra.Command{
+	Use:   "relocated [address]",
+	Short: "Export departed user addresses in postfix relocated(5) format",
+	Long: `Export departed user addresses in postfix relocated(5) format to
+the file named by the -o flag (default stdout '-'). The optional address
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: relocatedExport,
+}
+
+// addRelocated add a departed user
+var addRelocated = &cobra.Command{
+	Use:   "relocated address new_location",
+	Short: "Bounce mail for a departed user with their new location",
+	Long: `Bounce mail for address with a "user has moved to new_location" message.
+The address is 'user', 'user@domain' or '@domain' for every address in the domain.
+The new location is usually the new email address but can be any text.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: relocatedAdd,
+}
+
+// deleteRelocated remove a departed user
+var deleteRelocated = &cobra.Command{
+	Use:   "relocated address",
+	Short: "Delete a departed user address",
+	Long:  `Delete the relocated entry for address from the database.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  relocatedDelete,
+}
+
+// editRelocated change a departed user
+var editRelocated = &cobra.Command{
+	Use:   "relocated address",
+	Short: "Change the new location of a departed user",
+	Long:  `Change the new location reported for the relocated address.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  relocatedEdit,
+}
+
+// showRelocated display a departed user
+var showRelocated = &cobra.Command{
+	Use:   "relocated address",
+	Short: "Display a departed user address",
+	Long:  `Display the relocated entry for address to the standard output`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  relocatedShow,
+}
+
+// linkage to top level commands
+func init() {
+	importCmd.AddCommand(importRelocated)
+	exportCmd.AddCommand(exportRelocated)
+	addCmd.AddCommand(addRelocated)
+	deleteCmd.AddCommand(deleteRelocated)
+	editCmd.AddCommand(editRelocated)
+	editRelocated.Flags().StringVarP(&relocTarget, "target", "t", "",
+		"New location for this address")
+	showCmd.AddCommand(showRelocated)
+}
+
+// relocatedImport the departed users in relocated(5) format
+func relocatedImport(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	err = procImport(cmd, SIMPLE, procRelocated)
+	return err
+}
+
+// procRelocated
+func procRelocated(tokens []string) error {
+	if len(tokens) < 2 {
+		return fmt.Errorf("A relocated entry must be 'address new_location'")
+	}
+	_, err := mdb.InsertRelocated(tokens[0], strings.Join(tokens[1:], " "))
+	return err
+}
+
+// relocatedExport the departed users in relocated(5) format
+func relocatedExport(cmd *cobra.Command, args []string) error {
+	var (
+		err     error
+		pattern string = "*"
+		rlist   []*maildb.Relocated
+	)
+
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	if rlist, err = mdb.FindRelocated(pattern); err == nil {
+		for _, r := range rlist {
+			cmd.Printf("%s\n", r.Export())
+		}
+	}
+	return err
+}
+
+// relocatedAdd the departed user
+func relocatedAdd(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	err = procRelocated(args)
+	return err
+}
+
+// relocatedCheck
+// the mailbox and virtual deletes check the new location before
+// they delete anything
+func relocatedCheck(cmd *cobra.Command) error {
+	if cmd.Flags().Changed("relocated") && strings.TrimSpace(relocTarget) == "" {
+		return maildb.ErrMdbRelocatedNoTarget
+	}
+	return nil
+}
+
+// relocatedLeave
+// used by the mailbox and virtual deletes to leave a relocated entry
+// behind once the address is gone. It is in the delete's transaction
+// so the address stays if the entry cannot be made
+func relocatedLeave(cmd *cobra.Command, address string) error {
+	if !cmd.Flags().Changed("relocated") {
+		return nil
+	}
+	_, err := mdb.InsertRelocated(address, relocTarget)
+	return err
+}
+
+// relocatedDelete the departed user in the first arg
+func relocatedDelete(cmd *cobra.Command, args []string) error {
+	return mdb.DeleteRelocated(args[0])
+}
+
+// relocatedEdit the departed user in the first arg
+func relocatedEdit(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		r   *maildb.Relocated
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if r, err = mdb.GetRelocated(args[0]); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("target") {
+		err = r.SetTarget(relocTarget)
+	}
+	return err
+}
+
+// relocatedShow the departed user in the first arg
+func relocatedShow(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		r   *maildb.Relocated
+	)
+
+	if r, err = mdb.LookupRelocated(args[0]); err == nil {
+		cmd.Printf("Address:\t%s\nNew Location:\t%s\n", r.Address(), r.Target())
+	}
+	return err
+}
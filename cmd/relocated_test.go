@@ -0,0 +1,170 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestRelocatedCmd
+func TestRelocatedCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		relocFile   string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestRelocatedCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestRelocatedCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	relocFile = filepath.Join(dir, "relocated")
+	err = ioutil.WriteFile(relocFile, []byte(`# departed users
+bill@zip.com	bill@dish.net
+@run.com	the new pobox.org domain
+`), 0644)
+	if err != nil {
+		t.Errorf("Write of %s: Unexpected error, %s", relocFile, err)
+		return
+	}
+	args = []string{"-d", dbfile, "import", "relocated", "-i", relocFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import relocated: Unexpected error, %s", err)
+	}
+	if errout != "" {
+		t.Errorf("Import relocated: did not expect error output, got %s", errout)
+	}
+
+	// leave a forwarding note for a mailbox and a virtual alias
+	args = []string{"-d", dbfile, "add", "virtual", "sales@pobox.org", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual sales@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "virtual", "sales@pobox.org", "-r", "sales@dish.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete virtual sales@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "mailbox", "dave@pobox.org", "--relocated", "dave@dish.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete mailbox dave@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "mailbox", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Show mailbox dave@pobox.org: should have failed")
+	}
+
+	// the mailbox stays if its relocated entry cannot be made
+	args = []string{"-d", dbfile, "add", "relocated", "jeff@pobox.org", "jeff@old.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add relocated jeff@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "mailbox", "jeff@pobox.org", "--relocated", "jeff@dish.net"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != maildb.ErrMdbDupRelocated {
+		t.Errorf("Delete mailbox jeff@pobox.org: expected duplicate relocated, got %v", err)
+	}
+	args = []string{"-d", dbfile, "delete", "relocated", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete relocated jeff@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "mailbox", "jeff@pobox.org", "--relocated", " "}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != maildb.ErrMdbRelocatedNoTarget {
+		t.Errorf("Delete mailbox jeff@pobox.org with no target: expected no target, got %v", err)
+	}
+	args = []string{"-d", dbfile, "show", "mailbox", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show mailbox jeff@pobox.org: should still be there, %s", err)
+	}
+
+	args = []string{"-d", dbfile, "edit", "relocated", "@run.com", "-t", "pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit relocated @run.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "relocated", "@run.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show relocated @run.com: Unexpected error, %s", err)
+	}
+	if out != "Address:\t@run.com\nNew Location:\tpobox.org\n" {
+		t.Errorf("Show relocated @run.com: did not get expected output, got %s", out)
+	}
+	args = []string{"-d", dbfile, "export", "relocated"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export relocated: Unexpected error, %s", err)
+	}
+	if out != "@run.com pobox.org\nbill@zip.com bill@dish.net\n"+
+		"dave@pobox.org dave@dish.net\nsales@pobox.org sales@dish.net\n" {
+		t.Errorf("Export relocated: did not get expected output, got %s", out)
+	}
+
+	// Open the database directly so we can test views.
+	// This is the lookup postfix makes with relocated.query
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT target FROM relocated_map WHERE address = 'dave@pobox.org'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"target": "dave@dish.net",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup dave@pobox.org: %s", err)
+	}
+	mdb.Close()
+
+	args = []string{"-d", dbfile, "delete", "relocated", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete relocated dave@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "relocated", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Show relocated dave@pobox.org: should have failed")
+	} else if err != maildb.ErrMdbRelocatedNotFound {
+		t.Errorf("Show relocated dave@pobox.org: Unexpected error, %s", err)
+	}
+}
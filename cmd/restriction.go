@@ -0,0 +1,205 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	classRestrictions string
+)
+
+// importRestrictionClass do import of main.cf class definitions
+var importRestrictionClass = &cobra.Command{
+	Use:   "restriction-classes",
+	Short: "Import restriction class definitions in main.cf format",
+	Long: `Import smtpd restriction class definitions from the file named by the -i flag
+(default stdin '-'). Each definition is a main.cf 'name = restriction, ...' line
+which can be continued on indented lines. The smtpd_restriction_classes line itself is skipped.`,
+	Args: cobra.NoArgs,
+	RunE: restrictionImport,
+}
+
+// exportRestrictionClass do export of the main.cf fragment
+var exportRestrictionClass = &cobra.Command{
+	Use:   "restriction-classes [name]",
+	Short: "Export restriction class definitions as a main.cf fragment",
+	Long: `Export the smtpd_restriction_classes parameter and the definition of each class
+to the file named by the -o flag (default stdout '-'). The optional name
+can contain '*' to match a set of them.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: restrictionExport,
+}
+
+// addRestrictionClass add a class
+var addRestrictionClass = &cobra.Command{
+	Use:   "restriction-class name restriction ...",
+	Short: "Add a restriction class definition to the database",
+	Long: `Add the named restriction class to the database. The rest of the arguments
+are its restrictions in the order postfix evaluates them. The name is
+what access rules use as their action.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: restrictionAdd,
+}
+
+// deleteRestrictionClass delete a class
+var deleteRestrictionClass = &cobra.Command{
+	Use:   "restriction-class name",
+	Short: "Delete the named restriction class from the database",
+	Long: `Delete the named restriction class from the database. Access rules that
+use it are not changed. The check command reports them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: restrictionDelete,
+}
+
+// editRestrictionClass change a class
+var editRestrictionClass = &cobra.Command{
+	Use:   "restriction-class name",
+	Short: "Change the restrictions of the named restriction class",
+	Long:  `Replace the restriction list of the named restriction class.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  restrictionEdit,
+}
+
+// showRestrictionClass display a class
+var showRestrictionClass = &cobra.Command{
+	Use:   "restriction-class name",
+	Short: "Display the named restriction class",
+	Long:  `Display the named restriction class and its restrictions to the standard output.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  restrictionShow,
+}
+
+// linkage to top level commands
+func init() {
+	importCmd.AddCommand(importRestrictionClass)
+	exportCmd.AddCommand(exportRestrictionClass)
+	addCmd.AddCommand(addRestrictionClass)
+	deleteCmd.AddCommand(deleteRestrictionClass)
+	editCmd.AddCommand(editRestrictionClass)
+	editRestrictionClass.Flags().StringVarP(&classRestrictions, "restrictions", "r", "",
+		"New comma separated restriction list for this class")
+	showCmd.AddCommand(showRestrictionClass)
+}
+
+// restrictionImport the class definitions
+func restrictionImport(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	err = procImport(cmd, SIMPLE, procRestrictionClass)
+	return err
+}
+
+// procRestrictionClass
+// The SIMPLE split is on white space but main.cf does not need any
+// around the '=' so put the line back together and split it there
+func procRestrictionClass(tokens []string) error {
+	line := strings.Join(tokens, " ")
+	eq := strings.IndexByte(line, '=')
+	if eq == -1 {
+		return fmt.Errorf("A restriction class must be 'name = restriction, ...'")
+	}
+	name := strings.TrimSpace(line[:eq])
+	if name == "smtpd_restriction_classes" {
+		return nil
+	}
+	_, err := mdb.InsertRestrictionClass(name, line[eq+1:])
+	return err
+}
+
+// restrictionExport the classes as a main.cf fragment
+func restrictionExport(cmd *cobra.Command, args []string) error {
+	var (
+		err     error
+		pattern string = "*"
+		rlist   []*maildb.RestrictionClass
+		names   []string
+	)
+
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	if rlist, err = mdb.FindRestrictionClass(pattern); err != nil {
+		return err
+	}
+	for _, rc := range rlist {
+		names = append(names, rc.Name())
+	}
+	cmd.Printf("smtpd_restriction_classes = %s\n", strings.Join(names, ", "))
+	for _, rc := range rlist {
+		cmd.Printf("%s\n", rc.Export())
+	}
+	return nil
+}
+
+// restrictionAdd the class in the first arg
+func restrictionAdd(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	_, err = mdb.InsertRestrictionClass(args[0], strings.Join(args[1:], " "))
+	return err
+}
+
+// restrictionDelete the class in the first arg
+func restrictionDelete(cmd *cobra.Command, args []string) error {
+	return mdb.DeleteRestrictionClass(args[0])
+}
+
+// restrictionEdit the class in the first arg
+func restrictionEdit(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		rc  *maildb.RestrictionClass
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if rc, err = mdb.GetRestrictionClass(args[0]); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("restrictions") {
+		err = rc.SetRestrictions(classRestrictions)
+	} else {
+		err = fmt.Errorf("restrictions option for restriction-class edit not set")
+	}
+	return err
+}
+
+// restrictionShow the class in the first arg
+func restrictionShow(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		rc  *maildb.RestrictionClass
+	)
+
+	if rc, err = mdb.LookupRestrictionClass(args[0]); err == nil {
+		cmd.Printf("Name:\t\t%s\nRestrictions:\t%s\n", rc.Name(), rc.Restrictions())
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestRestrictionClassCmd
+func TestRestrictionClassCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		inFile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestRestrictionClassCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestRestrictionClassCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = filepath.Join(dir, "test.db")
+
+	args = []string{"create", "-d", dbfile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "import", "access", "-i", "./test_access.txt"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import of access: Unexpected error, %s", err)
+	}
+
+	// nothing in test_access.txt is defined yet
+	args = []string{"-d", dbfile, "check", "--fix=false"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != exitStatus(2) {
+		t.Errorf("Check: expected status 2, got %v", err)
+	}
+	if out != "error: Access DUMP: action x-dump is not a defined restriction class\n"+
+		"error: Access STALL: action x-stall is not a defined restriction class\n"+
+		"error: Access permit: action x-permit is not a defined restriction class\n"+
+		"error: Access reject: action x-reject is not a defined restriction class\n"+
+		"notice: Access DUMP: not used by any address, domain or access check\n"+
+		"notice: Access STALL: not used by any address, domain or access check\n"+
+		"notice: Access permit: not used by any address, domain or access check\n"+
+		"notice: Access reject: not used by any address, domain or access check\n" {
+		t.Errorf("Check: did not get expected output, got %s", out)
+	}
+
+	inFile = filepath.Join(dir, "classes.cf")
+	if err = ioutil.WriteFile(inFile, []byte(`# our classes
+smtpd_restriction_classes = x-dump, x-stall
+x-dump = check_recipient_access hash:/etc/postfix/dump,
+	reject
+x-stall=defer_if_permit
+`), 0644); err != nil {
+		t.Errorf("Write of classes.cf: Unexpected error, %s", err)
+		return
+	}
+	args = []string{"-d", dbfile, "import", "restriction-classes", "-i", inFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import of restriction-classes: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "restriction-class", "x-permit", "permit_mynetworks,", "permit"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add x-permit: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "restriction-class", "x-permit", "permit"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add x-permit again: should have failed")
+	} else if err != maildb.ErrMdbDupClass {
+		t.Errorf("Add x-permit again: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "check", "--fix=false"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != exitStatus(2) {
+		t.Errorf("Check after import: expected status 2, got %v", err)
+	}
+	if out != "error: Access reject: action x-reject is not a defined restriction class\n"+
+		"notice: Access DUMP: not used by any address, domain or access check\n"+
+		"notice: Access STALL: not used by any address, domain or access check\n"+
+		"notice: Access permit: not used by any address, domain or access check\n"+
+		"notice: Access reject: not used by any address, domain or access check\n" {
+		t.Errorf("Check after import: did not get expected output, got %s", out)
+	}
+
+	args = []string{"-d", dbfile, "edit", "restriction-class", "x-stall", "-r", "defer_if_reject"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit x-stall: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "restriction-class", "x-stall"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show x-stall: Unexpected error, %s", err)
+	}
+	if out != "Name:\t\tx-stall\nRestrictions:\tdefer_if_reject\n" {
+		t.Errorf("Show x-stall: did not get expected output, got %s", out)
+	}
+
+	args = []string{"-d", dbfile, "export", "restriction-classes"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export restriction-classes: Unexpected error, %s", err)
+	}
+	if out != "smtpd_restriction_classes = x-dump, x-permit, x-stall\n"+
+		"x-dump = check_recipient_access hash:/etc/postfix/dump, reject\n"+
+		"x-permit = permit_mynetworks, permit\n"+
+		"x-stall = defer_if_reject\n" {
+		t.Errorf("Export restriction-classes: did not get expected output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Export restriction-classes: did not expect error output, got %s", errout)
+	}
+
+	args = []string{"-d", dbfile, "delete", "restriction-class", "x-dump"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete x-dump: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "restriction-class", "x-dump"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Show x-dump after delete: should have failed")
+	} else if err != maildb.ErrMdbClassNotFound {
+		t.Errorf("Show x-dump after delete: Unexpected error, %s", err)
+	}
+}
@@ -18,7 +18,9 @@ package cmd
 
 import (
 	_ "embed"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/lieb/postdove/maildb"
 	"github.com/spf13/cobra"
@@ -66,6 +68,121 @@ You will also have to do some imports and adds to this otherwise empty database.
 	RunE: cmdCreate,
 }
 
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the database for problems postfix or dovecot would find",
+	Long: `Check the database for entries that are consistent in the database but that
+postfix or dovecot would trip over, such as access rules whose action is not
+a restriction class defined in the database, alias loops and mailboxes with no
+uid or gid. Findings are reported as errors, warnings or notices. The exit
+status is 2 if there are errors, 1 if there are only warnings and 0 otherwise.`,
+	Args:          cobra.NoArgs,
+	RunE:          cmdCheck,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// queryCmd represents the query command
+var queryCmd = &cobra.Command{
+	Use:   "query map key",
+	Short: "Look up a key in a postfix map the way postfix does",
+	Long: `Look up key in one of the postfix maps with its query file the way postfix
+does, expanding %s, %u and %d in the query and retrying the key without its
+extension, as @domain and so on in postfix's order until one is found. The
+result is printed the way "postmap -q" prints it. The exit status is 1 if
+nothing is found. The maps are:
+  ` + strings.Join(maildb.PostfixMaps(), ", ") + `.`,
+	Args:          cobra.ExactArgs(2),
+	RunE:          cmdQuery,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [socketmap|policy]",
+	Short: "Run a lookup or policy server for postfix",
+	Long: `Run a server that postfix connects to for its lookups in the database in
+place of opening the database file itself or for its policy checks.`,
+}
+
+// checkpasswordCmd represents the checkpassword command
+var checkpasswordCmd = &cobra.Command{
+	Use:   "checkpassword prog [args...]",
+	Short: "Check a login for dovecot or another service with the checkpassword interface",
+	Long: `Check a login with the checkpassword interface. The user, password and
+timestamp, each ending in a NUL, are read from file descriptor 3. The user is
+a mailbox address and the password is checked with the scheme it is stored
+in. The mailbox must be enabled. If the login is good, prog is run with USER,
+HOME and dovecot's userdb_uid, userdb_gid, userdb_quota_rule and EXTRA set
+for it. With --userdb-only, for a dovecot userdb, the password is not checked
+if dovecot sets AUTHORIZED=1 and AUTHORIZED=2 is set. Otherwise the password is
+always checked. The exit status is 1 for a bad login, 2 if the input is wrong
+and 111 if the database could not be used.`,
+	Args:          cobra.MinimumNArgs(1),
+	RunE:          cmdCheckpassword,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+// explainCmd represents the explain command
+var explainCmd = &cobra.Command{
+	Use:   "explain address",
+	Short: "Trace what postfix will do with mail for an address",
+	Long: `Trace what postfix will do with mail for address by making its lookups
+in the database in the order it makes them. Each address the mail is
+expanded into is shown under the one it came from. Loops, dead ends,
+disabled mailboxes and anything else that loses or bounces the mail
+are marked with "**".`,
+	Args: cobra.ExactArgs(1),
+	RunE: cmdExplain,
+}
+
+// vacationCmd represents the vacation command
+var vacationCmd = &cobra.Command{
+	Use:   "vacation [set|clear|show]",
+	Short: "Manage the vacation auto-reply of a mailbox",
+	Long: `Manage the out of office auto-reply of a mailbox. The settings are kept in
+the database and a dovecot Sieve vacation script is generated from them
+into the mailbox home.`,
+}
+
+// sieveCmd represents the sieve command
+var sieveCmd = &cobra.Command{
+	Use:   "sieve [put|get|list|activate|delete|deploy]",
+	Short: "Manage the Sieve scripts of mailboxes and domains",
+	Long: `Manage the Sieve filter scripts of mailboxes and the before and after
+scripts of domains. The scripts are kept in the database and written out
+to where dovecot's Pigeonhole plugin finds them.`,
+}
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list [create|delete|edit|add-member|remove-member|show|import|export]",
+	Short: "Manage distribution lists",
+	Long: `Manage distribution lists. A list is an address whose mail goes to all of
+its members. The members are kept in the database and postfix finds them
+through the alias maps in place of an :include: file.`,
+}
+
+// limitCmd represents the limit command
+var limitCmd = &cobra.Command{
+	Use:   "limit [set|clear|show]",
+	Short: "Manage the send limits of mailboxes and domains",
+	Long: `Manage how much mail a mailbox, or each mailbox of a domain, may send. The
+limits are checked by the policy server, "serve policy", which keeps count
+of what each mailbox sends.`,
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config [generate]",
+	Short: "Manage the postfix and dovecot configuration for the database",
+	Long: `Manage the postfix query files and main.cf parameters and the dovecot SQL
+configuration that use the database.`,
+}
+
 // importCmd represents the import command
 var importCmd = &cobra.Command{
 	Use:   "import [table] ",
@@ -125,19 +242,34 @@ var showCmd = &cobra.Command{
 readable format`,
 }
 
+// exitStatus
+// Returned by a command that has printed what it has to say and only
+// needs to exit with this status, like grep or postmap -q.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	cobra.CheckErr(rootCmd.Execute())
+	err := rootCmd.Execute()
+	if status, ok := err.(exitStatus); ok {
+		os.Exit(int(status))
+	}
+	cobra.CheckErr(err)
 }
 
 // callPersistentPreRunE
 // hack to chain preruns for import/export/??
 // otherwise, the db doesn't get opened which is a root prerun for everything!
+// It is the root's, the parent of "import access" is import itself and its
+// redirect would be done twice.
 func callPersistentPreRunE(cmd *cobra.Command, args []string) error {
-	if parent := cmd.Parent(); parent != nil {
-		if parent.PersistentPreRunE != nil {
-			if err := parent.PersistentPreRunE(parent, args); err != nil {
+	if root := cmd.Root(); root != cmd {
+		if root.PersistentPreRunE != nil {
+			if err := root.PersistentPreRunE(root, args); err != nil {
 				return err
 			}
 		}
@@ -177,6 +309,21 @@ func init() {
 	// Create command and schema arg
 	rootCmd.AddCommand(createCmd)
 
+	// Check command
+	rootCmd.AddCommand(checkCmd)
+
+	// Explain command
+	rootCmd.AddCommand(explainCmd)
+
+	// Checkpassword command
+	rootCmd.AddCommand(checkpasswordCmd)
+
+	// Query command
+	rootCmd.AddCommand(queryCmd)
+
+	// Serve command
+	rootCmd.AddCommand(serveCmd)
+
 	// Import command and input file arg
 	rootCmd.AddCommand(importCmd)
 	importCmd.PersistentFlags().StringVarP(&inFilePath, "input", "i", "-",
@@ -198,4 +345,19 @@ func init() {
 
 	// Show command
 	rootCmd.AddCommand(showCmd)
+
+	// Vacation command
+	rootCmd.AddCommand(vacationCmd)
+
+	// Sieve command
+	rootCmd.AddCommand(sieveCmd)
+
+	// List command
+	rootCmd.AddCommand(listCmd)
+
+	// Limit command
+	rootCmd.AddCommand(limitCmd)
+
+	// Config command
+	rootCmd.AddCommand(configCmd)
 }
@@ -0,0 +1,104 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+// addSendAs grant logins the use of a sender address
+var addSendAs = &cobra.Command{
+	Use:   "sendas sender login ...",
+	Short: "Allow mailbox logins to send as an address or domain",
+	Long: `Allow one or more mailbox logins to use sender as the envelope sender.
+The sender is either an RFC2822 address or '@domain' for any address in the domain.
+A mailbox can always send as its own address and its virtual aliases.
+This is the data for postfix smtpd_sender_login_maps.`,
+	Args: cobra.MinimumNArgs(2), // sender login ...
+	RunE: sendasAdd,
+}
+
+// deleteSendAs remove a login's use of a sender address
+var deleteSendAs = &cobra.Command{
+	Use:   "sendas sender login ...",
+	Short: "Remove mailbox logins from a sender address or domain",
+	Long: `Remove one or more mailbox logins from the list allowed to use sender.
+Only the grants made with 'add sendas' can be removed.`,
+	Args: cobra.MinimumNArgs(2), // sender login ...
+	RunE: sendasDelete,
+}
+
+// showSendAs display the logins allowed to use a sender
+var showSendAs = &cobra.Command{
+	Use:   "sendas sender",
+	Short: "Display the mailbox logins allowed to send as an address or domain",
+	Long: `Display the mailbox logins allowed to use sender as the envelope sender
+including mailboxes that own the address to the standard output`,
+	Args: cobra.ExactArgs(1),
+	RunE: sendasShow,
+}
+
+// linkage to top level commands
+func init() {
+	addCmd.AddCommand(addSendAs)
+	deleteCmd.AddCommand(deleteSendAs)
+	showCmd.AddCommand(showSendAs)
+}
+
+// sendasAdd the logins to the sender
+func sendasAdd(cmd *cobra.Command, args []string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	for _, l := range args[1:] {
+		if _, err = mdb.InsertSendAs(args[0], l); err != nil {
+			break
+		}
+	}
+	return err
+}
+
+// sendasDelete the logins from the sender
+func sendasDelete(cmd *cobra.Command, args []string) error {
+	var err error
+
+	for _, l := range args[1:] {
+		if err = mdb.DeleteSendAs(args[0], l); err != nil {
+			break
+		}
+	}
+	return err
+}
+
+// sendasShow the logins for the sender in the first arg
+func sendasShow(cmd *cobra.Command, args []string) error {
+	var (
+		err   error
+		slist []*maildb.SendAs
+	)
+
+	if slist, err = mdb.LookupSendAs(args[0]); err == nil {
+		cmd.Printf("Sender:\t\t%s\nLogins:", slist[0].Sender())
+		for _, s := range slist {
+			cmd.Printf("\t\t%s\n", s.Login())
+		}
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestSendAsCmd
+func TestSendAsCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestSendAsCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestSendAsCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "add", "virtual", "sales@pobox.org", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual sales@pobox.org: Unexpected error, %s", err)
+	}
+
+	// a mailbox owns its address and its aliases
+	args = []string{"-d", dbfile, "show", "sendas", "sales@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show sendas sales@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "Sender:\t\tsales@pobox.org\nLogins:\t\tjeff@pobox.org\n" {
+		t.Errorf("Show sendas sales@pobox.org: did not get expected output, got %s", out)
+	}
+
+	// only mailboxes can log in
+	args = []string{"-d", dbfile, "add", "sendas", "sales@pobox.org", "sales@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add sendas sales@pobox.org for itself: should have failed")
+	} else if err != maildb.ErrMdbNotMbox {
+		t.Errorf("Add sendas sales@pobox.org for itself: Unexpected error, %s", err)
+	}
+
+	args = []string{"-d", dbfile, "add", "sendas", "sales@pobox.org", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add sendas sales@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "" {
+		t.Errorf("Add sendas sales@pobox.org: did not expect output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Add sendas sales@pobox.org: did not expect error output, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "add", "sendas", "@run.com", "dave@pobox.org", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add sendas @run.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "sendas", "sales@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show sendas sales@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "Sender:\t\tsales@pobox.org\nLogins:\t\tdave@pobox.org\n\t\tjeff@pobox.org\n" {
+		t.Errorf("Show sendas sales@pobox.org: did not get expected output, got %s", out)
+	}
+
+	// Open the database directly so we can test views.
+	// These are the lookups postfix makes with sender_login.query
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT login FROM sender_login WHERE sender = '@run.com'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"login": "dave@pobox.org",
+		},
+		{
+			"login": "jeff@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup @run.com: %s", err)
+	}
+	q = `
+SELECT login FROM sender_login WHERE sender = 'dave@pobox.org'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"login": "dave@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup dave@pobox.org: %s", err)
+	}
+	mdb.Close()
+
+	args = []string{"-d", dbfile, "delete", "sendas", "@run.com", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete sendas @run.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "sendas", "@run.com", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Delete sendas @run.com again: should have failed")
+	} else if err != maildb.ErrMdbSendAsNotFound {
+		t.Errorf("Delete sendas @run.com again: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "sendas", "@run.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show sendas @run.com: Unexpected error, %s", err)
+	}
+	if out != "Sender:\t\t@run.com\nLogins:\t\tdave@pobox.org\n" {
+		t.Errorf("Show sendas @run.com: did not get expected output, got %s", out)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestSenderTransport
+func TestSenderTransport(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestSenderTransport")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestSenderTransport-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "add", "transport", "provider", "-t", "smtp",
+		"-n", "[smtp.provider.net]:587"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add transport provider: Unexpected error, %s", err)
+	}
+
+	// all of run.com goes out through the provider
+	args = []string{"-d", dbfile, "edit", "domain", "run.com", "--sender-transport", "provider"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit run.com sender transport: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "domain", "run.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show run.com: Unexpected error, %s", err)
+	}
+	if out != "Name:\t\trun.com\nClass:\t\tvirtual\nTransport:\t--\n"+
+		"UserID:\t\t83\nGroup ID:\t99\nRestrictions:\t--\nSender Relay:\tprovider\n" {
+		t.Errorf("Show run.com: did not get expected output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Show run.com: did not expect error output, got %s", errout)
+	}
+
+	// except for the boss who goes direct
+	args = []string{"-d", dbfile, "add", "address", "boss@run.com", "-s", "relay"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add boss@run.com: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "address", "boss@run.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show boss@run.com: Unexpected error, %s", err)
+	}
+	if out != "Address:\tboss@run.com\nTransport:\t--\nRestrictions:\t--\n"+
+		"Sender Relay:\trelay\n" {
+		t.Errorf("Show boss@run.com: did not get expected output, got %s", out)
+	}
+	args = []string{"-d", dbfile, "add", "address", "boss", "-s", "relay"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add boss -s relay: should have failed")
+	} else if err != maildb.ErrMdbSendAsNoDomain {
+		t.Errorf("Add boss -s relay: Unexpected error, %s", err)
+	}
+
+	// round trip through export/import
+	args = []string{"-d", dbfile, "export", "domain", "run.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export run.com: Unexpected error, %s", err)
+	}
+	if out != "run.com class=virtual, vuid=83, vgid=99, sender_transport=provider\n" {
+		t.Errorf("Export run.com: got (%s)", out)
+	}
+	args = []string{"-d", dbfile, "export", "address", "boss@run.com"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export boss@run.com: Unexpected error, %s", err)
+	}
+	if out != "boss@run.com, sender_transport=relay\n" {
+		t.Errorf("Export boss@run.com: got (%s)", out)
+	}
+	impFile := filepath.Join(dir, "addr.txt")
+	if err = ioutil.WriteFile(impFile,
+		[]byte("sales@dish.net sender_transport=provider\n"), 0644); err != nil {
+		t.Errorf("Could not write %s, %s", impFile, err)
+	}
+	args = []string{"-d", dbfile, "import", "address", "-i", impFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import of sales@dish.net: Unexpected error, %s", err)
+	}
+
+	// Open the database directly so we can test views.
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT sender, transport FROM sender_transport ORDER BY sender
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"sender":    "@run.com",
+			"transport": "smtp:[smtp.provider.net]:587",
+		},
+		{
+			"sender":    "boss@run.com",
+			"transport": "smtp:faraway.net:25",
+		},
+		{
+			"sender":    "sales@dish.net",
+			"transport": "smtp:[smtp.provider.net]:587",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("sender_transport: %s", err)
+	}
+	q = `
+SELECT relayhost FROM sender_relayhost WHERE sender = '@run.com'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"relayhost": "[smtp.provider.net]:587",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("sender_relayhost @run.com: %s", err)
+	}
+	mdb.Close()
+
+	// the provider is busy while anyone uses it
+	args = []string{"-d", dbfile, "delete", "transport", "provider"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Delete transport provider: should have failed")
+	} else if err != maildb.ErrMdbTransBusy {
+		t.Errorf("Delete transport provider: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "edit", "domain", "run.com", "--no-sender-transport"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit run.com --no-sender-transport: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "edit", "address", "sales@dish.net", "-S"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit sales@dish.net -S: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "delete", "transport", "provider"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete transport provider: Unexpected error, %s", err)
+	}
+}
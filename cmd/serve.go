@@ -0,0 +1,427 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+// socketmapMax is the longest request or reply postfix sends or takes
+const socketmapMax = 100000
+
+// socketmapSecret are the maps that are never served. They return
+// passwords and the lookups are not authenticated.
+var socketmapSecret = map[string]bool{
+	"sasl_password": true,
+}
+
+var (
+	serveListen   string
+	serveMode     string
+	serveMaxConns int
+	serveTimeout  time.Duration
+	serveQueryDir string
+)
+
+// serveSocketmap the socketmap server
+var serveSocketmap = &cobra.Command{
+	Use:   "socketmap",
+	Short: "Answer postfix socketmap lookups",
+	Long: `Answer postfix socketmap_table(5) lookups for all the maps with query files
+from the views in the database. The map name in a postfix parameter is the
+name of its query file without the ".query", for example
+
+  virtual_alias_maps = socketmap:unix:private/postdove:virtual_alias
+
+The lookups are the ones the query files make. The sasl_password map is not
+served because it returns passwords. The database is opened again if the
+file is replaced and, along with the query files, on SIGHUP.`,
+	Args: cobra.NoArgs,
+	RunE: serveSocketmapCmd,
+}
+
+func init() {
+	serveCmd.AddCommand(serveSocketmap)
+	serveSocketmap.Flags().StringVarP(&serveListen, "listen", "l", "",
+		"Listen on unix:/path/to/socket or inet:host:port, a loopback host")
+	serveSocketmap.MarkFlagRequired("listen")
+	serveSocketmap.Flags().StringVarP(&serveMode, "mode", "M", "",
+		"Octal permissions of a unix socket instead of those from the umask")
+	serveSocketmap.Flags().IntVarP(&serveMaxConns, "max-conns", "m", 20,
+		"Most connections served at once, the rest wait")
+	serveSocketmap.Flags().DurationVarP(&serveTimeout, "timeout", "t", 5*time.Minute,
+		"Close a connection idle for this long")
+	serveSocketmap.Flags().StringVarP(&serveQueryDir, "query-dir", "q", "",
+		"Read the query files from this directory instead of the built in ones")
+}
+
+// serveSocketmapCmd
+// Serve until SIGINT or SIGTERM
+func serveSocketmapCmd(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		ln  net.Listener
+		srv *socketmapServer
+	)
+
+	logger := log.New(cmd.ErrOrStderr(), "postdove: ", 0)
+	if srv, err = newSocketmapServer(dbFile, serveQueryDir, logger); err != nil {
+		return err
+	}
+	defer srv.close()
+	if ln, err = listenOn(serveListen, serveMode); err != nil {
+		return err
+	}
+	defer handleSignals(ln, srv.reload, logger)()
+	return srv.serve(ln, serveMaxConns, serveTimeout)
+}
+
+// handleSignals
+// SIGHUP calls reload, SIGINT and SIGTERM close the listener to stop the
+// server. The returned function stops the handling.
+func handleSignals(ln net.Listener, reload func() error, logger *log.Logger) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			if sig != syscall.SIGHUP {
+				ln.Close()
+				return
+			}
+			if err := reload(); err != nil {
+				logger.Printf("reload: %s", err)
+			}
+		}
+	}()
+	return func() { signal.Stop(sigs) }
+}
+
+// listenOn
+// Listen on a postfix style address, unix:/path or inet:host:port. A
+// socket left behind by a server that is gone is removed first. The
+// socket gets the octal mode, if there is one, or the umask's. There is
+// no access control on the connections so inet is only for loopback.
+func listenOn(addr string, mode string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		var perm uint64
+
+		if mode != "" {
+			var err error
+			if perm, err = strconv.ParseUint(mode, 8, 32); err != nil || perm > 0777 {
+				return nil, fmt.Errorf("socket mode %s is not octal permissions", mode)
+			}
+		}
+		path := strings.TrimPrefix(addr, "unix:")
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if mode != "" {
+			if err = os.Chmod(path, os.FileMode(perm)); err != nil {
+				ln.Close()
+				return nil, err
+			}
+		}
+		return ln, nil
+	case strings.HasPrefix(addr, "inet:"):
+		hostport := strings.TrimPrefix(addr, "inet:")
+		host, _, err := net.SplitHostPort(hostport)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("inet listen host %q is not loopback, the lookups have no access control", host)
+		}
+		return net.Listen("tcp", hostport)
+	default:
+		return nil, fmt.Errorf("listen address must be unix:/path or inet:host:port")
+	}
+}
+
+// socketmapServer
+// The database and queries the lookups are made with. They are swapped
+// by a reload while the lookups in progress finish with the old ones.
+type socketmapServer struct {
+	mu       sync.RWMutex
+	dbPath   string
+	queryDir string
+	mdb      *maildb.MailDB
+	dbInfo   os.FileInfo
+	queries  map[string]*maildb.PostfixQuery
+	log      *log.Logger
+}
+
+// newSocketmapServer
+func newSocketmapServer(dbPath string, queryDir string, logger *log.Logger) (*socketmapServer, error) {
+	srv := &socketmapServer{
+		dbPath:   dbPath,
+		queryDir: queryDir,
+		log:      logger,
+	}
+	if err := srv.open(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// open
+// the database and the queries, with the lock held
+func (srv *socketmapServer) open() error {
+	var (
+		err     error
+		queries map[string]*maildb.PostfixQuery
+	)
+
+	if srv.queryDir == "" {
+		queries, err = maildb.BuiltinQueries()
+	} else {
+		queries, err = readQueryDir(srv.queryDir)
+	}
+	if err != nil {
+		return err
+	}
+	for name := range socketmapSecret {
+		delete(queries, name)
+	}
+	fi, err := os.Stat(srv.dbPath)
+	if err != nil {
+		return err
+	}
+	mdb, err := maildb.NewMailDB(srv.dbPath)
+	if err != nil {
+		return err
+	}
+	if srv.mdb != nil {
+		srv.mdb.Close()
+	}
+	srv.mdb = mdb
+	srv.dbInfo = fi
+	srv.queries = queries
+	return nil
+}
+
+// reload
+func (srv *socketmapServer) reload() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.log.Printf("reloading %s", srv.dbPath)
+	return srv.open()
+}
+
+// close
+func (srv *socketmapServer) close() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.mdb != nil {
+		srv.mdb.Close()
+		srv.mdb = nil
+	}
+}
+
+// replaced
+// Has the database file been replaced since it was opened? Changes to
+// it are seen without opening it again but a new file is not.
+func (srv *socketmapServer) replaced() bool {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	fi, err := os.Stat(srv.dbPath)
+	return err == nil && !os.SameFile(fi, srv.dbInfo)
+}
+
+// readQueryDir
+// the query files in dir by map name
+func readQueryDir(dir string) (map[string]*maildb.PostfixQuery, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.query"))
+	if err != nil {
+		return nil, err
+	}
+	queries := make(map[string]*maildb.PostfixQuery)
+	for _, f := range files {
+		c, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		pq, err := maildb.ParsePostfixQuery(f, string(c))
+		if err != nil {
+			return nil, err
+		}
+		queries[strings.TrimSuffix(filepath.Base(f), ".query")] = pq
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no query files in %s", dir)
+	}
+	return queries, nil
+}
+
+// serve
+// Accept connections until the listener is closed
+func (srv *socketmapServer) serve(ln net.Listener, maxConns int, timeout time.Duration) error {
+	return acceptConns(ln, maxConns, func(conn net.Conn) {
+		srv.handle(conn, timeout)
+	})
+}
+
+// acceptConns
+// Accept connections until the listener is closed and handle each in
+// its own goroutine. Only maxConns are served at once, the rest wait in
+// the listen queue.
+func acceptConns(ln net.Listener, maxConns int, handle func(net.Conn)) error {
+	var wg sync.WaitGroup
+
+	slots := make(chan struct{}, maxConns)
+	defer wg.Wait()
+	for {
+		slots <- struct{}{}
+		conn, err := ln.Accept()
+		if err != nil {
+			<-slots
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			handle(conn)
+		}()
+	}
+}
+
+// handle
+// Answer the requests on one connection until postfix closes it
+func (srv *socketmapServer) handle(conn net.Conn, timeout time.Duration) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		req, err := readNetstring(r, socketmapMax)
+		if err != nil {
+			if err != io.EOF {
+				srv.log.Printf("socketmap: %s", err)
+			}
+			return
+		}
+		reply := srv.lookup(req)
+		if len(reply) > socketmapMax {
+			reply = "PERM reply too long"
+		}
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if err = writeNetstring(conn, reply); err != nil {
+			srv.log.Printf("socketmap: %s", err)
+			return
+		}
+	}
+}
+
+// lookup
+// Answer a "name key" request
+func (srv *socketmapServer) lookup(req string) string {
+	i := strings.Index(req, " ")
+	if i < 1 {
+		return "PERM request must be a map name and a key"
+	}
+	name, key := req[:i], strings.ToLower(req[i+1:])
+	if srv.replaced() {
+		if err := srv.reload(); err != nil {
+			srv.log.Printf("reload: %s", err)
+			return "TEMP database cannot be opened"
+		}
+	}
+
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	pq, ok := srv.queries[name]
+	if !ok {
+		return "PERM unknown map " + name
+	}
+	res, found, err := srv.mdb.PostfixLookup(pq, key)
+	if err != nil {
+		srv.log.Printf("%s %s: %s", name, key, err)
+		return "TEMP " + err.Error()
+	}
+	if !found {
+		return "NOTFOUND "
+	}
+	return "OK " + res
+}
+
+// readNetstring
+// A netstring, "length:data," is read from r
+func readNetstring(r *bufio.Reader, max int) (string, error) {
+	n := 0
+	for digits := 0; ; digits++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && digits == 0 {
+				return "", io.EOF
+			}
+			return "", fmt.Errorf("netstring: no length, %s", err)
+		}
+		if c == ':' && digits > 0 {
+			break
+		}
+		if c < '0' || c > '9' || digits > len(strconv.Itoa(max)) {
+			return "", fmt.Errorf("netstring: bad length")
+		}
+		n = n*10 + int(c-'0')
+	}
+	if n > max {
+		return "", fmt.Errorf("netstring: length %d is too long", n)
+	}
+	data := make([]byte, n+1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", fmt.Errorf("netstring: short data, %s", err)
+	}
+	if data[n] != ',' {
+		return "", fmt.Errorf("netstring: no trailing ','")
+	}
+	return string(data[:n]), nil
+}
+
+// writeNetstring
+func writeNetstring(w io.Writer, s string) error {
+	_, err := fmt.Fprintf(w, "%d:%s,", len(s), s)
+	return err
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// socketmapAsk
+// what a postfix client does for one lookup
+func socketmapAsk(conn net.Conn, r *bufio.Reader, req string) (string, error) {
+	if err := writeNetstring(conn, req); err != nil {
+		return "", err
+	}
+	return readNetstring(r, socketmapMax)
+}
+
+// TestSocketmapServer
+func TestSocketmapServer(t *testing.T) {
+	var (
+		err    error
+		dir    string
+		dbfile string
+		args   []string
+		reply  string
+	)
+
+	fmt.Println("TestSocketmapServer")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestSocketmapServer-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	args = []string{"-d", dbfile, "add", "virtual", "sales@pobox.org", "jeff@pobox.org", "dave@pobox.org"}
+	if _, _, err = doTest(rootCmd, "", args); err != nil {
+		t.Errorf("Add virtual sales@pobox.org: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "transport", "provider", "-t", "smtp",
+		"-n", "[smtp.provider.net]:587", "-u", "pobox", "-p", "-"}
+	if _, _, err = doTest(rootCmd, "s3cret\n", args); err != nil {
+		t.Errorf("Add transport provider: Unexpected error, %s", err)
+	}
+
+	srv, err := newSocketmapServer(dbfile, "", log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Errorf("New server: Unexpected error, %s", err)
+		return
+	}
+	defer srv.close()
+	if _, err = listenOn("tcp:localhost:0", ""); err == nil {
+		t.Errorf("Listen on tcp: should have failed")
+	}
+	// no access control so only loopback
+	for _, addr := range []string{"inet::0", "inet:0.0.0.0:0", "inet:[::]:0", "inet:pobox.org:0"} {
+		if _, err = listenOn(addr, ""); err == nil {
+			t.Errorf("Listen on %s: should have failed", addr)
+		}
+	}
+	if ln, err := listenOn("inet:127.0.0.1:0", ""); err != nil {
+		t.Errorf("Listen on inet:127.0.0.1:0: Unexpected error, %s", err)
+	} else {
+		ln.Close()
+	}
+	sock := filepath.Join(dir, "postdove")
+	if _, err = listenOn("unix:"+sock, "rw"); err == nil {
+		t.Errorf("Listen with mode rw: should have failed")
+	}
+	ln, err := listenOn("unix:"+sock, "0660")
+	if err != nil {
+		t.Errorf("Listen: Unexpected error, %s", err)
+		return
+	}
+	if fi, err := os.Stat(sock); err != nil {
+		t.Errorf("Stat socket: Unexpected error, %s", err)
+	} else if fi.Mode().Perm() != 0660 {
+		t.Errorf("Socket mode: expected 0660, got %o", fi.Mode().Perm())
+	}
+	done := make(chan error)
+	go func() {
+		done <- srv.serve(ln, 1, time.Minute)
+	}()
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Errorf("Dial: Unexpected error, %s", err)
+		ln.Close()
+		return
+	}
+	r := bufio.NewReader(conn)
+	for _, q := range []struct {
+		req, reply string
+	}{
+		{"virtual_alias sales@pobox.org", "OK jeff@pobox.org,dave@pobox.org"},
+		{"virtual_alias SALES@pobox.org", "OK jeff@pobox.org,dave@pobox.org"},
+		{"virtual_alias sales+x@pobox.org", "NOTFOUND "},
+		{"transport_maps dave@pobox.org", "OK lmtp:localhost:24"},
+		{"transport_maps pobox.org", "NOTFOUND "},
+		{"sender_login jeff@pobox.org", "OK jeff@pobox.org"},
+		{"nosuch jeff@pobox.org", "PERM unknown map nosuch"},
+		{"sasl_password [smtp.provider.net]:587", "PERM unknown map sasl_password"},
+		{"virtual_alias", "PERM request must be a map name and a key"},
+	} {
+		if reply, err = socketmapAsk(conn, r, q.req); err != nil {
+			t.Errorf("Ask %s: Unexpected error, %s", q.req, err)
+		} else if reply != q.reply {
+			t.Errorf("Ask %s: expected %q, got %q", q.req, q.reply, reply)
+		}
+	}
+
+	// only one connection at a time so the next waits for this one
+	conn2, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Errorf("Dial second: Unexpected error, %s", err)
+	} else {
+		r2 := bufio.NewReader(conn2)
+		conn2.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if reply, err = socketmapAsk(conn2, r2, "alias_maps postmaster"); err == nil {
+			t.Errorf("Ask second while first is open: should have waited, got %s", reply)
+		}
+		conn.Close()
+		conn2.SetReadDeadline(time.Now().Add(10 * time.Second))
+		if reply, err = readNetstring(r2, socketmapMax); err != nil {
+			t.Errorf("Ask second after first closed: Unexpected error, %s", err)
+		} else if reply != "OK root" {
+			t.Errorf("Ask second after first closed: expected OK root, got %q", reply)
+		}
+
+		// a new database file is noticed
+		newfile := filepath.Join(dir, "new.db")
+		c, _ := ioutil.ReadFile(dbfile)
+		if err = ioutil.WriteFile(newfile, c, 0644); err != nil {
+			t.Errorf("Copy DB: %s", err)
+		}
+		args = []string{"-d", newfile, "add", "virtual", "orders@pobox.org", "jeff@pobox.org"}
+		if _, _, err = doTest(rootCmd, "", args); err != nil {
+			t.Errorf("Add virtual orders@pobox.org: Unexpected error, %s", err)
+		}
+		if err = os.Rename(newfile, dbfile); err != nil {
+			t.Errorf("Rename DB: %s", err)
+		}
+		if reply, err = socketmapAsk(conn2, r2, "virtual_alias orders@pobox.org"); err != nil {
+			t.Errorf("Ask after new DB: Unexpected error, %s", err)
+		} else if reply != "OK jeff@pobox.org" {
+			t.Errorf("Ask after new DB: expected OK jeff@pobox.org, got %q", reply)
+		}
+
+		// a bad netstring drops the connection
+		if _, err = conn2.Write([]byte("x:abc,")); err != nil {
+			t.Errorf("Write bad netstring: %s", err)
+		}
+		if _, err = readNetstring(r2, socketmapMax); err != io.EOF {
+			t.Errorf("Read after bad netstring: expected EOF, got %v", err)
+		}
+		conn2.Close()
+	}
+
+	ln.Close()
+	if err = <-done; err != nil {
+		t.Errorf("Serve: Unexpected error, %s", err)
+	}
+}
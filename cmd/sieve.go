@@ -0,0 +1,356 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sieveInput    string
+	sieveActivate bool
+	sieveDir      string
+)
+
+// dovecot sieve_before and sieve_after scripts of the domains go here
+const defaultSieveDir = "/etc/dovecot/sieve"
+
+// sieveLink
+// Pigeonhole's default active script in the mailbox home. It is a link
+// into the "sieve" directory next to it that holds all the scripts.
+const sieveLink = ".dovecot.sieve"
+
+// putSieve store a script
+var putSieve = &cobra.Command{
+	Use:   "put owner name",
+	Short: "Store a Sieve script for a mailbox or domain",
+	Long: `Store the Sieve script name for owner, replacing any script already there.
+The owner is a mailbox, user@domain, or @domain for the before or after
+script that dovecot runs for every mailbox in the domain. The script is
+read from the file named by the -i flag (default stdin '-') and must pass
+the syntax check. The scripts of owner are then deployed.`,
+	Args: cobra.ExactArgs(2),
+	RunE: sievePut,
+}
+
+// getSieve print a script
+var getSieve = &cobra.Command{
+	Use:   "get owner name",
+	Short: "Print a Sieve script",
+	Long:  `Print the Sieve script name of owner to the standard output.`,
+	Args:  cobra.ExactArgs(2),
+	RunE:  sieveGet,
+}
+
+// listSieve list the scripts of an owner
+var listSieve = &cobra.Command{
+	Use:   "list owner",
+	Short: "List the Sieve scripts of a mailbox or domain",
+	Long: `List the names of the Sieve scripts of owner to the standard output.
+The active script is marked "ACTIVE".`,
+	Args: cobra.ExactArgs(1),
+	RunE: sieveList,
+}
+
+// activateSieve make a script the active one
+var activateSieve = &cobra.Command{
+	Use:   "activate user@domain [name]",
+	Short: "Make a Sieve script the active one of a mailbox",
+	Long: `Make the Sieve script name the one dovecot runs for the mailbox. Without
+a name, no script is active. The scripts of the mailbox are then deployed.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: sieveActivateCmd,
+}
+
+// deleteSieve remove a script
+var deleteSieve = &cobra.Command{
+	Use:   "delete owner name",
+	Short: "Delete a Sieve script",
+	Long: `Delete the Sieve script name of owner from the database and the deployed
+scripts. The active script of a mailbox must be deactivated first.`,
+	Args: cobra.ExactArgs(2),
+	RunE: sieveDelete,
+}
+
+// deploySieve write out the scripts
+var deploySieve = &cobra.Command{
+	Use:   "deploy [owner]",
+	Short: "Write the Sieve scripts where dovecot finds them",
+	Long: `Write the Sieve scripts of owner, or of every mailbox and domain that has
+scripts, out of the database. The scripts of a mailbox go into the "sieve"
+directory of the mailbox home with the active one linked from
+~/.dovecot.sieve. The before and after scripts of a domain go into
+the domain's directory under --sieve-dir.`,
+	Args: cobra.RangeArgs(0, 1),
+	RunE: sieveDeploy,
+}
+
+// linkage to the sieve command
+func init() {
+	sieveCmd.AddCommand(putSieve)
+	putSieve.Flags().StringVarP(&sieveInput, "input", "i", "-",
+		"Input file of the Sieve script")
+	putSieve.Flags().BoolVarP(&sieveActivate, "activate", "a", false,
+		"Make this the active script of the mailbox")
+	sieveCmd.AddCommand(getSieve)
+	sieveCmd.AddCommand(listSieve)
+	sieveCmd.AddCommand(activateSieve)
+	sieveCmd.AddCommand(deleteSieve)
+	sieveCmd.AddCommand(deploySieve)
+	for _, c := range []*cobra.Command{putSieve, activateSieve, deleteSieve, deploySieve} {
+		c.Flags().StringVar(&mailHome, "mail-home", defaultMailHome,
+			"dovecot mail_home for mailboxes without a home of their own")
+		c.Flags().StringVar(&sieveDir, "sieve-dir", defaultSieveDir,
+			"Directory of the domain before and after scripts")
+	}
+}
+
+// sievePut
+func sievePut(cmd *cobra.Command, args []string) error {
+	var (
+		err  error
+		in   io.Reader = cmd.InOrStdin()
+		data []byte
+	)
+
+	if sieveInput != "-" {
+		f, err := os.Open(sieveInput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if data, err = ioutil.ReadAll(in); err != nil {
+		return err
+	}
+	if err = sieveStore(cmd, args[0], args[1], string(data)); err != nil {
+		return err
+	}
+	return deployOwner(args[0])
+}
+
+// sieveStore
+// The database part of put. It is committed before the deploy reads
+// the scripts back.
+func sieveStore(cmd *cobra.Command, owner string, name string, script string) error {
+	var (
+		err error
+		s   *maildb.SieveScript
+	)
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if s, err = mdb.GetSieve(owner, name); err == maildb.ErrMdbSieveNotFound {
+		_, err = mdb.InsertSieve(owner, name, script)
+	} else if err == nil {
+		err = s.SetScript(script)
+	}
+	if err == nil && cmd.Flags().Changed("activate") && sieveActivate {
+		err = mdb.ActivateSieve(owner, name)
+	}
+	return err
+}
+
+// sieveGet
+func sieveGet(cmd *cobra.Command, args []string) error {
+	s, err := mdb.LookupSieve(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	cmd.Print(s.Script())
+	return nil
+}
+
+// sieveList
+func sieveList(cmd *cobra.Command, args []string) error {
+	slist, err := mdb.FindSieve(args[0], "*")
+	if err != nil {
+		return err
+	}
+	for _, s := range slist {
+		if s.IsActive() {
+			cmd.Printf("%s ACTIVE\n", s.Name())
+		} else {
+			cmd.Printf("%s\n", s.Name())
+		}
+	}
+	return nil
+}
+
+// sieveActivateCmd
+func sieveActivateCmd(cmd *cobra.Command, args []string) error {
+	var err error
+
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+	if err = sieveActivateName(args[0], name); err != nil {
+		return err
+	}
+	return deployOwner(args[0])
+}
+
+// sieveActivateName
+// The database part of activate
+func sieveActivateName(owner string, name string) error {
+	var err error
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	err = mdb.ActivateSieve(owner, name)
+	return err
+}
+
+// sieveDelete
+func sieveDelete(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		dir string
+	)
+
+	if dir, _, _, err = sieveHome(args[0]); err != nil {
+		return err
+	}
+	if err = mdb.DeleteSieve(args[0], args[1]); err != nil {
+		return err
+	}
+	if err = removeSieve(dir, args[1]+".sieve"); err != nil {
+		return err
+	}
+	return deployOwner(args[0])
+}
+
+// sieveDeploy
+func sieveDeploy(cmd *cobra.Command, args []string) error {
+	var (
+		err    error
+		owners []string
+	)
+
+	if len(args) > 0 {
+		owners = args
+	} else if owners, err = mdb.SieveOwners(); err != nil {
+		return err
+	}
+	for _, o := range owners {
+		if err = deployOwner(o); err != nil {
+			return fmt.Errorf("%s: %s", o, err)
+		}
+	}
+	return nil
+}
+
+// sieveHome
+// The directory the scripts of owner go in and who owns them. Domain
+// scripts belong to root and must be readable by every mailbox user.
+func sieveHome(owner string) (string, int, int, error) {
+	if strings.HasPrefix(owner, "@") {
+		if _, err := maildb.DecodeRFC822(owner); err != nil {
+			return "", -1, -1, err
+		}
+		return filepath.Join(sieveDir, strings.ToLower(owner[1:])), -1, -1, nil
+	}
+	mh, err := mdb.LookupMailHome(owner)
+	if err != nil {
+		return "", -1, -1, err
+	}
+	uid, gid := mh.Owner()
+	return filepath.Join(mh.Path(mailHome), "sieve"), uid, gid, nil
+}
+
+// deployOwner
+// Write the scripts of owner and, for a mailbox, point the link in its
+// home at the active one.
+func deployOwner(owner string) error {
+	var (
+		err    error
+		dir    string
+		uid    int
+		gid    int
+		perm   os.FileMode = 0600
+		slist  []*maildb.SieveScript
+		active string
+	)
+
+	if dir, uid, gid, err = sieveHome(owner); err != nil {
+		return err
+	}
+	if slist, err = mdb.FindSieve(owner, "*"); err != nil && err != maildb.ErrMdbSieveNotFound {
+		return err
+	}
+	domain := strings.HasPrefix(owner, "@")
+	if domain {
+		perm = 0644
+	} else {
+		// the home is made first so it is not left owned by root
+		home := filepath.Dir(dir)
+		if err = os.MkdirAll(filepath.Dir(home), 0755); err != nil {
+			return err
+		}
+		if err = makeDir(home, uid, gid, 0700); err != nil {
+			return err
+		}
+	}
+	for _, s := range slist {
+		if err = writeSieve(dir, uid, gid, perm, s.Name()+".sieve", s.Script()); err != nil {
+			return err
+		}
+		if s.IsActive() {
+			active = s.Name()
+		}
+	}
+	if domain {
+		return nil
+	}
+	return linkSieve(filepath.Dir(dir), uid, gid, active)
+}
+
+// linkSieve
+// Point ~/.dovecot.sieve at the active script or remove it if none is.
+// Anything other than a link is the user's own and left alone.
+func linkSieve(home string, uid int, gid int, active string) error {
+	link := filepath.Join(home, sieveLink)
+	if fi, err := os.Lstat(link); err == nil {
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s is not a link, not replacing it", link)
+		}
+		if err = os.Remove(link); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if active == "" {
+		return nil
+	}
+	if err := os.Symlink(filepath.Join("sieve", active+".sieve"), link); err != nil {
+		return err
+	}
+	return os.Lchown(link, uid, gid)
+}
@@ -0,0 +1,192 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestSieveCmd
+func TestSieveCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		home        string
+		sdir        string
+		link        string
+		data        []byte
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestSieveCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestSieveCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	// we can only chown to ourselves if we are not root
+	args = []string{"-d", dbfile, "edit", "mailbox", "jeff@pobox.org",
+		"-u", fmt.Sprintf("%d", os.Getuid()), "-g", fmt.Sprintf("%d", os.Getgid())}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit mailbox jeff@pobox.org: Unexpected error, %s", err)
+	}
+	home = filepath.Join(dir, "pobox.org/jeff")
+	sdir = filepath.Join(dir, "sieve")
+	link = filepath.Join(home, ".dovecot.sieve")
+
+	// every run that writes names the mail home and sieve directory of the test
+	sieve := func(a ...string) []string {
+		args := append([]string{"-d", dbfile, "sieve"}, a...)
+		return append(args, "--mail-home", filepath.Join(dir, "%d/%n"), "--sieve-dir", sdir)
+	}
+	args = sieve("put", "jeff@pobox.org", "main", "-i", "-")
+	out, errout, err = doTest(rootCmd, "fileinto \"Junk\";\n", args)
+	if err == nil {
+		t.Errorf("Sieve put bad script: should have failed")
+	} else if !strings.Contains(err.Error(), "needs require \"fileinto\"") {
+		t.Errorf("Sieve put bad script: Unexpected error, %s", err)
+	}
+	args = sieve("put", "sales@pobox.org", "main", "-i", "-")
+	out, errout, err = doTest(rootCmd, "keep;\n", args)
+	if err == nil {
+		t.Errorf("Sieve put for sales@pobox.org: should have failed")
+	} else if err != maildb.ErrMdbNotMbox {
+		t.Errorf("Sieve put for sales@pobox.org: Unexpected error, %s", err)
+	}
+
+	args = sieve("put", "jeff@pobox.org", "main", "-i", "-", "-a=false")
+	out, errout, err = doTest(rootCmd, "require \"fileinto\";\nfileinto \"Junk\";\n", args)
+	if err != nil {
+		t.Errorf("Sieve put jeff@pobox.org main: Unexpected error, %s", err)
+	}
+	if errout != "" {
+		t.Errorf("Sieve put jeff@pobox.org main: did not expect error output, got %s", errout)
+	}
+	if data, err = ioutil.ReadFile(filepath.Join(home, "sieve/main.sieve")); err != nil {
+		t.Errorf("Sieve put jeff@pobox.org main: no script, %s", err)
+	} else if string(data) != "require \"fileinto\";\nfileinto \"Junk\";\n" {
+		t.Errorf("Sieve put jeff@pobox.org main: unexpected script, got %s", string(data))
+	}
+	if _, err = os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("Sieve put jeff@pobox.org main: no script is active, got %v", err)
+	}
+	args = sieve("put", "jeff@pobox.org", "other", "-i", "-", "-a")
+	out, errout, err = doTest(rootCmd, "keep;\n", args)
+	if err != nil {
+		t.Errorf("Sieve put jeff@pobox.org other: Unexpected error, %s", err)
+	}
+	if target, err := os.Readlink(link); err != nil {
+		t.Errorf("Sieve put jeff@pobox.org other: no link, %s", err)
+	} else if target != "sieve/other.sieve" {
+		t.Errorf("Sieve put jeff@pobox.org other: link to %s", target)
+	}
+	args = []string{"-d", dbfile, "sieve", "list", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Sieve list jeff@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "main\nother ACTIVE\n" {
+		t.Errorf("Sieve list jeff@pobox.org: did not get expected output, got %s", out)
+	}
+	args = []string{"-d", dbfile, "sieve", "get", "jeff@pobox.org", "main"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Sieve get jeff@pobox.org main: Unexpected error, %s", err)
+	}
+	if out != "require \"fileinto\";\nfileinto \"Junk\";\n" {
+		t.Errorf("Sieve get jeff@pobox.org main: did not get expected output, got %s", out)
+	}
+
+	args = sieve("activate", "jeff@pobox.org", "main")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Sieve activate jeff@pobox.org main: Unexpected error, %s", err)
+	}
+	if target, err := os.Readlink(link); err != nil || target != "sieve/main.sieve" {
+		t.Errorf("Sieve activate jeff@pobox.org main: link to %s, %v", target, err)
+	}
+	args = sieve("delete", "jeff@pobox.org", "main")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Sieve delete active script: should have failed")
+	} else if err != maildb.ErrMdbSieveActive {
+		t.Errorf("Sieve delete active script: Unexpected error, %s", err)
+	}
+	args = sieve("delete", "jeff@pobox.org", "other")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Sieve delete jeff@pobox.org other: Unexpected error, %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(home, "sieve/other.sieve")); !os.IsNotExist(err) {
+		t.Errorf("Sieve delete jeff@pobox.org other: script still there, %v", err)
+	}
+	args = sieve("activate", "jeff@pobox.org")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Sieve deactivate jeff@pobox.org: Unexpected error, %s", err)
+	}
+	if _, err = os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("Sieve deactivate jeff@pobox.org: link still there, %v", err)
+	}
+
+	// the domain scripts are not activated and go in the sieve dir
+	args = sieve("put", "@pobox.org", "main", "-i", "-", "-a=false")
+	out, errout, err = doTest(rootCmd, "keep;\n", args)
+	if err == nil {
+		t.Errorf("Sieve put @pobox.org main: should have failed")
+	} else if err != maildb.ErrMdbSieveDomainName {
+		t.Errorf("Sieve put @pobox.org main: Unexpected error, %s", err)
+	}
+	args = sieve("put", "@pobox.org", "before", "-i", "-")
+	out, errout, err = doTest(rootCmd, "keep;\n", args)
+	if err != nil {
+		t.Errorf("Sieve put @pobox.org before: Unexpected error, %s", err)
+	}
+	if data, err = ioutil.ReadFile(filepath.Join(sdir, "pobox.org/before.sieve")); err != nil {
+		t.Errorf("Sieve put @pobox.org before: no script, %s", err)
+	} else if string(data) != "keep;\n" {
+		t.Errorf("Sieve put @pobox.org before: unexpected script, got %s", string(data))
+	}
+
+	// deploy puts back what was lost
+	os.RemoveAll(sdir)
+	os.RemoveAll(home)
+	args = sieve("deploy")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Sieve deploy: Unexpected error, %s", err)
+	}
+	for _, f := range []string{
+		filepath.Join(sdir, "pobox.org/before.sieve"),
+		filepath.Join(home, "sieve/main.sieve"),
+	} {
+		if _, err = os.Stat(f); err != nil {
+			t.Errorf("Sieve deploy: %s", err)
+		}
+	}
+}
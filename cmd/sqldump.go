@@ -0,0 +1,619 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// sqlRow is one row of a table read from a dump or a foreign database.
+// A NULL column is simply missing.
+type sqlRow map[string]string
+
+// sqlite databases start with this
+const sqliteMagic = "SQLite format 3\x00"
+
+// readSqlTables
+// read the named tables from either an sqlite database file or a SQL dump
+// from mysqldump or pg_dump. Tables that are not there come back empty.
+func readSqlTables(path string, in io.Reader, tables []string) (map[string][]sqlRow, error) {
+	var (
+		buf []byte
+		err error
+	)
+
+	if path != "" {
+		buf, err = ioutil.ReadFile(path)
+	} else {
+		buf, err = ioutil.ReadAll(in)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(buf, []byte(sqliteMagic)) {
+		if path == "" {
+			return nil, fmt.Errorf("An sqlite database must be named with --from")
+		}
+		return readSqliteTables(path, tables)
+	}
+	return readSqlDump(buf, tables)
+}
+
+// readSqliteTables
+func readSqliteTables(path string, tables []string) (map[string][]sqlRow, error) {
+	var (
+		db   *sql.DB
+		rows *sql.Rows
+		have = make(map[string]bool)
+		res  = make(map[string][]sqlRow)
+		err  error
+	)
+
+	if db, err = sql.Open("sqlite3", "file:"+path+"?mode=ro"); err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if rows, err = db.Query("SELECT name FROM sqlite_master WHERE type = 'table'"); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		have[strings.ToLower(name)] = true
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	for _, t := range tables {
+		if !have[t] {
+			continue
+		}
+		if res[t], err = readSqliteTable(db, t); err != nil {
+			return nil, fmt.Errorf("table %s: %s", t, err)
+		}
+	}
+	return res, nil
+}
+
+// readSqliteTable
+func readSqliteTable(db *sql.DB, table string) ([]sqlRow, error) {
+	var (
+		rows *sql.Rows
+		cols []string
+		res  []sqlRow
+		err  error
+	)
+
+	if rows, err = db.Query("SELECT * FROM \"" + table + "\""); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if cols, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+	vals := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		r := make(sqlRow)
+		for i, c := range cols {
+			if vals[i].Valid {
+				r[strings.ToLower(c)] = vals[i].String
+			}
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// SQL dump parsing. We only need the CREATE TABLE, INSERT and COPY
+// statements and only enough of those to get the column names and the
+// literal values. Everything else is skipped a statement at a time.
+
+type sqlTokKind int
+
+const (
+	sqlWord   sqlTokKind = iota // keyword, bare name or TRUE/FALSE/NULL
+	sqlIdent                    // `quoted` or "quoted" name
+	sqlString                   // 'literal'
+	sqlNumber                   // 123, -1, 1.5
+	sqlPunct                    // ( ) , ; . and friends
+)
+
+type sqlToken struct {
+	kind sqlTokKind
+	text string
+}
+
+// is
+// a case insensitive keyword or punctuation match
+func (t sqlToken) is(s string) bool {
+	return (t.kind == sqlWord || t.kind == sqlPunct) && strings.EqualFold(t.text, s)
+}
+
+type sqlDump struct {
+	buf     []byte
+	pos     int
+	line    int
+	escapes bool // backslash escapes in strings, mysql does, postgres doesn't
+	want    map[string]bool
+	cols    map[string][]string
+	tables  map[string][]sqlRow
+}
+
+// readSqlDump
+func readSqlDump(buf []byte, tables []string) (map[string][]sqlRow, error) {
+	var (
+		stmt []sqlToken
+		err  error
+	)
+
+	d := &sqlDump{
+		buf:     buf,
+		line:    1,
+		escapes: true,
+		want:    make(map[string]bool),
+		cols:    make(map[string][]string),
+		tables:  make(map[string][]sqlRow),
+	}
+	for _, t := range tables {
+		d.want[t] = true
+	}
+	for {
+		line := d.line
+		if stmt, err = d.statement(); err != nil {
+			return nil, fmt.Errorf("At line %d: %s", d.line, err)
+		}
+		if stmt == nil {
+			break
+		}
+		if err = d.exec(stmt); err != nil {
+			return nil, fmt.Errorf("At line %d: %s", line, err)
+		}
+	}
+	return d.tables, nil
+}
+
+// statement
+// the tokens up to the next ';'. nil at the end of the dump
+func (d *sqlDump) statement() ([]sqlToken, error) {
+	var stmt []sqlToken
+
+	for {
+		t, err := d.token()
+		if err == io.EOF {
+			return stmt, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if t.is(";") {
+			if len(stmt) == 0 {
+				continue
+			}
+			return stmt, nil
+		}
+		stmt = append(stmt, t)
+	}
+}
+
+// token
+func (d *sqlDump) token() (sqlToken, error) {
+	if err := d.skipSpace(); err != nil {
+		return sqlToken{}, err
+	}
+	c := d.buf[d.pos]
+	switch {
+	case c == '\'':
+		return d.quoted(sqlString, '\'', d.escapes)
+	case (c == 'E' || c == 'e') && d.peek(1) == '\'':
+		d.pos++
+		return d.quoted(sqlString, '\'', true)
+	case c == '`' || c == '"':
+		return d.quoted(sqlIdent, c, false)
+	case isDigit(c) || (c == '-' && isDigit(d.peek(1))):
+		start := d.pos
+		d.pos++
+		for d.pos < len(d.buf) && (isDigit(d.buf[d.pos]) || strings.IndexByte(".eE+-", d.buf[d.pos]) >= 0) {
+			d.pos++
+		}
+		return sqlToken{kind: sqlNumber, text: string(d.buf[start:d.pos])}, nil
+	case isWordByte(c):
+		start := d.pos
+		for d.pos < len(d.buf) && (isWordByte(d.buf[d.pos]) || isDigit(d.buf[d.pos])) {
+			d.pos++
+		}
+		return sqlToken{kind: sqlWord, text: string(d.buf[start:d.pos])}, nil
+	default:
+		d.pos++
+		return sqlToken{kind: sqlPunct, text: string(c)}, nil
+	}
+}
+
+// skipSpace
+// skip white space and comments. io.EOF if there is nothing left
+func (d *sqlDump) skipSpace() error {
+	for d.pos < len(d.buf) {
+		c := d.buf[d.pos]
+		switch {
+		case c == '\n':
+			d.line++
+			d.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			d.pos++
+		case c == '#' || (c == '-' && d.peek(1) == '-'):
+			for d.pos < len(d.buf) && d.buf[d.pos] != '\n' {
+				d.pos++
+			}
+		case c == '/' && d.peek(1) == '*': // includes mysql's /*!40101 ... */
+			end := bytes.Index(d.buf[d.pos+2:], []byte("*/"))
+			if end < 0 {
+				return fmt.Errorf("Unterminated comment")
+			}
+			end += d.pos + 4
+			d.line += bytes.Count(d.buf[d.pos:end], []byte("\n"))
+			d.pos = end
+		default:
+			return nil
+		}
+	}
+	return io.EOF
+}
+
+// quoted
+// a string or quoted name. A doubled quote is a quote
+func (d *sqlDump) quoted(kind sqlTokKind, q byte, escapes bool) (sqlToken, error) {
+	var s strings.Builder
+
+	d.pos++
+	for d.pos < len(d.buf) {
+		c := d.buf[d.pos]
+		d.pos++
+		switch {
+		case c == q:
+			if d.pos < len(d.buf) && d.buf[d.pos] == q {
+				s.WriteByte(q)
+				d.pos++
+				continue
+			}
+			return sqlToken{kind: kind, text: s.String()}, nil
+		case c == '\\' && escapes && d.pos < len(d.buf):
+			s.WriteByte(unescapeByte(d.buf[d.pos]))
+			d.pos++
+		default:
+			if c == '\n' {
+				d.line++
+			}
+			s.WriteByte(c)
+		}
+	}
+	return sqlToken{}, fmt.Errorf("Unterminated quoted string")
+}
+
+// peek
+func (d *sqlDump) peek(n int) byte {
+	if d.pos+n < len(d.buf) {
+		return d.buf[d.pos+n]
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// unescapeByte
+// the character following a '\' in mysql strings and postgres COPY data
+func unescapeByte(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case 'b':
+		return '\b'
+	case 'f':
+		return '\f'
+	case 'v':
+		return '\v'
+	case '0':
+		return 0
+	case 'Z':
+		return 0x1a
+	default:
+		return c
+	}
+}
+
+// tableName
+// a possibly schema qualified name starting at stmt[i]. We only want
+// the last part, public.mailbox is mailbox
+func tableName(stmt []sqlToken, i int) (string, int) {
+	var name string
+
+	for i < len(stmt) && (stmt[i].kind == sqlWord || stmt[i].kind == sqlIdent) {
+		name = stmt[i].text
+		i++
+		if i < len(stmt) && stmt[i].is(".") {
+			i++
+			continue
+		}
+		break
+	}
+	if n := strings.LastIndexByte(name, '.'); n >= 0 {
+		name = name[n+1:]
+	}
+	return strings.ToLower(name), i
+}
+
+// columnList
+// a parenthesized list of names at stmt[i], if there is one
+func columnList(stmt []sqlToken, i int) ([]string, int, error) {
+	var cols []string
+
+	if i >= len(stmt) || !stmt[i].is("(") {
+		return nil, i, nil
+	}
+	for i++; i < len(stmt); i++ {
+		switch {
+		case stmt[i].is(")"):
+			return cols, i + 1, nil
+		case stmt[i].is(","):
+		case stmt[i].kind == sqlWord || stmt[i].kind == sqlIdent:
+			cols = append(cols, strings.ToLower(stmt[i].text))
+		default:
+			return nil, i, fmt.Errorf("Unexpected %q in column list", stmt[i].text)
+		}
+	}
+	return nil, i, fmt.Errorf("Unterminated column list")
+}
+
+// exec
+// pick out the statements we want and ignore the rest
+func (d *sqlDump) exec(stmt []sqlToken) error {
+	i := 0
+	switch {
+	case stmt[0].is("SET"):
+		// pg_dump tells us whether backslash is just a backslash
+		if len(stmt) >= 4 && stmt[1].is("standard_conforming_strings") {
+			d.escapes = !stmt[3].is("on")
+		}
+		return nil
+	case stmt[0].is("CREATE"):
+		for i = 1; i < len(stmt) && stmt[i].kind == sqlWord && !stmt[i].is("TABLE"); i++ {
+		}
+		if i == len(stmt) || !stmt[i].is("TABLE") {
+			return nil
+		}
+		i++
+		if i+2 < len(stmt) && stmt[i].is("IF") && stmt[i+1].is("NOT") && stmt[i+2].is("EXISTS") {
+			i += 3
+		}
+		name, i := tableName(stmt, i)
+		if d.want[name] {
+			d.cols[name] = tableColumns(stmt[i:])
+		}
+		return nil
+	case stmt[0].is("INSERT") || stmt[0].is("REPLACE"):
+		for i = 1; i < len(stmt) && !stmt[i].is("INTO"); i++ {
+		}
+		name, i := tableName(stmt, i+1)
+		if !d.want[name] {
+			return nil
+		}
+		cols, i, err := columnList(stmt, i)
+		if err != nil {
+			return err
+		}
+		if cols == nil {
+			if cols = d.cols[name]; cols == nil {
+				return fmt.Errorf("No columns known for table %s", name)
+			}
+		}
+		if i >= len(stmt) || !stmt[i].is("VALUES") {
+			return fmt.Errorf("Only INSERT ... VALUES is supported")
+		}
+		return d.values(name, cols, stmt[i+1:])
+	case stmt[0].is("COPY"):
+		name, i := tableName(stmt, 1)
+		cols, _, err := columnList(stmt, i)
+		if err != nil {
+			return err
+		}
+		if cols == nil {
+			cols = d.cols[name]
+		}
+		return d.copyData(name, cols)
+	default:
+		return nil
+	}
+}
+
+// tableColumns
+// the column names from the body of a CREATE TABLE. Each element at
+// the top level that does not start with a constraint keyword is a column
+func tableColumns(stmt []sqlToken) []string {
+	var (
+		cols  []string
+		depth int
+		first = true
+	)
+
+	for _, t := range stmt {
+		switch {
+		case t.is("("):
+			depth++
+			continue
+		case t.is(")"):
+			depth--
+			continue
+		case t.is(",") && depth == 1:
+			first = true
+			continue
+		}
+		if depth != 1 || !first {
+			continue
+		}
+		first = false
+		if t.kind == sqlWord {
+			switch strings.ToUpper(t.text) {
+			case "PRIMARY", "KEY", "UNIQUE", "INDEX", "CONSTRAINT",
+				"FOREIGN", "CHECK", "FULLTEXT", "SPATIAL":
+				continue
+			}
+		}
+		if t.kind == sqlWord || t.kind == sqlIdent {
+			cols = append(cols, strings.ToLower(t.text))
+		}
+	}
+	return cols
+}
+
+// values
+// the (...), (...) tuples of an INSERT. A value is the first token of
+// each element so '2021-01-01'::timestamp is just the string. Function
+// calls like now() are not values we need and come out as NULL.
+func (d *sqlDump) values(name string, cols []string, stmt []sqlToken) error {
+	var (
+		row   sqlRow
+		col   int
+		depth int
+		first bool
+	)
+
+	for _, t := range stmt {
+		switch {
+		case t.is("("):
+			depth++
+			if depth == 1 {
+				row = make(sqlRow)
+				col = 0
+				first = true
+			}
+			continue
+		case t.is(")"):
+			depth--
+			if depth == 0 {
+				if col+1 != len(cols) {
+					return fmt.Errorf("%s: %d values for %d columns", name, col+1, len(cols))
+				}
+				d.tables[name] = append(d.tables[name], row)
+			}
+			continue
+		case t.is(",") && depth == 1:
+			col++
+			first = true
+			continue
+		}
+		if depth != 1 || !first {
+			continue
+		}
+		first = false
+		if col >= len(cols) {
+			continue // counted at the ')'
+		}
+		switch t.kind {
+		case sqlString, sqlNumber:
+			row[cols[col]] = t.text
+		case sqlWord:
+			if t.is("TRUE") || t.is("FALSE") {
+				row[cols[col]] = strings.ToLower(t.text)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("%s: unbalanced parentheses in VALUES", name)
+	}
+	return nil
+}
+
+// copyData
+// the tab separated lines that follow a COPY ... FROM stdin up to "\."
+func (d *sqlDump) copyData(name string, cols []string) error {
+	// the data starts on the line after the statement
+	if nl := bytes.IndexByte(d.buf[d.pos:], '\n'); nl >= 0 {
+		d.pos += nl + 1
+		d.line++
+	} else {
+		d.pos = len(d.buf)
+	}
+	lines := bufio.NewScanner(bytes.NewReader(d.buf[d.pos:]))
+	lines.Buffer(make([]byte, 0, 64*1024), len(d.buf)+1)
+	for lines.Scan() {
+		text := lines.Text()
+		d.pos += len(text) + 1
+		d.line++
+		if text == "\\." {
+			return nil
+		}
+		if !d.want[name] {
+			continue
+		}
+		if cols == nil {
+			return fmt.Errorf("No columns known for table %s", name)
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != len(cols) {
+			return fmt.Errorf("%s: %d values for %d columns", name, len(fields), len(cols))
+		}
+		row := make(sqlRow)
+		for i, f := range fields {
+			if f == "\\N" {
+				continue
+			}
+			row[cols[i]] = copyUnescape(f)
+		}
+		d.tables[name] = append(d.tables[name], row)
+	}
+	return fmt.Errorf("COPY data for %s is not terminated by \\.", name)
+}
+
+// copyUnescape
+func copyUnescape(f string) string {
+	if strings.IndexByte(f, '\\') < 0 {
+		return f
+	}
+	var s strings.Builder
+	for i := 0; i < len(f); i++ {
+		if f[i] == '\\' && i+1 < len(f) {
+			i++
+			s.WriteByte(unescapeByte(f[i]))
+		} else {
+			s.WriteByte(f[i])
+		}
+	}
+	return s.String()
+}
@@ -0,0 +1,326 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	suDomain string
+	suPasswd string
+	suShadow string
+	suMinUid int64
+	suMaxUid int64
+	suUid    string
+	suGid    string
+	suHome   string
+	suDryRun bool
+)
+
+// errSysUsersDryRun rolls back a dry run
+var errSysUsersDryRun = errors.New("dry run")
+
+// importSystemUsers
+var importSystemUsers = &cobra.Command{
+	Use:   "system-users [user ...]",
+	Short: "Import the unix accounts of a system as mailboxes",
+	Long: `Import the unix accounts in the --passwd file as mailboxes in the --domain domain,
+usually a local class domain. The password hashes come from the --shadow file and
+are stored as CRYPT. Only the accounts with a uid from --min-uid to --max-uid are
+imported, and only the named users if there are any.
+The uid, gid and home of each account are used unless --uid, --gid or --home say
+otherwise. --uid and --gid can be a number or "domain" to use the domain's.
+--home can be "none" or a template where %u is the user and %d the domain.
+A locked or expired account is imported disabled. With --dry-run the mailboxes are
+printed in the mailbox import format and nothing is imported.`,
+	RunE: sysUsersImport,
+}
+
+func init() {
+	importCmd.AddCommand(importSystemUsers)
+	importSystemUsers.Flags().StringVarP(&suDomain, "domain", "D", "",
+		"Domain of the mailboxes (required)")
+	importSystemUsers.Flags().StringVarP(&suPasswd, "passwd", "p", "/etc/passwd",
+		"passwd(5) file of the accounts")
+	importSystemUsers.Flags().StringVarP(&suShadow, "shadow", "s", "/etc/shadow",
+		"shadow(5) file of the password hashes, \"\" for none")
+	importSystemUsers.Flags().Int64VarP(&suMinUid, "min-uid", "m", 1000,
+		"Lowest uid to import")
+	importSystemUsers.Flags().Int64VarP(&suMaxUid, "max-uid", "M", 60000,
+		"Highest uid to import")
+	importSystemUsers.Flags().StringVarP(&suUid, "uid", "u", "",
+		"Mailbox uid, a number or \"domain\" (default the account's)")
+	importSystemUsers.Flags().StringVarP(&suGid, "gid", "g", "",
+		"Mailbox gid, a number or \"domain\" (default the account's)")
+	importSystemUsers.Flags().StringVarP(&suHome, "home", "H", "",
+		"Mailbox home, \"none\" or a template with %u and %d (default the account's)")
+	importSystemUsers.Flags().BoolVarP(&suDryRun, "dry-run", "n", false,
+		"Print the mailboxes instead of importing them")
+	importSystemUsers.MarkFlagRequired("domain")
+}
+
+// sysUser
+// an account from passwd with its shadow entry
+type sysUser struct {
+	name     string
+	password string
+	uid      int64
+	gid      int64
+	home     string
+	expire   int64 // days since the epoch, 0 is never
+	shadowed bool
+}
+
+// sysUsersImport
+func sysUsersImport(cmd *cobra.Command, args []string) error {
+	var (
+		users    []*sysUser
+		shadow   map[string][]string
+		mb       *maildb.VMailbox
+		rollback error
+		err      error
+	)
+
+	if users, err = readPasswd(suPasswd); err != nil {
+		return err
+	}
+	if suShadow != "" {
+		if shadow, err = readShadow(suShadow); err != nil {
+			return err
+		}
+	}
+	if users, err = pickSysUsers(cmd, users, args); err != nil {
+		return err
+	}
+
+	mdb.Begin()
+	defer mdb.End(&rollback)
+
+	if _, err = mdb.GetDomain(suDomain); err != nil {
+		rollback = err
+		return fmt.Errorf("%s: %s", suDomain, err)
+	}
+	today := time.Now().Unix() / 86400
+	for _, u := range users {
+		if s, ok := shadow[u.name]; ok {
+			u.password = s[1]
+			u.shadowed = true
+			if len(s) > 7 && s[7] != "" {
+				if u.expire, err = strconv.ParseInt(s[7], 10, 64); err != nil {
+					err = fmt.Errorf("%s: shadow expire field: %s", u.name, err)
+					break
+				}
+			}
+		}
+		if mb, err = sysUserMailbox(cmd, u, today); err != nil {
+			err = fmt.Errorf("%s: %s", u.name, err)
+			break
+		}
+		if suDryRun {
+			cmd.Println(mb.Export())
+		}
+	}
+	if err != nil {
+		rollback = err
+	} else if suDryRun {
+		rollback = errSysUsersDryRun
+	}
+	return err
+}
+
+// pickSysUsers
+// the accounts in the uid range, and only the named ones if there are any
+func pickSysUsers(cmd *cobra.Command, users []*sysUser, names []string) ([]*sysUser, error) {
+	var picked []*sysUser
+
+	want := make(map[string]bool)
+	for _, n := range names {
+		want[n] = true
+	}
+	found := make(map[string]bool)
+	for _, u := range users {
+		if len(names) > 0 && !want[u.name] {
+			continue
+		}
+		found[u.name] = true
+		if u.uid >= suMinUid && u.uid <= suMaxUid {
+			picked = append(picked, u)
+		} else if len(names) > 0 {
+			cmd.PrintErrf("Warning: %s has uid %d, it is not imported\n", u.name, u.uid)
+		}
+	}
+	for _, n := range names {
+		if !found[n] {
+			return nil, fmt.Errorf("%s is not in %s", n, suPasswd)
+		}
+	}
+	return picked, nil
+}
+
+// sysUserMailbox
+// make the mailbox for an account
+func sysUserMailbox(cmd *cobra.Command, u *sysUser, today int64) (*maildb.VMailbox, error) {
+	var (
+		mb       *maildb.VMailbox
+		disabled bool
+		err      error
+	)
+
+	if mb, err = mdb.InsertVMailbox(u.name + "@" + suDomain); err != nil {
+		return nil, err
+	}
+	hash := u.password
+	if strings.HasPrefix(hash, "!") { // usermod -L puts a '!' in front
+		hash = strings.TrimLeft(hash, "!")
+		disabled = true
+		cmd.PrintErrf("Warning: %s is locked, its mailbox is disabled\n", u.name)
+	}
+	if u.expire > 0 && u.expire <= today {
+		disabled = true
+		cmd.PrintErrf("Warning: %s has expired, its mailbox is disabled\n", u.name)
+	}
+	if hash == "" || hash == "*" || (hash == "x" && !u.shadowed) {
+		cmd.PrintErrf("Warning: %s has no password, it cannot log in until one is set\n", u.name)
+	} else if !maildb.IsCryptHash(hash) {
+		cmd.PrintErrf("Warning: %s has no crypt(3) hash, it cannot log in until a password is set\n",
+			u.name)
+	} else {
+		if err = mb.SetPwType("CRYPT"); err != nil {
+			return nil, err
+		}
+		if err = mb.SetPassword(hash); err != nil {
+			return nil, err
+		}
+	}
+	if err = sysUserId(suUid, u.uid, mb.SetUid); err != nil {
+		return nil, err
+	}
+	if err = sysUserId(suGid, u.gid, mb.SetGid); err != nil {
+		return nil, err
+	}
+	home := u.home
+	if suHome == "none" {
+		home = ""
+	} else if suHome != "" {
+		home = strings.NewReplacer("%u", u.name, "%d", suDomain, "%%", "%").Replace(suHome)
+	}
+	if home != "" {
+		if err = mb.SetHome(home); err != nil {
+			return nil, err
+		}
+	}
+	if disabled {
+		err = mb.Disable()
+	}
+	return mb, err
+}
+
+// sysUserId
+// set the uid or gid from its flag, the account's own if not set and
+// nothing if the domain's is to be used
+func sysUserId(flag string, own int64, set func(int64) error) error {
+	switch flag {
+	case "":
+		return set(own)
+	case "domain":
+		return nil
+	default:
+		id, err := strconv.ParseInt(flag, 10, 64)
+		if err != nil || id < 0 {
+			return fmt.Errorf("uid and gid must be a number or \"domain\", not %q", flag)
+		}
+		return set(id)
+	}
+}
+
+// readPasswd
+// the accounts in a passwd(5) file. NIS +/- entries are skipped
+func readPasswd(path string) ([]*sysUser, error) {
+	var users []*sysUser
+
+	err := readColonFile(path, 7, func(f []string) error {
+		if strings.HasPrefix(f[0], "+") || strings.HasPrefix(f[0], "-") {
+			return nil
+		}
+		u := &sysUser{
+			name:     f[0],
+			password: f[1],
+			home:     f[5],
+		}
+		var err error
+		if u.uid, err = strconv.ParseInt(f[2], 10, 64); err != nil {
+			return fmt.Errorf("uid of %s: %s", f[0], err)
+		}
+		if u.gid, err = strconv.ParseInt(f[3], 10, 64); err != nil {
+			return fmt.Errorf("gid of %s: %s", f[0], err)
+		}
+		users = append(users, u)
+		return nil
+	})
+	return users, err
+}
+
+// readShadow
+// the shadow(5) entries by name
+func readShadow(path string) (map[string][]string, error) {
+	shadow := make(map[string][]string)
+
+	err := readColonFile(path, 2, func(f []string) error {
+		shadow[f[0]] = f
+		return nil
+	})
+	return shadow, err
+}
+
+// readColonFile
+// call worker with the fields of each line that has at least min fields.
+// Blank lines and '#' comment lines are skipped.
+func readColonFile(path string, min int, worker func([]string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	lineno := 0
+	for lines.Scan() {
+		lineno++
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < min {
+			return fmt.Errorf("%s at line %d: expected %d fields, got %d",
+				path, lineno, min, len(fields))
+		}
+		if err = worker(fields); err != nil {
+			return fmt.Errorf("%s at line %d: %s", path, lineno, err)
+		}
+	}
+	return lines.Err()
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var suTestPasswd = `root:x:0:0:root:/root:/bin/bash
+# a comment
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+alice:x:1000:1000:Alice A,,,:/home/alice:/bin/bash
+bob:x:1001:1001:Bob:/home/bob:/bin/bash
+carl:x:1002:100::/home/carl:/usr/sbin/nologin
+dora:x:1003:1003::/home/dora:/bin/sh
+ed:x:1004:1004::/home/ed:/bin/sh
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+`
+
+var suTestShadow = `root:$6$r$x:19000:0:99999:7:::
+daemon:*:19000:0:99999:7:::
+alice:$6$saltsalt$abcdefg:19000:0:99999:7:::
+bob:$y$j9T$salt$hash:19000:0:99999:7:::
+carl:!$6$s$h:19000:0:99999:7:::
+dora:*:19000:0:99999:7:::
+ed:$1$xx$yy:19000:0:99999:7::1:
+`
+
+// TestSystemUsersCmd
+func TestSystemUsersCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestSystemUsersCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestSystemUsersCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = filepath.Join(dir, "test.db")
+	passwd := filepath.Join(dir, "passwd")
+	shadow := filepath.Join(dir, "shadow")
+	if err = ioutil.WriteFile(passwd, []byte(suTestPasswd), 0644); err != nil {
+		t.Errorf("Write passwd: %s", err)
+		return
+	}
+	if err = ioutil.WriteFile(shadow, []byte(suTestShadow), 0600); err != nil {
+		t.Errorf("Write shadow: %s", err)
+		return
+	}
+
+	args = []string{"create", "-d", dbfile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "domain", "home.lan", "--class", "local"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add domain home.lan: Unexpected error, %s", err)
+	}
+
+	sysUsers := func(extra ...string) []string {
+		return append([]string{"-d", dbfile, "import", "system-users", "--domain", "home.lan",
+			"--passwd", passwd, "--shadow", shadow, "--min-uid", "1000", "--max-uid", "60000"},
+			extra...)
+	}
+
+	// A dry run shows what would be imported and imports nothing
+	args = sysUsers("--uid", "", "--gid", "", "--home", "", "--dry-run")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Dry run: Unexpected error, %s", err)
+	}
+	expected := `alice@home.lan:{CRYPT}$6$saltsalt$abcdefg:1000:1000::/home/alice::userdb_quota_rule=*:bytes=300M mbox_enabled=true
+bob@home.lan:{CRYPT}$y$j9T$salt$hash:1001:1001::/home/bob::userdb_quota_rule=*:bytes=300M mbox_enabled=true
+carl@home.lan:{CRYPT}$6$s$h:1002:100::/home/carl::userdb_quota_rule=*:bytes=300M mbox_enabled=false
+dora@home.lan:{PLAIN}*:1003:1003::/home/dora::userdb_quota_rule=*:bytes=300M mbox_enabled=true
+ed@home.lan:{CRYPT}$1$xx$yy:1004:1004::/home/ed::userdb_quota_rule=*:bytes=300M mbox_enabled=false
+`
+	if out != expected {
+		t.Errorf("Dry run: expected\n%s got\n%s", expected, out)
+	}
+	warnings := `Warning: carl is locked, its mailbox is disabled
+Warning: dora has no password, it cannot log in until one is set
+Warning: ed has expired, its mailbox is disabled
+`
+	if errout != warnings {
+		t.Errorf("Dry run: expected warnings\n%s got\n%s", warnings, errout)
+	}
+	args = []string{"-d", dbfile, "show", "mailbox", "alice@home.lan"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Show alice@home.lan after dry run: expected not found, got %s", out)
+	}
+
+	// Named users with the ids and home mapped
+	args = sysUsers("--uid", "domain", "--gid", "5000", "--home", "/var/vmail/%d/%u", "--dry-run=false",
+		"alice", "bob", "root")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import alice, bob: Unexpected error, %s", err)
+	}
+	if errout != "Warning: root has uid 0, it is not imported\n" {
+		t.Errorf("Import alice, bob: unexpected warnings, %s", errout)
+	}
+	args = []string{"-d", dbfile, "export", "mailbox"}
+	out, errout, err = doTest(rootCmd, "", args)
+	expected = `alice@home.lan:{CRYPT}$6$saltsalt$abcdefg::5000::/var/vmail/home.lan/alice::userdb_quota_rule=*:bytes=300M mbox_enabled=true
+bob@home.lan:{CRYPT}$y$j9T$salt$hash::5000::/var/vmail/home.lan/bob::userdb_quota_rule=*:bytes=300M mbox_enabled=true
+`
+	if err != nil || out != expected {
+		t.Errorf("Export alice, bob: expected\n%s got\n%s %v", expected, out, err)
+	}
+
+	// Importing them again fails and takes everything back with it
+	args = sysUsers("--uid", "", "--gid", "", "--home", "none", "--dry-run=false")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil || !strings.HasPrefix(err.Error(), "alice: ") {
+		t.Errorf("Import again: expected alice duplicate, got %v", err)
+	}
+	args = []string{"-d", dbfile, "show", "mailbox", "carl@home.lan"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Show carl@home.lan: expected not found, got %s", out)
+	}
+
+	// Without shadow the passwd "x" is no password
+	args = sysUsers("--shadow", "", "--uid", "", "--gid", "", "--home", "none", "--dry-run=false", "dora")
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import dora: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "mailbox", "dora@home.lan"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil || !strings.Contains(out, "Password:\t--\n") || !strings.Contains(out, "Home:\t\t--\n") {
+		t.Errorf("Show dora@home.lan: unexpected %s %v", out, err)
+	}
+
+	// Errors
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{sysUsers("--dry-run", "zed"), "zed is not in " + passwd},
+		{sysUsers("--uid", "bin", "--dry-run", "ed"), "ed: uid and gid must be a number or \"domain\", not \"bin\""},
+		{append(sysUsers("--uid", "", "--dry-run", "ed"), "--domain", "nowhere.lan"),
+			"nowhere.lan: domain not found"},
+		{sysUsers("--passwd", filepath.Join(dir, "nosuch"), "--dry-run"),
+			"open " + filepath.Join(dir, "nosuch") + ": no such file or directory"},
+	} {
+		out, errout, err = doTest(rootCmd, "", c.args)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("Import %v: expected %s, got %v", c.args, c.err, err)
+		}
+	}
+}
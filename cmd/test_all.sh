@@ -7,10 +7,37 @@ go test -run=TestTransport_
 go test -run=TestTransportEdit
 go test -run=TestTransportAdd_
 go test -run=TestTransportAddOne
+go test -run=TestTransportSasl
+go test -run=TestTransportMaster
 go test -run=Test_Domain
+go test -run=TestDomainAlias
 go test -run=Test_Address
+go test -run=TestSenderTransport
 go test -run=TestAliasCmds
+go test -run=TestVirtualCatchall
 go test -run=TestVMailboxCmd
+go test -run=TestSendAsCmd
+go test -run=TestCanonicalCmd
+go test -run=TestRelocatedCmd
+go test -run=TestBccCmd
+go test -run=TestCheckAccessCmd
+go test -run=TestRestrictionClassCmd
+go test -run=TestVacationCmd
+go test -run=TestSieveCmd
+go test -run=TestDistListCmd
+go test -run=TestExplainCmd
+go test -run=TestConsistencyCmd
+go test -run=TestConfigGenerateCmd
+go test -run=TestQueryCmd
+go test -run=TestSocketmapServer
+go test -run=TestCheckpasswordCmd
+go test -run=TestLimitCmd
+go test -run=TestPolicyServer
+go test -run=TestExportMapsCmd
+go test -run=TestPostfixAdminCmd
+go test -run=TestSystemUsersCmd
+go test -run=TestMainCfExpand
+go test -run=TestMainCfCmd
 go test -run=Test_Create
 go test -run=TestCreateNoAliases
 go test -run=TestViews
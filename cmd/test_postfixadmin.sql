@@ -0,0 +1,94 @@
+-- MySQL dump 10.19  Distrib 10.3.39-MariaDB, for Linux (x86_64)
+--
+-- Host: localhost    Database: postfix
+-- ------------------------------------------------------
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET NAMES utf8mb4 */;
+
+DROP TABLE IF EXISTS `admin`;
+CREATE TABLE `admin` (
+  `username` varchar(255) NOT NULL,
+  `password` varchar(255) NOT NULL,
+  PRIMARY KEY (`username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='Postfix Admin - Virtual Admins';
+INSERT INTO `admin` VALUES ('admin@example.com','$1$x$y');
+
+DROP TABLE IF EXISTS `domain`;
+CREATE TABLE `domain` (
+  `domain` varchar(255) NOT NULL,
+  `description` varchar(255) CHARACTER SET utf8 NOT NULL,
+  `aliases` int(10) NOT NULL DEFAULT 0,
+  `mailboxes` int(10) NOT NULL DEFAULT 0,
+  `maxquota` bigint(20) NOT NULL DEFAULT 0,
+  `quota` bigint(20) NOT NULL DEFAULT 0,
+  `transport` varchar(255) NOT NULL,
+  `backupmx` tinyint(1) NOT NULL DEFAULT 0,
+  `created` datetime NOT NULL DEFAULT '2000-01-01 00:00:00',
+  `modified` datetime NOT NULL DEFAULT '2000-01-01 00:00:00',
+  `active` tinyint(1) NOT NULL DEFAULT 1,
+  PRIMARY KEY (`domain`),
+  KEY `domain` (`domain`,`active`)
+) ENGINE=InnoDB DEFAULT CHARSET=latin1 COMMENT='Postfix Admin - Virtual Domains';
+
+LOCK TABLES `domain` WRITE;
+/*!40000 ALTER TABLE `domain` DISABLE KEYS */;
+INSERT INTO `domain` VALUES ('ALL','',0,0,0,0,'',0,'2021-03-01 10:00:00','2021-03-01 10:00:00',1),('example.com','Example; the main one',0,0,2048,0,'virtual',0,'2021-03-01 10:00:00','2021-03-01 10:00:00',1),('lists.example.com','Lists',0,-1,0,0,'smtp:[lists.example.net]',0,'2021-03-01 10:00:00','2021-03-01 10:00:00',1),('backup.net','Secondary MX',0,0,0,0,'relay',1,'2021-03-01 10:00:00','2021-03-01 10:00:00',1),('old.org','',0,0,0,0,'lmtp:unix:private/dovecot-lmtp',0,'2021-03-01 10:00:00','2021-03-01 10:00:00',0),('brand.com','Alias of example',0,0,0,0,'virtual',0,'2021-03-01 10:00:00','2021-03-01 10:00:00',1);
+/*!40000 ALTER TABLE `domain` ENABLE KEYS */;
+UNLOCK TABLES;
+
+DROP TABLE IF EXISTS `alias_domain`;
+CREATE TABLE `alias_domain` (
+  `alias_domain` varchar(255) NOT NULL,
+  `target_domain` varchar(255) NOT NULL,
+  `created` datetime NOT NULL DEFAULT '2000-01-01 00:00:00',
+  `modified` datetime NOT NULL DEFAULT '2000-01-01 00:00:00',
+  `active` tinyint(1) NOT NULL DEFAULT 1,
+  PRIMARY KEY (`alias_domain`),
+  KEY `active` (`active`),
+  KEY `target_domain` (`target_domain`)
+) ENGINE=InnoDB DEFAULT CHARSET=latin1 COMMENT='Postfix Admin - Domain Aliases';
+INSERT INTO `alias_domain` VALUES ('brand.com','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',1),('gone.com','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',0);
+
+DROP TABLE IF EXISTS `mailbox`;
+CREATE TABLE `mailbox` (
+  `username` varchar(255) NOT NULL,
+  `password` varchar(255) NOT NULL,
+  `name` varchar(255) CHARACTER SET utf8 NOT NULL,
+  `maildir` varchar(255) NOT NULL,
+  `quota` bigint(20) NOT NULL DEFAULT 0,
+  `local_part` varchar(255) NOT NULL,
+  `domain` varchar(255) NOT NULL,
+  `created` datetime NOT NULL DEFAULT '2000-01-01 00:00:00',
+  `modified` datetime NOT NULL DEFAULT '2000-01-01 00:00:00',
+  `active` tinyint(1) NOT NULL DEFAULT 1,
+  PRIMARY KEY (`username`),
+  KEY `domain` (`domain`)
+) ENGINE=InnoDB DEFAULT CHARSET=latin1 COMMENT='Postfix Admin - Virtual Mailboxes';
+INSERT INTO `mailbox` VALUES ('bob@example.com','{SHA512-CRYPT}$6$saltsalt$Zk5n.Xx0','Bob O\'Brien','example.com/bob/',1073741824,'bob','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',1),('carol@example.com','$1$abcdefgh$Jd0uYl6Mz3','Carol','example.com/carol/',0,'carol','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',0),('dan@example.com','{SSHA512}c2VjcmV0','Dan','/srv/mail/example.com/dan/',0,'dan','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',1);
+INSERT INTO `mailbox` (`username`, `password`, `name`, `maildir`, `quota`, `local_part`, `domain`, `created`, `modified`, `active`) VALUES ('eve@old.org','{PLAIN}it''s secret','Eve','old.org/eve/',5242880,'eve','old.org',NOW(),NOW(),1);
+
+DROP TABLE IF EXISTS `alias`;
+CREATE TABLE `alias` (
+  `address` varchar(255) NOT NULL,
+  `goto` text NOT NULL,
+  `domain` varchar(255) NOT NULL,
+  `created` datetime NOT NULL DEFAULT '2000-01-01 00:00:00',
+  `modified` datetime NOT NULL DEFAULT '2000-01-01 00:00:00',
+  `active` tinyint(1) NOT NULL DEFAULT 1,
+  PRIMARY KEY (`address`),
+  KEY `domain` (`domain`)
+) ENGINE=InnoDB DEFAULT CHARSET=latin1 COMMENT='Postfix Admin - Virtual Aliases';
+INSERT INTO `alias` VALUES ('bob@example.com','bob@example.com','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',1),('carol@example.com','carol@example.com,carol@gmail.com','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',1),('dan@example.com','dan@example.com','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',1),('eve@old.org','eve@old.org','old.org','2021-03-01 10:00:00','2021-03-01 10:00:00',1),('sales@example.com','bob@example.com, carol@example.com','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',1),('info@example.com','bob@example.com','example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',0),('@lists.example.com','bob@example.com','lists.example.com','2021-03-01 10:00:00','2021-03-01 10:00:00',1);
+
+DROP TABLE IF EXISTS `vacation`;
+CREATE TABLE `vacation` (
+  `email` varchar(255) NOT NULL,
+  `subject` varchar(255) CHARACTER SET utf8 NOT NULL,
+  `body` text CHARACTER SET utf8 NOT NULL,
+  `active` tinyint(1) NOT NULL DEFAULT 1,
+  PRIMARY KEY (`email`)
+) ENGINE=InnoDB DEFAULT CHARSET=latin1;
+INSERT INTO `vacation` VALUES ('bob@example.com','Away','I am away;\nback soon.',1),('dan@example.com','Away','Gone',0);
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+
+-- Dump completed on 2021-03-01 10:00:00
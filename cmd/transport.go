@@ -17,7 +17,10 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
 	//"strconv"
 	"strings"
 
@@ -30,8 +33,17 @@ var (
 	transNexthop   string
 	noTransport    bool
 	noNexthop      bool
+	saslUser       string
+	saslPassword   string
+	noSasl         bool
+	masterCf       string
+	noValidate     bool
+	validate       bool            // set by loadServices for this command
+	services       map[string]bool // nil if there is no master.cf to check
 )
 
+const defaultMasterCf = "/etc/postfix/master.cf"
+
 // importTransport do import of an transport file
 var importTransport = &cobra.Command{
 	Use:   "transport",
@@ -89,12 +101,24 @@ var showTransport = &cobra.Command{
 // linkage to top level
 func init() {
 	importCmd.AddCommand(importTransport)
+	importTransport.Flags().StringVarP(&masterCf, "master-cf", "m", defaultMasterCf,
+		"Postfix master.cf that defines the transport services")
+	importTransport.Flags().BoolVar(&noValidate, "no-validate", false,
+		"Do not check transports against master.cf or the nexthop syntax")
 	exportCmd.AddCommand(exportTransport)
 	addCmd.AddCommand(addTransport)
 	addTransport.Flags().StringVarP(&transTransport, "transport", "t", "",
 		"Transport protocol/method")
 	addTransport.Flags().StringVarP(&transNexthop, "nexthop", "n", "",
 		"Transport nexthop to send email")
+	addTransport.Flags().StringVarP(&saslUser, "sasl-user", "u", "",
+		"SASL username to log in to the nexthop")
+	addTransport.Flags().StringVarP(&saslPassword, "sasl-password", "p", "",
+		"SASL password to log in to the nexthop, '-' reads it from stdin")
+	addTransport.Flags().StringVarP(&masterCf, "master-cf", "m", defaultMasterCf,
+		"Postfix master.cf that defines the transport services")
+	addTransport.Flags().BoolVar(&noValidate, "no-validate", false,
+		"Do not check the transport against master.cf or the nexthop syntax")
 	deleteCmd.AddCommand(deleteTransport)
 	editCmd.AddCommand(editTransport)
 	editTransport.Flags().StringVarP(&transTransport, "transport", "t", "",
@@ -105,6 +129,16 @@ func init() {
 		"Transport nexthop to send email")
 	editTransport.Flags().BoolVarP(&noNexthop, "no-nexthop", "N", false,
 		"Clear transport nexthop used to send email")
+	editTransport.Flags().StringVarP(&saslUser, "sasl-user", "u", "",
+		"SASL username to log in to the nexthop")
+	editTransport.Flags().StringVarP(&saslPassword, "sasl-password", "p", "",
+		"SASL password to log in to the nexthop, '-' reads it from stdin")
+	editTransport.Flags().BoolVarP(&noSasl, "no-sasl", "U", false,
+		"Clear the SASL username and password")
+	editTransport.Flags().StringVarP(&masterCf, "master-cf", "m", defaultMasterCf,
+		"Postfix master.cf that defines the transport services")
+	editTransport.Flags().BoolVar(&noValidate, "no-validate", false,
+		"Do not check the transport against master.cf or the nexthop syntax")
 	showCmd.AddCommand(showTransport)
 }
 
@@ -112,12 +146,80 @@ func init() {
 func transportImport(cmd *cobra.Command, args []string) error {
 	var err error
 
+	if err = loadServices(cmd); err != nil {
+		return err
+	}
 	mdb.Begin()
 	defer mdb.End(&err)
 	err = procImport(cmd, SIMPLE, procTransport)
 	return err
 }
 
+// loadServices
+// Read the service names from master.cf for checkTransport. The default
+// file is skipped if it is not there because postdove does not have to
+// run on the postfix host. One named by --master-cf must be there.
+func loadServices(cmd *cobra.Command) error {
+	var (
+		f   *os.File
+		err error
+	)
+
+	services = nil
+	validate = !noValidate
+	if !validate {
+		return nil
+	}
+	if f, err = os.Open(masterCf); err != nil {
+		if os.IsNotExist(err) && !cmd.Flags().Changed("master-cf") {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	services, err = readServices(f)
+	return err
+}
+
+// readServices
+// The names of the unix type services in master.cf. These are the
+// delivery agents a transport can name. Comments and the indented
+// option lines are skipped.
+func readServices(r io.Reader) (map[string]bool, error) {
+	var (
+		lines = bufio.NewScanner(r)
+		svc   = make(map[string]bool)
+	)
+
+	for lines.Scan() {
+		line := lines.Text()
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' {
+			continue
+		}
+		if f := strings.Fields(line); len(f) >= 2 && f[1] == "unix" {
+			svc[f[0]] = true
+		}
+	}
+	return svc, lines.Err()
+}
+
+// checkTransport
+// The transport must be a master.cf service and the nexthop must be
+// something that transport can use
+func checkTransport(transport string, nexthop string) error {
+	if !validate {
+		return nil
+	}
+	if transport != "" && services != nil && !services[transport] {
+		return fmt.Errorf("%s is not a unix service in %s", transport, masterCf)
+	}
+	if err := maildb.DecodeNexthop(transport, nexthop); err != nil {
+		return fmt.Errorf("%s: %s", nexthop, err)
+	}
+	return nil
+}
+
 // procTransport
 func procTransport(tokens []string) error {
 	var (
@@ -134,7 +236,7 @@ func procTransport(tokens []string) error {
 	kv := strings.SplitN(tokens[1], ":", 2)
 	if len(kv) != 2 {
 		err = fmt.Errorf("Transport value must include ':' to separate transport from nexthop, (%v)", kv)
-	} else {
+	} else if err = checkTransport(kv[0], kv[1]); err == nil {
 		if kv[0] != "" {
 			err = tr.SetTransport(kv[0])
 		}
@@ -173,6 +275,19 @@ func transportAdd(cmd *cobra.Command, args []string) error {
 		tr  *maildb.Transport
 	)
 
+	if err = loadServices(cmd); err != nil {
+		return err
+	}
+	trans, hop := "", ""
+	if cmd.Flags().Changed("transport") {
+		trans = transTransport
+	}
+	if cmd.Flags().Changed("nexthop") {
+		hop = transNexthop
+	}
+	if err = checkTransport(trans, hop); err != nil {
+		return err
+	}
 	mdb.Begin()
 	defer mdb.End(&err)
 
@@ -183,6 +298,33 @@ func transportAdd(cmd *cobra.Command, args []string) error {
 	if err == nil && cmd.Flags().Changed("nexthop") {
 		err = tr.SetNexthop(transNexthop)
 	}
+	if err == nil {
+		err = setSasl(cmd, tr)
+	}
+	return err
+}
+
+// setSasl
+// apply the SASL flags common to add and edit
+func setSasl(cmd *cobra.Command, tr *maildb.Transport) error {
+	var err error
+
+	if cmd.Flags().Changed("sasl-user") {
+		err = tr.SetSaslUser(saslUser)
+	}
+	if err == nil && cmd.Flags().Changed("sasl-password") {
+		pw := saslPassword
+		if pw == "-" { // keep it off the command line
+			pw, err = bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+			if err == io.EOF {
+				err = nil
+			}
+			pw = strings.TrimRight(pw, "\r\n")
+		}
+		if err == nil {
+			err = tr.SetSaslPassword(pw)
+		}
+	}
 	return err
 }
 
@@ -198,6 +340,9 @@ func transportEdit(cmd *cobra.Command, args []string) error {
 		tr  *maildb.Transport
 	)
 
+	if err = loadServices(cmd); err != nil {
+		return err
+	}
 	mdb.Begin()
 	defer mdb.End(&err)
 
@@ -217,6 +362,24 @@ func transportEdit(cmd *cobra.Command, args []string) error {
 			err = tr.SetNexthop(transNexthop)
 		}
 	}
+	if err == nil && (cmd.Flags().Changed("transport") || cmd.Flags().Changed("nexthop")) {
+		// check the pair as it is after the edit
+		trans, hop := tr.Transport(), tr.Nexthop()
+		if trans == "--" {
+			trans = ""
+		}
+		if hop == "--" {
+			hop = ""
+		}
+		err = checkTransport(trans, hop)
+	}
+	if err == nil {
+		if cmd.Flags().Changed("no-sasl") {
+			err = tr.ClearSasl()
+		} else {
+			err = setSasl(cmd, tr)
+		}
+	}
 	return err
 }
 
@@ -232,5 +395,92 @@ func transportShow(cmd *cobra.Command, args []string) error {
 	}
 	cmd.Printf("Name:\t\t%s\nTransport:\t%s\nNexthop:\t%s\n",
 		tr.Name(), tr.Transport(), tr.Nexthop())
+	if tr.SaslUser() != "--" { // the password is never shown
+		cmd.Printf("SASL User:\t%s\n", tr.SaslUser())
+	}
 	return nil
 }
+
+// transportSet
+// finds or makes the transport for a transport(5) value like
+// "smtp:[mx.example.com]" for the imports from other systems.
+// The domains and addresses that have the same value share a transport
+// named for its service, smtp, smtp-2 and so on.
+type transportSet struct {
+	names  map[string]bool   // transport names in use
+	values map[string]string // "transport:nexthop" -> transport name
+}
+
+// newTransportSet
+// start with the transports already in the database. This is
+// done before the import's transaction.
+func newTransportSet() (*transportSet, error) {
+	ts := &transportSet{
+		names:  make(map[string]bool),
+		values: make(map[string]string),
+	}
+	tl, err := mdb.FindTransport("*")
+	if err != nil {
+		if err == maildb.ErrMdbTransNotFound {
+			err = nil
+		}
+		return ts, err
+	}
+	for _, tr := range tl {
+		ts.names[tr.Name()] = true
+		ts.values[transportValue(tr.Transport(), tr.Nexthop())] = tr.Name()
+	}
+	return ts, nil
+}
+
+// transportValue
+func transportValue(transport, nexthop string) string {
+	if transport == "--" {
+		transport = ""
+	}
+	if nexthop == "--" {
+		nexthop = ""
+	}
+	return transport + ":" + nexthop
+}
+
+// get
+// the name of the transport for value, inserting it if it is new
+func (ts *transportSet) get(value string) (string, error) {
+	var (
+		tr  *maildb.Transport
+		err error
+	)
+
+	kv := strings.SplitN(value, ":", 2)
+	if len(kv) == 1 {
+		kv = append(kv, "")
+	}
+	key := transportValue(kv[0], kv[1])
+	if name, ok := ts.values[key]; ok {
+		return name, nil
+	}
+	if err = checkTransport(kv[0], kv[1]); err != nil {
+		return "", err
+	}
+	base := kv[0]
+	if base == "" {
+		base = "nexthop"
+	}
+	name := uniqueName(ts.names, base)
+	if tr, err = mdb.InsertTransport(name); err != nil {
+		return "", err
+	}
+	if kv[0] != "" {
+		err = tr.SetTransport(kv[0])
+	}
+	if err == nil && kv[1] != "" {
+		err = tr.SetNexthop(kv[1])
+	}
+	if err != nil {
+		return "", err
+	}
+	ts.names[name] = true
+	ts.values[key] = name
+	return name, nil
+}
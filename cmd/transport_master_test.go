@@ -0,0 +1,142 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestTransportMaster
+func TestTransportMaster(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		master      string
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestTransportMaster")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestTransportMaster-*")
+	defer os.RemoveAll(dir)
+	dbfile = filepath.Join(dir, "test.db")
+
+	args = []string{"create", "-d", dbfile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+
+	master = filepath.Join(dir, "master.cf")
+	if err = ioutil.WriteFile(master, []byte(`# service type  private unpriv  chroot  wakeup  maxproc command + args
+smtp      inet  n       -       n       -       -       smtpd
+smtp      unix  -       -       n       -       -       smtp
+relay     unix  -       -       n       -       -       smtp
+        -o syslog_name=postfix/$service_name
+lmtp      unix  -       -       n       -       -       lmtp
+error     unix  -       -       n       -       -       error
+#mailman   unix  -       n       n       -       -       pipe
+#  flags=FRX user=list argv=/usr/lib/mailman/bin/postfix-to-mailman.py
+127.0.0.1:10025 inet n    -    n    -    - smtpd
+`), 0644); err != nil {
+		t.Errorf("Write of master.cf: Unexpected error, %s", err)
+		return
+	}
+
+	args = []string{"-d", dbfile, "add", "transport", "dove", "-m", master,
+		"-t", "lmtp", "-n", "unix:private/dovecot-lmtp"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add transport dove: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "transport", "typo", "-m", master, "-t", "lmpt"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add transport typo: should have failed")
+	} else if !strings.Contains(err.Error(), "lmpt is not a unix service") {
+		t.Errorf("Add transport typo: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "add", "transport", "typo", "-m", master,
+		"-t", "smtp", "-n", "[2001:db8::1"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add transport typo with bad nexthop: should have failed")
+	} else if !strings.Contains(err.Error(), maildb.ErrMdbBadNexthop.Error()) {
+		t.Errorf("Add transport typo with bad nexthop: Unexpected error, %s", err)
+	}
+
+	// the inet smtpd and the commented out mailman are not transports
+	args = []string{"-d", dbfile, "import", "transport", "-m", master}
+	out, errout, err = doTest(rootCmd, "relay relay:[gw.example.com]:587\nbounce error:go away\n", args)
+	if err != nil {
+		t.Errorf("Import transport: Unexpected error, %s", err)
+	}
+	out, errout, err = doTest(rootCmd, "list mailman:\n", args)
+	if err == nil {
+		t.Errorf("Import transport mailman: should have failed")
+	} else if !strings.Contains(err.Error(), "mailman is not a unix service") {
+		t.Errorf("Import transport mailman: Unexpected error, %s", err)
+	}
+
+	// a bad edit leaves the transport alone
+	args = []string{"-d", dbfile, "edit", "transport", "dove", "-m", master, "-n", "inet:[::1]:0"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Edit transport dove: should have failed")
+	}
+	args = []string{"-d", dbfile, "show", "transport", "dove"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show transport dove: Unexpected error, %s", err)
+	}
+	if out != "Name:\t\tdove\nTransport:\tlmtp\nNexthop:\tunix:private/dovecot-lmtp\n" {
+		t.Errorf("Show transport dove: did not get expected output, got %s", out)
+	}
+
+	// a master.cf that was asked for must be there
+	args = []string{"-d", dbfile, "add", "transport", "typo", "-m", filepath.Join(dir, "nothere.cf"),
+		"-t", "lmpt"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add transport typo with missing master.cf: should have failed")
+	}
+	args = []string{"-d", dbfile, "add", "transport", "typo", "-m", filepath.Join(dir, "nothere.cf"),
+		"-t", "lmpt", "--no-validate=false"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add transport typo with --no-validate=false: should have failed")
+	}
+	args = []string{"-d", dbfile, "add", "transport", "typo", "-m", filepath.Join(dir, "nothere.cf"),
+		"-t", "lmpt", "--no-validate"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add transport typo with --no-validate: Unexpected error, %s", err)
+	}
+	if errout != "" {
+		t.Errorf("Add transport typo with --no-validate: did not expect error output, got %s", errout)
+	}
+}
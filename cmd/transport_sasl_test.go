@@ -0,0 +1,128 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestTransportSasl
+func TestTransportSasl(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestTransportSasl")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestTransportSasl-*")
+	defer os.RemoveAll(dir)
+	dbfile = filepath.Join(dir, "test.db")
+
+	args = []string{"create", "-d", dbfile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Create DB: Unexpected error, %s", err)
+	}
+
+	// the password comes from stdin
+	args = []string{"-d", dbfile, "add", "transport", "provider", "-t", "smtp",
+		"-n", "[smtp.provider.net]:587", "-u", "pobox", "-p", "-"}
+	out, errout, err = doTest(rootCmd, "s3cret\n", args)
+	if err != nil {
+		t.Errorf("Add transport provider: Unexpected error, %s", err)
+	}
+	if out != "" {
+		t.Errorf("Add transport provider: did not expect output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Add transport provider: did not expect error output, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "show", "transport", "provider"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show transport provider: Unexpected error, %s", err)
+	}
+	if out != "Name:\t\tprovider\nTransport:\tsmtp\nNexthop:\t[smtp.provider.net]:587\n"+
+		"SASL User:\tpobox\n" {
+		t.Errorf("Show transport provider: did not get expected output, got %s", out)
+	}
+	// the secret stays out of the transport export
+	args = []string{"-d", dbfile, "export", "transport"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export transport: Unexpected error, %s", err)
+	}
+	if out != "provider smtp:[smtp.provider.net]:587\n" {
+		t.Errorf("Export transport: got (%s)", out)
+	}
+
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT credentials FROM sasl_password WHERE nexthop = '[smtp.provider.net]:587'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"credentials": "pobox:s3cret",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("sasl_password [smtp.provider.net]:587: %s", err)
+	}
+	mdb.Close()
+
+	args = []string{"-d", dbfile, "edit", "transport", "provider", "--no-sasl"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit transport provider --no-sasl: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "show", "transport", "provider"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show transport provider: Unexpected error, %s", err)
+	}
+	if out != "Name:\t\tprovider\nTransport:\tsmtp\nNexthop:\t[smtp.provider.net]:587\n" {
+		t.Errorf("Show transport provider after --no-sasl: got %s", out)
+	}
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	defer mdb.Close()
+	q = `
+SELECT credentials FROM sasl_password
+`
+	expectedRes = []maildb.QueryRes{}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("sasl_password after --no-sasl: %s", err)
+	}
+}
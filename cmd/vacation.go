@@ -0,0 +1,259 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lieb/postdove/maildb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vacSubject   string
+	vacBody      string
+	vacStart     string
+	vacEnd       string
+	vacDays      int64
+	vacAddresses string
+	mailHome     string
+)
+
+// dovecot mail_home for the mailboxes that do not have their own
+const defaultMailHome = "/srv/dovecot/%d/%n"
+
+// vacationScript
+// The generated script in the mailbox home. dovecot runs it ahead of the
+// user's own scripts with "sieve_before = ~/.dovecot.vacation.sieve"
+const vacationScript = ".dovecot.vacation.sieve"
+
+// setVacation start or change a vacation
+var setVacation = &cobra.Command{
+	Use:   "set user@domain",
+	Short: "Set up or change the vacation auto-reply of a mailbox",
+	Long: `Set up or change the vacation auto-reply of the mailbox user@domain and
+write its Sieve script into the mailbox home. A new vacation needs a --body.
+Flags that are not given keep their current values.`,
+	Args: cobra.ExactArgs(1),
+	RunE: vacationSet,
+}
+
+// clearVacation end a vacation
+var clearVacation = &cobra.Command{
+	Use:   "clear user@domain",
+	Short: "Remove the vacation auto-reply of a mailbox",
+	Long: `Remove the vacation auto-reply of the mailbox user@domain from the database
+and its Sieve script from the mailbox home.`,
+	Args: cobra.ExactArgs(1),
+	RunE: vacationClear,
+}
+
+// showVacation display a vacation
+var showVacation = &cobra.Command{
+	Use:   "show user@domain",
+	Short: "Display the vacation auto-reply of a mailbox",
+	Long:  `Display the vacation auto-reply settings of the mailbox user@domain to the standard output.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  vacationShow,
+}
+
+// linkage to the vacation command
+func init() {
+	vacationCmd.AddCommand(setVacation)
+	setVacation.Flags().StringVarP(&vacSubject, "subject", "s", "",
+		"Subject of the reply, empty for \"Auto: \" and the original subject")
+	setVacation.Flags().StringVarP(&vacBody, "body", "b", "",
+		"Message text of the reply, '-' reads it from stdin")
+	setVacation.Flags().StringVar(&vacStart, "start", "",
+		"First day to reply as YYYY-MM-DD, empty to start now")
+	setVacation.Flags().StringVar(&vacEnd, "end", "",
+		"Last day to reply as YYYY-MM-DD, empty to reply until cleared")
+	setVacation.Flags().Int64VarP(&vacDays, "days", "D", 7,
+		"Days before replying to the same sender again")
+	setVacation.Flags().StringVarP(&vacAddresses, "addresses", "a", "",
+		"Other addresses of this user to reply for, comma separated")
+	setVacation.Flags().StringVar(&mailHome, "mail-home", defaultMailHome,
+		"dovecot mail_home for mailboxes without a home of their own")
+	vacationCmd.AddCommand(clearVacation)
+	clearVacation.Flags().StringVar(&mailHome, "mail-home", defaultMailHome,
+		"dovecot mail_home for mailboxes without a home of their own")
+	vacationCmd.AddCommand(showVacation)
+}
+
+// vacationSet
+func vacationSet(cmd *cobra.Command, args []string) error {
+	var (
+		err  error
+		mh   *maildb.MailHome
+		v    *maildb.Vacation
+		body string = vacBody
+		data []byte
+	)
+
+	if mh, err = mdb.LookupMailHome(args[0]); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("body") && vacBody == "-" {
+		if data, err = ioutil.ReadAll(cmd.InOrStdin()); err != nil {
+			return err
+		}
+		body = strings.TrimRight(string(data), "\n")
+	}
+
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if v, err = mdb.GetVacation(args[0]); err == maildb.ErrMdbVacationNotFound {
+		if !cmd.Flags().Changed("body") {
+			err = maildb.ErrMdbVacationNoBody
+			return err
+		}
+		v, err = mdb.InsertVacation(args[0], body)
+	} else if err == nil && cmd.Flags().Changed("body") {
+		err = v.SetBody(body)
+	}
+	if err == nil && cmd.Flags().Changed("subject") {
+		err = v.SetSubject(vacSubject)
+	}
+	if err == nil && (cmd.Flags().Changed("start") || cmd.Flags().Changed("end")) {
+		start, end := "", "" // keep the one not given
+		if cmd.Flags().Changed("start") {
+			start = vacStart
+		} else if v.Start() != "--" {
+			start = v.Start()
+		}
+		if cmd.Flags().Changed("end") {
+			end = vacEnd
+		} else if v.End() != "--" {
+			end = v.End()
+		}
+		err = v.SetDates(start, end)
+	}
+	if err == nil && cmd.Flags().Changed("days") {
+		err = v.SetDays(vacDays)
+	}
+	if err == nil && cmd.Flags().Changed("addresses") {
+		err = v.SetAddresses(vacAddresses)
+	}
+	if err == nil {
+		uid, gid := mh.Owner()
+		err = writeSieve(mh.Path(mailHome), uid, gid, 0600, vacationScript, v.Sieve())
+	}
+	return err
+}
+
+// vacationClear
+func vacationClear(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		mh  *maildb.MailHome
+	)
+
+	if mh, err = mdb.LookupMailHome(args[0]); err != nil {
+		return err
+	}
+	if err = mdb.DeleteVacation(args[0]); err != nil {
+		return err
+	}
+	return removeSieve(mh.Path(mailHome), vacationScript)
+}
+
+// vacationShow
+func vacationShow(cmd *cobra.Command, args []string) error {
+	var (
+		err error
+		v   *maildb.Vacation
+	)
+
+	if v, err = mdb.LookupVacation(args[0]); err != nil {
+		return err
+	}
+	cmd.Printf("User:\t\t%s\nSubject:\t%s\nStart:\t\t%s\nEnd:\t\t%s\n",
+		v.User(), v.Subject(), v.Start(), v.End())
+	cmd.Printf("Reply Days:\t%d\nAddresses:\t%s\n", v.Days(), v.Addresses())
+	for i, l := range strings.Split(v.Body(), "\n") {
+		if i == 0 {
+			cmd.Printf("Message:\t%s\n", l)
+		} else {
+			cmd.Printf("\t\t%s\n", l)
+		}
+	}
+	return nil
+}
+
+// makeDir
+// Make a directory owned by uid and gid if it is not there yet. The
+// parent must already be there.
+func makeDir(dir string, uid int, gid int, perm os.FileMode) error {
+	var err error
+
+	if _, err = os.Stat(dir); os.IsNotExist(err) {
+		if err = os.Mkdir(dir, perm); err != nil {
+			return err
+		}
+		// the umask could have taken some of perm away
+		if err = os.Chmod(dir, perm); err != nil {
+			return err
+		}
+		return os.Chown(dir, uid, gid)
+	}
+	return err
+}
+
+// writeSieve
+// Replace a Sieve script in dir, owned by uid and gid. The directory is
+// made if dovecot has not done it yet and is searchable by whoever can
+// read the script. The compiled copy dovecot left next to the old
+// script is removed.
+func writeSieve(dir string, uid int, gid int, perm os.FileMode, name string, script string) error {
+	var err error
+
+	if err = os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if err = makeDir(dir, uid, gid, perm|(perm&0444)>>2); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, name)
+	tmp := file + ".tmp"
+	os.Remove(tmp)
+	if err = ioutil.WriteFile(tmp, []byte(script), perm); err == nil {
+		if err = os.Chown(tmp, uid, gid); err == nil {
+			err = os.Rename(tmp, file)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Remove(strings.TrimSuffix(file, ".sieve") + ".svbin")
+	return nil
+}
+
+// removeSieve
+// Remove a Sieve script and its compiled copy from a mailbox home
+func removeSieve(home string, name string) error {
+	file := filepath.Join(home, name)
+	os.Remove(strings.TrimSuffix(file, ".sieve") + ".svbin")
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
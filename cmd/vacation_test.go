@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestVacationCmd
+func TestVacationCmd(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		script      string
+		data        []byte
+		args        []string
+		out, errout string
+	)
+
+	fmt.Println("TestVacationCmd")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestVacationCmd-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	// we can only chown to ourselves if we are not root
+	args = []string{"-d", dbfile, "edit", "mailbox", "jeff@pobox.org",
+		"-u", fmt.Sprintf("%d", os.Getuid()), "-g", fmt.Sprintf("%d", os.Getgid())}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Edit mailbox jeff@pobox.org: Unexpected error, %s", err)
+	}
+
+	args = []string{"-d", dbfile, "vacation", "set", "dave@pobox.org", "-s", "Away"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Vacation set dave@pobox.org without a body: should have failed")
+	} else if err != maildb.ErrMdbVacationNoBody {
+		t.Errorf("Vacation set dave@pobox.org without a body: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "vacation", "set", "sales@pobox.org", "-b", "-"}
+	out, errout, err = doTest(rootCmd, "Gone\n", args)
+	if err == nil {
+		t.Errorf("Vacation set sales@pobox.org: should have failed")
+	}
+
+	// the body comes from stdin and jeff uses the mail_home
+	args = []string{"-d", dbfile, "vacation", "set", "jeff@pobox.org", "-b", "-",
+		"-s", "Away", "--start", "2026-10-01", "--mail-home", filepath.Join(dir, "%d/%n")}
+	out, errout, err = doTest(rootCmd, "Gone fishing.\nBack Monday.\n", args)
+	if err != nil {
+		t.Errorf("Vacation set jeff@pobox.org: Unexpected error, %s", err)
+	}
+	if errout != "" {
+		t.Errorf("Vacation set jeff@pobox.org: did not expect error output, got %s", errout)
+	}
+	script = filepath.Join(dir, "pobox.org/jeff/.dovecot.vacation.sieve")
+	if data, err = ioutil.ReadFile(script); err != nil {
+		t.Errorf("Vacation set jeff@pobox.org: no script, %s", err)
+	} else if string(data) != `# Generated by postdove for jeff@pobox.org. Edits will be lost.
+require ["vacation", "date", "relational"];
+
+if currentdate :value "ge" "date" "2026-10-01" {
+  vacation :days 7 :subject "Away"
+    "Gone fishing.
+Back Monday.";
+}
+` {
+		t.Errorf("Vacation set jeff@pobox.org: unexpected script, got %s", string(data))
+	}
+
+	// only the days change
+	args = []string{"-d", dbfile, "vacation", "set", "jeff@pobox.org", "-D", "2",
+		"--mail-home", filepath.Join(dir, "%d/%n")}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Vacation set jeff@pobox.org days: Unexpected error, %s", err)
+	}
+	args = []string{"-d", dbfile, "vacation", "show", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Vacation show jeff@pobox.org: Unexpected error, %s", err)
+	}
+	if out != "User:\t\tjeff@pobox.org\nSubject:\tAway\nStart:\t\t2026-10-01\nEnd:\t\t--\n"+
+		"Reply Days:\t2\nAddresses:\t--\nMessage:\tGone fishing.\n\t\tBack Monday.\n" {
+		t.Errorf("Vacation show jeff@pobox.org: did not get expected output, got %s", out)
+	}
+	if data, err = ioutil.ReadFile(script); err != nil {
+		t.Errorf("Vacation set jeff@pobox.org days: no script, %s", err)
+	} else if !strings.Contains(string(data), "vacation :days 2 :subject") {
+		t.Errorf("Vacation set jeff@pobox.org days: script not updated, got %s", string(data))
+	}
+
+	args = []string{"-d", dbfile, "vacation", "clear", "jeff@pobox.org",
+		"--mail-home", filepath.Join(dir, "%d/%n")}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Vacation clear jeff@pobox.org: Unexpected error, %s", err)
+	}
+	if _, err = os.Stat(script); !os.IsNotExist(err) {
+		t.Errorf("Vacation clear jeff@pobox.org: script still there, %v", err)
+	}
+	args = []string{"-d", dbfile, "vacation", "show", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Vacation show jeff@pobox.org after clear: should have failed")
+	} else if err != maildb.ErrMdbVacationNotFound {
+		t.Errorf("Vacation show jeff@pobox.org after clear: Unexpected error, %s", err)
+	}
+}
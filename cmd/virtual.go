@@ -68,7 +68,8 @@ var deleteVirtual = &cobra.Command{
 	Use:   "virtual address",
 	Short: "Delete an virtual address aliasfrom the database.",
 	Long: `Delete an virtual address alias from the database.
-All of the recipients pointed to by this name will be also deleted`,
+All of the recipients pointed to by this name will be also deleted.
+The --relocated flag leaves a relocated entry so senders are told where the user went.`,
 	Args: cobra.ExactArgs(1), // virtual name
 	RunE: virtualDelete,
 }
@@ -99,6 +100,8 @@ func init() {
 	exportCmd.AddCommand(exportVirtual)
 	addCmd.AddCommand(addVirtual)
 	deleteCmd.AddCommand(deleteVirtual)
+	deleteVirtual.Flags().StringVarP(&relocTarget, "relocated", "r", "",
+		"Leave a relocated entry with this new location")
 	editCmd.AddCommand(editVirtual)
 	editVirtual.Flags().StringSliceVarP(&vAddRecipient, "add", "a", []string{""},
 		"Recipient to add to this virtual alias")
@@ -114,10 +117,34 @@ func virtualImport(cmd *cobra.Command, args []string) error {
 	mdb.Begin()
 	defer mdb.End(&err)
 
+	catchalls = nil
 	err = procImport(cmd, POSTFIX, procVirtual) // once past syntax, they are same
+	if err == nil {
+		for _, c := range catchalls {
+			catchallWarn(cmd, c)
+		}
+	}
 	return err
 }
 
+// catch-all keys seen by procVirtual so the caller can warn about them
+var catchalls []*maildb.Address
+
+// catchallWarn
+// A catch-all in a domain that also has mailboxes takes all the mail for
+// misspelled or departed users. Postfix still delivers to the mailboxes but
+// the admin should know what they asked for.
+func catchallWarn(cmd *cobra.Command, a *maildb.Address) {
+	d, err := mdb.GetDomain(a.Domain())
+	if err != nil {
+		return
+	}
+	if n, err := d.Mailboxes(); err == nil && n > 0 {
+		cmd.PrintErrf("Warning: catch-all %s in a domain with %d mailboxes\n",
+			a.Address(), n)
+	}
+}
+
 // procVirtual
 func procVirtual(tokens []string) error {
 	var (
@@ -136,6 +163,9 @@ func procVirtual(tokens []string) error {
 				break
 			}
 		}
+		if err == nil && a.IsCatchall() {
+			catchalls = append(catchalls, a)
+		}
 	}
 	return err
 }
@@ -173,16 +203,31 @@ func virtualAdd(cmd *cobra.Command, args []string) error {
 	mdb.Begin()
 	defer mdb.End(&err)
 
-	return procVirtual(args)
+	catchalls = nil
+	if err = procVirtual(args); err == nil && len(catchalls) > 0 {
+		catchallWarn(cmd, catchalls[0])
+	}
+	return err
 }
 
 // virtualDelete the address in the first arg
 func virtualDelete(cmd *cobra.Command, args []string) error {
+	var err error
+
 	if ap, err := maildb.DecodeRFC822(args[0]); err != nil {
 	} else if ap.IsLocal() {
 		return fmt.Errorf("A virtual alias must be 'mailbox@domain'")
 	}
-	return mdb.RemoveAlias(args[0])
+	if err = relocatedCheck(cmd); err != nil {
+		return err
+	}
+	mdb.Begin()
+	defer mdb.End(&err)
+
+	if err = mdb.RemoveAlias(args[0]); err == nil {
+		err = relocatedLeave(cmd, args[0])
+	}
+	return err
 }
 
 // virtualEdit the address in the first arg
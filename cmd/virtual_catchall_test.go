@@ -0,0 +1,202 @@
+/*
+Copyright © 2021 Jim Lieb <lieb@sea-troll.net>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lieb/postdove/maildb"
+)
+
+// TestVirtualCatchall
+func TestVirtualCatchall(t *testing.T) {
+	var (
+		err         error
+		dir         string
+		dbfile      string
+		args        []string
+		out, errout string
+		q           string
+		expectedRes []maildb.QueryRes
+	)
+
+	fmt.Println("TestVirtualCatchall")
+
+	// Make a database and test it
+	dir, err = ioutil.TempDir("", "TestVirtualCatchall-*")
+	defer os.RemoveAll(dir)
+	dbfile = makeTestDB(t, dir)
+
+	// catch-alls only make sense in virtual and vmailbox domains
+	args = []string{"-d", dbfile, "add", "virtual", "@zip.com", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add virtual @zip.com: should have failed")
+	} else if err != maildb.ErrMdbCatchallDomain {
+		t.Errorf("Add virtual @zip.com: Unexpected error, %s", err)
+	}
+
+	// and a catch-all is a key, not a recipient
+	args = []string{"-d", dbfile, "add", "virtual", "info@run.com", "@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err == nil {
+		t.Errorf("Add virtual info@run.com to @pobox.org: should have failed")
+	} else if err != maildb.ErrMdbCatchallTarget {
+		t.Errorf("Add virtual info@run.com to @pobox.org: Unexpected error, %s", err)
+	}
+
+	// no mailboxes in run.com so no warning
+	args = []string{"-d", dbfile, "add", "virtual", "@run.com", "dave@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual @run.com: Unexpected error, %s", err)
+	}
+	if out != "" {
+		t.Errorf("Add virtual @run.com: did not expect output, got %s", out)
+	}
+	if errout != "" {
+		t.Errorf("Add virtual @run.com: did not expect error output, got %s", errout)
+	}
+
+	// pobox.org has jeff and dave so warn
+	args = []string{"-d", dbfile, "add", "virtual", "@pobox.org", "jeff@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Add virtual @pobox.org: Unexpected error, %s", err)
+	}
+	if errout != "Warning: catch-all @pobox.org in a domain with 2 mailboxes\n" {
+		t.Errorf("Add virtual @pobox.org: did not get expected warning, got %s", errout)
+	}
+
+	args = []string{"-d", dbfile, "show", "virtual", "@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Show virtual @pobox.org: Unexpected error, %s", err)
+	}
+	if out != "Virtual Alias:\t@pobox.org\nTargets:\tjeff@pobox.org\n" {
+		t.Errorf("Show virtual @pobox.org: did not get expected output, got %s", out)
+	}
+
+	args = []string{"-d", dbfile, "export", "virtual"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export virtual: Unexpected error, %s", err)
+	}
+	exportList := "@pobox.org jeff@pobox.org\n" +
+		"@run.com dave@pobox.org\n"
+	if out != exportList {
+		t.Errorf("Export virtual: Expected (%s), got (%s)", exportList, out)
+	}
+
+	// Open the database directly so we can test views.
+	// These are the lookups postfix makes with virtual_alias.query
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+
+	// an exact match for a mailbox wins over the catch-all
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox || '@' || domain_name = 'dave@pobox.org'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "dave@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup dave@pobox.org: %s", err)
+	}
+	// an unknown user has no entry so postfix then tries the catch-all
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox || '@' || domain_name = 'bob@pobox.org'
+`
+	expectedRes = []maildb.QueryRes{}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup bob@pobox.org: %s", err)
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE mailbox || '@' || domain_name = '@pobox.org'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "jeff@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup @pobox.org: %s", err)
+	}
+	// run.com has no mailboxes so there is nothing but the catch-all
+	q = `
+SELECT recipient FROM virt_alias WHERE domain_name = 'run.com'
+`
+	expectedRes = []maildb.QueryRes{
+		{
+			"recipient": "dave@pobox.org",
+		},
+	}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup *@run.com: %s", err)
+	}
+	mdb.Close()
+
+	// delete the catch-all and the identity entries go too
+	args = []string{"-d", dbfile, "delete", "virtual", "@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Delete virtual @pobox.org: Unexpected error, %s", err)
+	}
+	if mdb, err = maildb.NewMailDB(dbfile); err != nil {
+		t.Errorf("Could not reopen database for view testing, %s", err)
+		return
+	}
+	q = `
+SELECT recipient FROM virt_alias WHERE domain_name = 'pobox.org'
+`
+	expectedRes = []maildb.QueryRes{}
+	if err = queryView(mdb, q, expectedRes); err != nil {
+		t.Errorf("Lookup *@pobox.org after delete: %s", err)
+	}
+	mdb.Close()
+
+	// import it back from a virtual(5) file, still with a warning
+	vFile := filepath.Join(dir, "virtual.txt")
+	if err = ioutil.WriteFile(vFile,
+		[]byte("@pobox.org jeff@pobox.org\n"), 0644); err != nil {
+		t.Errorf("Could not write %s, %s", vFile, err)
+	}
+	args = []string{"-d", dbfile, "import", "virtual", "-i", vFile}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Import of @pobox.org: Unexpected error, %s", err)
+	}
+	if errout != "Warning: catch-all @pobox.org in a domain with 2 mailboxes\n" {
+		t.Errorf("Import of @pobox.org: did not get expected warning, got %s", errout)
+	}
+	args = []string{"-d", dbfile, "export", "virtual", "@pobox.org"}
+	out, errout, err = doTest(rootCmd, "", args)
+	if err != nil {
+		t.Errorf("Export virtual @pobox.org: Unexpected error, %s", err)
+	}
+	if out != "@pobox.org jeff@pobox.org\n" {
+		t.Errorf("Export virtual @pobox.org: got (%s)", out)
+	}
+}
@@ -7,5 +7,6 @@ require (
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
 	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
 	github.com/spf13/cobra v1.4.0
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.0 // indirect
 )
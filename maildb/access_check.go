@@ -0,0 +1,366 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// access check classes, in the same order as the schema
+const (
+	clientCheck = 0
+	senderCheck = 1
+	heloCheck   = 2
+)
+
+var checkClasses = []string{"client", "sender", "helo"}
+
+// AccessCheck
+// A check_client_access, check_sender_access or check_helo_access
+// pattern and the Access restriction it gets
+type AccessCheck struct {
+	mdb     *MailDB
+	id      int64
+	class   int
+	pattern string
+	lookup  sql.NullString
+	access  *Access
+}
+
+// checkClass
+// map the class name to the schema value
+func checkClass(class string) (int, error) {
+	for i, c := range checkClasses {
+		if strings.ToLower(class) == c {
+			return i, nil
+		}
+	}
+	return 0, ErrMdbBadCheckClass
+}
+
+// decodeCheck
+// Validate the pattern for the class. Return the pattern as stored and
+// the key postfix looks up for it, if it has one.
+// client: an IP address, a CIDR network, or a host or domain name
+// sender: "user@domain", "user@" or a domain name
+// helo: a host or domain name or an IP address
+func decodeCheck(class int, pattern string) (string, sql.NullString, error) {
+	var (
+		p      string
+		lookup string
+		err    error
+	)
+
+	switch class {
+	case clientCheck:
+		if p, lookup, err = DecodeCIDR(pattern); err != nil {
+			if strings.ContainsAny(pattern, "/:") ||
+				strings.Trim(pattern, "0123456789. ") == "" { // meant to be an address
+				return "", sql.NullString{}, err
+			}
+			if p, err = DecodeHostname(pattern); err != nil {
+				return "", sql.NullString{}, err
+			}
+			lookup = p
+		}
+	case senderCheck:
+		if strings.Contains(pattern, "@") {
+			var ap *AddressParts
+
+			if ap, err = DecodeRFC822(pattern); err != nil {
+				return "", sql.NullString{}, err
+			}
+			if ap.domain == "" { // "user@"
+				p = ap.lpart + "@"
+			} else if ap.lpart == "" {
+				return "", sql.NullString{}, ErrMdbAddressEmpty
+			} else {
+				if _, err = DecodeHostname(ap.domain); err != nil {
+					return "", sql.NullString{}, err
+				}
+				p = ap.String()
+			}
+		} else if p, err = DecodeHostname(pattern); err != nil {
+			return "", sql.NullString{}, err
+		}
+		lookup = p
+	case heloCheck:
+		if ip := net.ParseIP(strings.TrimSpace(pattern)); ip != nil {
+			p = ip.String()
+		} else if p, err = DecodeHostname(pattern); err != nil {
+			return "", sql.NullString{}, err
+		}
+		lookup = p
+	default:
+		return "", sql.NullString{}, ErrMdbBadCheckClass
+	}
+	return p, sql.NullString{String: lookup, Valid: lookup != ""}, nil
+}
+
+// Class
+func (c *AccessCheck) Class() string {
+	return checkClasses[c.class]
+}
+
+// Pattern
+func (c *AccessCheck) Pattern() string {
+	return c.pattern
+}
+
+// Lookup
+// the key postfix uses in a sqlite table, "--" if only cidr can match it
+func (c *AccessCheck) Lookup() string {
+	if c.lookup.Valid {
+		return c.lookup.String
+	}
+	return "--"
+}
+
+// IsAddress
+// a client IP address or network rather than a name
+func (c *AccessCheck) IsAddress() bool {
+	if c.class != clientCheck {
+		return false
+	}
+	_, _, err := DecodeCIDR(c.pattern)
+	return err == nil
+}
+
+// Access
+func (c *AccessCheck) Access() string {
+	return c.access.Name()
+}
+
+// Action
+func (c *AccessCheck) Action() string {
+	return c.access.Action()
+}
+
+// Export
+func (c *AccessCheck) Export() string {
+	var (
+		line strings.Builder
+	)
+
+	fmt.Fprintf(&line, "%s %s", c.pattern, c.access.Name())
+	return line.String()
+}
+
+// qCheck
+// common part of the queries
+const qCheck = `
+SELECT c.id, c.pattern, c.lookup, a.id, a.name, a.action
+ FROM accesscheck AS c JOIN access AS a ON (c.access = a.id)
+ WHERE c.class = ?`
+
+// scanCheck
+func (mdb *MailDB) scanCheck(class int, row interface{ Scan(...interface{}) error }) (*AccessCheck, error) {
+	c := &AccessCheck{
+		mdb:    mdb,
+		class:  class,
+		access: &Access{mdb: mdb},
+	}
+	err := row.Scan(&c.id, &c.pattern, &c.lookup,
+		&c.access.id, &c.access.name, &c.access.action)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbCheckNotFound
+	case nil:
+		return c, nil
+	default:
+		return nil, err
+	}
+}
+
+// LookupAccessCheck
+// Return the check for this pattern. No transaction
+func (mdb *MailDB) LookupAccessCheck(class string, pattern string) (*AccessCheck, error) {
+	var (
+		cl  int
+		p   string
+		err error
+	)
+
+	if cl, err = checkClass(class); err != nil {
+		return nil, err
+	}
+	if p, _, err = decodeCheck(cl, pattern); err != nil {
+		return nil, err
+	}
+	return mdb.scanCheck(cl, mdb.db.QueryRow(qCheck+" AND c.pattern = ?", cl, p))
+}
+
+// GetAccessCheck
+// Same as LookupAccessCheck but in the transaction
+func (mdb *MailDB) GetAccessCheck(class string, pattern string) (*AccessCheck, error) {
+	var (
+		cl  int
+		p   string
+		err error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if cl, err = checkClass(class); err != nil {
+		return nil, err
+	}
+	if p, _, err = decodeCheck(cl, pattern); err != nil {
+		return nil, err
+	}
+	return mdb.scanCheck(cl, mdb.tx.QueryRow(qCheck+" AND c.pattern = ?", cl, p))
+}
+
+// FindAccessCheck
+// Return the checks in this class whose pattern matches where '*'
+// matches anything. No transaction
+func (mdb *MailDB) FindAccessCheck(class string, pattern string) ([]*AccessCheck, error) {
+	var (
+		cl    int
+		rows  *sql.Rows
+		clist []*AccessCheck
+		c     *AccessCheck
+		err   error
+	)
+
+	if cl, err = checkClass(class); err != nil {
+		return nil, err
+	}
+	q := qCheck + " AND c.pattern LIKE ? ORDER BY c.pattern"
+	if rows, err = mdb.db.Query(q, cl, strings.ReplaceAll(pattern, "*", "%")); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if c, err = mdb.scanCheck(cl, rows); err != nil {
+			return nil, err
+		}
+		clist = append(clist, c)
+	}
+	if err = rows.Err(); err == nil && len(clist) == 0 {
+		err = ErrMdbCheckNotFound
+	}
+	return clist, err
+}
+
+// InsertAccessCheck
+// Apply the named Access restriction to pattern. Transaction required
+func (mdb *MailDB) InsertAccessCheck(class string, pattern string, access string) (*AccessCheck, error) {
+	var (
+		cl     int
+		p      string
+		lookup sql.NullString
+		ac     *Access
+		res    sql.Result
+		err    error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if cl, err = checkClass(class); err != nil {
+		return nil, err
+	}
+	if p, lookup, err = decodeCheck(cl, pattern); err != nil {
+		return nil, err
+	}
+	if ac, err = mdb.GetAccess(access); err != nil {
+		return nil, err
+	}
+	res, err = mdb.tx.Exec(
+		"INSERT INTO accesscheck (class, pattern, lookup, access) VALUES (?, ?, ?, ?)",
+		cl, p, lookup, ac.id)
+	if err != nil {
+		if IsErrConstraintUnique(err) {
+			err = ErrMdbDupCheck
+		}
+		return nil, err
+	}
+	c := &AccessCheck{
+		mdb:     mdb,
+		class:   cl,
+		pattern: p,
+		lookup:  lookup,
+		access:  ac,
+	}
+	if c.id, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetAccess
+// Change the restriction for this check. Transaction required
+func (c *AccessCheck) SetAccess(name string) error {
+	var (
+		ac  *Access
+		err error
+	)
+
+	if ac, err = c.mdb.GetAccess(name); err != nil {
+		return err
+	}
+	res, err := c.mdb.tx.Exec("UPDATE accesscheck SET access = ? WHERE id = ?", ac.id, c.id)
+	if err != nil {
+		return err
+	}
+	if r, err := res.RowsAffected(); err != nil {
+		return err
+	} else if r != 1 {
+		return ErrMdbBadUpdate
+	}
+	c.access = ac
+	return nil
+}
+
+// DeleteAccessCheck
+// Remove the check for this pattern. No transaction
+func (mdb *MailDB) DeleteAccessCheck(class string, pattern string) error {
+	var (
+		cl  int
+		p   string
+		res sql.Result
+		c   int64
+		err error
+	)
+
+	if cl, err = checkClass(class); err != nil {
+		return err
+	}
+	if p, _, err = decodeCheck(cl, pattern); err != nil {
+		return err
+	}
+	qd := `DELETE FROM accesscheck WHERE class = ? AND pattern = ?`
+	if res, err = mdb.db.Exec(qd, cl, p); err != nil {
+		return err
+	}
+	if c, err = res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbCheckNotFound
+	}
+	return nil
+}
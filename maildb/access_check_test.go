@@ -0,0 +1,165 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestAccessCheck
+func TestAccessCheck(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		dir   string
+		c     *AccessCheck
+		clist []*AccessCheck
+	)
+
+	fmt.Printf("Access Check Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	_, err = mdb.InsertAccess("block", "REJECT")
+	if err == nil {
+		_, err = mdb.InsertAccess("allow", "OK")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of access rules failed, %s", err)
+		return
+	}
+
+	// bad ones
+	for _, b := range []struct {
+		class   string
+		pattern string
+		access  string
+		err     error
+	}{
+		{"relay", "10.0.0.0/8", "block", ErrMdbBadCheckClass},
+		{"client", "10.0.0.1/8", "block", ErrMdbBadCIDR},
+		{"client", "10.0.0.0/8", "nothing", ErrMdbAccessNotFound},
+		{"sender", "@example.com", "block", ErrMdbAddressEmpty},
+		{"sender", "bill@bad_domain", "block", ErrMdbBadHostname},
+		{"helo", "bad helo", "block", ErrMdbBadHostname},
+	} {
+		mdb.Begin()
+		_, err = mdb.InsertAccessCheck(b.class, b.pattern, b.access)
+		mdb.End(&err)
+		if err == nil {
+			t.Errorf("Insert %s %s should have failed", b.class, b.pattern)
+		} else if err != b.err {
+			t.Errorf("Insert %s %s: expected %s, got %s", b.class, b.pattern, b.err, err)
+		}
+	}
+
+	mdb.Begin()
+	for _, g := range [][]string{
+		{"client", "10.0.0.0/8", "block"},
+		{"client", "192.168.4.0/22", "block"},
+		{"client", "192.168.5.7", "allow"},
+		{"client", "Spam.Example.COM", "block"},
+		{"sender", "Bill@Example.com", "block"},
+		{"sender", "postmaster@", "allow"},
+		{"sender", "example.org", "allow"},
+		{"helo", "localhost", "block"},
+	} {
+		if _, err = mdb.InsertAccessCheck(g[0], g[1], g[2]); err != nil {
+			t.Errorf("Insert %s %s, %s", g[0], g[1], err)
+			break
+		}
+	}
+	mdb.End(&err)
+	mdb.Begin()
+	_, err = mdb.InsertAccessCheck("client", "10", "allow") // same as 10.0.0.0/8
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Duplicate 10 should have failed")
+	} else if err != ErrMdbDupCheck {
+		t.Errorf("Duplicate 10, %s", err)
+	}
+
+	if c, err = mdb.LookupAccessCheck("client", "10"); err != nil {
+		t.Errorf("Lookup client 10, %s", err)
+	} else if c.Pattern() != "10.0.0.0/8" || c.Lookup() != "10" || c.Action() != "REJECT" {
+		t.Errorf("Lookup client 10: got %s, %s, %s", c.Pattern(), c.Lookup(), c.Action())
+	}
+	if c, err = mdb.LookupAccessCheck("client", "192.168.4.0/22"); err != nil {
+		t.Errorf("Lookup client 192.168.4.0/22, %s", err)
+	} else if c.Lookup() != "--" || !c.IsAddress() {
+		t.Errorf("Lookup client 192.168.4.0/22: got lookup %s", c.Lookup())
+	}
+	if clist, err = mdb.FindAccessCheck("client", "*"); err != nil {
+		t.Errorf("Find client *, %s", err)
+	} else if len(clist) != 4 {
+		t.Errorf("Find client *: expected 4, got %d", len(clist))
+	} else if clist[3].Export() != "spam.example.com block" || clist[3].IsAddress() {
+		t.Errorf("Find client *: expected spam.example.com last, got %s", clist[3].Export())
+	}
+	if clist, err = mdb.FindAccessCheck("sender", "*"); err != nil {
+		t.Errorf("Find sender *, %s", err)
+	} else if len(clist) != 3 || clist[0].Pattern() != "bill@example.com" {
+		t.Errorf("Find sender *: unexpected result, %d, %s", len(clist), clist[0].Pattern())
+	}
+
+	// the rules are busy now
+	if err = mdb.DeleteAccess("block"); err == nil {
+		t.Errorf("Delete block should have failed")
+	} else if err != ErrMdbAccessBusy {
+		t.Errorf("Delete block, %s", err)
+	}
+	mdb.Begin()
+	if c, err = mdb.GetAccessCheck("helo", "LOCALHOST"); err == nil {
+		err = c.SetAccess("allow")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Set access of helo localhost, %s", err)
+	}
+	if c, err = mdb.LookupAccessCheck("helo", "localhost"); err != nil {
+		t.Errorf("Lookup helo localhost, %s", err)
+	} else if c.Access() != "allow" {
+		t.Errorf("Lookup helo localhost: expected allow, got %s", c.Access())
+	}
+	if err = mdb.DeleteAccessCheck("helo", "localhost"); err != nil {
+		t.Errorf("Delete helo localhost, %s", err)
+	}
+	if err = mdb.DeleteAccessCheck("helo", "localhost"); err == nil {
+		t.Errorf("Delete helo localhost again should have failed")
+	} else if err != ErrMdbCheckNotFound {
+		t.Errorf("Delete helo localhost again, %s", err)
+	}
+}
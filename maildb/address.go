@@ -35,6 +35,7 @@ type Address struct {
 	localpart string
 	transport *Transport
 	access    *Access
+	sTrans    sql.NullString // name of the transport for mail sent from here
 }
 
 // IsLocal
@@ -47,11 +48,22 @@ func (a *Address) IsLocal() bool {
 	}
 }
 
+// IsCatchall
+// a catch-all address has a domain but an empty localpart, i.e. "@example.com"
+func (a *Address) IsCatchall() bool {
+	return a.d != nil && a.localpart == ""
+}
+
 // InVmailDomain
 func (a *Address) InVMailDomain() bool {
 	return a.d.IsVmailbox()
 }
 
+// InLocalDomain
+func (a *Address) InLocalDomain() bool {
+	return a.d.IsLocal()
+}
+
 // Id
 func (a *Address) Id() int64 {
 	return a.id
@@ -69,6 +81,15 @@ func (a *Address) Address() string {
 	return line.String()
 }
 
+// Domain
+// name of the address's domain, empty for a local address
+func (a *Address) Domain() string {
+	if a.d != nil {
+		return a.d.Name()
+	}
+	return ""
+}
+
 // Transport
 func (a *Address) Transport() string {
 	if a.transport != nil {
@@ -78,6 +99,16 @@ func (a *Address) Transport() string {
 	}
 }
 
+// SenderTransport
+// transport for mail sent from this address
+func (a *Address) SenderTransport() string {
+	if a.sTrans.Valid {
+		return a.sTrans.String
+	} else {
+		return "--"
+	}
+}
+
 // Rclass
 func (a *Address) Rclass() string {
 	if a.access != nil {
@@ -101,6 +132,9 @@ func (a *Address) Export() string {
 	if a.transport != nil {
 		fmt.Fprintf(&line, ", transport=%s", a.transport.Name())
 	}
+	if a.sTrans.Valid {
+		fmt.Fprintf(&line, ", sender_transport=%s", a.sTrans.String)
+	}
 	return line.String()
 }
 
@@ -110,13 +144,16 @@ func (a *Address) Export() string {
 //
 // query for local (no domain) addresses
 var qaLocal string = `
-SELECT id, localpart, transport, access FROM address
+SELECT id, localpart, transport, access,
+       (SELECT name FROM transport WHERE id = sender_transport)
+ FROM address
  WHERE localpart = ? AND domain IS NULL
 `
 
 // query for full localpart@domain addresses
 var qaRFC822 string = `
 SELECT a.id, a.localpart, a.transport, a.access,
+       (SELECT name FROM transport WHERE id = a.sender_transport),
        d.id, d.name, d.class, d.transport, d.access, d.vuid, d.vgid
  FROM address AS a, domain AS d
  WHERE a.localpart = ? AND a.domain IS d.id AND d.name = ?
@@ -147,11 +184,11 @@ func (mdb *MailDB) LookupAddress(addr string) (*Address, error) {
 	if ap.domain == "" { // A "local" address
 		row = mdb.db.QueryRow(qaLocal, ap.lpart)
 		err = row.Scan(
-			&a.id, &a.localpart, &aTrans, &aAccess)
+			&a.id, &a.localpart, &aTrans, &aAccess, &a.sTrans)
 	} else { // A full RFC822 address
 		row = mdb.db.QueryRow(qaRFC822, ap.lpart, ap.domain)
 		err = row.Scan(
-			&a.id, &a.localpart, &aTrans, &aAccess,
+			&a.id, &a.localpart, &aTrans, &aAccess, &a.sTrans,
 			&d.id, &d.name, &d.class, &dTrans, &dAccess, &d.vuid, &d.vgid)
 	}
 	switch err {
@@ -220,11 +257,11 @@ func (mdb *MailDB) GetAddress(addr string) (*Address, error) {
 	if ap.domain == "" { // A "local" address
 		row = mdb.tx.QueryRow(qaLocal, ap.lpart)
 		err = row.Scan(
-			&a.id, &a.localpart, &aTrans, &aAccess)
+			&a.id, &a.localpart, &aTrans, &aAccess, &a.sTrans)
 	} else { // A full RFC822 address
 		row = mdb.tx.QueryRow(qaRFC822, ap.lpart, ap.domain)
 		err = row.Scan(
-			&a.id, &a.localpart, &aTrans, &aAccess,
+			&a.id, &a.localpart, &aTrans, &aAccess, &a.sTrans,
 			&d.id, &d.name, &d.class, &dTrans, &dAccess, &d.vuid, &d.vgid)
 	}
 	switch err {
@@ -398,7 +435,9 @@ func (mdb *MailDB) FindAddress(address string) ([]*Address, error) {
 	if ap, err = DecodeRFC822(address); err != nil {
 		return nil, err
 	}
-	q = "SELECT id, localpart, transport, access FROM address"
+	q = `SELECT id, localpart, transport, access,
+       (SELECT name FROM transport WHERE id = sender_transport)
+ FROM address`
 	if ap.domain == "" { // "*" is for locals only
 		qa := q + " WHERE domain IS NULL"
 		if ap.lpart == "*" {
@@ -416,7 +455,7 @@ func (mdb *MailDB) FindAddress(address string) ([]*Address, error) {
 			a := &Address{
 				mdb: mdb,
 			}
-			err = rows.Scan(&a.id, &a.localpart, &aTrans, &aAccess)
+			err = rows.Scan(&a.id, &a.localpart, &aTrans, &aAccess, &a.sTrans)
 			if err != nil {
 				break
 			}
@@ -460,7 +499,7 @@ func (mdb *MailDB) FindAddress(address string) ([]*Address, error) {
 				a := &Address{
 					mdb: mdb,
 				}
-				err = rows.Scan(&a.id, &a.localpart, &aTrans, &aAccess)
+				err = rows.Scan(&a.id, &a.localpart, &aTrans, &aAccess, &a.sTrans)
 				if err != nil {
 					break
 				}
@@ -575,6 +614,12 @@ func (a *Address) AttachAlias(target string) error {
 		err = ErrMdbAddressTarget
 		return err
 	}
+	if rp.IsCatchall() { // "@domain" is only a key, never a place to deliver to
+		return ErrMdbCatchallTarget
+	}
+	if a.IsCatchall() && !a.d.IsVirtual() && !a.d.IsVmailbox() {
+		return ErrMdbCatchallDomain
+	}
 	if rp.extension != "" {
 		ext = sql.NullString{Valid: true, String: rp.extension}
 	}
@@ -656,6 +701,49 @@ func (a *Address) SetRclass(name string) error {
 	return err
 }
 
+// SetSenderTransport
+func (a *Address) SetSenderTransport(name string) error {
+	var (
+		tr  *Transport
+		err error
+	)
+
+	if a.IsLocal() { // postfix only looks up user@domain or @domain
+		return ErrMdbSendAsNoDomain
+	}
+	if tr, err = a.mdb.GetTransport(name); err != nil {
+		return err
+	}
+	res, err := a.mdb.tx.Exec("UPDATE address SET sender_transport = ? WHERE id = ?", tr.id, a.id)
+	if err == nil {
+		c, err := res.RowsAffected()
+		if err == nil {
+			if c == 1 {
+				a.sTrans = sql.NullString{String: tr.Name(), Valid: true}
+			} else {
+				err = ErrMdbAddressNotFound
+			}
+		}
+	}
+	return err
+}
+
+// ClearSenderTransport
+func (a *Address) ClearSenderTransport() error {
+	res, err := a.mdb.tx.Exec("UPDATE address SET sender_transport = NULL WHERE id = ?", a.id)
+	if err == nil {
+		c, err := res.RowsAffected()
+		if err == nil {
+			if c == 1 {
+				a.sTrans = sql.NullString{}
+			} else {
+				err = ErrMdbAddressNotFound
+			}
+		}
+	}
+	return err
+}
+
 // ClearRclass
 func (a *Address) ClearRclass() error {
 	res, err := a.mdb.tx.Exec("UPDATE address SET access = NULL WHERE id = ?", a.id)
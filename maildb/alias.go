@@ -135,7 +135,8 @@ func (al *Alias) Export() string {
 // RemoveAlias and all its targets
 // All we need to do here is delete the aliases that aliasAddr points to
 // As the set of aliases disappear, their delete triggers clean up all the
-// orphan targets (and the alias address itself) on the way out
+// orphan targets (and the alias address itself) on the way out.
+// Works inside or outside a transaction
 func (mdb *MailDB) RemoveAlias(alias string) error {
 	var (
 		ap  *AddressParts
@@ -152,14 +153,22 @@ func (mdb *MailDB) RemoveAlias(alias string) error {
 DELETE FROM alias WHERE address =
 (SELECT a.id FROM address a  WHERE a.domain IS NULL AND a.localpart = ?)
 `
-		res, err = mdb.db.Exec(qd, ap.lpart)
+		if mdb.tx != nil {
+			res, err = mdb.tx.Exec(qd, ap.lpart)
+		} else {
+			res, err = mdb.db.Exec(qd, ap.lpart)
+		}
 	} else {
 		qd := `
 DELETE FROM alias WHERE address =
 (SELECT a.id FROM address a, domain d
   WHERE a.domain = d.id AND a.localpart = ? AND d.name = ?)
 `
-		res, err = mdb.db.Exec(qd, ap.lpart, ap.domain)
+		if mdb.tx != nil {
+			res, err = mdb.tx.Exec(qd, ap.lpart, ap.domain)
+		} else {
+			res, err = mdb.db.Exec(qd, ap.lpart, ap.domain)
+		}
 	}
 	if err == nil {
 		c, err = res.RowsAffected()
@@ -529,3 +529,104 @@ func TestAliasOps(t *testing.T) {
 		}
 	}
 }
+
+// TestCatchall
+func TestCatchall(t *testing.T) {
+	var (
+		err     error
+		mdb     *MailDB
+		d       *Domain
+		dir     string
+		a       *Address
+		al_list []*Alias
+		n       int64
+	)
+
+	fmt.Printf("Catch-all Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	// a vmailbox domain with a user and a plain internet domain
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		_, err = mdb.InsertDomain("tatooine")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker and tatooine failed, %s", err)
+		return
+	}
+
+	// a catch-all cannot be a mailbox
+	mdb.Begin()
+	_, err = mdb.InsertVMailbox("@skywalker")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Add of mailbox @skywalker should have failed")
+	} else if err != ErrMdbCatchallMbox {
+		t.Errorf("Add of mailbox @skywalker, %s", err)
+	}
+
+	// nor can it be in an internet domain
+	if err = makeAlias(mdb, "@tatooine", []string{"luke@skywalker"}); err == nil {
+		t.Errorf("makeAlias of @tatooine should have failed")
+	} else if err != ErrMdbCatchallDomain {
+		t.Errorf("makeAlias of @tatooine, %s", err)
+	}
+
+	// nor can it be a recipient
+	if err = makeAlias(mdb, "leia@skywalker", []string{"@skywalker"}); err == nil {
+		t.Errorf("makeAlias of leia@skywalker to @skywalker should have failed")
+	} else if err != ErrMdbCatchallTarget {
+		t.Errorf("makeAlias of leia@skywalker to @skywalker, %s", err)
+	}
+
+	// now a real one
+	if err = makeAlias(mdb, "@skywalker", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("makeAlias of @skywalker, %s", err)
+	}
+	if a, err = mdb.LookupAddress("@skywalker"); err != nil {
+		t.Errorf("Lookup of @skywalker, %s", err)
+	} else {
+		if !a.IsCatchall() {
+			t.Errorf("@skywalker should be a catch-all")
+		}
+		if a.Address() != "@skywalker" {
+			t.Errorf("@skywalker: address should be @skywalker, got %s", a.Address())
+		}
+	}
+	if a, err = mdb.LookupAddress("luke@skywalker"); err != nil {
+		t.Errorf("Lookup of luke@skywalker, %s", err)
+	} else if a.IsCatchall() {
+		t.Errorf("luke@skywalker should not be a catch-all")
+	}
+	if al_list, err = mdb.LookupAlias("@skywalker"); err != nil {
+		t.Errorf("LookupAlias of @skywalker, %s", err)
+	} else if len(al_list) != 1 {
+		t.Errorf("LookupAlias of @skywalker: expected 1 alias, got %d", len(al_list))
+	} else if al_list[0].Export() != "@skywalker luke@skywalker" {
+		t.Errorf("LookupAlias of @skywalker: expected \"@skywalker luke@skywalker\", got %s",
+			al_list[0].Export())
+	}
+	if d, err = mdb.LookupDomain("skywalker"); err != nil {
+		t.Errorf("Lookup of skywalker, %s", err)
+	} else if n, err = d.Mailboxes(); err != nil {
+		t.Errorf("Mailboxes of skywalker, %s", err)
+	} else if n != 1 {
+		t.Errorf("Mailboxes of skywalker: expected 1, got %d", n)
+	}
+}
@@ -0,0 +1,143 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// AuthUser
+// A mailbox's login as dovecot's passdb and userdb queries see it in
+// user_mailbox and user_deny. The MailHome has where its files are.
+type AuthUser struct {
+	MailHome
+	scheme    string
+	password  string
+	quotaRule string
+	deny      bool
+}
+
+// LookupAuthUser
+// The login for user, no transaction.
+func (mdb *MailDB) LookupAuthUser(user string) (*AuthUser, error) {
+	var (
+		ap       *AddressParts
+		password string
+		deny     sql.NullString
+		err      error
+	)
+
+	if ap, err = DecodeRFC822(user); err != nil {
+		return nil, err
+	}
+	au := &AuthUser{
+		MailHome: MailHome{
+			user:   ap.lpart + "@" + ap.domain,
+			lpart:  ap.lpart,
+			domain: ap.domain,
+		},
+	}
+	q := `SELECT password, home, uid, gid, quota_rule FROM user_mailbox WHERE username = ? AND domain = ?`
+	switch err = mdb.db.QueryRow(q, ap.lpart, ap.domain).Scan(&password, &au.home,
+		&au.uid, &au.gid, &au.quotaRule); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbNotMbox
+	case nil:
+	default:
+		return nil, err
+	}
+	if strings.HasPrefix(password, "{") {
+		if i := strings.IndexByte(password, '}'); i > 0 {
+			au.scheme = strings.ToUpper(password[1:i])
+			password = password[i+1:]
+		}
+	}
+	au.password = password
+	q = `SELECT deny FROM user_deny WHERE username = ? AND domain = ?`
+	switch err = mdb.db.QueryRow(q, ap.lpart, ap.domain).Scan(&deny); err {
+	case sql.ErrNoRows:
+	case nil:
+		au.deny = deny.Valid && deny.String == "true"
+	default:
+		return nil, err
+	}
+	return au, nil
+}
+
+// User
+func (au *AuthUser) User() string {
+	return au.user
+}
+
+// QuotaRule
+// the dovecot quota_rule of the mailbox
+func (au *AuthUser) QuotaRule() string {
+	return au.quotaRule
+}
+
+// IsDenied
+// user_deny has the login locked out
+func (au *AuthUser) IsDenied() bool {
+	return au.deny
+}
+
+// Verify
+// Check password against the stored one with its scheme. A mailbox with
+// no password ("*") never matches. ErrMdbPwScheme if the scheme is not
+// one we can check.
+func (au *AuthUser) Verify(password string) (bool, error) {
+	var stored, given []byte
+
+	if au.password == "*" || au.password == "" {
+		return false, nil
+	}
+	switch au.scheme {
+	case "PLAIN":
+		stored, given = []byte(au.password), []byte(password)
+	case "CRYPT", "MD5-CRYPT", "SHA256-CRYPT", "SHA512-CRYPT":
+		h, err := Crypt(password, au.password)
+		if err != nil {
+			return false, err
+		}
+		stored, given = []byte(au.password), []byte(h)
+	case "SHA256":
+		sum := sha256.Sum256([]byte(password))
+		given = sum[:]
+		// dovecot stores it in base64 unless told to use hex
+		var err error
+		if len(au.password) == 2*sha256.Size {
+			stored, err = hex.DecodeString(au.password)
+		} else {
+			stored, err = base64.StdEncoding.DecodeString(au.password)
+		}
+		if err != nil {
+			return false, nil
+		}
+	default:
+		return false, ErrMdbPwScheme
+	}
+	return subtle.ConstantTimeCompare(stored, given) == 1, nil
+}
@@ -0,0 +1,221 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestCrypt
+func TestCrypt(t *testing.T) {
+	fmt.Printf("Crypt Test\n")
+
+	// from glibc's crypt(3)
+	hashes := []struct {
+		key  string
+		hash string
+	}{
+		{"Hello world!", "$1$saltsalt$le8lFSqqnPaRFOlmAZpvH1"},
+		{"Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "$5$rounds=1000$short$Zn1jA7vGSJxSOcxz82BehM5YBK0V0FxI3Muhq4iKhwC"},
+		{"Hello world!", "$6$rounds=1400$anotherlongsalts$5FGyu8c4BZDX4wJgs0Un26YOw2XibT5eTkHF1I1aP3QqStoJI9BHD2YPJYsAjEePVGUyBjdZxcNqMWlrrbIOC."},
+		{"a very much longer password that is longer than sixty four bytes of text ok",
+			"$5$x$jtDhBUipnEoFvSydv45jngnCRKUaFzzMKQXEGsfsYC7"},
+		{"a very much longer password that is longer than sixty four bytes of text ok",
+			"$1$x$wlInWueibvhHTBceVHAPz."},
+	}
+	for _, h := range hashes {
+		if c, err := Crypt(h.key, h.hash); err != nil {
+			t.Errorf("Crypt of %s: %s", h.hash, err)
+		} else if c != h.hash {
+			t.Errorf("Crypt of %s: got %s", h.hash, c)
+		}
+	}
+	// the salt alone, as for a new password
+	if c, _ := Crypt("Hello world!", "$6$saltstring"); c != hashes[2].hash {
+		t.Errorf("Crypt with salt only: got %s", c)
+	}
+	if _, err := Crypt("secret", "ab01FAX.bQRSU"); err != ErrMdbPwScheme {
+		t.Errorf("Crypt of DES hash: expected ErrMdbPwScheme, got %v", err)
+	}
+	for _, h := range hashes {
+		if !IsCryptHash(h.hash) {
+			t.Errorf("IsCryptHash of %s: expected true", h.hash)
+		}
+	}
+	// dovecot can check these with the system crypt
+	for _, h := range []string{"ab01FAX.bQRSU", "$y$j9T$salt$hash", "$2b$10$abc"} {
+		if !IsCryptHash(h) {
+			t.Errorf("IsCryptHash of %q: expected true", h)
+		}
+	}
+	for _, h := range []string{"*", "", "!", "x", "$y$", "secret", "{SHA256}abc", "ab01FAX.bQRS!"} {
+		if IsCryptHash(h) {
+			t.Errorf("IsCryptHash of %q: expected false", h)
+		}
+	}
+}
+
+// TestAuthUser
+func TestAuthUser(t *testing.T) {
+	var (
+		err error
+		mdb *MailDB
+		d   *Domain
+		mb  *VMailbox
+		dir string
+		au  *AuthUser
+		ok  bool
+	)
+
+	fmt.Printf("AuthUser Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mailboxes := []struct {
+		user   string
+		pwType string
+		pw     string
+	}{
+		{"luke@skywalker", "plain", "xwing"},
+		{"leia@skywalker", "sha256", "K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols="},
+		{"han@skywalker", "crypt",
+			"$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG."},
+		{"vader@skywalker", "plain", ""},
+	}
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		err = d.SetVUid(5000)
+	}
+	for _, m := range mailboxes {
+		if err == nil {
+			mb, err = mdb.InsertVMailbox(m.user)
+		}
+		if err == nil {
+			err = mb.SetPwType(m.pwType)
+		}
+		if err == nil {
+			err = mb.SetPassword(m.pw)
+		}
+	}
+	if err == nil {
+		err = mb.SetHome("/home/vader")
+	}
+	if err == nil {
+		err = mb.Disable()
+	}
+	if err == nil {
+		_, err = mdb.InsertAddress("chewie@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+
+	logins := []struct {
+		user string
+		pw   string
+		ok   bool
+	}{
+		{"luke@skywalker", "xwing", true},
+		{"luke@skywalker", "xWing", false},
+		{"Luke@Skywalker", "xwing", true},
+		{"leia@skywalker", "secret", true},
+		{"leia@skywalker", "K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols=", false},
+		{"han@skywalker", "secret", true},
+		{"han@skywalker", "", false},
+		{"vader@skywalker", "", false},
+		{"vader@skywalker", "*", false},
+	}
+	for _, l := range logins {
+		if au, err = mdb.LookupAuthUser(l.user); err != nil {
+			t.Errorf("LookupAuthUser %s: %s", l.user, err)
+			continue
+		}
+		if ok, err = au.Verify(l.pw); err != nil {
+			t.Errorf("Verify %s/%s: %s", l.user, l.pw, err)
+		} else if ok != l.ok {
+			t.Errorf("Verify %s/%s: expected %v, got %v", l.user, l.pw, l.ok, ok)
+		}
+	}
+
+	if au, err = mdb.LookupAuthUser("luke@skywalker"); err != nil {
+		t.Errorf("LookupAuthUser luke@skywalker: %s", err)
+		return
+	}
+	if au.User() != "luke@skywalker" || au.IsDenied() || au.QuotaRule() != "*:bytes=300M" {
+		t.Errorf("luke@skywalker: unexpected %s, %v, %s", au.User(), au.IsDenied(), au.QuotaRule())
+	}
+	if uid, gid := au.Owner(); uid != 5000 || gid != -1 {
+		t.Errorf("luke@skywalker: expected owner 5000/-1, got %d/%d", uid, gid)
+	}
+	if p := au.Path("/var/mail/%d/%n"); p != "/var/mail/skywalker/luke" {
+		t.Errorf("luke@skywalker: unexpected home %s", p)
+	}
+	if au, err = mdb.LookupAuthUser("vader@skywalker"); err != nil {
+		t.Errorf("LookupAuthUser vader@skywalker: %s", err)
+	} else if !au.IsDenied() || au.Path("/var/mail/%d/%n") != "/home/vader" {
+		t.Errorf("vader@skywalker: expected denied with home /home/vader, got %v, %s",
+			au.IsDenied(), au.Path("/var/mail/%d/%n"))
+	}
+	if _, err = mdb.LookupAuthUser("chewie@skywalker"); err != ErrMdbNotMbox {
+		t.Errorf("LookupAuthUser chewie@skywalker: expected ErrMdbNotMbox, got %v", err)
+	}
+	if _, err = mdb.LookupAuthUser("yoda@dagobah"); err != ErrMdbNotMbox {
+		t.Errorf("LookupAuthUser yoda@dagobah: expected ErrMdbNotMbox, got %v", err)
+	}
+
+	// a scheme we cannot check
+	mdb.Begin()
+	if mb, err = mdb.GetVMailbox("luke@skywalker"); err == nil {
+		_, err = mdb.tx.Exec("UPDATE vmailbox SET pw_type = 'ARGON2I' WHERE id = ?", mb.a.Id())
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Set ARGON2I: %s", err)
+		return
+	}
+	if au, err = mdb.LookupAuthUser("luke@skywalker"); err == nil {
+		_, err = au.Verify("xwing")
+	}
+	if err != ErrMdbPwScheme {
+		t.Errorf("Verify ARGON2I: expected ErrMdbPwScheme, got %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// Bcc
+// A sender or recipient bcc map entry. The address, "user", "user@domain"
+// or "@domain", gets a blind copy sent to target
+type Bcc struct {
+	mdb     *MailDB
+	id      int64
+	class   int
+	address string
+	target  string
+}
+
+// bccTarget
+// postfix only allows one address and it must be a whole one
+func bccTarget(target string) (string, error) {
+	ap, err := DecodeRFC822(target)
+	if err != nil {
+		return "", err
+	}
+	if ap.lpart == "" || ap.domain == "" {
+		return "", ErrMdbBccTarget
+	}
+	return strings.TrimSpace(target), nil
+}
+
+// Class
+func (b *Bcc) Class() string {
+	if b.class == recipientMap {
+		return "recipient"
+	}
+	return "sender"
+}
+
+// Address
+func (b *Bcc) Address() string {
+	return b.address
+}
+
+// Target
+func (b *Bcc) Target() string {
+	return b.target
+}
+
+// Export
+func (b *Bcc) Export() string {
+	var (
+		line strings.Builder
+	)
+
+	fmt.Fprintf(&line, "%s %s", b.address, b.target)
+	return line.String()
+}
+
+// LookupBcc
+// Return the entry for this key in this class. No transaction
+func (mdb *MailDB) LookupBcc(class string, key string) (*Bcc, error) {
+	var (
+		cl  int
+		k   string
+		err error
+	)
+
+	if cl, err = mapClass(class); err != nil {
+		return nil, err
+	}
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	b := &Bcc{
+		mdb:   mdb,
+		class: cl,
+	}
+	q := `SELECT id, address, target FROM bcc_map WHERE class = ? AND address = ?`
+	switch err = mdb.db.QueryRow(q, cl, k).Scan(&b.id, &b.address, &b.target); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbBccNotFound
+	case nil:
+		return b, nil
+	default:
+		return nil, err
+	}
+}
+
+// GetBcc
+// Same as LookupBcc but in the transaction
+func (mdb *MailDB) GetBcc(class string, key string) (*Bcc, error) {
+	var (
+		cl  int
+		k   string
+		err error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if cl, err = mapClass(class); err != nil {
+		return nil, err
+	}
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	b := &Bcc{
+		mdb:   mdb,
+		class: cl,
+	}
+	q := `SELECT id, address, target FROM bcc_map WHERE class = ? AND address = ?`
+	switch err = mdb.tx.QueryRow(q, cl, k).Scan(&b.id, &b.address, &b.target); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbBccNotFound
+	case nil:
+		return b, nil
+	default:
+		return nil, err
+	}
+}
+
+// FindBcc
+// Return the entries in this class whose key matches the pattern
+// where '*' matches anything. No transaction
+func (mdb *MailDB) FindBcc(class string, pattern string) ([]*Bcc, error) {
+	var (
+		cl  int
+		err error
+	)
+
+	if cl, err = mapClass(class); err != nil {
+		return nil, err
+	}
+	q := `
+SELECT id, address, target, class FROM bcc_map
+ WHERE class = ? AND address LIKE ? ORDER BY address
+`
+	return mdb.findBcc(q, cl, strings.ReplaceAll(pattern, "*", "%"))
+}
+
+// FindArchived
+// Return the mailboxes and virtual aliases matching the pattern that
+// are copied somewhere, either by their own entry or their domain's.
+// The address is the archived one, not the key. No transaction
+func (mdb *MailDB) FindArchived(pattern string) ([]*Bcc, error) {
+	q := `
+SELECT 0, address, target, class FROM archived
+ WHERE address LIKE ? ORDER BY address, class
+`
+	return mdb.findBcc(q, strings.ReplaceAll(pattern, "*", "%"))
+}
+
+// findBcc
+// common part of the Find queries
+func (mdb *MailDB) findBcc(q string, args ...interface{}) ([]*Bcc, error) {
+	var (
+		rows  *sql.Rows
+		blist []*Bcc
+		err   error
+	)
+
+	if rows, err = mdb.db.Query(q, args...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		b := &Bcc{
+			mdb: mdb,
+		}
+		if err = rows.Scan(&b.id, &b.address, &b.target, &b.class); err != nil {
+			return nil, err
+		}
+		blist = append(blist, b)
+	}
+	if err = rows.Err(); err == nil && len(blist) == 0 {
+		err = ErrMdbBccNotFound
+	}
+	return blist, err
+}
+
+// InsertBcc
+// Copy mail for key in this class to target. The key address is created
+// if needed. Transaction required
+func (mdb *MailDB) InsertBcc(class string, key string, target string) (*Bcc, error) {
+	var (
+		cl    int
+		k     string
+		t     string
+		a     *Address
+		res   sql.Result
+		count int64
+		err   error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if cl, err = mapClass(class); err != nil {
+		return nil, err
+	}
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	if t, err = bccTarget(target); err != nil {
+		return nil, err
+	}
+	if a, err = mdb.GetOrInsAddress(k); err != nil {
+		return nil, err
+	}
+	qc := `SELECT count(*) FROM bcc WHERE address = ? AND class = ?`
+	if err = mdb.tx.QueryRow(qc, a.Id(), cl).Scan(&count); err != nil {
+		return nil, err
+	} else if count > 0 {
+		return nil, ErrMdbDupBcc
+	}
+	res, err = mdb.tx.Exec("INSERT INTO bcc (address, class, target) VALUES (?, ?, ?)",
+		a.Id(), cl, t)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bcc{
+		mdb:     mdb,
+		class:   cl,
+		address: k,
+		target:  t,
+	}
+	if b.id, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetTarget
+// Change where the copies go
+func (b *Bcc) SetTarget(target string) error {
+	var (
+		t   string
+		err error
+	)
+
+	if t, err = bccTarget(target); err != nil {
+		return err
+	}
+	res, err := b.mdb.tx.Exec("UPDATE bcc SET target = ? WHERE id = ?", t, b.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbBadUpdate
+	}
+	b.target = t
+	return nil
+}
+
+// DeleteBcc
+// Remove the entry for this key. The key address goes too if nothing
+// else uses it. No transaction
+func (mdb *MailDB) DeleteBcc(class string, key string) error {
+	var (
+		cl  int
+		k   string
+		res sql.Result
+		c   int64
+		err error
+	)
+
+	if cl, err = mapClass(class); err != nil {
+		return err
+	}
+	if k, err = mapKey(key); err != nil {
+		return err
+	}
+	qd := `
+DELETE FROM bcc WHERE id = (SELECT id FROM bcc_map WHERE class = ? AND address = ?)
+`
+	if res, err = mdb.db.Exec(qd, cl, k); err != nil {
+		return err
+	}
+	if c, err = res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbBccNotFound
+	}
+	return nil
+}
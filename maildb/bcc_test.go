@@ -0,0 +1,167 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestBcc
+func TestBcc(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		d     *Domain
+		dir   string
+		b     *Bcc
+		blist []*Bcc
+	)
+
+	fmt.Printf("Bcc Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("leia@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+	if err = makeAlias(mdb, "jedi@skywalker", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("makeAlias of jedi@skywalker, %s", err)
+	}
+
+	mdb.Begin()
+	_, err = mdb.InsertBcc("recipient", "@skywalker", "@archive.org")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Insert with domain target should have failed")
+	} else if err != ErrMdbBccTarget {
+		t.Errorf("Insert with domain target, %s", err)
+	}
+
+	// the whole domain one way, one user the other
+	mdb.Begin()
+	_, err = mdb.InsertBcc("recipient", "@skywalker", "in@archive.org")
+	if err == nil {
+		_, err = mdb.InsertBcc("recipient", "leia@skywalker", "leia@archive.org")
+	}
+	if err == nil {
+		_, err = mdb.InsertBcc("sender", "luke@skywalker", "out@archive.org")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert bccs, %s", err)
+		return
+	}
+	mdb.Begin()
+	_, err = mdb.InsertBcc("recipient", "@skywalker", "all@archive.org")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Duplicate @skywalker should have failed")
+	} else if err != ErrMdbDupBcc {
+		t.Errorf("Duplicate @skywalker, %s", err)
+	}
+	if b, err = mdb.LookupBcc("sender", "luke@skywalker"); err != nil {
+		t.Errorf("Lookup sender luke@skywalker, %s", err)
+	} else if b.Export() != "luke@skywalker out@archive.org" {
+		t.Errorf("Lookup sender luke@skywalker: got %s", b.Export())
+	}
+
+	// the address entry wins over the domain one
+	if blist, err = mdb.FindArchived("*"); err != nil {
+		t.Errorf("Find archived, %s", err)
+	} else {
+		var res []string
+		for _, b := range blist {
+			res = append(res, fmt.Sprintf("%s %s %s", b.Address(), b.Class(), b.Target()))
+		}
+		expected := []string{
+			"jedi@skywalker recipient in@archive.org",
+			"leia@skywalker recipient leia@archive.org",
+			"luke@skywalker sender out@archive.org",
+			"luke@skywalker recipient in@archive.org",
+		}
+		if len(res) != len(expected) {
+			t.Errorf("Find archived: expected %v, got %v", expected, res)
+		} else {
+			for i := range res {
+				if res[i] != expected[i] {
+					t.Errorf("Find archived: expected %s, got %s", expected[i], res[i])
+				}
+			}
+		}
+	}
+
+	// the mailbox goes but the entry keeps its key
+	mdb.Begin()
+	if b, err = mdb.GetBcc("recipient", "leia@skywalker"); err == nil {
+		err = b.SetTarget("organa@archive.org")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Set target of leia@skywalker, %s", err)
+	}
+	if err = mdb.DeleteVMailbox("leia@skywalker"); err != nil {
+		t.Errorf("Delete mailbox leia@skywalker, %s", err)
+	}
+	if b, err = mdb.LookupBcc("recipient", "leia@skywalker"); err != nil {
+		t.Errorf("Lookup recipient leia@skywalker, %s", err)
+	} else if b.Target() != "organa@archive.org" {
+		t.Errorf("Lookup recipient leia@skywalker: expected organa@archive.org, got %s", b.Target())
+	}
+	if _, err = mdb.FindArchived("leia@*"); err == nil {
+		t.Errorf("leia@skywalker is no longer a mailbox to archive")
+	} else if err != ErrMdbBccNotFound {
+		t.Errorf("Find archived leia@*, %s", err)
+	}
+	if err = mdb.DeleteBcc("recipient", "leia@skywalker"); err != nil {
+		t.Errorf("Delete recipient leia@skywalker, %s", err)
+	}
+	if _, err = mdb.LookupAddress("leia@skywalker"); err == nil {
+		t.Errorf("leia@skywalker should be gone")
+	} else if err != ErrMdbAddressNotFound {
+		t.Errorf("Lookup of leia@skywalker, %s", err)
+	}
+}
@@ -0,0 +1,327 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// sender/recipient classes of the canonical and bcc maps, as in the schema
+const (
+	senderMap    = 0
+	recipientMap = 1
+)
+
+// Canonical
+// A canonical(5) rewrite of an address, "user", "user@domain" or
+// "@domain", to a target for either the sender or the recipient
+type Canonical struct {
+	mdb     *MailDB
+	id      int64
+	class   int
+	address string
+	target  string
+}
+
+// mapClass
+// map the class name to the schema value
+func mapClass(class string) (int, error) {
+	switch strings.ToLower(class) {
+	case "sender":
+		return senderMap, nil
+	case "recipient":
+		return recipientMap, nil
+	default:
+		return 0, ErrMdbBadMapClass
+	}
+}
+
+// mapKey
+// decode a canonical(5) style key, "user", "user@domain" or "@domain",
+// into the form the map views present it
+func mapKey(key string) (string, error) {
+	ap, err := DecodeRFC822(key)
+	if err != nil {
+		return "", err
+	}
+	if ap.domain == "" {
+		return ap.lpart, nil
+	}
+	return ap.lpart + "@" + ap.domain, nil
+}
+
+// canonTarget
+// A target is either an address or "@domain" to replace just the domain
+func canonTarget(target string) (string, error) {
+	ap, err := DecodeRFC822(target)
+	if err != nil {
+		return "", err
+	}
+	if ap.domain == "" {
+		return "", ErrMdbAddressTarget
+	}
+	return strings.TrimSpace(target), nil
+}
+
+// Class
+func (c *Canonical) Class() string {
+	if c.class == recipientMap {
+		return "recipient"
+	}
+	return "sender"
+}
+
+// Address
+func (c *Canonical) Address() string {
+	return c.address
+}
+
+// Target
+func (c *Canonical) Target() string {
+	return c.target
+}
+
+// Export
+func (c *Canonical) Export() string {
+	var (
+		line strings.Builder
+	)
+
+	fmt.Fprintf(&line, "%s %s", c.address, c.target)
+	return line.String()
+}
+
+// LookupCanonical
+// Return the mapping for this key in this class. No transaction
+func (mdb *MailDB) LookupCanonical(class string, key string) (*Canonical, error) {
+	var (
+		cl  int
+		k   string
+		err error
+	)
+
+	if cl, err = mapClass(class); err != nil {
+		return nil, err
+	}
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	c := &Canonical{
+		mdb:   mdb,
+		class: cl,
+	}
+	q := `
+SELECT id, address, target FROM canonical_map WHERE class = ? AND address = ?
+`
+	row := mdb.db.QueryRow(q, cl, k)
+	switch err = row.Scan(&c.id, &c.address, &c.target); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbCanonicalNotFound
+	case nil:
+		return c, nil
+	default:
+		return nil, err
+	}
+}
+
+// FindCanonical
+// Return the mappings in this class whose key matches the pattern
+// where '*' matches anything. No transaction
+func (mdb *MailDB) FindCanonical(class string, pattern string) ([]*Canonical, error) {
+	var (
+		cl    int
+		rows  *sql.Rows
+		clist []*Canonical
+		err   error
+	)
+
+	if cl, err = mapClass(class); err != nil {
+		return nil, err
+	}
+	q := `
+SELECT id, address, target FROM canonical_map
+ WHERE class = ? AND address LIKE ? ORDER BY address
+`
+	p := strings.ReplaceAll(pattern, "*", "%")
+	if rows, err = mdb.db.Query(q, cl, p); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := &Canonical{
+			mdb:   mdb,
+			class: cl,
+		}
+		if err = rows.Scan(&c.id, &c.address, &c.target); err != nil {
+			return nil, err
+		}
+		clist = append(clist, c)
+	}
+	if err = rows.Err(); err == nil && len(clist) == 0 {
+		err = ErrMdbCanonicalNotFound
+	}
+	return clist, err
+}
+
+// InsertCanonical
+// Map key to target in this class. The key address is created if needed.
+// Transaction required
+func (mdb *MailDB) InsertCanonical(class string, key string, target string) (*Canonical, error) {
+	var (
+		cl    int
+		k     string
+		t     string
+		a     *Address
+		res   sql.Result
+		count int64
+		err   error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if cl, err = mapClass(class); err != nil {
+		return nil, err
+	}
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	if t, err = canonTarget(target); err != nil {
+		return nil, err
+	}
+	if a, err = mdb.GetOrInsAddress(k); err != nil {
+		return nil, err
+	}
+	qc := `SELECT count(*) FROM canonical WHERE address = ? AND class = ?`
+	if err = mdb.tx.QueryRow(qc, a.Id(), cl).Scan(&count); err != nil {
+		return nil, err
+	} else if count > 0 {
+		return nil, ErrMdbDupCanonical
+	}
+	res, err = mdb.tx.Exec("INSERT INTO canonical (address, class, target) VALUES (?, ?, ?)",
+		a.Id(), cl, t)
+	if err != nil {
+		return nil, err
+	}
+	c := &Canonical{
+		mdb:     mdb,
+		class:   cl,
+		address: k,
+		target:  t,
+	}
+	if c.id, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetTarget
+// Change where this mapping rewrites to
+func (c *Canonical) SetTarget(target string) error {
+	var (
+		t   string
+		err error
+	)
+
+	if t, err = canonTarget(target); err != nil {
+		return err
+	}
+	res, err := c.mdb.tx.Exec("UPDATE canonical SET target = ? WHERE id = ?", t, c.id)
+	if err != nil {
+		return err
+	}
+	if r, err := res.RowsAffected(); err != nil {
+		return err
+	} else if r != 1 {
+		return ErrMdbBadUpdate
+	}
+	c.target = t
+	return nil
+}
+
+// GetCanonical
+// Same as LookupCanonical but in the transaction
+func (mdb *MailDB) GetCanonical(class string, key string) (*Canonical, error) {
+	var (
+		cl  int
+		k   string
+		err error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if cl, err = mapClass(class); err != nil {
+		return nil, err
+	}
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	c := &Canonical{
+		mdb:   mdb,
+		class: cl,
+	}
+	q := `SELECT id, address, target FROM canonical_map WHERE class = ? AND address = ?`
+	switch err = mdb.tx.QueryRow(q, cl, k).Scan(&c.id, &c.address, &c.target); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbCanonicalNotFound
+	case nil:
+		return c, nil
+	default:
+		return nil, err
+	}
+}
+
+// DeleteCanonical
+// Remove the mapping for this key. The key address goes too if nothing
+// else uses it. No transaction
+func (mdb *MailDB) DeleteCanonical(class string, key string) error {
+	var (
+		cl  int
+		k   string
+		res sql.Result
+		c   int64
+		err error
+	)
+
+	if cl, err = mapClass(class); err != nil {
+		return err
+	}
+	if k, err = mapKey(key); err != nil {
+		return err
+	}
+	qd := `
+DELETE FROM canonical WHERE id = (SELECT id FROM canonical_map WHERE class = ? AND address = ?)
+`
+	if res, err = mdb.db.Exec(qd, cl, k); err != nil {
+		return err
+	}
+	if c, err = res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbCanonicalNotFound
+	}
+	return nil
+}
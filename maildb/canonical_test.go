@@ -0,0 +1,175 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestCanonical
+func TestCanonical(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		dir   string
+		c     *Canonical
+		clist []*Canonical
+	)
+
+	fmt.Printf("Canonical Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	_, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		_, err = mdb.InsertDomain("rebels.org")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of domains failed, %s", err)
+		return
+	}
+
+	// bad ones first
+	mdb.Begin()
+	_, err = mdb.InsertCanonical("both", "luke@skywalker", "luke@rebels.org")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Insert of class both should have failed")
+	} else if err != ErrMdbBadMapClass {
+		t.Errorf("Insert of class both, %s", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertCanonical("sender", "luke@skywalker", "luke")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Insert of local target should have failed")
+	} else if err != ErrMdbAddressTarget {
+		t.Errorf("Insert of local target, %s", err)
+	}
+	if _, err = mdb.InsertCanonical("sender", "luke@skywalker", "luke@rebels.org"); err == nil {
+		t.Errorf("Insert without transaction should have failed")
+	} else if err != ErrMdbTransaction {
+		t.Errorf("Insert without transaction, %s", err)
+	}
+
+	// the three kinds of keys
+	mdb.Begin()
+	c, err = mdb.InsertCanonical("sender", "luke@skywalker", "luke.skywalker@rebels.org")
+	if err == nil {
+		_, err = mdb.InsertCanonical("sender", "@skywalker", "@rebels.org")
+	}
+	if err == nil {
+		_, err = mdb.InsertCanonical("sender", "root", "admin@rebels.org")
+	}
+	if err == nil {
+		_, err = mdb.InsertCanonical("recipient", "luke@skywalker", "luke@rebels.org")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert canonicals, %s", err)
+		return
+	}
+	if c.Export() != "luke@skywalker luke.skywalker@rebels.org" {
+		t.Errorf("Insert luke@skywalker: expected export \"luke@skywalker luke.skywalker@rebels.org\", got %s",
+			c.Export())
+	}
+	mdb.Begin()
+	_, err = mdb.InsertCanonical("sender", "luke@skywalker", "jedi@rebels.org")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Duplicate luke@skywalker should have failed")
+	} else if err != ErrMdbDupCanonical {
+		t.Errorf("Duplicate luke@skywalker, %s", err)
+	}
+
+	// the classes are separate
+	if c, err = mdb.LookupCanonical("recipient", "luke@skywalker"); err != nil {
+		t.Errorf("Lookup recipient luke@skywalker, %s", err)
+	} else if c.Target() != "luke@rebels.org" || c.Class() != "recipient" {
+		t.Errorf("Lookup recipient luke@skywalker: got %s %s", c.Class(), c.Target())
+	}
+	if _, err = mdb.LookupCanonical("recipient", "@skywalker"); err == nil {
+		t.Errorf("Lookup recipient @skywalker should have failed")
+	} else if err != ErrMdbCanonicalNotFound {
+		t.Errorf("Lookup recipient @skywalker, %s", err)
+	}
+	if clist, err = mdb.FindCanonical("sender", "*"); err != nil {
+		t.Errorf("Find sender *, %s", err)
+	} else if len(clist) != 3 {
+		t.Errorf("Find sender *: expected 3, got %d", len(clist))
+	} else if clist[0].Export() != "@skywalker @rebels.org" ||
+		clist[1].Export() != "luke@skywalker luke.skywalker@rebels.org" ||
+		clist[2].Export() != "root admin@rebels.org" {
+		t.Errorf("Find sender *: unexpected order, %s, %s, %s",
+			clist[0].Export(), clist[1].Export(), clist[2].Export())
+	}
+
+	// change one
+	mdb.Begin()
+	if c, err = mdb.GetCanonical("sender", "root"); err == nil {
+		err = c.SetTarget("postmaster@rebels.org")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Set target of root, %s", err)
+	}
+	if c, err = mdb.LookupCanonical("sender", "root"); err != nil {
+		t.Errorf("Lookup sender root, %s", err)
+	} else if c.Target() != "postmaster@rebels.org" {
+		t.Errorf("Lookup sender root: expected postmaster@rebels.org, got %s", c.Target())
+	}
+
+	// deletes clean up the key address when it is the last user
+	if err = mdb.DeleteCanonical("sender", "root"); err != nil {
+		t.Errorf("Delete sender root, %s", err)
+	}
+	if _, err = mdb.LookupAddress("root"); err == nil {
+		t.Errorf("root should be gone")
+	} else if err != ErrMdbAddressNotFound {
+		t.Errorf("Lookup of root, %s", err)
+	}
+	if err = mdb.DeleteCanonical("sender", "luke@skywalker"); err != nil {
+		t.Errorf("Delete sender luke@skywalker, %s", err)
+	}
+	if _, err = mdb.LookupAddress("luke@skywalker"); err != nil {
+		t.Errorf("luke@skywalker is still a recipient key, %s", err)
+	}
+	if err = mdb.DeleteCanonical("sender", "luke@skywalker"); err == nil {
+		t.Errorf("Delete sender luke@skywalker again should have failed")
+	} else if err != ErrMdbCanonicalNotFound {
+		t.Errorf("Delete sender luke@skywalker again, %s", err)
+	}
+}
@@ -0,0 +1,517 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// Severity
+// How bad a finding is. Errors lose or bounce mail, warnings are likely
+// mistakes and notices are leftovers that can be cleaned up.
+type Severity int
+
+const (
+	SevNotice Severity = iota
+	SevWarning
+	SevError
+)
+
+// String
+func (s Severity) String() string {
+	switch s {
+	case SevError:
+		return "error"
+	case SevWarning:
+		return "warning"
+	default:
+		return "notice"
+	}
+}
+
+// Finding
+// One problem found by Check. A finding with a table can be fixed by
+// deleting that row.
+type Finding struct {
+	severity Severity
+	entity   string
+	message  string
+	table    string
+	id       int64
+}
+
+// Severity
+func (f *Finding) Severity() Severity {
+	return f.severity
+}
+
+// Entity
+// the kind and name of the thing with the problem
+func (f *Finding) Entity() string {
+	return f.entity
+}
+
+// Message
+func (f *Finding) Message() string {
+	return f.message
+}
+
+// Fixable
+// Safe to fix by deleting it
+func (f *Finding) Fixable() bool {
+	return f.table != ""
+}
+
+// String
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.severity, f.entity, f.message)
+}
+
+// addrName
+// the SQL for the name of address a, user or user@domain
+const addrName = `(CASE WHEN a.domain IS NULL THEN a.localpart
+ ELSE a.localpart || '@' || (SELECT name FROM domain WHERE id = a.domain) END)`
+
+// Check
+// Run all the consistency rules over the database and return what they
+// found, the worst first. No transaction
+func (mdb *MailDB) Check() ([]*Finding, error) {
+	var (
+		flist []*Finding
+		err   error
+	)
+
+	rules := []func() ([]*Finding, error){
+		mdb.checkForeignKeys,
+		mdb.checkClasses,
+		mdb.checkAliasLoops,
+		mdb.checkNoRoute,
+		mdb.checkMailboxes,
+		mdb.checkUnused,
+	}
+	for _, rule := range rules {
+		var fl []*Finding
+
+		if fl, err = rule(); err != nil {
+			return nil, err
+		}
+		flist = append(flist, fl...)
+	}
+	sort.SliceStable(flist, func(i, j int) bool {
+		return flist[i].severity > flist[j].severity
+	})
+	return flist, nil
+}
+
+// Fix
+// Delete the row behind a fixable finding. Transaction required
+func (mdb *MailDB) Fix(f *Finding) error {
+	var (
+		res sql.Result
+		err error
+	)
+
+	if mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if !f.Fixable() {
+		return ErrMdbNotFixable
+	}
+	// the table name is one of ours, never user input
+	q := fmt.Sprintf("DELETE FROM %s WHERE id = ?", f.table)
+	if res, err = mdb.tx.Exec(q, f.id); err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbNotFixable
+	}
+	return nil
+}
+
+// findings
+// Run query q, whose rows are an id and an entity name, and make a
+// finding of each. The message is a format for the name
+func (mdb *MailDB) findings(q string, sev Severity, table string,
+	kind string, format string) ([]*Finding, error) {
+	var (
+		rows  *sql.Rows
+		flist []*Finding
+		err   error
+	)
+
+	if rows, err = mdb.db.Query(q); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err = rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		f := &Finding{
+			severity: sev,
+			entity:   kind + " " + name,
+			message:  format,
+		}
+		if table != "" {
+			f.table = table
+			f.id = id
+		}
+		flist = append(flist, f)
+	}
+	return flist, rows.Err()
+}
+
+// checkForeignKeys
+// Rows that got in with foreign keys off or from an older schema
+func (mdb *MailDB) checkForeignKeys() ([]*Finding, error) {
+	var (
+		rows  *sql.Rows
+		flist []*Finding
+		err   error
+	)
+
+	if rows, err = mdb.db.Query("PRAGMA foreign_key_check"); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			table  string
+			rowid  sql.NullInt64
+			parent string
+			fkid   int
+		)
+		if err = rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return nil, err
+		}
+		flist = append(flist, &Finding{
+			severity: SevError,
+			entity:   fmt.Sprintf("%s %d", table, rowid.Int64),
+			message:  fmt.Sprintf("references a missing %s", parent),
+		})
+	}
+	return flist, rows.Err()
+}
+
+// checkClasses
+// Access rules that name a restriction class postfix does not have
+func (mdb *MailDB) checkClasses() ([]*Finding, error) {
+	var flist []*Finding
+
+	alist, err := mdb.UndefinedClasses()
+	if err != nil {
+		return nil, err
+	}
+	for _, ac := range alist {
+		flist = append(flist, &Finding{
+			severity: SevError,
+			entity:   "Access " + ac.Name(),
+			message: fmt.Sprintf("action %s is not a defined restriction class",
+				ac.Action()),
+		})
+	}
+	return flist, nil
+}
+
+// checkAliasLoops
+// Follow the virtual and local aliases the way postfix expands them and
+// report each loop once. An alias to itself is not a loop, postfix stops
+// expanding there.
+func (mdb *MailDB) checkAliasLoops() ([]*Finding, error) {
+	var (
+		rows  *sql.Rows
+		flist []*Finding
+		err   error
+	)
+
+	q := `
+SELECT mailbox || '@' || domain_name, recipient FROM virt_alias
+ WHERE mailbox != ''
+UNION ALL
+SELECT local_user, recipient FROM etc_aliases
+`
+	if rows, err = mdb.db.Query(q); err != nil {
+		return nil, err
+	}
+	graph := make(map[string][]string)
+	for rows.Next() {
+		var key, recip string
+
+		if err = rows.Scan(&key, &recip); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		key = strings.ToLower(key)
+		graph[key] = append(graph[key], strings.ToLower(recip))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the key postfix finds for a recipient, trying it without the
+	// extension if it is not there
+	next := func(recip string) string {
+		if _, ok := graph[recip]; ok {
+			return recip
+		}
+		lpart, domain := recip, ""
+		if i := strings.LastIndex(recip, "@"); i >= 0 {
+			lpart, domain = recip[:i], recip[i:]
+		}
+		if i := strings.Index(lpart, "+"); i > 0 {
+			recip = lpart[:i] + domain
+			if _, ok := graph[recip]; ok {
+				return recip
+			}
+		}
+		return ""
+	}
+
+	var (
+		keys  []string
+		path  []string
+		visit func(key string)
+	)
+	done := make(map[string]bool)
+	seen := make(map[string]bool)
+	onPath := make(map[string]bool)
+	visit = func(key string) {
+		path = append(path, key)
+		onPath[key] = true
+		for _, r := range graph[key] {
+			n := next(r)
+			if n == "" || n == key {
+				continue
+			}
+			if onPath[n] {
+				loop := path[indexOf(path, n):]
+				if f := loopFinding(loop, seen); f != nil {
+					flist = append(flist, f)
+				}
+			} else if !done[n] {
+				visit(n)
+			}
+		}
+		onPath[key] = false
+		path = path[:len(path)-1]
+		done[key] = true
+	}
+	for k := range graph {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !done[k] {
+			visit(k)
+		}
+	}
+	return flist, nil
+}
+
+func indexOf(path []string, key string) int {
+	for i, p := range path {
+		if p == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// loopFinding
+// Make the finding for a loop starting at its smallest key so it is
+// reported once however we came into it
+func loopFinding(loop []string, seen map[string]bool) *Finding {
+	first := 0
+	for i, k := range loop {
+		if k < loop[first] {
+			first = i
+		}
+	}
+	l := append(append([]string{}, loop[first:]...), loop[:first]...)
+	l = append(l, l[0])
+	s := strings.Join(l, " -> ")
+	if seen[s] {
+		return nil
+	}
+	seen[s] = true
+	return &Finding{
+		severity: SevError,
+		entity:   "Alias " + l[0],
+		message:  "loops, " + s,
+	}
+}
+
+// checkNoRoute
+// Virtual alias targets in internet domains that DNS cannot find and
+// that have no transport to send them somewhere else
+func (mdb *MailDB) checkNoRoute() ([]*Finding, error) {
+	var (
+		rows  *sql.Rows
+		flist []*Finding
+		err   error
+	)
+
+	q := `
+SELECT DISTINCT aa.localpart || '@' || ad.name, ` + addrName + `, d.name
+ FROM alias AS al
+ JOIN address AS aa ON (al.address = aa.id)
+ JOIN domain AS ad ON (aa.domain = ad.id)
+ JOIN address AS a ON (al.target = a.id)
+ JOIN domain AS d ON (a.domain = d.id)
+ WHERE d.class = 0 AND d.transport IS NULL AND a.transport IS NULL
+ ORDER BY 1, 2
+`
+	if rows, err = mdb.db.Query(q); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias, target, domain string
+
+		if err = rows.Scan(&alias, &target, &domain); err != nil {
+			return nil, err
+		}
+		if routable(domain) {
+			continue
+		}
+		flist = append(flist, &Finding{
+			severity: SevWarning,
+			entity:   "Alias " + alias,
+			message: fmt.Sprintf("target %s has no route, %s is an internet domain with no transport",
+				target, domain),
+		})
+	}
+	return flist, rows.Err()
+}
+
+// routable
+// Could DNS find this domain? A single label or a name in one of the
+// reserved top level domains will never resolve.
+func routable(domain string) bool {
+	d := strings.ToLower(strings.TrimSuffix(domain, "."))
+	i := strings.LastIndex(d, ".")
+	if i < 0 {
+		return false
+	}
+	switch d[i+1:] {
+	case "invalid", "test", "example", "localhost", "local":
+		return false
+	}
+	return true
+}
+
+// checkMailboxes
+// Mailboxes dovecot cannot deliver to or that cannot log in
+func (mdb *MailDB) checkMailboxes() ([]*Finding, error) {
+	flist, err := mdb.findings(`
+SELECT id, username || '@' || domain FROM user_mailbox
+ WHERE uid IS NULL OR gid IS NULL ORDER BY domain, username
+`, SevError, "", "Mailbox",
+		"no uid or gid in the mailbox, its domain or localhost")
+	if err != nil {
+		return nil, err
+	}
+	fl, err := mdb.findings(`
+SELECT mb.id, a.localpart || '@' || d.name FROM vmailbox AS mb
+ JOIN address AS a ON (mb.id = a.id)
+ JOIN domain AS d ON (a.domain = d.id)
+ WHERE mb.password IS NULL OR mb.password IN ('', '*')
+ ORDER BY d.name, a.localpart
+`, SevWarning, "", "Mailbox", "no password, it cannot log in")
+	if err != nil {
+		return nil, err
+	}
+	return append(flist, fl...), nil
+}
+
+// saslUnused
+// the transport has no credentials. Without the credentials database
+// any of them might so none is unused.
+func (mdb *MailDB) saslUnused() string {
+	if !mdb.sasl {
+		return "0"
+	}
+	return "id NOT IN (SELECT id FROM saslpassword)"
+}
+
+// checkUnused
+// Transports, access rules and addresses that nothing refers to. These
+// are safe to delete. A transport with a SASL password stays because
+// main.cf can use its credentials for a relayhost. So does an address
+// with its own transport or access or that is a relay recipient.
+func (mdb *MailDB) checkUnused() ([]*Finding, error) {
+	var flist []*Finding
+
+	rules := []struct {
+		q, table, kind, msg string
+	}{
+		{`
+SELECT id, name FROM transport
+ WHERE id NOT IN (SELECT transport FROM address WHERE transport IS NOT NULL)
+   AND id NOT IN (SELECT sender_transport FROM address WHERE sender_transport IS NOT NULL)
+   AND id NOT IN (SELECT transport FROM domain WHERE transport IS NOT NULL)
+   AND id NOT IN (SELECT sender_transport FROM domain WHERE sender_transport IS NOT NULL)
+   AND ` + mdb.saslUnused() + `
+ ORDER BY name
+`, "transport", "Transport", "not used by any address or domain"},
+		{`
+SELECT id, name FROM access
+ WHERE id NOT IN (SELECT access FROM address WHERE access IS NOT NULL)
+   AND id NOT IN (SELECT access FROM domain WHERE access IS NOT NULL)
+   AND id NOT IN (SELECT access FROM accesscheck)
+ ORDER BY name
+`, "access", "Access", "not used by any address, domain or access check"},
+		{`
+SELECT a.id, ` + addrName + ` AS name FROM address AS a
+ WHERE a.id NOT IN (SELECT address FROM alias)
+   AND a.id NOT IN (SELECT target FROM alias WHERE target IS NOT NULL)
+   AND a.id NOT IN (SELECT id FROM vmailbox)
+   AND a.id NOT IN (SELECT address FROM sendas WHERE address IS NOT NULL)
+   AND a.id NOT IN (SELECT address FROM canonical)
+   AND a.id NOT IN (SELECT address FROM relocated)
+   AND a.id NOT IN (SELECT address FROM bcc)
+   AND a.id NOT IN (SELECT id FROM distlist)
+   AND a.transport IS NULL AND a.access IS NULL AND a.sender_transport IS NULL
+   AND (a.domain IS NULL OR a.domain NOT IN (SELECT id FROM domain WHERE class = 2))
+ ORDER BY name
+`, "address", "Address", "not an alias, mailbox or list and nothing refers to it"},
+	}
+	for _, r := range rules {
+		fl, err := mdb.findings(r.q, SevNotice, r.table, r.kind, r.msg)
+		if err != nil {
+			return nil, err
+		}
+		flist = append(flist, fl...)
+	}
+	return flist, nil
+}
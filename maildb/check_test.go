@@ -0,0 +1,170 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestConsistency
+func TestConsistency(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		d     *Domain
+		a     *Address
+		mb    *VMailbox
+		dir   string
+		flist []*Finding
+	)
+
+	fmt.Printf("Consistency Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	// an empty database is clean
+	if flist, err = mdb.Check(); err != nil {
+		t.Errorf("Check of empty database, %s", err)
+		return
+	}
+	if len(flist) != 0 {
+		t.Errorf("Check of empty database, expected nothing, got %v", flist)
+	}
+
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		mb, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		err = mb.SetPassword("force")
+	}
+	if err == nil {
+		err = mb.SetUid(5000)
+	}
+	if err == nil {
+		err = mb.SetGid(5000)
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("leia@skywalker")
+	}
+	for _, al := range [][]string{
+		{"jedi@skywalker", "luke@skywalker"},
+		{"jedi@skywalker", "yoda@dagobah"},
+		{"ben@skywalker", "obiwan@tatooine.org"},
+		{"ping@skywalker", "pong+x@skywalker"},
+		{"pong@skywalker", "ping@skywalker"},
+		{"luke@skywalker.org", "luke@skywalker.org"},
+		{"han", "chewie"},
+		{"chewie", "han"},
+	} {
+		if err == nil {
+			if a, err = mdb.GetOrInsAddress(al[0]); err == nil {
+				err = a.AttachAlias(al[1])
+			}
+		}
+	}
+	if err == nil {
+		_, err = mdb.InsertTransport("hyperdrive")
+	}
+	if err == nil {
+		_, err = mdb.InsertAccess("blaster", "REJECT")
+	}
+	if err == nil {
+		_, err = mdb.InsertAddress("ghost@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+
+	if flist, err = mdb.Check(); err != nil {
+		t.Errorf("Check, %s", err)
+		return
+	}
+	expected := []string{
+		"error: Alias chewie: loops, chewie -> han -> chewie",
+		"error: Alias ping@skywalker: loops, ping@skywalker -> pong@skywalker -> ping@skywalker",
+		"error: Mailbox leia@skywalker: no uid or gid in the mailbox, its domain or localhost",
+		"warning: Alias jedi@skywalker: target yoda@dagobah has no route, dagobah is an internet domain with no transport",
+		"warning: Mailbox leia@skywalker: no password, it cannot log in",
+		"notice: Transport hyperdrive: not used by any address or domain",
+		"notice: Access blaster: not used by any address, domain or access check",
+		"notice: Address ghost@skywalker: not an alias, mailbox or list and nothing refers to it",
+	}
+	if len(flist) != len(expected) {
+		t.Errorf("Check, expected %d findings, got %d, %v", len(expected), len(flist), flist)
+	} else {
+		for i, f := range flist {
+			if f.String() != expected[i] {
+				t.Errorf("Check, expected %s, got %s", expected[i], f)
+			}
+			if f.Fixable() != (f.Severity() == SevNotice) {
+				t.Errorf("Check, %s should not be fixable", f)
+			}
+		}
+	}
+
+	// Fix needs a transaction
+	if err = mdb.Fix(flist[len(flist)-1]); err != ErrMdbTransaction {
+		t.Errorf("Fix outside transaction, expected error, got %v", err)
+	}
+	mdb.Begin()
+	for _, f := range flist {
+		if err = mdb.Fix(f); err != nil {
+			if f.Fixable() || err != ErrMdbNotFixable {
+				break
+			}
+			err = nil
+		}
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Fix, %s", err)
+	}
+	if flist, err = mdb.Check(); err != nil {
+		t.Errorf("Check after fix, %s", err)
+		return
+	}
+	if len(flist) != 5 {
+		t.Errorf("Check after fix, expected 5 findings, got %v", flist)
+	}
+	if _, err = mdb.LookupAddress("ghost@skywalker"); err != ErrMdbAddressNotFound {
+		t.Errorf("Lookup ghost after fix, expected not found, got %v", err)
+	}
+}
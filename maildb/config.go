@@ -0,0 +1,128 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// configDir is where the templates are in the embedded files. Under it
+// "postfix" and "dovecot" are the trees for their config directories.
+const configDir = "files/config"
+
+// ConfigFile
+// A postfix or dovecot configuration file made from its template
+type ConfigFile struct {
+	dir     string
+	name    string
+	content string
+}
+
+// Dir
+// the "postfix" or "dovecot" config directory this goes in
+func (cf *ConfigFile) Dir() string {
+	return cf.dir
+}
+
+// Name
+// the path to the file in its config directory
+func (cf *ConfigFile) Name() string {
+	return cf.name
+}
+
+// Content
+func (cf *ConfigFile) Content() string {
+	return cf.content
+}
+
+// SchemaVersion
+// A short hash of the built in schema. The generated config files are
+// marked with it so an installed file that is older than the schema
+// shows up in a diff.
+func SchemaVersion() (string, error) {
+	c, err := DbContent.ReadFile("files/schema.sql")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(c))[:12], nil
+}
+
+// ConfigFiles
+// Make all the configuration files for the database at dbPath. The
+// postfix query files go in queryDir, the absolute path of the "query"
+// directory under the postfix config directory.
+func ConfigFiles(dbPath string, queryDir string) ([]*ConfigFile, error) {
+	var (
+		cflist []*ConfigFile
+		err    error
+	)
+
+	schema, err := SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	vars := struct {
+		DbPath     string
+		SaslDbPath string
+		QueryDir   string
+		Schema     string
+	}{
+		DbPath:     dbPath,
+		SaslDbPath: SaslDbPath(dbPath),
+		QueryDir:   queryDir,
+		Schema:     schema,
+	}
+	err = fs.WalkDir(DbContent, configDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		c, err := DbContent.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		t, err := template.New(path.Base(p)).Parse(string(c))
+		if err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+		var out bytes.Buffer
+		if err = t.Execute(&out, vars); err != nil {
+			return fmt.Errorf("%s: %s", p, err)
+		}
+		rel := strings.TrimPrefix(p, configDir+"/")
+		i := strings.Index(rel, "/")
+		cflist = append(cflist, &ConfigFile{
+			dir:     rel[:i],
+			name:    rel[i+1:],
+			content: out.String(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cflist, nil
+}
@@ -0,0 +1,75 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestConfigFiles
+func TestConfigFiles(t *testing.T) {
+	fmt.Printf("Config Files Test\n")
+
+	schema, err := SchemaVersion()
+	if err != nil {
+		t.Errorf("SchemaVersion, %s", err)
+		return
+	}
+	if len(schema) != 12 {
+		t.Errorf("SchemaVersion, expected 12 hex digits, got %s", schema)
+	}
+	cflist, err := ConfigFiles("/var/lib/postdove.sqlite", "/etc/postfix/query")
+	if err != nil {
+		t.Errorf("ConfigFiles, %s", err)
+		return
+	}
+	names := make(map[string]*ConfigFile)
+	for _, cf := range cflist {
+		names[cf.Dir()+"/"+cf.Name()] = cf
+		if strings.Contains(cf.Content(), "{{") {
+			t.Errorf("ConfigFiles %s: template not expanded", cf.Name())
+		}
+		if !strings.Contains(cf.Content(), "for schema "+schema+".") {
+			t.Errorf("ConfigFiles %s: no schema version", cf.Name())
+		}
+	}
+	for _, n := range []struct {
+		name, line string
+	}{
+		{"postfix/query/virtual_alias.query", "dbpath = /var/lib/postdove.sqlite\n"},
+		{"postfix/query/relay_recipients.query", "domain_name IS '%d'\n"},
+		{"postfix/postdove.cf", "query = sqlite:/etc/postfix/query\n"},
+		{"postfix/postdove.cf", "virtual_alias_maps = $query/virtual_alias.query, $query/virtual_catchall.query\n"},
+		{"dovecot/dovecot-sql.conf.ext", "connect = /var/lib/postdove.sqlite\n"},
+		{"dovecot/sql-deny.conf.ext", "connect = /var/lib/postdove.sqlite\n"},
+	} {
+		if cf, ok := names[n.name]; !ok {
+			t.Errorf("ConfigFiles: %s not generated", n.name)
+		} else if !strings.Contains(cf.Content(), n.line) {
+			t.Errorf("ConfigFiles %s: expected %q, got %s", n.name, n.line, cf.Content())
+		}
+	}
+	if len(cflist) != 27 {
+		t.Errorf("ConfigFiles: expected 27 files, got %d", len(cflist))
+	}
+}
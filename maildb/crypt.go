@@ -0,0 +1,287 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// The crypt(3) hashes dovecot's CRYPT scheme finds in the password
+// column. These are the ones glibc makes, MD5 ($1$), SHA256 ($5$) and
+// SHA512 ($6$). DES and bcrypt hashes are not supported.
+
+// the crypt(3) alphabet for its base64
+const cryptB64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const (
+	shaRoundsDefault = 5000
+	shaRoundsMin     = 1000
+	shaRoundsMax     = 999999999
+	shaSaltMax       = 16
+	md5SaltMax       = 8
+)
+
+// the byte order of the encoded hashes, three bytes to four characters
+var (
+	md5CryptOrder = [][3]int{
+		{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5},
+	}
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// cryptEncode
+// append n characters of the 24 bits in b2, b1, b0
+func cryptEncode(s *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		s.WriteByte(cryptB64[w&0x3f])
+		w >>= 6
+	}
+}
+
+// Crypt
+// hash key the way crypt(3) does with the method and salt of setting,
+// a crypt hash or only its "$id$salt" prefix. Compare the result with
+// the stored hash to check a password.
+func Crypt(key string, setting string) (string, error) {
+	switch {
+	case strings.HasPrefix(setting, "$1$"):
+		return md5Crypt([]byte(key), setting[3:]), nil
+	case strings.HasPrefix(setting, "$5$"):
+		return shaCrypt(sha256.New, "$5$", sha256CryptOrder, []byte(key), setting[3:]), nil
+	case strings.HasPrefix(setting, "$6$"):
+		return shaCrypt(sha512.New, "$6$", sha512CryptOrder, []byte(key), setting[3:]), nil
+	default:
+		return "", ErrMdbPwScheme
+	}
+}
+
+// IsCryptHash
+// Is hash a crypt(3) hash, one that dovecot's CRYPT scheme checks with
+// the system crypt? This is what the importers store as CRYPT as it
+// is. Crypt only has some of these methods, the rest can only be
+// checked by dovecot.
+func IsCryptHash(hash string) bool {
+	for _, id := range []string{"$1$", "$2a$", "$2b$", "$2y$", "$5$", "$6$", "$7$", "$y$", "$gy$"} {
+		if strings.HasPrefix(hash, id) && len(hash) > len(id) {
+			return true
+		}
+	}
+	// the traditional DES, two characters of salt and eleven of hash
+	if len(hash) != 13 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if strings.IndexByte(cryptB64, hash[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// cryptSalt
+// the salt from what follows the "$id$", at most max characters
+func cryptSalt(setting string, max int) string {
+	if i := strings.IndexByte(setting, '$'); i >= 0 {
+		setting = setting[:i]
+	}
+	if len(setting) > max {
+		setting = setting[:max]
+	}
+	return setting
+}
+
+// md5Crypt
+// the FreeBSD MD5 crypt
+func md5Crypt(key []byte, setting string) string {
+	var s strings.Builder
+
+	salt := []byte(cryptSalt(setting, md5SaltMax))
+	h := md5.New()
+	h.Write(key)
+	h.Write(salt)
+	h.Write(key)
+	alt := h.Sum(nil)
+
+	h = md5.New()
+	h.Write(key)
+	h.Write([]byte("$1$"))
+	h.Write(salt)
+	for n := len(key); n > 0; n -= md5.Size {
+		if n > md5.Size {
+			h.Write(alt)
+		} else {
+			h.Write(alt[:n])
+		}
+	}
+	for n := len(key); n != 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(key[:1])
+		}
+	}
+	alt = h.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		h = md5.New()
+		if i&1 != 0 {
+			h.Write(key)
+		} else {
+			h.Write(alt)
+		}
+		if i%3 != 0 {
+			h.Write(salt)
+		}
+		if i%7 != 0 {
+			h.Write(key)
+		}
+		if i&1 != 0 {
+			h.Write(alt)
+		} else {
+			h.Write(key)
+		}
+		alt = h.Sum(nil)
+	}
+
+	s.WriteString("$1$")
+	s.Write(salt)
+	s.WriteByte('$')
+	for _, o := range md5CryptOrder {
+		cryptEncode(&s, alt[o[0]], alt[o[1]], alt[o[2]], 4)
+	}
+	cryptEncode(&s, 0, 0, alt[11], 2)
+	return s.String()
+}
+
+// shaRepeat
+// the digest repeated to fill n bytes
+func shaRepeat(digest []byte, n int) []byte {
+	b := make([]byte, 0, n)
+	for len(b)+len(digest) < n {
+		b = append(b, digest...)
+	}
+	return append(b, digest[:n-len(b)]...)
+}
+
+// shaCrypt
+// Ulrich Drepper's SHA crypt used by glibc for both SHA256 and SHA512
+func shaCrypt(newHash func() hash.Hash, magic string, order [][3]int, key []byte, setting string) string {
+	var s strings.Builder
+
+	rounds, custom := shaRoundsDefault, false
+	if strings.HasPrefix(setting, "rounds=") {
+		if i := strings.IndexByte(setting, '$'); i > 0 {
+			if r, err := strconv.ParseUint(setting[len("rounds="):i], 10, 64); err == nil {
+				rounds, custom = int(r), true
+				if r < shaRoundsMin {
+					rounds = shaRoundsMin
+				} else if r > shaRoundsMax {
+					rounds = shaRoundsMax
+				}
+				setting = setting[i+1:]
+			}
+		}
+	}
+	salt := []byte(cryptSalt(setting, shaSaltMax))
+
+	h := newHash()
+	h.Write(key)
+	h.Write(salt)
+	h.Write(key)
+	alt := h.Sum(nil)
+
+	h = newHash()
+	h.Write(key)
+	h.Write(salt)
+	h.Write(shaRepeat(alt, len(key)))
+	for n := len(key); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(alt)
+		} else {
+			h.Write(key)
+		}
+	}
+	alt = h.Sum(nil)
+
+	h = newHash()
+	for i := 0; i < len(key); i++ {
+		h.Write(key)
+	}
+	p := shaRepeat(h.Sum(nil), len(key))
+
+	h = newHash()
+	for i := 0; i < 16+int(alt[0]); i++ {
+		h.Write(salt)
+	}
+	sb := shaRepeat(h.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(alt)
+		}
+		if i%3 != 0 {
+			h.Write(sb)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(alt)
+		} else {
+			h.Write(p)
+		}
+		alt = h.Sum(nil)
+	}
+
+	s.WriteString(magic)
+	if custom {
+		s.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	s.Write(salt)
+	s.WriteByte('$')
+	for _, o := range order {
+		cryptEncode(&s, alt[o[0]], alt[o[1]], alt[o[2]], 4)
+	}
+	if len(alt) == sha512.Size {
+		cryptEncode(&s, 0, 0, alt[63], 2)
+	} else {
+		cryptEncode(&s, 0, alt[31], alt[30], 3)
+	}
+	return s.String()
+}
@@ -22,6 +22,8 @@ package maildb
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 )
 
@@ -56,6 +58,9 @@ func DecodeRFC822(addr string) (*AddressParts, error) {
 	} else { // just local
 		local = a
 	}
+	if local == "" && domain == "" { // a bare '@'
+		return nil, ErrMdbAddressEmpty
+	}
 	if strings.Contains(local, "+") { // we have an address extension
 		pl := strings.Index(local, "+")
 		loc := local[0:pl]
@@ -123,6 +128,12 @@ func (ap *AddressParts) IsPipe() bool {
 	return ap.lpart == "" && ap.domain == ""
 }
 
+// IsCatchall
+// "@domain" matches any localpart in the domain that has no entry of its own
+func (ap *AddressParts) IsCatchall() bool {
+	return ap.lpart == "" && ap.domain != ""
+}
+
 // IsLocal
 func (ap *AddressParts) IsLocal() bool {
 	return ap.lpart != "" && ap.domain == ""
@@ -161,7 +172,27 @@ type TransportParts struct {
 	nexthop   string
 }
 
+// Transport
+func (tp *TransportParts) Transport() string {
+	return tp.transport
+}
+
+// Nexthop
+func (tp *TransportParts) Nexthop() string {
+	return tp.nexthop
+}
+
+// textNexthop
+// transports where the nexthop is the text of the bounce or defer
+// message rather than a destination
+var textNexthop = map[string]bool{
+	"error":   true,
+	"retry":   true,
+	"discard": true,
+}
+
 // DecodeTransport
+// Split a transport(5) "transport:nexthop" value and check the nexthop
 func DecodeTransport(trans string) (*TransportParts, error) {
 	i := strings.Index(trans, ":")
 	if i >= 0 {
@@ -169,8 +200,187 @@ func DecodeTransport(trans string) (*TransportParts, error) {
 			transport: trans[0:i],
 			nexthop:   trans[i+1:],
 		}
+		if err := DecodeNexthop(t.transport, t.nexthop); err != nil {
+			return nil, err
+		}
 		return t, nil
 	} else {
 		return nil, ErrMdbTransNoColon
 	}
 }
+
+// DecodeNexthop
+// Check the nexthop syntax for this transport. The nexthop can be a list
+// separated by commas or white space as the smtp and lmtp clients allow.
+func DecodeNexthop(transport string, nexthop string) error {
+	if textNexthop[transport] {
+		return nil
+	}
+	onSep := func(c rune) bool { return c == ',' || c == ' ' || c == '\t' }
+	for _, hop := range strings.FieldsFunc(nexthop, onSep) {
+		if err := decodeHop(hop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeHop
+// One destination, "host", "[host]" with no MX lookup, a bracketed IPv4
+// or IPv6 address, "[ipv6:addr]", any of these followed by ":port", and
+// for lmtp the same prefixed by "inet:" or a "unix:path" socket. The port is a number or a service name. An unbracketed IPv4 address
+// is allowed for lmtp which does no MX lookups.
+func decodeHop(hop string) error {
+	var (
+		host string
+		port string
+		more bool
+	)
+
+	if strings.HasPrefix(hop, "unix:") {
+		if len(hop) == len("unix:") {
+			return ErrMdbBadNexthop
+		}
+		return nil
+	}
+	hop = strings.TrimPrefix(hop, "inet:")
+	if strings.HasPrefix(hop, "[") {
+		end := strings.IndexByte(hop, ']')
+		if end == -1 {
+			return ErrMdbBadNexthop
+		}
+		host = hop[1:end]
+		if rest := hop[end+1:]; rest != "" {
+			if rest[0] != ':' {
+				return ErrMdbBadNexthop
+			}
+			port, more = rest[1:], true
+		}
+		if a := strings.ToLower(host); strings.HasPrefix(a, "ipv6:") {
+			if ip := net.ParseIP(a[len("ipv6:"):]); ip == nil || ip.To4() != nil {
+				return ErrMdbBadNexthop
+			}
+		} else if net.ParseIP(host) == nil {
+			if _, err := DecodeHostname(host); err != nil {
+				return ErrMdbBadNexthop
+			}
+		}
+	} else {
+		if c := strings.LastIndexByte(hop, ':'); c >= 0 {
+			host, port, more = hop[0:c], hop[c+1:], true
+		} else {
+			host = hop
+		}
+		if ip := net.ParseIP(host); ip == nil || ip.To4() == nil {
+			if _, err := DecodeHostname(host); err != nil {
+				return ErrMdbBadNexthop // includes an unbracketed IPv6
+			}
+		}
+	}
+	if more {
+		return decodePort(port)
+	}
+	return nil
+}
+
+// decodePort
+// a port number or a services(5) name
+func decodePort(port string) error {
+	if port == "" {
+		return ErrMdbBadNexthop
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		if n < 1 || n > 65535 || port != strconv.Itoa(n) {
+			return ErrMdbBadNexthop
+		}
+		return nil
+	}
+	for i, c := range port {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') &&
+			!(i > 0 && ((c >= '0' && c <= '9') || c == '-')) {
+			return ErrMdbBadNexthop
+		}
+	}
+	return nil
+}
+
+// DecodeHostname
+// A host or domain name, lower cased. Each label is letters, digits
+// and '-' but not at either end and the last cannot be all digits.
+// A trailing '.' is dropped.
+func DecodeHostname(name string) (string, error) {
+	h := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if h == "" || len(h) > 253 {
+		return "", ErrMdbBadHostname
+	}
+	labels := strings.Split(h, ".")
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", ErrMdbBadHostname // no all numeric top level domains
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return "", ErrMdbBadHostname
+		}
+		for _, c := range l {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+				return "", ErrMdbBadHostname
+			}
+		}
+	}
+	return h, nil
+}
+
+// DecodeCIDR
+// Decode an IP address, an IPv4 "net.work" prefix as in access(5), or an
+// "address/prefix" network. The first return is the form stored and
+// exported, the host address or the CIDR network. Host bits set in a
+// network are an error, the same as postfix cidr_table(5).
+// The second is the key postfix looks up for it in a non-CIDR table.
+// It is empty if the network is not on an octet boundary.
+func DecodeCIDR(addr string) (string, string, error) {
+	var (
+		ip    net.IP
+		ipnet *net.IPNet
+		err   error
+	)
+
+	a := strings.TrimSpace(addr)
+	if a == "" {
+		return "", "", ErrMdbBadCIDR
+	}
+	if !strings.Contains(a, "/") {
+		if ip = net.ParseIP(a); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			return ip.String(), ip.String(), nil
+		}
+		// maybe a short IPv4 prefix, "10" or "192.168"
+		octets := strings.Split(a, ".")
+		if len(octets) > 3 {
+			return "", "", ErrMdbBadCIDR
+		}
+		for _, o := range octets {
+			if n, err := strconv.Atoi(o); err != nil || n < 0 || n > 255 || o != strconv.Itoa(n) {
+				return "", "", ErrMdbBadCIDR
+			}
+		}
+		full := a + strings.Repeat(".0", 4-len(octets))
+		a = full + "/" + strconv.Itoa(8*len(octets))
+	}
+	if ip, ipnet, err = net.ParseCIDR(a); err != nil {
+		return "", "", ErrMdbBadCIDR
+	}
+	if !ip.Equal(ipnet.IP) {
+		return "", "", ErrMdbBadCIDR
+	}
+	ones, bits := ipnet.Mask.Size()
+	if ones == bits { // a host after all
+		return ipnet.IP.String(), ipnet.IP.String(), nil
+	}
+	lookup := ""
+	if bits == 32 && ones%8 == 0 && ones > 0 {
+		lookup = strings.Join(strings.Split(ipnet.IP.String(), ".")[:ones/8], ".")
+	}
+	return ipnet.String(), lookup, nil
+}
@@ -49,6 +49,8 @@ type Domain struct {
 	access    *Access
 	vuid      sql.NullInt64
 	vgid      sql.NullInt64
+	aliasOf   sql.NullString // name of the domain this one is an alias of
+	sTrans    sql.NullString // name of the transport for mail sent from here
 }
 
 var domainClass = []string{
@@ -94,6 +96,12 @@ func (d *Domain) Export() string {
 	if d.access != nil {
 		fmt.Fprintf(&line, ", rclass=%s", d.access.Name())
 	}
+	if d.aliasOf.Valid {
+		fmt.Fprintf(&line, ", alias_of=%s", d.aliasOf.String)
+	}
+	if d.sTrans.Valid {
+		fmt.Fprintf(&line, ", sender_transport=%s", d.sTrans.String)
+	}
 	return line.String()
 }
 
@@ -147,6 +155,31 @@ func (d *Domain) Rclass() string {
 	}
 }
 
+// AliasOf
+func (d *Domain) AliasOf() string {
+	if d.aliasOf.Valid {
+		return d.aliasOf.String
+	} else {
+		return "--"
+	}
+}
+
+// SenderTransport
+// transport for mail sent from this domain
+func (d *Domain) SenderTransport() string {
+	if d.sTrans.Valid {
+		return d.sTrans.String
+	} else {
+		return "--"
+	}
+}
+
+// IsAlias
+// An alias domain resolves all of its localparts in its target domain
+func (d *Domain) IsAlias() bool {
+	return d.aliasOf.Valid
+}
+
 // IsInternet
 func (d *Domain) IsInternet() bool {
 	if d.class == internet {
@@ -192,6 +225,15 @@ func (d *Domain) IsVmailbox() bool {
 	}
 }
 
+// query for a domain by name. The alias target and sender transport
+// are returned by name
+var qdName string = `
+SELECT d.id, d.class, d.transport, d.access, d.vuid, d.vgid,
+       (SELECT name FROM domain WHERE id = d.alias_of),
+       (SELECT name FROM transport WHERE id = d.sender_transport)
+FROM domain AS d WHERE d.name = ?
+`
+
 // LookupDomain
 // Does lookup outside a transaction
 func (mdb *MailDB) LookupDomain(name string) (*Domain, error) {
@@ -208,10 +250,9 @@ func (mdb *MailDB) LookupDomain(name string) (*Domain, error) {
 		mdb:  mdb,
 		name: name,
 	}
-	row := mdb.db.QueryRow(
-		"SELECT id, class, transport, access, vuid, vgid FROM domain WHERE name = ?",
-		name)
-	switch err := row.Scan(&d.id, &d.class, &trans, &access, &d.vuid, &d.vgid); err {
+	row := mdb.db.QueryRow(qdName, name)
+	switch err := row.Scan(&d.id, &d.class, &trans, &access, &d.vuid, &d.vgid,
+		&d.aliasOf, &d.sTrans); err {
 	case sql.ErrNoRows:
 		return nil, ErrMdbDomainNotFound
 	case nil:
@@ -251,18 +292,24 @@ func (mdb *MailDB) FindDomain(name string) ([]*Domain, error) {
 	)
 	if name == "*" {
 		q = `
-SELECT id, name, class, transport, access, vuid, vgid FROM domain ORDER BY NAME`
+SELECT d.id, d.name, d.class, d.transport, d.access, d.vuid, d.vgid,
+       (SELECT name FROM domain WHERE id = d.alias_of),
+       (SELECT name FROM transport WHERE id = d.sender_transport)
+FROM domain AS d ORDER BY NAME`
 	} else {
 		name = strings.ReplaceAll(name, "*", "%")
 		q = `
-SELECT id, name, class, transport, access, vuid, vgid FROM domain WHERE name LIKE ? ORDER BY name`
+SELECT d.id, d.name, d.class, d.transport, d.access, d.vuid, d.vgid,
+       (SELECT name FROM domain WHERE id = d.alias_of),
+       (SELECT name FROM transport WHERE id = d.sender_transport)
+FROM domain AS d WHERE d.name LIKE ? ORDER BY d.name`
 	}
 	rows, err := mdb.db.Query(q, name)
 	if err == nil {
 		for rows.Next() {
 			d = &Domain{mdb: mdb}
 			if err = rows.Scan(&d.id, &d.name, &d.class, &trans,
-				&access, &d.vuid, &d.vgid); err != nil {
+				&access, &d.vuid, &d.vgid, &d.aliasOf, &d.sTrans); err != nil {
 				break
 			}
 			if access.Valid {
@@ -354,10 +401,9 @@ func (mdb *MailDB) GetDomain(name string) (*Domain, error) {
 	if mdb.tx == nil {
 		return nil, ErrMdbTransaction
 	}
-	row := mdb.tx.QueryRow(
-		"SELECT id, class, transport, access, vuid, vgid FROM domain WHERE name = ?",
-		name)
-	switch err = row.Scan(&d.id, &d.class, &trans, &access, &d.vuid, &d.vgid); err {
+	row := mdb.tx.QueryRow(qdName, name)
+	switch err = row.Scan(&d.id, &d.class, &trans, &access, &d.vuid, &d.vgid,
+		&d.aliasOf, &d.sTrans); err {
 	case sql.ErrNoRows:
 		err = ErrMdbDomainNotFound
 	case nil:
@@ -397,6 +443,9 @@ func (d *Domain) SetClass(class string) error {
 			return ErrMdbBadClass
 		}
 	}
+	if d.aliasOf.Valid && !aliasClass(dclass) {
+		return ErrMdbDomainAliasClass
+	}
 	res, err := d.mdb.tx.Exec("UPDATE domain SET class = ? WHERE id = ?", dclass, d.id)
 	if err == nil {
 		c, err := res.RowsAffected()
@@ -565,6 +614,132 @@ func (d *Domain) ClearRclass() error {
 	return err
 }
 
+// SetSenderTransport
+func (d *Domain) SetSenderTransport(name string) error {
+	var (
+		tr  *Transport
+		err error
+	)
+
+	if tr, err = d.mdb.GetTransport(name); err != nil {
+		return err
+	}
+	res, err := d.mdb.tx.Exec("UPDATE domain SET sender_transport = ? WHERE id = ?", tr.id, d.id)
+	if err == nil {
+		c, err := res.RowsAffected()
+		if err == nil {
+			if c == 1 {
+				d.sTrans = sql.NullString{String: tr.Name(), Valid: true}
+			} else {
+				err = ErrMdbDomainNotFound
+			}
+		}
+	}
+	return err
+}
+
+// ClearSenderTransport
+func (d *Domain) ClearSenderTransport() error {
+	res, err := d.mdb.tx.Exec("UPDATE domain SET sender_transport = NULL WHERE id = ?", d.id)
+	if err == nil {
+		c, err := res.RowsAffected()
+		if err == nil {
+			if c == 1 {
+				d.sTrans = sql.NullString{}
+			} else {
+				err = ErrMdbDomainNotFound
+			}
+		}
+	}
+	return err
+}
+
+// Mailboxes
+// Return the number of mailboxes in this domain. Works inside or outside a transaction
+func (d *Domain) Mailboxes() (int64, error) {
+	var (
+		row *sql.Row
+		c   int64
+	)
+
+	q := `
+SELECT count(*) FROM vmailbox AS mb JOIN address AS a ON (mb.id = a.id)
+WHERE a.domain = ?
+`
+	if d.mdb.tx != nil {
+		row = d.mdb.tx.QueryRow(q, d.id)
+	} else {
+		row = d.mdb.db.QueryRow(q, d.id)
+	}
+	if err := row.Scan(&c); err != nil {
+		return 0, err
+	}
+	return c, nil
+}
+
+// aliasClass
+// can a domain of this class be an alias domain?
+func aliasClass(class Class) bool {
+	return class == virtual || class == internet
+}
+
+// SetAliasOf
+// Make this domain an alias of the named domain. The target must already
+// exist and cannot itself be an alias domain so we never chase chains or loops.
+// Only a virtual domain, or one with no class, can be an alias, postfix
+// looks its addresses up in virt_alias and nowhere else.
+func (d *Domain) SetAliasOf(name string) error {
+	var (
+		td  *Domain
+		err error
+	)
+
+	if !aliasClass(d.class) {
+		return ErrMdbDomainAliasClass
+	}
+	if td, err = d.mdb.GetDomain(name); err != nil {
+		return err
+	}
+	if td.id == d.id || td.IsAlias() {
+		return ErrMdbDomainAliasLoop
+	}
+	row := d.mdb.tx.QueryRow("SELECT count(*) FROM domain WHERE alias_of = ?", d.id)
+	var c int64
+	if err = row.Scan(&c); err != nil {
+		return err
+	} else if c > 0 { // someone is already an alias of us
+		return ErrMdbDomainAliasLoop
+	}
+	res, err := d.mdb.tx.Exec("UPDATE domain SET alias_of = ? WHERE id = ?", td.id, d.id)
+	if err == nil {
+		c, err := res.RowsAffected()
+		if err == nil {
+			if c == 1 {
+				d.aliasOf = sql.NullString{Valid: true, String: td.name}
+			} else {
+				err = ErrMdbDomainNotFound
+			}
+		}
+	}
+	return err
+}
+
+// ClearAliasOf
+func (d *Domain) ClearAliasOf() error {
+	res, err := d.mdb.tx.Exec("UPDATE domain SET alias_of = NULL WHERE id = ?", d.id)
+	if err == nil {
+		c, err := res.RowsAffected()
+		if err == nil {
+			if c == 1 {
+				d.aliasOf = NullStr
+			} else {
+				err = ErrMdbDomainNotFound
+			}
+		}
+	}
+	return err
+}
+
 // DeleteDomain
 func (mdb *MailDB) DeleteDomain(name string) error {
 	res, err := mdb.db.Exec("DELETE FROM domain WHERE name = ?", name)
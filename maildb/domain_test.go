@@ -343,6 +343,104 @@ func TestDomain(t *testing.T) {
 		}
 	}
 
+	// Alias domains. buz.com becomes an alias of bar.com
+	mdb.Begin()
+	if d, err = mdb.GetDomain("buz.com"); err == nil {
+		err = d.SetAliasOf("bar.com")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("SetAliasOf buz.com: unexpected error, %s", err)
+	} else if d, err = mdb.LookupDomain("buz.com"); err != nil {
+		t.Errorf("Lookup buz.com after SetAliasOf, %s", err)
+	} else {
+		if !d.IsAlias() {
+			t.Errorf("buz.com: IsAlias should be true")
+		}
+		if d.AliasOf() != "bar.com" {
+			t.Errorf("buz.com: expected alias of bar.com, got %s", d.AliasOf())
+		}
+		if d.Export() != "buz.com class=internet, alias_of=bar.com" {
+			t.Errorf("buz.com: unexpected export, got %s", d.Export())
+		}
+	}
+	// no chains or loops
+	mdb.Begin()
+	if d, err = mdb.GetDomain("bar.com"); err == nil {
+		err = d.SetAliasOf("buz.com")
+	}
+	mdb.End(&err)
+	if err != ErrMdbDomainAliasLoop {
+		t.Errorf("SetAliasOf bar.com to alias buz.com: expected loop error, got %v", err)
+	}
+	mdb.Begin()
+	if d, err = mdb.GetDomain("buz.net"); err == nil {
+		err = d.SetAliasOf("buz.com")
+	}
+	mdb.End(&err)
+	if err != ErrMdbDomainAliasLoop {
+		t.Errorf("SetAliasOf buz.net to alias buz.com: expected loop error, got %v", err)
+	}
+	mdb.Begin()
+	if d, err = mdb.GetDomain("buz.org"); err == nil {
+		err = d.SetAliasOf("buz.org")
+	}
+	mdb.End(&err)
+	if err != ErrMdbDomainAliasLoop {
+		t.Errorf("SetAliasOf buz.org to itself: expected loop error, got %v", err)
+	}
+	// the target is busy while it has an alias
+	err = mdb.DeleteDomain("bar.com")
+	if err != ErrMdbDomainBusy {
+		t.Errorf("Delete of alias target bar.com: expected busy, got %v", err)
+	}
+	mdb.Begin()
+	if d, err = mdb.GetDomain("buz.com"); err == nil {
+		err = d.ClearAliasOf()
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("ClearAliasOf buz.com: unexpected error, %s", err)
+	} else if d, err = mdb.LookupDomain("buz.com"); err != nil {
+		t.Errorf("Lookup buz.com after ClearAliasOf, %s", err)
+	} else if d.IsAlias() || d.AliasOf() != "--" {
+		t.Errorf("buz.com: should no longer be an alias, got %s", d.AliasOf())
+	}
+
+	// Sender transports. buz.com sends via relay
+	mdb.Begin()
+	if d, err = mdb.GetDomain("buz.com"); err == nil {
+		if err = d.SetSenderTransport("nowhere"); err != ErrMdbTransNotFound {
+			t.Errorf("SetSenderTransport buz.com to nowhere: expected not found, got %v", err)
+		}
+		err = d.SetSenderTransport("relay")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("SetSenderTransport buz.com: unexpected error, %s", err)
+	} else if d, err = mdb.LookupDomain("buz.com"); err != nil {
+		t.Errorf("Lookup buz.com after SetSenderTransport, %s", err)
+	} else {
+		if d.SenderTransport() != "relay" {
+			t.Errorf("buz.com: expected sender transport relay, got %s", d.SenderTransport())
+		}
+		if d.Export() != "buz.com class=internet, sender_transport=relay" {
+			t.Errorf("buz.com: unexpected export, got %s", d.Export())
+		}
+	}
+	mdb.Begin()
+	if d, err = mdb.GetDomain("buz.com"); err == nil {
+		err = d.ClearSenderTransport()
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("ClearSenderTransport buz.com: unexpected error, %s", err)
+	} else if d, err = mdb.LookupDomain("buz.com"); err != nil {
+		t.Errorf("Lookup buz.com after ClearSenderTransport, %s", err)
+	} else if d.SenderTransport() != "--" {
+		t.Errorf("buz.com: should no longer have a sender transport, got %s", d.SenderTransport())
+	}
+
 	// Delete stuff
 	err = mdb.DeleteDomain("baz")
 	if err == nil {
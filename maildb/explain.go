@@ -0,0 +1,524 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"os/user"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// Delivery
+// What postfix does with one recipient, found by making its lookups in
+// its order. An address that expands into others has them as its
+// targets. Problems are what will lose the mail or bounce it.
+type Delivery struct {
+	address  string
+	notes    []string
+	problems []string
+	targets  []*Delivery
+}
+
+// Address
+func (dl *Delivery) Address() string {
+	return dl.address
+}
+
+// Notes
+// what each lookup found, in order
+func (dl *Delivery) Notes() []string {
+	return dl.notes
+}
+
+// Problems
+// loops, dead ends and disabled mailboxes
+func (dl *Delivery) Problems() []string {
+	return dl.problems
+}
+
+// Targets
+func (dl *Delivery) Targets() []*Delivery {
+	return dl.targets
+}
+
+// HasProblems
+// anywhere in the tree
+func (dl *Delivery) HasProblems() bool {
+	if len(dl.problems) > 0 {
+		return true
+	}
+	for _, t := range dl.targets {
+		if t.HasProblems() {
+			return true
+		}
+	}
+	return false
+}
+
+func (dl *Delivery) note(format string, args ...interface{}) {
+	dl.notes = append(dl.notes, fmt.Sprintf(format, args...))
+}
+
+func (dl *Delivery) problem(format string, args ...interface{}) {
+	dl.problems = append(dl.problems, fmt.Sprintf(format, args...))
+}
+
+// mapLookup
+// Try the keys in order the way postfix does with a table and return
+// the first one found and its results
+func (mdb *MailDB) mapLookup(q string, keys ...string) (string, []string, error) {
+	var (
+		rows *sql.Rows
+		vals []string
+		err  error
+	)
+
+	for _, k := range keys {
+		if rows, err = mdb.db.Query(q, k); err != nil {
+			return "", nil, err
+		}
+		for rows.Next() {
+			var v string
+			if err = rows.Scan(&v); err != nil {
+				rows.Close()
+				return "", nil, err
+			}
+			vals = append(vals, v)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return "", nil, err
+		}
+		if len(vals) > 0 {
+			return k, vals, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// addrKeys
+// The keys postfix tries for user+ext@domain in a table of addresses,
+// the address, the address without the extension, the bare user for a
+// local address and then the catch-all.
+func addrKeys(ap *AddressParts, catchall bool) []string {
+	var keys []string
+
+	at := ""
+	if ap.domain != "" {
+		at = "@" + ap.domain
+	}
+	if ap.extension != "" {
+		keys = append(keys, ap.lpart+"+"+ap.extension+at)
+	}
+	keys = append(keys, ap.lpart+at)
+	if catchall && ap.domain != "" {
+		keys = append(keys, at)
+	}
+	return keys
+}
+
+// withExtension
+// postfix puts an unmatched extension back onto the result of a
+// canonical or virtual lookup (propagate_unmatched_extensions)
+func withExtension(r string, ap *AddressParts, key string) string {
+	if ap.extension == "" || strings.Contains(key, "+") {
+		return r
+	}
+	rp, err := DecodeRFC822(r)
+	if err != nil || rp.lpart == "" || rp.extension != "" {
+		return r
+	}
+	rp.extension = ap.extension
+	return rp.String()
+}
+
+// Explain
+// Follow what postfix does with mail for rcpt through the database:
+// recipient access, canonical rewriting, virtual alias expansion,
+// the domain class and then local aliases, relay recipients or the
+// mailbox, and the transport.
+func (mdb *MailDB) Explain(rcpt string) (*Delivery, error) {
+	ap, err := DecodeRFC822(rcpt)
+	if err != nil {
+		return nil, err
+	}
+	if ap.lpart == "" {
+		return nil, ErrMdbNotRecipient
+	}
+	dl := &Delivery{
+		address: ap.String(),
+	}
+	if err = mdb.explainAccess(dl, ap); err != nil {
+		return nil, err
+	}
+	if err = mdb.explain(dl, ap, nil, true); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+// explainAccess
+// check_recipient_access in smtpd, before anything else
+func (mdb *MailDB) explainAccess(dl *Delivery, ap *AddressParts) error {
+	var (
+		rc     *RestrictionClass
+		action string
+		err    error
+	)
+
+	q := `SELECT access_key FROM address_access WHERE username = ? AND domain_name = ?`
+	switch err = mdb.db.QueryRow(q, ap.lpart, ap.domain).Scan(&action); err {
+	case sql.ErrNoRows:
+		return nil
+	case nil:
+	default:
+		return err
+	}
+	dl.note("recipient access: %s", action)
+	f := strings.Fields(action)
+	if len(f) == 0 {
+		return nil
+	}
+	if IsBuiltinAction(action) {
+		verb := strings.ToLower(f[0])
+		if verb == "reject" || verb == "defer" || verb[0] == '4' || verb[0] == '5' {
+			dl.problem("rejected by recipient access %s", action)
+		}
+		return nil
+	}
+	if rc, err = mdb.LookupRestrictionClass(f[0]); err == ErrMdbClassNotFound {
+		dl.problem("recipient access %s is not a defined restriction class", f[0])
+		return nil
+	} else if err != nil {
+		return err
+	}
+	dl.note("restriction class %s: %s", rc.Name(), rc.Restrictions())
+	return nil
+}
+
+// explain
+// The rest of the lookups for dl whose address is ap. The path is the
+// addresses expanded to get here. Virtual alias expansion stops at an
+// address that maps to itself.
+func (mdb *MailDB) explain(dl *Delivery, ap *AddressParts, path []string, virtual bool) error {
+	var (
+		key  string
+		vals []string
+		err  error
+	)
+
+	if len(path) == 0 { // cleanup rewrites once, on the way in
+		q := `SELECT target FROM recipient_canonical WHERE address = ?`
+		if key, vals, err = mdb.mapLookup(q, addrKeys(ap, true)...); err != nil {
+			return err
+		}
+		if len(vals) > 0 {
+			target := vals[0]
+			if strings.HasPrefix(target, "@") {
+				target = ap.lpart + target
+			}
+			target = withExtension(target, ap, key)
+			dl.note("recipient canonical %s: rewritten to %s", key, target)
+			if ap, err = DecodeRFC822(target); err != nil {
+				dl.problem("canonical target %s, %s", target, err)
+				return nil
+			}
+		}
+	}
+	addr := ap.String()
+	path = append(path, addr)
+
+	if virtual && ap.domain != "" {
+		q := `SELECT recipient FROM virt_alias WHERE mailbox || '@' || domain_name = ?`
+		if key, vals, err = mdb.mapLookup(q, addrKeys(ap, true)...); err != nil {
+			return err
+		}
+		if len(vals) > 0 {
+			dl.note("virtual alias %s: %s", key, strings.Join(vals, ", "))
+			return mdb.expand(dl, ap, key, vals, path)
+		}
+	}
+
+	q := `SELECT target FROM recipient_bcc WHERE address = ?`
+	if key, vals, err = mdb.mapLookup(q, addrKeys(ap, true)...); err != nil {
+		return err
+	}
+	if len(vals) > 0 {
+		dl.note("recipient bcc %s: copied to %s", key, vals[0])
+	}
+
+	if ap.domain == "" {
+		dl.note("domain: none, a local name in $myorigin")
+		return mdb.explainLocal(dl, ap, path)
+	}
+	d, err := mdb.LookupDomain(ap.domain)
+	if err == ErrMdbDomainNotFound {
+		dl.note("domain %s: not in the database, sent to the internet", ap.domain)
+		return mdb.explainTransport(dl, ap, nil)
+	} else if err != nil {
+		return err
+	}
+	if d.IsAlias() {
+		dl.note("domain %s: %s, an alias of %s", d.Name(), d.Class(), d.AliasOf())
+	} else {
+		dl.note("domain %s: %s", d.Name(), d.Class())
+	}
+
+	q = `SELECT target FROM relocated_map WHERE address = ?`
+	if key, vals, err = mdb.mapLookup(q, addrKeys(ap, true)...); err != nil {
+		return err
+	}
+	if len(vals) > 0 {
+		dl.problem("relocated %s: bounced as moved to %s", key, vals[0])
+		return nil
+	}
+
+	switch {
+	case d.IsLocal():
+		return mdb.explainLocal(dl, ap, path)
+	case d.IsRelay():
+		var n int64
+		qr := `SELECT count(*) FROM address_relay WHERE username = ? AND domain_name = ?`
+		if err = mdb.db.QueryRow(qr, ap.lpart, ap.domain).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			dl.problem("dead end: not a relay recipient, rejected as unknown")
+			return nil
+		}
+		dl.note("relay recipient")
+	case d.IsVirtual():
+		if d.IsAlias() {
+			dl.problem("dead end: %s has no %s@%s, rejected as unknown", d.AliasOf(), ap.lpart, d.AliasOf())
+		} else {
+			dl.problem("dead end: no virtual alias, rejected as unknown")
+		}
+		return nil
+	case d.IsVmailbox():
+		if done, err := mdb.explainMailbox(dl, ap); err != nil || done {
+			return err
+		}
+	}
+	return mdb.explainTransport(dl, ap, d)
+}
+
+// expand
+// The targets of a virtual alias
+func (mdb *MailDB) expand(dl *Delivery, ap *AddressParts, key string, vals []string, path []string) error {
+	addr := ap.String()
+	for _, v := range vals {
+		r := withExtension(v, ap, key)
+		t := &Delivery{
+			address: r,
+		}
+		dl.targets = append(dl.targets, t)
+		rp, err := DecodeRFC822(r)
+		if err != nil {
+			t.problem("dead end: %s", err)
+			continue
+		}
+		if rp.String() == addr {
+			t.note("maps to itself, no more expansion")
+			if err = mdb.explain(t, rp, path, false); err != nil {
+				return err
+			}
+			continue
+		}
+		if loop := inPath(rp.String(), path); loop != "" {
+			t.problem("loop: %s", loop)
+			continue
+		}
+		if err = mdb.explain(t, rp, path, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inPath
+// the loop back to addr if it has already been expanded
+func inPath(addr string, path []string) string {
+	for i, p := range path {
+		if p == addr {
+			return strings.Join(append(path[i:], addr), " -> ")
+		}
+	}
+	return ""
+}
+
+// explainLocal
+// local(8) looks up the aliases and then delivers to the account
+func (mdb *MailDB) explainLocal(dl *Delivery, ap *AddressParts, path []string) error {
+	q := `SELECT recipient FROM etc_aliases WHERE local_user = ?`
+	keys := []string{ap.lpart}
+	if ap.extension != "" {
+		keys = []string{ap.lpart + "+" + ap.extension, ap.lpart}
+	}
+	key, vals, err := mdb.mapLookup(q, keys...)
+	if err != nil {
+		return err
+	}
+	if len(vals) == 0 {
+		if _, err = user.Lookup(ap.lpart); err != nil {
+			dl.problem("dead end: no local alias or account %s", ap.lpart)
+			return nil
+		}
+		dl.note("local account %s", ap.lpart)
+		return mdb.explainTransport(dl, ap, nil)
+	}
+	dl.note("local alias %s: %s", key, strings.Join(vals, ", "))
+	for _, v := range vals {
+		t := &Delivery{
+			address: v,
+		}
+		dl.targets = append(dl.targets, t)
+		switch {
+		case strings.HasPrefix(v, "|") || strings.HasPrefix(v, "\"|"):
+			t.note("delivered by local(8) to the command")
+			continue
+		case strings.HasPrefix(v, "/"):
+			t.note("delivered by local(8) to the file")
+			continue
+		case strings.HasPrefix(v, ":include:"):
+			t.note("expanded by local(8) from the file")
+			continue
+		}
+		rp, err := DecodeRFC822(v)
+		if err != nil {
+			t.problem("dead end: %s", err)
+			continue
+		}
+		if rp.domain == "" && rp.lpart == ap.lpart {
+			// local(8) delivers to the account of an alias that names itself
+			t.note("maps to itself, no more expansion")
+			if _, err = user.Lookup(rp.lpart); err != nil {
+				t.problem("dead end: no local account %s", rp.lpart)
+			}
+			continue
+		}
+		if loop := inPath(rp.String(), path); loop != "" {
+			t.problem("loop: %s", loop)
+			continue
+		}
+		if err = mdb.explain(t, rp, path, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// explainMailbox
+// The dovecot mailbox. Done is true if the mail goes no further
+func (mdb *MailDB) explainMailbox(dl *Delivery, ap *AddressParts) (bool, error) {
+	var (
+		home, quota string
+		uid, gid    sql.NullInt64
+		enable      bool
+	)
+
+	q := `
+SELECT home, quota_rule, uid, gid, enable FROM user_mailbox
+ WHERE username = ? AND domain = ?
+`
+	switch err := mdb.db.QueryRow(q, ap.lpart, ap.domain).Scan(&home, &quota, &uid, &gid, &enable); err {
+	case sql.ErrNoRows:
+		dl.problem("dead end: no mailbox, rejected as unknown")
+		return true, nil
+	case nil:
+	default:
+		return true, err
+	}
+	if home == "" {
+		home = "mail_home"
+	}
+	ids := "--"
+	if uid.Valid && gid.Valid {
+		ids = fmt.Sprintf("%d/%d", uid.Int64, gid.Int64)
+	}
+	dl.note("mailbox %s@%s: home %s, uid/gid %s, quota %s", ap.lpart, ap.domain, home, ids, quota)
+	if !enable {
+		dl.problem("mailbox %s@%s is disabled, dovecot refuses delivery", ap.lpart, ap.domain)
+	}
+	if !uid.Valid || !gid.Valid {
+		dl.problem("mailbox %s@%s has no uid or gid", ap.lpart, ap.domain)
+	}
+	mbox := ap.lpart + "@" + ap.domain
+	if v, err := mdb.LookupVacation(mbox); err == nil {
+		dl.note("vacation auto-reply: start %s, end %s", v.Start(), v.End())
+	} else if err != ErrMdbVacationNotFound {
+		return true, err
+	}
+	if slist, err := mdb.FindSieve(mbox, "*"); err == nil {
+		for _, s := range slist {
+			if s.IsActive() {
+				dl.note("sieve script %s", s.Name())
+			}
+		}
+	} else if err != ErrMdbSieveNotFound {
+		return true, err
+	}
+	return false, nil
+}
+
+// explainTransport
+// trivial-rewrite's choice of transport. The address_transport view has
+// the domain's transport for the addresses in the database but postfix
+// does not see it for any other address in the domain.
+func (mdb *MailDB) explainTransport(dl *Delivery, ap *AddressParts, d *Domain) error {
+	var (
+		tr  string
+		err error
+	)
+
+	q := `SELECT transport FROM address_transport WHERE username = ? AND domain_name = ?`
+	switch err = mdb.db.QueryRow(q, ap.lpart, ap.domain).Scan(&tr); err {
+	case sql.ErrNoRows:
+		def := "default_transport"
+		if ap.domain == "" || (d != nil && d.IsLocal()) {
+			def = "local_transport"
+		} else if d != nil && d.IsRelay() {
+			def = "relay_transport"
+		} else if d != nil && d.IsVmailbox() {
+			def = "virtual_transport"
+		}
+		dl.note("transport: $%s", def)
+		if d != nil && d.Transport() != "--" {
+			dl.note("domain transport %s is not used, the address is not in the database", d.Transport())
+		}
+		return nil
+	case nil:
+	default:
+		return err
+	}
+	dl.note("transport: %s", tr)
+	switch strings.SplitN(tr, ":", 2)[0] {
+	case "error":
+		dl.problem("bounced by the error transport")
+	case "discard":
+		dl.problem("discarded by the discard transport")
+	case "retry":
+		dl.problem("deferred by the retry transport")
+	}
+	return nil
+}
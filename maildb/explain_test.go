@@ -0,0 +1,152 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestExplain
+func TestExplain(t *testing.T) {
+	var (
+		err error
+		mdb *MailDB
+		d   *Domain
+		a   *Address
+		mb  *VMailbox
+		dir string
+		dl  *Delivery
+	)
+
+	fmt.Printf("Explain Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		err = d.SetVUid(5000)
+	}
+	if err == nil {
+		err = d.SetVGid(5000)
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		mb, err = mdb.InsertVMailbox("leia@skywalker")
+	}
+	if err == nil {
+		err = mb.Disable()
+	}
+	for _, al := range [][]string{
+		{"jedi@skywalker", "luke@skywalker"},
+		{"jedi@skywalker", "yoda@dagobah"},
+		{"ping@skywalker", "pong@skywalker"},
+		{"pong@skywalker", "ping@skywalker"},
+		{"royals@skywalker", "leia@skywalker"},
+	} {
+		if err == nil {
+			if a, err = mdb.GetOrInsAddress(al[0]); err == nil {
+				err = a.AttachAlias(al[1])
+			}
+		}
+	}
+	if err == nil {
+		_, err = mdb.InsertRelocated("anakin@skywalker", "vader@empire")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+
+	if _, err = mdb.Explain("@skywalker"); err != ErrMdbNotRecipient {
+		t.Errorf("Explain @skywalker, expected error, got %v", err)
+	}
+
+	// an alias with the extension carried over
+	if dl, err = mdb.Explain("jedi+council@skywalker"); err != nil {
+		t.Errorf("Explain jedi, %s", err)
+		return
+	}
+	if dl.HasProblems() {
+		t.Errorf("Explain jedi: should not have problems")
+	}
+	if len(dl.Targets()) != 2 || dl.Targets()[0].Address() != "luke+council@skywalker" ||
+		dl.Targets()[1].Address() != "yoda+council@dagobah" {
+		t.Errorf("Explain jedi: unexpected targets")
+	} else {
+		luke := strings.Join(dl.Targets()[0].Notes(), "\n")
+		if !strings.Contains(luke, "domain skywalker: vmailbox") ||
+			!strings.Contains(luke, "mailbox luke@skywalker:") ||
+			!strings.Contains(luke, "transport: $virtual_transport") {
+			t.Errorf("Explain jedi: unexpected notes for luke, got %s", luke)
+		}
+		yoda := strings.Join(dl.Targets()[1].Notes(), "\n")
+		// the alias put the domain in the database
+		if !strings.Contains(yoda, "domain dagobah: internet") ||
+			!strings.Contains(yoda, "transport: $default_transport") {
+			t.Errorf("Explain jedi: unexpected notes for yoda, got %s", yoda)
+		}
+	}
+
+	// the problems
+	for _, p := range []struct {
+		rcpt, problem string
+	}{
+		{"ping@skywalker", "loop: ping@skywalker -> pong@skywalker -> ping@skywalker"},
+		{"royals@skywalker", "mailbox leia@skywalker is disabled, dovecot refuses delivery"},
+		{"han@skywalker", "dead end: no mailbox, rejected as unknown"},
+		{"anakin@skywalker", "relocated anakin@skywalker: bounced as moved to vader@empire"},
+	} {
+		if dl, err = mdb.Explain(p.rcpt); err != nil {
+			t.Errorf("Explain %s, %s", p.rcpt, err)
+			continue
+		}
+		if !dl.HasProblems() {
+			t.Errorf("Explain %s: should have problems", p.rcpt)
+		}
+		for len(dl.Problems()) == 0 && len(dl.Targets()) > 0 {
+			dl = dl.Targets()[0]
+		}
+		if len(dl.Problems()) == 0 || dl.Problems()[0] != p.problem {
+			t.Errorf("Explain %s: expected %s, got %v", p.rcpt, p.problem, dl.Problems())
+		}
+	}
+}
@@ -24,6 +24,53 @@ CREATE TABLE "Access" (
        action TEXT NOT NULL
        );
 
+-- RestrictionClass table
+-- The smtpd_restriction_classes definitions in main.cf. An Access
+-- action names one of these or is one of the access(5) actions.
+DROP TABLE IF EXISTS "RestrictionClass";
+CREATE TABLE "RestrictionClass" (
+       id INTEGER PRIMARY KEY,
+       name TEXT UNIQUE NOT NULL,
+       restrictions TEXT NOT NULL
+       );
+
+-- AccessCheck table
+-- The check_client_access, check_sender_access and check_helo_access
+-- data. The pattern is decoded and validated by postdove, an IP address
+-- or CIDR network, a host or domain name, or a sender address. The
+-- lookup is the key postfix uses for the pattern in a sqlite table, NULL
+-- for a network that only a cidr table can match.
+DROP TABLE IF EXISTS "AccessCheck";
+CREATE TABLE "AccessCheck" (
+       id INTEGER PRIMARY KEY,
+       class INTEGER NOT NULL,	-- 0 == client, 1 == sender, 2 == helo
+       pattern TEXT NOT NULL,
+       lookup TEXT,
+       access INTEGER NOT NULL,
+       CONSTRAINT check_access FOREIGN KEY(access) REFERENCES Access(id),
+       UNIQUE(class, pattern));
+
+-- client_access
+DROP VIEW IF EXISTS client_access;
+CREATE VIEW client_access AS
+       SELECT c.lookup AS client, ac.action AS access_key
+       FROM accesscheck AS c, access AS ac
+       WHERE c.class = 0 AND c.access = ac.id AND c.lookup IS NOT NULL;
+
+-- sender_access
+DROP VIEW IF EXISTS sender_access;
+CREATE VIEW sender_access AS
+       SELECT c.lookup AS sender, ac.action AS access_key
+       FROM accesscheck AS c, access AS ac
+       WHERE c.class = 1 AND c.access = ac.id;
+
+-- helo_access
+DROP VIEW IF EXISTS helo_access;
+CREATE VIEW helo_access AS
+       SELECT c.lookup AS helo, ac.action AS access_key
+       FROM accesscheck AS c, access AS ac
+       WHERE c.class = 2 AND c.access = ac.id;
+
 -- transport table
 DROP TABLE IF EXISTS "Transport";
 CREATE TABLE "Transport" (
@@ -34,6 +81,27 @@ CREATE TABLE "Transport" (
        UNIQUE (transport,nexthop)
        );
 
+-- SMTP client credentials for a transport's nexthop. They are in the
+-- "sasl" database, a file of its own that only postfix can read, see
+-- SaslDbPath. A foreign key or trigger cannot reach across files so
+-- postdove keeps the id and the copy of the nexthop that the
+-- sasl_password query needs in step with the transport.
+DROP TABLE IF EXISTS sasl."SaslPassword";
+CREATE TABLE sasl."SaslPassword" (
+       id INTEGER PRIMARY KEY,	-- same as the transport's id
+       nexthop TEXT,		-- same as the transport's nexthop
+       username TEXT NOT NULL,
+       password TEXT
+       );
+
+-- sasl_password
+-- smtp_sasl_password_maps, nexthop to username:password
+DROP VIEW IF EXISTS sasl.sasl_password;
+CREATE VIEW sasl.sasl_password AS
+       SELECT nexthop, username || ':' || password AS credentials
+       FROM saslpassword
+       WHERE nexthop IS NOT NULL AND password IS NOT NULL;
+
 -- domain table
 DROP INDEX IF EXISTS domain_name;
 DROP TABLE IF EXISTS "Domain";
@@ -46,8 +114,12 @@ CREATE TABLE "Domain" (
        access INTEGER,
        vuid INTEGER,		-- virtual UID for dovecot general mboxes
        vgid INTEGER,		-- virtual GID
+       alias_of INTEGER,	-- alias domain, localparts resolve in this domain
+       sender_transport INTEGER, -- relay for mail sent from this domain
        CONSTRAINT dom_trans FOREIGN KEY(transport) REFERENCES Transport(id),
-       CONSTRAINT dom_access FOREIGN KEY(access) REFERENCES Access(id)
+       CONSTRAINT dom_access FOREIGN KEY(access) REFERENCES Access(id),
+       CONSTRAINT dom_alias FOREIGN KEY(alias_of) REFERENCES Domain(id),
+       CONSTRAINT dom_strans FOREIGN KEY(sender_transport) REFERENCES Transport(id)
        );
 
 CREATE UNIQUE INDEX domain_name ON domain(name);
@@ -104,9 +176,11 @@ CREATE TABLE "Address" (
        domain INTEGER,
        transport INTEGER,
        access INTEGER,
+       sender_transport INTEGER, -- relay for mail sent from this address
        CONSTRAINT addr_domain FOREIGN KEY(domain) REFERENCES Domain(id),
        CONSTRAINT addr_trans FOREIGN KEY(transport) REFERENCES Transport(id),
        CONSTRAINT addr_access FOREIGN KEY(access) REFERENCES Access(id)
+       CONSTRAINT addr_strans FOREIGN KEY(sender_transport) REFERENCES Transport(id)
        UNIQUE (localpart, domain)
        );
 
@@ -134,12 +208,15 @@ CREATE TRIGGER addr_update_null_check BEFORE UPDATE ON Address
      END;  END;
 
 -- create a trigger to delete the domain when addr refs are 0 meaning this is the only
--- one pointing to it and domain.class != vmailbox
+-- one pointing to it and domain.class != vmailbox. An alias domain pointing to it
+-- also keeps it around.
 DROP TRIGGER  IF EXISTS after_addr_del;
 CREATE TRIGGER after_addr_del AFTER DELETE ON address
  WHEN OLD.domain IS NOT NULL
     AND (SELECT class FROM domain WHERE id = OLD.domain) != 4
     AND (SELECT count(*) FROM address WHERE domain = OLD.domain) < 1
+    AND (SELECT count(*) FROM domain WHERE alias_of = OLD.domain) < 1
+    AND (SELECT count(*) FROM sendas WHERE domain = OLD.domain) < 1
  BEGIN
   DELETE FROM domain WHERE id = OLD.domain; END;
 
@@ -182,6 +259,38 @@ CREATE VIEW "address_relay" AS
        FROM address AS a, domain AS d
        WHERE a.domain = d.id AND d.class = 2;
 
+-- sender_transport
+-- sender_dependent_default_transport_maps, sender address or @domain
+-- to transport:nexthop. Postfix falls back to the @domain lookup itself
+-- so an address does not inherit its domain's sender transport here.
+DROP VIEW IF EXISTS "sender_transport";
+CREATE VIEW "sender_transport" AS
+       SELECT a.localpart || '@' || d.name AS sender,
+              COALESCE(tr.transport, '') || ':' || COALESCE(tr.nexthop, '') AS transport
+	FROM address AS a
+	JOIN domain AS d ON (a.domain = d.id)
+	JOIN transport AS tr ON (a.sender_transport = tr.id)
+  UNION ALL
+       SELECT '@' || d.name AS sender,
+              COALESCE(tr.transport, '') || ':' || COALESCE(tr.nexthop, '') AS transport
+	FROM domain AS d
+	JOIN transport AS tr ON (d.sender_transport = tr.id);
+
+-- sender_relayhost
+-- sender_dependent_relayhost_maps only wants the nexthop
+DROP VIEW IF EXISTS "sender_relayhost";
+CREATE VIEW "sender_relayhost" AS
+       SELECT a.localpart || '@' || d.name AS sender, tr.nexthop AS relayhost
+	FROM address AS a
+	JOIN domain AS d ON (a.domain = d.id)
+	JOIN transport AS tr ON (a.sender_transport = tr.id)
+	WHERE tr.nexthop IS NOT NULL
+  UNION ALL
+       SELECT '@' || d.name AS sender, tr.nexthop AS relayhost
+	FROM domain AS d
+	JOIN transport AS tr ON (d.sender_transport = tr.id)
+	WHERE tr.nexthop IS NOT NULL;
+
 -- Alias table
 DROP TABLE IF EXISTS "Alias";
 CREATE TABLE "Alias" (
@@ -199,18 +308,30 @@ CREATE TABLE "Alias" (
 -- key (address) itself. Protect over-eager deletes by checking the reference
 -- linkage. This can cascade via the after_addr_del trigger to a domain.
 
--- Delete addresses so long as no other alias target or a vmailbox references it
+-- Delete addresses so long as no other alias target, a vmailbox, or a
+-- sender login references it
 DROP TRIGGER IF EXISTS after_alias_del_recip;
 CREATE TRIGGER after_alias_del_recip AFTER DELETE ON alias
  WHEN (SELECT count(*) FROM alias WHERE target = OLD.target) < 1
     AND (SELECT count(*) FROM vmailbox WHERE id = OLD.target) < 1
+    AND (SELECT count(*) FROM sendas WHERE address = OLD.target) < 1
+    AND (SELECT count(*) FROM canonical WHERE address = OLD.target) < 1
+    AND (SELECT count(*) FROM relocated WHERE address = OLD.target) < 1
+    AND (SELECT count(*) FROM bcc WHERE address = OLD.target) < 1
+    AND (SELECT count(*) FROM distlist WHERE id = OLD.target) < 1
   BEGIN
     DELETE FROM address WHERE id = OLD.target; END;
 
--- Delete addresses so long as no other alias references it as a target
+-- Delete addresses so long as no other alias, sender login, or canonical
+-- mapping references it
 DROP TRIGGER IF EXISTS after_alias_del_addr;
 CREATE TRIGGER after_alias_del_addr AFTER DELETE ON alias
  WHEN (SELECT count(*) FROM alias WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM sendas WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM canonical WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM relocated WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM bcc WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM distlist WHERE id = OLD.address) < 1
   BEGIN
     DELETE FROM address WHERE id = OLD.address; END;
 
@@ -230,7 +351,174 @@ CREATE VIEW "etc_aliases" AS
 	       FROM address ta WHERE ta.id = al.target)
          END) AS recipient
   FROM alias AS al, address AS aa
-  WHERE al.address IS aa.id AND aa.domain IS NULL;
+  WHERE al.address IS aa.id AND aa.domain IS NULL
+  UNION ALL
+-- the members of local distribution lists
+  SELECT la.localpart AS local_user, lm.member AS recipient
+  FROM listmember AS lm
+  JOIN address AS la ON (lm.list = la.id)
+  WHERE la.domain IS NULL
+  UNION ALL
+-- and "owner-list" for their owners. local(8) uses it as the sender
+-- of the copies so bounces go to the owner.
+  SELECT 'owner-' || la.localpart AS local_user, dl.owner AS recipient
+  FROM distlist AS dl
+  JOIN address AS la ON (dl.id = la.id)
+  WHERE la.domain IS NULL AND dl.owner IS NOT NULL
+     AND (SELECT count(*) FROM address
+          WHERE localpart = 'owner-' || la.localpart AND domain IS NULL) < 1;
+
+-- Canonical table for canonical(5) address rewriting
+-- The key is an address, "user", "user@domain", or "@domain" for the
+-- whole domain. The target is kept as text because it is usually
+-- somewhere else and "@domain" just replaces the domain part.
+DROP TABLE IF EXISTS "Canonical";
+CREATE TABLE "Canonical" (
+       id INTEGER PRIMARY KEY,
+       address INTEGER NOT NULL,
+       class INTEGER NOT NULL,	-- 0 == sender, 1 == recipient
+       target TEXT NOT NULL,
+       CONSTRAINT canon_addr FOREIGN KEY(address) REFERENCES Address(id) ON DELETE CASCADE,
+       UNIQUE(address, class));
+
+-- Delete the key address so long as nothing else references it
+DROP TRIGGER IF EXISTS after_canonical_del;
+CREATE TRIGGER after_canonical_del AFTER DELETE ON canonical
+ WHEN (SELECT count(*) FROM canonical WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM alias
+         WHERE address = OLD.address OR target = OLD.address) < 1
+    AND (SELECT count(*) FROM vmailbox WHERE id = OLD.address) < 1
+    AND (SELECT count(*) FROM sendas WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM relocated WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM bcc WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM distlist WHERE id = OLD.address) < 1
+  BEGIN
+    DELETE FROM address WHERE id = OLD.address; END;
+
+-- canonical_map
+-- the key in the form postfix looks it up and its rewrite
+DROP VIEW IF EXISTS "canonical_map";
+CREATE VIEW "canonical_map" AS
+       SELECT c.id AS id, c.class AS class,
+              (CASE WHEN a.domain IS NULL
+                    THEN a.localpart
+                    ELSE a.localpart || '@' || (SELECT name FROM domain WHERE id = a.domain)
+               END) AS address,
+              c.target AS target
+	FROM canonical AS c
+	JOIN address AS a ON (c.address = a.id);
+
+-- sender_canonical
+DROP VIEW IF EXISTS "sender_canonical";
+CREATE VIEW "sender_canonical" AS
+       SELECT address, target FROM canonical_map WHERE class = 0;
+
+-- recipient_canonical
+DROP VIEW IF EXISTS "recipient_canonical";
+CREATE VIEW "recipient_canonical" AS
+       SELECT address, target FROM canonical_map WHERE class = 1;
+
+-- Relocated table for relocated(5) bounces of departed users
+-- The key is the same as for canonical. The target is text for the
+-- bounce message, usually the new address.
+DROP TABLE IF EXISTS "Relocated";
+CREATE TABLE "Relocated" (
+       id INTEGER PRIMARY KEY,
+       address INTEGER NOT NULL UNIQUE,
+       target TEXT NOT NULL,
+       CONSTRAINT reloc_addr FOREIGN KEY(address) REFERENCES Address(id) ON DELETE CASCADE);
+
+-- Delete the key address so long as nothing else references it
+DROP TRIGGER IF EXISTS after_relocated_del;
+CREATE TRIGGER after_relocated_del AFTER DELETE ON relocated
+ WHEN (SELECT count(*) FROM alias
+         WHERE address = OLD.address OR target = OLD.address) < 1
+    AND (SELECT count(*) FROM vmailbox WHERE id = OLD.address) < 1
+    AND (SELECT count(*) FROM sendas WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM canonical WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM bcc WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM distlist WHERE id = OLD.address) < 1
+  BEGIN
+    DELETE FROM address WHERE id = OLD.address; END;
+
+-- relocated_map
+DROP VIEW IF EXISTS "relocated_map";
+CREATE VIEW "relocated_map" AS
+       SELECT r.id AS id,
+              (CASE WHEN a.domain IS NULL
+                    THEN a.localpart
+                    ELSE a.localpart || '@' || (SELECT name FROM domain WHERE id = a.domain)
+               END) AS address,
+              r.target AS target
+	FROM relocated AS r
+	JOIN address AS a ON (r.address = a.id);
+
+-- Bcc table for sender_bcc_maps and recipient_bcc_maps
+-- The key is the same as for canonical. The target is the one address
+-- that gets a blind copy, usually an archive mailbox somewhere.
+DROP TABLE IF EXISTS "Bcc";
+CREATE TABLE "Bcc" (
+       id INTEGER PRIMARY KEY,
+       address INTEGER NOT NULL,
+       class INTEGER NOT NULL,	-- 0 == sender, 1 == recipient
+       target TEXT NOT NULL,
+       CONSTRAINT bcc_addr FOREIGN KEY(address) REFERENCES Address(id) ON DELETE CASCADE,
+       UNIQUE(address, class));
+
+-- Delete the key address so long as nothing else references it
+DROP TRIGGER IF EXISTS after_bcc_del;
+CREATE TRIGGER after_bcc_del AFTER DELETE ON bcc
+ WHEN (SELECT count(*) FROM bcc WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM alias
+         WHERE address = OLD.address OR target = OLD.address) < 1
+    AND (SELECT count(*) FROM vmailbox WHERE id = OLD.address) < 1
+    AND (SELECT count(*) FROM sendas WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM canonical WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM relocated WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM distlist WHERE id = OLD.address) < 1
+  BEGIN
+    DELETE FROM address WHERE id = OLD.address; END;
+
+-- bcc_map
+DROP VIEW IF EXISTS "bcc_map";
+CREATE VIEW "bcc_map" AS
+       SELECT b.id AS id, b.class AS class,
+              (CASE WHEN a.domain IS NULL
+                    THEN a.localpart
+                    ELSE a.localpart || '@' || (SELECT name FROM domain WHERE id = a.domain)
+               END) AS address,
+              b.target AS target
+	FROM bcc AS b
+	JOIN address AS a ON (b.address = a.id);
+
+-- sender_bcc
+DROP VIEW IF EXISTS "sender_bcc";
+CREATE VIEW "sender_bcc" AS
+       SELECT address, target FROM bcc_map WHERE class = 0;
+
+-- recipient_bcc
+DROP VIEW IF EXISTS "recipient_bcc";
+CREATE VIEW "recipient_bcc" AS
+       SELECT address, target FROM bcc_map WHERE class = 1;
+
+-- archived
+-- The mailboxes and virtual aliases that get copied and where to.
+-- An entry for the address itself wins over the one for its domain
+-- the same way postfix looks them up.
+DROP VIEW IF EXISTS "archived";
+CREATE VIEW "archived" AS
+       SELECT x.address AS address, m.class AS class, m.target AS target
+	FROM (SELECT a.localpart || '@' || d.name AS address, d.name AS domain
+	      FROM address AS a
+	      JOIN domain AS d ON (a.domain = d.id)
+	      WHERE a.localpart != ''
+	        AND (a.id IN (SELECT id FROM vmailbox)
+		     OR a.id IN (SELECT address FROM alias))) AS x
+	JOIN bcc_map AS m
+	  ON (m.address = x.address
+	      OR (m.address = '@' || x.domain
+	          AND NOT EXISTS (SELECT id FROM bcc_map
+	                          WHERE address = x.address AND class = m.class)));
 
 -- virt_alias models the virtuals file where a line is
 --   alias    recipient
@@ -250,7 +538,73 @@ CREATE VIEW "virt_alias" AS
 	FROM Alias AS va
 	JOIN address AS aa ON (va.address = aa.id)
 	JOIN domain AS ad ON (aa.domain = ad.id)
-	JOIN address AS ta ON (va.target = ta.id);
+	JOIN address AS ta ON (va.target = ta.id)
+  UNION ALL
+-- alias domains map every mailbox or alias in the target domain
+-- unless the alias domain has its own entry for that localpart.
+-- An address that is only a recipient is not an entry.
+       SELECT ta.localpart AS mailbox, ad.name AS domain_name,
+              ta.localpart || '@' || td.name AS recipient
+	FROM domain AS ad
+	JOIN domain AS td ON (ad.alias_of = td.id)
+	JOIN address AS ta ON (ta.domain = td.id)
+	WHERE ta.localpart != ''
+	   AND ((SELECT count(*) FROM vmailbox WHERE id = ta.id) > 0
+	       OR (SELECT count(*) FROM alias WHERE address = ta.id) > 0)
+	   AND (SELECT count(*) FROM address AS oa
+	        WHERE oa.domain = ad.id AND oa.localpart = ta.localpart
+		  AND (oa.id IN (SELECT id FROM vmailbox)
+		       OR oa.id IN (SELECT address FROM alias)
+		       OR oa.id IN (SELECT id FROM distlist))) < 1
+  UNION ALL
+-- and the target domain's catch-all, unless the alias domain has its own
+       SELECT '' AS mailbox, ad.name AS domain_name,
+	      ta.localpart ||
+	      (CASE WHEN va.extension IS NOT NULL
+	      	    THEN '+' || va.extension
+		    ELSE ''
+	      END) ||
+	      (SELECT CASE WHEN ta.domain IS NULL
+		    THEN ''
+		    ELSE '@' || (SELECT name FROM domain WHERE id IS ta.domain)
+	      END) AS recipient
+	FROM domain AS ad
+	JOIN address AS ca ON (ca.domain = ad.alias_of AND ca.localpart = '')
+	JOIN alias AS va ON (va.address = ca.id)
+	JOIN address AS ta ON (va.target = ta.id)
+	WHERE (SELECT count(*) FROM alias AS oa
+	       JOIN address AS oc ON (oa.address = oc.id)
+	       WHERE oc.domain = ad.id AND oc.localpart = '') < 1
+  UNION ALL
+-- a catch-all (@domain) would also swallow mail for the domain's own
+-- mailboxes so map each of them to itself. Postfix tries the exact
+-- address first and never gets to the catch-all.
+       SELECT ma.localpart AS mailbox, md.name AS domain_name,
+              ma.localpart || '@' || md.name AS recipient
+	FROM vmailbox AS mb
+	JOIN address AS ma ON (mb.id = ma.id)
+	JOIN domain AS md ON (ma.domain = md.id)
+	WHERE (SELECT count(*) FROM alias AS ca
+	       JOIN address AS cd ON (ca.address = cd.id)
+	       WHERE cd.domain = md.id AND cd.localpart = '') > 0
+	   AND (SELECT count(*) FROM alias WHERE address = ma.id) < 1
+  UNION ALL
+-- the members of distribution lists in a domain
+       SELECT la.localpart AS mailbox, ld.name AS domain_name,
+              lm.member AS recipient
+	FROM listmember AS lm
+	JOIN address AS la ON (lm.list = la.id)
+	JOIN domain AS ld ON (la.domain = ld.id)
+  UNION ALL
+-- and owner-list@domain for their owners
+       SELECT 'owner-' || la.localpart AS mailbox, ld.name AS domain_name,
+              dl.owner AS recipient
+	FROM distlist AS dl
+	JOIN address AS la ON (dl.id = la.id)
+	JOIN domain AS ld ON (la.domain = ld.id)
+	WHERE dl.owner IS NOT NULL
+	   AND (SELECT count(*) FROM address
+	        WHERE localpart = 'owner-' || la.localpart AND domain = ld.id) < 1;
 
 -- vmailbox, dovecot user database
 DROP TABLE IF EXISTS "VMailbox";
@@ -292,6 +646,10 @@ CREATE TRIGGER before_del_mbox BEFORE DELETE ON vmailbox
 DROP TRIGGER IF EXISTS after_del_mbox;
 CREATE TRIGGER after_del_mbox AFTER DELETE ON vmailbox
  WHEN (SELECT count(*) FROM alias WHERE target = OLD.id) < 1
+    AND (SELECT count(*) FROM canonical WHERE address = OLD.id) < 1
+    AND (SELECT count(*) FROM relocated WHERE address = OLD.id) < 1
+    AND (SELECT count(*) FROM bcc WHERE address = OLD.id) < 1
+    AND (SELECT count(*) FROM distlist WHERE id = OLD.id) < 1
   BEGIN
     DELETE FROM address WHERE id = OLD.id; END;
 
@@ -326,7 +684,222 @@ DROP VIEW IF EXISTS "user_deny";
 CREATE VIEW "user_deny" AS
      SELECT username, domain, 'true' AS deny
      FROM user_mailbox WHERE enable = 0;
+
+-- Vacation table
+-- The out of office auto-reply for a mailbox. The Sieve script that does
+-- the work is generated from this into the mailbox home.
+DROP TABLE IF EXISTS "Vacation";
+CREATE TABLE "Vacation" (
+       id INTEGER PRIMARY KEY,		-- the vmailbox
+       subject TEXT,			-- NULL lets dovecot make one
+       body TEXT NOT NULL,
+       start_date TEXT,			-- YYYY-MM-DD or NULL for now
+       end_date TEXT,			-- YYYY-MM-DD or NULL until cleared
+       days INTEGER NOT NULL DEFAULT 7,	-- between replies to a sender
+       addresses TEXT,			-- other addresses of the user, comma separated
+       CONSTRAINT vacation_mbox FOREIGN KEY(id) REFERENCES VMailbox(id) ON DELETE CASCADE);
+
+-- SieveScript table
+-- Sieve filters for a mailbox or, named "before" and "after", the ones
+-- dovecot runs around the mailbox scripts of every mailbox in a domain.
+-- A mailbox has at most one active script.
+DROP TABLE IF EXISTS "SieveScript";
+CREATE TABLE "SieveScript" (
+       id INTEGER PRIMARY KEY,
+       mailbox INTEGER,		-- owner vmailbox or NULL for a domain script
+       domain INTEGER,		-- owner domain or NULL for a mailbox script
+       name TEXT NOT NULL,
+       script TEXT NOT NULL,
+       active INTEGER NOT NULL DEFAULT 0,
+       CONSTRAINT sieve_mbox FOREIGN KEY(mailbox) REFERENCES VMailbox(id) ON DELETE CASCADE,
+       CONSTRAINT sieve_dom FOREIGN KEY(domain) REFERENCES Domain(id) ON DELETE CASCADE,
+       UNIQUE (mailbox, name),
+       UNIQUE (domain, name),
+       CHECK ((mailbox IS NULL AND domain IS NOT NULL) OR
+              (mailbox IS NOT NULL AND domain IS NULL)),
+       CHECK (domain IS NULL OR name IN ('before', 'after')));
+
+DROP INDEX IF EXISTS sieve_active;
+CREATE UNIQUE INDEX sieve_active ON SieveScript(mailbox) WHERE active = 1;
      
+-- SendAs table for smtpd_sender_login_maps
+-- An explicit grant for the login (a vmailbox) to use a sender address or,
+-- if domain is set instead, any address in that domain. A mailbox owning its
+-- own address and its virtual aliases is implied and not stored here.
+DROP TABLE IF EXISTS "SendAs";
+CREATE TABLE "SendAs" (
+       id INTEGER PRIMARY KEY,
+       address INTEGER,		-- sender address or NULL for the whole domain
+       domain INTEGER,		-- sender "@domain" or NULL for an address
+       login INTEGER NOT NULL,	-- vmailbox allowed to send
+       CONSTRAINT sendas_addr FOREIGN KEY(address) REFERENCES Address(id) ON DELETE CASCADE,
+       CONSTRAINT sendas_dom FOREIGN KEY(domain) REFERENCES Domain(id) ON DELETE CASCADE,
+       CONSTRAINT sendas_login FOREIGN KEY(login) REFERENCES VMailbox(id) ON DELETE CASCADE,
+       CHECK ((address IS NULL AND domain IS NOT NULL) OR
+              (address IS NOT NULL AND domain IS NULL)));
+
+-- Clean up a sender address that was only there for the grant.
+-- The after_addr_del trigger takes care of its domain.
+DROP TRIGGER IF EXISTS after_sendas_del;
+CREATE TRIGGER after_sendas_del AFTER DELETE ON sendas
+ WHEN OLD.address IS NOT NULL
+    AND (SELECT count(*) FROM sendas WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM alias
+         WHERE address = OLD.address OR target = OLD.address) < 1
+    AND (SELECT count(*) FROM vmailbox WHERE id = OLD.address) < 1
+    AND (SELECT count(*) FROM canonical WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM relocated WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM bcc WHERE address = OLD.address) < 1
+    AND (SELECT count(*) FROM distlist WHERE id = OLD.address) < 1
+  BEGIN
+    DELETE FROM address WHERE id = OLD.address; END;
+
+-- sender_login
+-- sender address (or @domain) and the SASL login names allowed to use it.
+-- Dovecot logins are the full mailbox address.
+-- A mailbox owns its own address and every virtual alias that ends up at
+-- it, through other aliases or an alias domain, the way virt_alias expands
+-- them. A catch-all or a distribution list is not a license to send as it.
+DROP VIEW IF EXISTS "sender_login";
+CREATE VIEW "sender_login" AS
+WITH RECURSIVE owned(sender, login) AS (
+       SELECT a.localpart || '@' || d.name, a.localpart || '@' || d.name
+	FROM vmailbox AS mb
+	JOIN address AS a ON (mb.id = a.id)
+	JOIN domain AS d ON (a.domain = d.id)
+  UNION
+       SELECT va.mailbox || '@' || va.domain_name, o.login
+	FROM virt_alias AS va
+	JOIN owned AS o
+	  ON (o.sender = va.recipient
+	      OR (instr(va.recipient, '+') BETWEEN 1 AND instr(va.recipient, '@')
+	          AND o.sender = substr(va.recipient, 1, instr(va.recipient, '+') - 1) ||
+	                         substr(va.recipient, instr(va.recipient, '@'))))
+	WHERE va.mailbox != ''
+	   AND (SELECT count(*) FROM distlist AS dl
+	        JOIN address AS la ON (dl.id = la.id)
+	        JOIN domain AS ld ON (la.domain = ld.id)
+	        WHERE ld.name = va.domain_name
+	          AND (la.localpart = va.mailbox
+	               OR ('owner-' || la.localpart = va.mailbox
+	                   AND dl.owner = va.recipient))) < 1)
+-- explicit grants for an address
+       SELECT sa.localpart || '@' || sd.name AS sender,
+              la.localpart || '@' || ld.name AS login
+	FROM sendas AS s
+	JOIN address AS sa ON (s.address = sa.id)
+	JOIN domain AS sd ON (sa.domain = sd.id)
+	JOIN address AS la ON (s.login = la.id)
+	JOIN domain AS ld ON (la.domain = ld.id)
+  UNION
+-- explicit grants for a whole domain
+       SELECT '@' || sd.name AS sender,
+              la.localpart || '@' || ld.name AS login
+	FROM sendas AS s
+	JOIN domain AS sd ON (s.domain = sd.id)
+	JOIN address AS la ON (s.login = la.id)
+	JOIN domain AS ld ON (la.domain = ld.id)
+  UNION
+-- and the implied ones
+       SELECT sender, login FROM owned;
+
+-- DistList table for distribution lists
+-- The list address is an address that is neither an alias nor a mailbox.
+-- The members are expanded straight into etc_aliases and virt_alias in
+-- place of an :include: file. They are kept as text, like canonical
+-- targets, because they are usually somewhere else.
+DROP TABLE IF EXISTS "DistList";
+CREATE TABLE "DistList" (
+       id INTEGER PRIMARY KEY,	-- same id as the list address
+       owner TEXT,		-- gets the bounces, NULL for none
+       CONSTRAINT list_addr FOREIGN KEY(id) REFERENCES Address(id));
+
+DROP TABLE IF EXISTS "ListMember";
+CREATE TABLE "ListMember" (
+       id INTEGER PRIMARY KEY,
+       list INTEGER NOT NULL,
+       member TEXT NOT NULL,
+       CONSTRAINT member_list FOREIGN KEY(list) REFERENCES DistList(id) ON DELETE CASCADE,
+       UNIQUE(list, member));
+
+-- A list address can be neither an alias nor a mailbox for the same
+-- reason an alias cannot be a mailbox.
+DROP TRIGGER IF EXISTS list_insert_check;
+CREATE TRIGGER list_insert_check BEFORE INSERT ON distlist
+ WHEN (SELECT count(*) FROM alias WHERE address = NEW.id) > 0
+    OR (SELECT count(*) FROM vmailbox WHERE id = NEW.id) > 0
+  BEGIN SELECT RAISE(FAIL, 'ErrMdbListAddress'); END;
+
+DROP TRIGGER IF EXISTS alias_insert_list_check;
+CREATE TRIGGER alias_insert_list_check BEFORE INSERT ON alias
+ WHEN (SELECT count(*) FROM distlist WHERE id = NEW.address) > 0
+  BEGIN SELECT RAISE(FAIL, 'New alias already a list'); END;
+
+DROP TRIGGER IF EXISTS mailbox_insert_list_check;
+CREATE TRIGGER mailbox_insert_list_check BEFORE INSERT ON vmailbox
+ WHEN (SELECT count(*) FROM distlist WHERE id = NEW.id) > 0
+  BEGIN SELECT RAISE(FAIL, 'New mailbox already a list'); END;
+
+-- Delete the list address so long as nothing else references it
+DROP TRIGGER IF EXISTS after_list_del;
+CREATE TRIGGER after_list_del AFTER DELETE ON distlist
+ WHEN (SELECT count(*) FROM alias WHERE target = OLD.id) < 1
+    AND (SELECT count(*) FROM sendas WHERE address = OLD.id) < 1
+    AND (SELECT count(*) FROM canonical WHERE address = OLD.id) < 1
+    AND (SELECT count(*) FROM relocated WHERE address = OLD.id) < 1
+    AND (SELECT count(*) FROM bcc WHERE address = OLD.id) < 1
+  BEGIN
+    DELETE FROM address WHERE id = OLD.id; END;
+
+-- dist_list
+-- the lists by their address
+DROP VIEW IF EXISTS "dist_list";
+CREATE VIEW "dist_list" AS
+       SELECT dl.id AS id,
+              (CASE WHEN a.domain IS NULL
+                    THEN a.localpart
+                    ELSE a.localpart || '@' || (SELECT name FROM domain WHERE id = a.domain)
+               END) AS address,
+              dl.owner AS owner
+	FROM distlist AS dl
+	JOIN address AS a ON (dl.id = a.id);
+
+-- SendLimit table
+-- What a mailbox, or each mailbox in a domain, may send through the
+-- policy server. A NULL limit is no limit. The limits of a mailbox are
+-- used in place of those of its domain.
+DROP TABLE IF EXISTS "SendLimit";
+CREATE TABLE "SendLimit" (
+       id INTEGER PRIMARY KEY,
+       mailbox INTEGER,		-- the vmailbox or NULL for a domain
+       domain INTEGER,		-- the domain or NULL for a mailbox
+       hourly INTEGER,		-- messages per hour
+       recipients INTEGER,	-- recipients per message
+       daily INTEGER,		-- recipients per day
+       action TEXT NOT NULL DEFAULT 'DEFER', -- DEFER or REJECT when over
+       disable INTEGER NOT NULL DEFAULT 0, -- bool to disable the mailbox too
+       CONSTRAINT limit_mbox FOREIGN KEY(mailbox) REFERENCES VMailbox(id) ON DELETE CASCADE,
+       CONSTRAINT limit_dom FOREIGN KEY(domain) REFERENCES Domain(id) ON DELETE CASCADE,
+       UNIQUE (mailbox),
+       UNIQUE (domain),
+       CHECK ((mailbox IS NULL AND domain IS NOT NULL) OR
+              (mailbox IS NOT NULL AND domain IS NULL)),
+       CHECK (action IN ('DEFER', 'REJECT')));
+
+-- SendCount table
+-- The messages a mailbox has sent in the last day, kept by the policy
+-- server to check the limits against.
+DROP INDEX IF EXISTS sendcount_mbox;
+DROP TABLE IF EXISTS "SendCount";
+CREATE TABLE "SendCount" (
+       id INTEGER PRIMARY KEY,
+       mailbox INTEGER NOT NULL,
+       sent INTEGER NOT NULL,	-- unix time
+       recipients INTEGER NOT NULL,
+       CONSTRAINT count_mbox FOREIGN KEY(mailbox) REFERENCES VMailbox(id) ON DELETE CASCADE);
+
+CREATE INDEX sendcount_mbox ON SendCount(mailbox, sent);
+
 -- backscatter and catchall here are for example. I don't do it so
 -- scratch this bit.
 --
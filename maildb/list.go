@@ -0,0 +1,367 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// DistList
+// A distribution list. Mail to the list address goes to every member and
+// bounces go to the owner by way of the "owner-list" address
+type DistList struct {
+	mdb     *MailDB
+	id      int64
+	address string
+	owner   sql.NullString
+	members []string
+}
+
+// listAddress
+// A list is a local name or a full address but not a catch-all
+func listAddress(addr string) (string, error) {
+	ap, err := DecodeRFC822(addr)
+	if err != nil {
+		return "", err
+	}
+	if ap.IsCatchall() || ap.extension != "" {
+		return "", ErrMdbListAddress
+	}
+	return mapKey(addr)
+}
+
+// listMember
+// A member or owner is a local name or a full address
+func listMember(member string) (string, error) {
+	ap, err := DecodeRFC822(member)
+	if err != nil {
+		return "", err
+	}
+	if ap.IsCatchall() {
+		return "", ErrMdbListMember
+	}
+	return ap.String(), nil
+}
+
+// Address
+func (l *DistList) Address() string {
+	return l.address
+}
+
+// Owner
+func (l *DistList) Owner() string {
+	if l.owner.Valid {
+		return l.owner.String
+	}
+	return "--"
+}
+
+// Members
+func (l *DistList) Members() []string {
+	return l.members
+}
+
+// Export
+// The members in the :include: file format, one per line
+func (l *DistList) Export() string {
+	var (
+		line strings.Builder
+	)
+
+	for _, m := range l.members {
+		fmt.Fprintf(&line, "%s\n", m)
+	}
+	return line.String()
+}
+
+// scanMembers
+// fill in the members from the query
+func (l *DistList) scanMembers(rows *sql.Rows, err error) error {
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	l.members = nil
+	for rows.Next() {
+		var m string
+		if err = rows.Scan(&m); err != nil {
+			return err
+		}
+		l.members = append(l.members, m)
+	}
+	return rows.Err()
+}
+
+const qListMembers = `SELECT member FROM listmember WHERE list = ? ORDER BY member`
+
+// LookupList
+// No transaction
+func (mdb *MailDB) LookupList(addr string) (*DistList, error) {
+	var (
+		k   string
+		err error
+	)
+
+	if k, err = listAddress(addr); err != nil {
+		return nil, err
+	}
+	l := &DistList{
+		mdb: mdb,
+	}
+	q := `SELECT id, address, owner FROM dist_list WHERE address = ?`
+	switch err = mdb.db.QueryRow(q, k).Scan(&l.id, &l.address, &l.owner); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbListNotFound
+	case nil:
+		if err = l.scanMembers(mdb.db.Query(qListMembers, l.id)); err != nil {
+			return nil, err
+		}
+		return l, nil
+	default:
+		return nil, err
+	}
+}
+
+// GetList
+// Same as LookupList but in the transaction
+func (mdb *MailDB) GetList(addr string) (*DistList, error) {
+	var (
+		k   string
+		err error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if k, err = listAddress(addr); err != nil {
+		return nil, err
+	}
+	l := &DistList{
+		mdb: mdb,
+	}
+	q := `SELECT id, address, owner FROM dist_list WHERE address = ?`
+	switch err = mdb.tx.QueryRow(q, k).Scan(&l.id, &l.address, &l.owner); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbListNotFound
+	case nil:
+		if err = l.scanMembers(mdb.tx.Query(qListMembers, l.id)); err != nil {
+			return nil, err
+		}
+		return l, nil
+	default:
+		return nil, err
+	}
+}
+
+// FindList
+// Return the lists whose address matches the pattern where '*'
+// matches anything. No transaction
+func (mdb *MailDB) FindList(pattern string) ([]*DistList, error) {
+	var (
+		rows  *sql.Rows
+		llist []*DistList
+		err   error
+	)
+
+	q := `SELECT id, address, owner FROM dist_list WHERE address LIKE ? ORDER BY address`
+	if rows, err = mdb.db.Query(q, strings.ReplaceAll(pattern, "*", "%")); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l := &DistList{
+			mdb: mdb,
+		}
+		if err = rows.Scan(&l.id, &l.address, &l.owner); err != nil {
+			return nil, err
+		}
+		llist = append(llist, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	} else if len(llist) == 0 {
+		return nil, ErrMdbListNotFound
+	}
+	rows.Close() // finished with it before the member queries
+	for _, l := range llist {
+		if err = l.scanMembers(mdb.db.Query(qListMembers, l.id)); err != nil {
+			return nil, err
+		}
+	}
+	return llist, nil
+}
+
+// InsertList
+// Make a new list with no members. The owner can be empty for none.
+// The list address is created if needed. Transaction required
+func (mdb *MailDB) InsertList(addr string, owner string) (*DistList, error) {
+	var (
+		k     string
+		o     sql.NullString
+		a     *Address
+		count int64
+		err   error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if k, err = listAddress(addr); err != nil {
+		return nil, err
+	}
+	if owner != "" {
+		if o.String, err = listMember(owner); err != nil {
+			return nil, err
+		}
+		o.Valid = true
+	}
+	if a, err = mdb.GetOrInsAddress(k); err != nil {
+		return nil, err
+	}
+	// a primary key collision is not a unique constraint error
+	qc := `SELECT count(*) FROM distlist WHERE id = ?`
+	if err = mdb.tx.QueryRow(qc, a.Id()).Scan(&count); err != nil {
+		return nil, err
+	} else if count > 0 {
+		return nil, ErrMdbDupList
+	}
+	if _, err = mdb.tx.Exec("INSERT INTO distlist (id, owner) VALUES (?, ?)", a.Id(), o); err != nil {
+		if err.Error() == "ErrMdbListAddress" {
+			err = ErrMdbListAddress
+		}
+		return nil, err
+	}
+	return &DistList{
+		mdb:     mdb,
+		id:      a.Id(),
+		address: k,
+		owner:   o,
+	}, nil
+}
+
+// SetOwner
+// Change the owner. An empty owner removes it. Transaction required
+func (l *DistList) SetOwner(owner string) error {
+	var (
+		o   sql.NullString
+		err error
+	)
+
+	if l.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if owner != "" {
+		if o.String, err = listMember(owner); err != nil {
+			return err
+		}
+		o.Valid = true
+	}
+	res, err := l.mdb.tx.Exec("UPDATE distlist SET owner = ? WHERE id = ?", o, l.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbBadUpdate
+	}
+	l.owner = o
+	return nil
+}
+
+// AddMember
+// Transaction required
+func (l *DistList) AddMember(member string) error {
+	var (
+		m   string
+		err error
+	)
+
+	if l.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if m, err = listMember(member); err != nil {
+		return err
+	}
+	if _, err = l.mdb.tx.Exec("INSERT INTO listmember (list, member) VALUES (?, ?)", l.id, m); err != nil {
+		if IsErrConstraintUnique(err) {
+			err = ErrMdbDupMember
+		}
+		return err
+	}
+	return l.scanMembers(l.mdb.tx.Query(qListMembers, l.id))
+}
+
+// RemoveMember
+// Transaction required
+func (l *DistList) RemoveMember(member string) error {
+	var (
+		m   string
+		err error
+	)
+
+	if l.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if m, err = listMember(member); err != nil {
+		return err
+	}
+	res, err := l.mdb.tx.Exec("DELETE FROM listmember WHERE list = ? AND member = ?", l.id, m)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbMemberNotFound
+	}
+	return l.scanMembers(l.mdb.tx.Query(qListMembers, l.id))
+}
+
+// DeleteList
+// Remove the list and its members. The list address goes too if
+// nothing else uses it. No transaction
+func (mdb *MailDB) DeleteList(addr string) error {
+	var (
+		k   string
+		res sql.Result
+		c   int64
+		err error
+	)
+
+	if k, err = listAddress(addr); err != nil {
+		return err
+	}
+	qd := `DELETE FROM distlist WHERE id = (SELECT id FROM dist_list WHERE address = ?)`
+	if res, err = mdb.db.Exec(qd, k); err != nil {
+		return err
+	}
+	if c, err = res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbListNotFound
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestDistList
+func TestDistList(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		d     *Domain
+		a     *Address
+		dir   string
+		l     *DistList
+		llist []*DistList
+		res   []QueryRes
+	)
+
+	fmt.Printf("Distribution List Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		a, err = mdb.InsertAddress("leia@skywalker")
+	}
+	if err == nil {
+		err = a.AttachAlias("luke@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+
+	// bad ones first
+	for _, b := range []struct {
+		list, owner string
+		err         error
+	}{
+		{"@skywalker", "", ErrMdbListAddress},
+		{"luke@skywalker", "", ErrMdbListAddress},
+		{"leia@skywalker", "", ErrMdbListAddress},
+		{"jedi@skywalker", "@rebels.org", ErrMdbListMember},
+	} {
+		mdb.Begin()
+		_, err = mdb.InsertList(b.list, b.owner)
+		mdb.End(&err)
+		if err == nil {
+			t.Errorf("Insert list %s should have failed", b.list)
+		} else if err != b.err {
+			t.Errorf("Insert list %s, %s", b.list, err)
+		}
+	}
+
+	mdb.Begin()
+	l, err = mdb.InsertList("jedi@skywalker", "Luke@Skywalker")
+	for _, m := range []string{"luke@skywalker", "yoda@dagobah.org", "ben"} {
+		if err == nil {
+			err = l.AddMember(m)
+		}
+	}
+	if err == nil {
+		_, err = mdb.InsertList("council", "")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert of jedi list, %s", err)
+		return
+	}
+	mdb.Begin()
+	_, err = mdb.InsertList("jedi@skywalker", "")
+	mdb.End(&err)
+	if err != ErrMdbDupList {
+		t.Errorf("Duplicate insert of jedi list, expected error, got %v", err)
+	}
+	mdb.Begin()
+	if l, err = mdb.GetList("jedi@skywalker"); err == nil {
+		err = l.AddMember("ben")
+	}
+	mdb.End(&err)
+	if err != ErrMdbDupMember {
+		t.Errorf("Duplicate member ben, expected error, got %v", err)
+	}
+	mdb.Begin()
+	if a, err = mdb.GetAddress("council"); err == nil {
+		err = a.AttachAlias("ben")
+	}
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Alias of list council should have failed")
+	}
+
+	if l, err = mdb.LookupList("jedi@skywalker"); err != nil {
+		t.Errorf("Lookup of jedi list, %s", err)
+	} else {
+		if l.Address() != "jedi@skywalker" || l.Owner() != "luke@skywalker" {
+			t.Errorf("Lookup of jedi list, got %s owned by %s", l.Address(), l.Owner())
+		}
+		if strings.Join(l.Members(), " ") != "ben luke@skywalker yoda@dagobah.org" {
+			t.Errorf("Lookup of jedi list, got members %v", l.Members())
+		}
+		if l.Export() != "ben\nluke@skywalker\nyoda@dagobah.org\n" {
+			t.Errorf("Export of jedi list, got %s", l.Export())
+		}
+	}
+	if llist, err = mdb.FindList("*"); err != nil {
+		t.Errorf("Find of all lists, %s", err)
+	} else if len(llist) != 2 || llist[0].Address() != "council" || llist[0].Owner() != "--" ||
+		len(llist[1].Members()) != 3 {
+		t.Errorf("Find of all lists, got %d lists", len(llist))
+	}
+
+	// postfix sees the members and the owner
+	if res, err = mdb.Query(`
+SELECT recipient FROM virt_alias WHERE mailbox || '@' || domain_name = 'jedi@skywalker'
+ ORDER BY recipient`); err != nil {
+		t.Errorf("virt_alias query, %s", err)
+	} else if len(res) != 3 || fmt.Sprint(res[0]["recipient"]) != "ben" {
+		t.Errorf("virt_alias query for jedi, got %v", res)
+	}
+	if res, err = mdb.Query(`
+SELECT recipient FROM virt_alias WHERE mailbox || '@' || domain_name = 'owner-jedi@skywalker'`); err != nil {
+		t.Errorf("virt_alias query, %s", err)
+	} else if len(res) != 1 || fmt.Sprint(res[0]["recipient"]) != "luke@skywalker" {
+		t.Errorf("virt_alias query for owner-jedi, got %v", res)
+	}
+	mdb.Begin()
+	if l, err = mdb.GetList("council"); err == nil {
+		err = l.AddMember("yoda@dagobah.org")
+	}
+	if err == nil {
+		err = l.SetOwner("mace")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Change council, %s", err)
+	}
+	if res, err = mdb.Query(`
+SELECT local_user, recipient FROM etc_aliases WHERE local_user LIKE '%council'
+ ORDER BY local_user`); err != nil {
+		t.Errorf("etc_aliases query, %s", err)
+	} else if len(res) != 2 || fmt.Sprint(res[0]["recipient"]) != "yoda@dagobah.org" ||
+		fmt.Sprint(res[1]["recipient"]) != "mace" {
+		t.Errorf("etc_aliases query for council, got %v", res)
+	}
+
+	// take them apart
+	mdb.Begin()
+	if l, err = mdb.GetList("jedi@skywalker"); err == nil {
+		err = l.RemoveMember("ben")
+	}
+	if err == nil {
+		err = l.RemoveMember("ben")
+	}
+	mdb.End(&err)
+	if err != ErrMdbMemberNotFound {
+		t.Errorf("Remove ben twice, expected error, got %v", err)
+	}
+	if err = mdb.DeleteList("jedi@skywalker"); err != nil {
+		t.Errorf("Delete jedi list, %s", err)
+	}
+	if err = mdb.DeleteList("jedi@skywalker"); err != ErrMdbListNotFound {
+		t.Errorf("Delete jedi list again, expected error, got %v", err)
+	}
+	if _, err = mdb.LookupAddress("jedi@skywalker"); err != ErrMdbAddressNotFound {
+		t.Errorf("List address jedi@skywalker should be gone, got %v", err)
+	}
+	if res, err = mdb.Query("SELECT count(*) AS n FROM listmember"); err != nil {
+		t.Errorf("listmember query, %s", err)
+	} else if fmt.Sprint(res[0]["n"]) != "1" {
+		t.Errorf("Members of jedi should be gone, got %v", res)
+	}
+}
@@ -305,6 +305,7 @@ func (mdb *MailDB) GetVMailbox(user string) (*VMailbox, error) {
 // must be under a transaction
 func (mdb *MailDB) InsertVMailbox(user string) (*VMailbox, error) {
 	var (
+		ap  *AddressParts
 		a   *Address
 		err error
 	)
@@ -312,14 +313,20 @@ func (mdb *MailDB) InsertVMailbox(user string) (*VMailbox, error) {
 	if mdb.tx == nil {
 		return nil, ErrMdbTransaction
 	}
-	// the domain must exist and be a vmailbox class
+	if ap, err = DecodeRFC822(user); err != nil {
+		return nil, err
+	} else if ap.IsCatchall() {
+		return nil, ErrMdbCatchallMbox
+	}
+	// the domain must exist and be a vmailbox or local class
 	// if we fail with a dup entry that could be either an already existing mbox
 	// or this address is an alias or something (which must be deleted before we can proceed)
 	if a, err = mdb.InsertAddress(user); err != nil {
 		return nil, err
 	}
-	// if we just created a new domain, it will be the default (not vmailbox) and fail
-	if !a.InVMailDomain() {
+	// if we just created a new domain, it will be the default (not vmailbox) and fail.
+	// A local domain can have mailboxes too, the logins of its system users
+	if !a.InVMailDomain() && !a.InLocalDomain() {
 		return nil, ErrMdbMboxNotMboxDomain
 	}
 	// Now we can insert the mailbox.
@@ -629,7 +636,8 @@ func (m *VMailbox) Disable() error {
 }
 
 // DeleteVMailbox
-// Potential cascaded delete of address is handled by triggers
+// Potential cascaded delete of address is handled by triggers.
+// Works inside or outside a transaction
 func (mdb *MailDB) DeleteVMailbox(address string) error {
 	var (
 		ap  *AddressParts
@@ -644,7 +652,12 @@ DELETE FROM vmailbox WHERE id =
   (SELECT a.id FROM address a, domain d
      WHERE a.domain = d.id AND a.localpart = ? AND d.name = ?)
 `
-	res, err := mdb.db.Exec(qd, ap.lpart, ap.domain)
+	var res sql.Result
+	if mdb.tx != nil {
+		res, err = mdb.tx.Exec(qd, ap.lpart, ap.domain)
+	} else {
+		res, err = mdb.db.Exec(qd, ap.lpart, ap.domain)
+	}
 	if err != nil {
 		if err.Error() == "ErrMdbMboxIsRecip" {
 			err = ErrMdbMboxIsRecip
@@ -87,6 +87,17 @@ func TestMailbox(t *testing.T) {
 	} else if err != ErrMdbMboxNotMboxDomain {
 		t.Errorf("Add of lost@nowhere, %s", err)
 	}
+	// a local domain can have the logins of its system users
+	mdb.Begin()
+	if d, err = mdb.InsertDomain("home.lan"); err == nil {
+		if err = d.SetClass("local"); err == nil {
+			_, err = mdb.InsertVMailbox("jane@home.lan")
+		}
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Add of jane@home.lan in a local domain, %s", err)
+	}
 	// see if we can add a user
 	mdb.Begin()
 	mb, err = mdb.InsertVMailbox("luke@skywalker")
@@ -22,12 +22,14 @@ package maildb
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"embed"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -47,6 +49,7 @@ var (
 	ErrMdbBadInclude        = errors.New("badly formed or empty include")
 	ErrMdbArgStringEmpty    = errors.New("Empty string not allowed here")
 	ErrMdbTransNoColon      = errors.New("No ':' separator")
+	ErrMdbBadNexthop        = errors.New("not a valid transport nexthop")
 	ErrMdbAddressNotFound   = errors.New("address not found")
 	ErrMdbDomainNotFound    = errors.New("domain not found")
 	ErrMdbDupAddress        = errors.New("Address already exists")
@@ -67,7 +70,7 @@ var (
 	ErrMdbRecipientNotFound = errors.New("alias recipient not found")
 	ErrMdbNoMailboxes       = errors.New("No Mailboxes")
 	ErrMdbMboxNoDomain      = errors.New("Mailbox must have a domain")
-	ErrMdbMboxNotMboxDomain = errors.New("Mailbox must be in a vmailbox domain")
+	ErrMdbMboxNotMboxDomain = errors.New("Mailbox must be in a vmailbox or local domain")
 	ErrMdbNotMbox           = errors.New("address is not a mailbox")
 	ErrMdbIsAlias           = errors.New("New mailbox already an alias")
 	ErrMdbIsMbox            = errors.New("New alias already a mailbox")
@@ -78,6 +81,59 @@ var (
 	ErrMdbBadGid            = errors.New("Group ID must be unsigned decimal integer")
 	ErrMdbBadUpdate         = errors.New("Update did not happen")
 	ErrMdbMboxIsRecip       = errors.New("Mailbox is an alias recipient")
+	ErrMdbDomainAliasLoop   = errors.New("Alias domain target cannot be itself or another alias domain")
+	ErrMdbDomainAliasClass  = errors.New("Alias domain must be virtual")
+	ErrMdbCatchallTarget    = errors.New("catch-all address cannot be an alias recipient")
+	ErrMdbCatchallDomain    = errors.New("catch-all must be in a virtual or vmailbox domain")
+	ErrMdbCatchallMbox      = errors.New("catch-all address cannot be a mailbox")
+	ErrMdbSendAsNotFound    = errors.New("sender login not found")
+	ErrMdbDupSendAs         = errors.New("sender login already exists")
+	ErrMdbSendAsNoDomain    = errors.New("sender must have a domain")
+	ErrMdbSaslNoUser        = errors.New("SASL password requires a SASL user")
+	ErrMdbSaslNoDb          = errors.New("SASL credentials database cannot be read")
+	ErrMdbBadMapClass       = errors.New("class must be sender or recipient")
+	ErrMdbCanonicalNotFound = errors.New("canonical mapping not found")
+	ErrMdbDupCanonical      = errors.New("canonical mapping already exists")
+	ErrMdbRelocatedNotFound = errors.New("relocated address not found")
+	ErrMdbDupRelocated      = errors.New("relocated address already exists")
+	ErrMdbRelocatedNoTarget = errors.New("relocated address must have a new location")
+	ErrMdbBccNotFound       = errors.New("bcc mapping not found")
+	ErrMdbDupBcc            = errors.New("bcc mapping already exists")
+	ErrMdbBccTarget         = errors.New("bcc target must be a full address")
+	ErrMdbBadCheckClass     = errors.New("access check class must be client, sender or helo")
+	ErrMdbCheckNotFound     = errors.New("access check not found")
+	ErrMdbDupCheck          = errors.New("access check already exists")
+	ErrMdbBadCIDR           = errors.New("not a valid IP address or network")
+	ErrMdbBadHostname       = errors.New("not a valid host or domain name")
+	ErrMdbClassNotFound     = errors.New("restriction class not found")
+	ErrMdbDupClass          = errors.New("restriction class already exists")
+	ErrMdbClassNoRestrict   = errors.New("restriction class must have restrictions")
+	ErrMdbVacationNotFound  = errors.New("vacation not found")
+	ErrMdbDupVacation       = errors.New("mailbox already has a vacation")
+	ErrMdbVacationNoBody    = errors.New("vacation must have a message body")
+	ErrMdbVacationDate      = errors.New("vacation dates must be YYYY-MM-DD with the end after the start")
+	ErrMdbVacationDays      = errors.New("vacation reply interval must be at least one day")
+	ErrMdbVacationAddress   = errors.New("vacation addresses must be user@domain")
+	ErrMdbSieveNotFound     = errors.New("sieve script not found")
+	ErrMdbDupSieve          = errors.New("sieve script already exists")
+	ErrMdbSieveName         = errors.New("not a valid sieve script name")
+	ErrMdbSieveDomainName   = errors.New("domain sieve scripts are named before or after")
+	ErrMdbSieveActive       = errors.New("sieve script is active")
+	ErrMdbSieveDomainActive = errors.New("domain sieve scripts are always active")
+	ErrMdbListNotFound      = errors.New("distribution list not found")
+	ErrMdbDupList           = errors.New("distribution list already exists")
+	ErrMdbListAddress       = errors.New("list address must be a name or address that is not an alias or mailbox")
+	ErrMdbListMember        = errors.New("list member must be a name or full address")
+	ErrMdbMemberNotFound    = errors.New("list member not found")
+	ErrMdbDupMember         = errors.New("list member already exists")
+	ErrMdbNotRecipient      = errors.New("not a recipient address")
+	ErrMdbNotFixable        = errors.New("finding cannot be fixed")
+	ErrMdbUnknownMap        = errors.New("not a postfix map that can be looked up")
+	ErrMdbPwScheme          = errors.New("password scheme not supported")
+	ErrMdbLimitNotFound     = errors.New("send limit not found")
+	ErrMdbDupLimit          = errors.New("send limit already exists")
+	ErrMdbLimitAction       = errors.New("send limit action must be defer or reject")
+	ErrMdbCdbTooBig         = errors.New("map is too big for a CDB file")
 )
 
 // Embedded files for database
@@ -136,23 +192,76 @@ func IsErrConstraintNotNull(err error) bool {
 	}
 }
 
+func init() {
+	sql.Register("sqlite3_postdove", &sqlite3.SQLiteDriver{ConnectHook: attachSasl})
+}
+
+// SaslDbPath
+// The SMTP client SASL credentials are kept in a database file of their
+// own next to the one at dbPath, postdove-sasl.sqlite for postdove.sqlite,
+// so it can be readable by postfix alone and not by dovecot and the other
+// readers of the main database.
+func SaslDbPath(dbPath string) string {
+	ext := filepath.Ext(dbPath)
+	return strings.TrimSuffix(dbPath, ext) + "-sasl" + ext
+}
+
+// attachSasl
+// Attach the credentials database to each connection as "sasl". Only
+// create makes it. A user that cannot read it, such as dovecot running
+// checkpassword, goes without and the MailDB knows it, see saslDb.
+func attachSasl(conn *sqlite3.SQLiteConn) error {
+	dbPath := conn.GetFilename("main")
+	if dbPath == "" { // in memory
+		return nil
+	}
+	path := SaslDbPath(dbPath)
+	if ok, err := saslDb(path); !ok {
+		return err
+	}
+	_, err := conn.Exec("ATTACH DATABASE ? AS sasl", []driver.Value{path})
+	return err
+}
+
+// saslDb
+// Can the credentials database at path be attached? Not if it is not
+// there or cannot be read, anything else is an error.
+func saslDb(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	f.Close()
+	return true, nil
+}
+
 // MailDB
 type MailDB struct {
-	db    *sql.DB
-	tx    *sql.Tx
-	dflts map[string]TableInfo
+	db     *sql.DB
+	tx     *sql.Tx
+	dflts  map[string]TableInfo
+	dbPath string
+	sasl   bool // the credentials database is attached
 }
 
 // NewMailDB
 // Sqlite DB open.  ":memory:" for testing...
 func NewMailDB(dbPath string) (*MailDB, error) {
 
-	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_foreign_keys=on")
+	db, err := sql.Open("sqlite3_postdove", "file:"+dbPath+"?_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("NewMailDB: open, %s", err)
 	}
 	mdb := &MailDB{
-		db: db,
+		db:     db,
+		dbPath: dbPath,
+	}
+	if mdb.sasl, err = saslDb(SaslDbPath(dbPath)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("NewMailDB: %s", err)
 	}
 	mdb.dflts = make(map[string]TableInfo)
 	return mdb, nil
@@ -294,6 +403,9 @@ func (mdb *MailDB) LoadSchema(schema string) error {
 	if err != nil {
 		return fmt.Errorf("LoadSchema: ReadFile, %s", err)
 	}
+	if err = mdb.createSasl(); err != nil {
+		return fmt.Errorf("LoadSchema: %s", err)
+	}
 	lines := strings.Split(string(c), ";\n")
 	for line, req := range lines {
 		if _, err = mdb.db.Exec(req); err != nil {
@@ -303,6 +415,26 @@ func (mdb *MailDB) LoadSchema(schema string) error {
 	return nil
 }
 
+// createSasl
+// Make the credentials database, mode 0600, for the schema. This is the
+// only place it is made so a misspelled database path does not leave
+// one behind. Connections made from now on attach it.
+func (mdb *MailDB) createSasl() error {
+	if mdb.dbPath == ":memory:" || mdb.sasl {
+		return nil
+	}
+	f, err := os.OpenFile(SaslDbPath(mdb.dbPath), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		if os.IsNotExist(err) { // no directory, opening the database says so
+			return nil
+		}
+		return err
+	}
+	f.Close()
+	mdb.sasl = true
+	return nil
+}
+
 type Input struct {
 	inFile fs.File
 	stream io.Reader
@@ -76,6 +76,77 @@ func TestDecode(t *testing.T) {
 	} else if err != ErrMdbAddrNoAddr {
 		t.Errorf("+bar@baz: err code, %s", err)
 	}
+	if ap, err = DecodeRFC822("@baz"); err != nil {
+		t.Errorf("@baz: unexpected error, %s", err)
+	} else if !ap.IsCatchall() {
+		t.Errorf("@baz: should be a catch-all")
+	}
+	if ap, err = DecodeRFC822("foo@baz"); err == nil && ap.IsCatchall() {
+		t.Errorf("foo@baz: should not be a catch-all")
+	}
+	ap, err = DecodeRFC822("@")
+	if err == nil {
+		t.Errorf("@: did not throw empty address error")
+	} else if err != ErrMdbAddressEmpty {
+		t.Errorf("@: err code, %s", err)
+	}
+}
+
+// TestDecodeCIDR
+func TestDecodeCIDR(t *testing.T) {
+	fmt.Printf("CIDR Test\n")
+
+	CIDRRes := []struct {
+		addr   string
+		cidr   string
+		lookup string
+	}{
+		{"192.168.1.10", "192.168.1.10", "192.168.1.10"},
+		{"192.168.1.10/32", "192.168.1.10", "192.168.1.10"},
+		{"192.168.1.0/24", "192.168.1.0/24", "192.168.1"},
+		{"10/8", "", ""}, // not an address
+		{"10", "10.0.0.0/8", "10"},
+		{"172.16", "172.16.0.0/16", "172.16"},
+		{"192.168.4.0/22", "192.168.4.0/22", ""},
+		{"2001:DB8::1", "2001:db8::1", "2001:db8::1"},
+		{"2001:db8::/32", "2001:db8::/32", ""},
+	}
+	for _, r := range CIDRRes {
+		cidr, lookup, err := DecodeCIDR(r.addr)
+		if r.cidr == "" {
+			if err == nil {
+				t.Errorf("%s: should have failed", r.addr)
+			} else if err != ErrMdbBadCIDR {
+				t.Errorf("%s: err code, %s", r.addr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error, %s", r.addr, err)
+		} else if cidr != r.cidr || lookup != r.lookup {
+			t.Errorf("%s: expected %s and %s, got %s and %s",
+				r.addr, r.cidr, r.lookup, cidr, lookup)
+		}
+	}
+	for _, bad := range []string{"", "192.168.1.1/24", "256.1", "1.2.3.4.5", "01.2", "::1/129", "mail.example.com"} {
+		if _, _, err := DecodeCIDR(bad); err == nil {
+			t.Errorf("%s: should have failed", bad)
+		} else if err != ErrMdbBadCIDR {
+			t.Errorf("%s: err code, %s", bad, err)
+		}
+	}
+	if h, err := DecodeHostname("Mail.Example.COM."); err != nil {
+		t.Errorf("Mail.Example.COM.: unexpected error, %s", err)
+	} else if h != "mail.example.com" {
+		t.Errorf("Mail.Example.COM.: expected mail.example.com, got %s", h)
+	}
+	for _, bad := range []string{"", "-mail.example.com", "mail..example.com", "mail_1.example.com", "a b", "10.1.1.300"} {
+		if _, err := DecodeHostname(bad); err == nil {
+			t.Errorf("%s: should have failed", bad)
+		} else if err != ErrMdbBadHostname {
+			t.Errorf("%s: err code, %s", bad, err)
+		}
+	}
 }
 
 // TestTarget
@@ -163,6 +234,13 @@ func TestTransport(t *testing.T) {
 		{"relay:[gateway.com]", "relay", "[gateway.com]"},
 		{"smtp:bar.example.com:25", "smtp", "bar.example.com:25"},
 		{"error:mail for you bounces", "error", "mail for you bounces"},
+		{"lmtp:unix:private/dovecot-lmtp", "lmtp", "unix:private/dovecot-lmtp"},
+		{"lmtp:inet:127.0.0.1:24", "lmtp", "inet:127.0.0.1:24"},
+		{"lmtp:inet:[mail.example.com]:lmtp", "lmtp", "inet:[mail.example.com]:lmtp"},
+		{"smtp:[192.168.1.1]", "smtp", "[192.168.1.1]"},
+		{"smtp:[2001:db8::1]:587", "smtp", "[2001:db8::1]:587"},
+		{"smtp:[ipv6:2001:db8::1]", "smtp", "[ipv6:2001:db8::1]"},
+		{"smtp:mx1.example.com, mx2.example.com:2525", "smtp", "mx1.example.com, mx2.example.com:2525"},
 	}
 	var (
 		tr  *TransportParts
@@ -183,4 +261,22 @@ func TestTransport(t *testing.T) {
 	if err == nil {
 		t.Errorf("foo: did not throw a no separator error")
 	}
+	for _, bad := range []string{
+		"smtp:[gateway.com",
+		"smtp:[gateway.com]25",
+		"smtp:foo.com:",
+		"smtp:foo.com:99999",
+		"smtp:foo.com:2x5",
+		"smtp:2001:db8::1",
+		"smtp:[ipv6:10.1.1.1]",
+		"smtp:bad_host.com",
+		"lmtp:unix:",
+		"lmtp:inet:",
+	} {
+		if _, err = DecodeTransport(bad); err == nil {
+			t.Errorf("%s: should have failed", bad)
+		} else if err != ErrMdbBadNexthop {
+			t.Errorf("%s: unexpected error, %s", bad, err)
+		}
+	}
 }
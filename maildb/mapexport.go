@@ -0,0 +1,212 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+)
+
+// MapEntry
+// A key and its value in a postfix lookup table
+type MapEntry struct {
+	key   string
+	value string
+}
+
+// Key
+func (me *MapEntry) Key() string {
+	return me.key
+}
+
+// Value
+func (me *MapEntry) Value() string {
+	return me.value
+}
+
+// mapExports
+// The maps that can be written out as lookup tables, named for their
+// query files. Each query gives every key the query file's lookup
+// would find with the value it would get. A key that postfix only
+// looks up as user@domain has no "@domain" entry because the query
+// file would not find one either.
+var mapExports = []struct {
+	name  string
+	query string
+}{
+	{"virtual_alias", `
+SELECT mailbox || '@' || domain_name, recipient FROM virt_alias
+  WHERE mailbox != ''`},
+	{"virtual_catchall", `
+SELECT '@' || domain_name, recipient FROM virt_alias WHERE mailbox = ''`},
+	{"alias_maps", `SELECT local_user, recipient FROM etc_aliases`},
+	{"transport_maps", `
+SELECT username || '@' || domain_name, transport FROM address_transport
+  WHERE username != ''`},
+	{"client_access", `SELECT client, access_key FROM client_access`},
+	{"sender_access", `SELECT sender, access_key FROM sender_access`},
+	{"helo_access", `SELECT helo, access_key FROM helo_access`},
+	{"recipient_access", `
+SELECT username || '@' || domain_name, access_key FROM address_access
+  WHERE username != '' AND access_key IS NOT NULL`},
+	{"domain_access", `SELECT domain_name, access_key FROM domain_access`},
+	{"mydestination", `SELECT name, name FROM local_domain`},
+	{"relay_domain", `SELECT name, name FROM relay_domain`},
+	{"virtual_domain", `SELECT name, name FROM virtual_domain`},
+	{"vmailbox_domain", `SELECT name, name FROM vmailbox_domain`},
+	{"virtual_mailbox", `
+SELECT username || '@' || domain,
+       COALESCE(NULLIF(home, ''), 'vmail/' || domain || '/' || username || '/Mail')
+  FROM user_mailbox WHERE username != ''`},
+}
+
+// MapExports
+// the names of the maps that can be exported
+func MapExports() []string {
+	var names []string
+
+	for _, m := range mapExports {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// MapEntries
+// All the keys of the map, in order and folded to lower case as
+// postmap does, with their values. The values of a key with more than
+// one are joined with commas the way the query file's lookup returns
+// them. No transaction
+func (mdb *MailDB) MapEntries(name string) ([]*MapEntry, error) {
+	var (
+		rows  *sql.Rows
+		query string
+		err   error
+	)
+
+	for _, m := range mapExports {
+		if m.name == name {
+			query = m.query
+		}
+	}
+	if query == "" {
+		return nil, ErrMdbUnknownMap
+	}
+	if rows, err = mdb.db.Query(query); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make(map[string][]string)
+	for rows.Next() {
+		var key, value sql.NullString
+
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		if !key.Valid || !value.Valid || key.String == "" || value.String == "" {
+			continue
+		}
+		k := strings.ToLower(key.String)
+		values[k] = append(values[k], value.String)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var entries []*MapEntry
+	for k, v := range values {
+		entries = append(entries, &MapEntry{key: k, value: strings.Join(v, ",")})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// cdbHash
+// the hash of D. J. Bernstein's constant database
+func cdbHash(key []byte) uint32 {
+	h := uint32(5381)
+	for _, c := range key {
+		h = ((h << 5) + h) ^ uint32(c)
+	}
+	return h
+}
+
+// cdbAppend
+// two little endian words
+func cdbAppend(buf []byte, a uint32, b uint32) []byte {
+	var w [8]byte
+
+	binary.LittleEndian.PutUint32(w[:4], a)
+	binary.LittleEndian.PutUint32(w[4:], b)
+	return append(buf, w[:]...)
+}
+
+// WriteCDB
+// Write the entries as a CDB file, the format of a postfix cdb: table.
+// The keys and values have no terminating NUL, as postmap makes them.
+func WriteCDB(w io.Writer, entries []*MapEntry) error {
+	type slot struct {
+		hash uint32
+		pos  uint32
+	}
+	var (
+		buf     []byte
+		buckets [256][]slot
+	)
+
+	// the records follow the header of 256 table positions and lengths
+	const headerSize = 256 * 8
+	buf = make([]byte, headerSize)
+	for _, e := range entries {
+		k, v := []byte(e.key), []byte(e.value)
+		if uint64(len(buf))+uint64(8+len(k)+len(v)) > 0xffffffff {
+			return ErrMdbCdbTooBig
+		}
+		h := cdbHash(k)
+		buckets[h&0xff] = append(buckets[h&0xff], slot{hash: h, pos: uint32(len(buf))})
+		buf = cdbAppend(buf, uint32(len(k)), uint32(len(v)))
+		buf = append(buf, k...)
+		buf = append(buf, v...)
+	}
+	// then a hash table for each bucket, twice the size of its slots
+	for i, b := range buckets {
+		n := uint32(len(b) * 2)
+		binary.LittleEndian.PutUint32(buf[i*8:], uint32(len(buf)))
+		binary.LittleEndian.PutUint32(buf[i*8+4:], n)
+		table := make([]slot, n)
+		for _, s := range b {
+			j := (s.hash >> 8) % n
+			for table[j].pos != 0 {
+				j = (j + 1) % n
+			}
+			table[j] = s
+		}
+		for _, s := range table {
+			buf = cdbAppend(buf, s.hash, s.pos)
+		}
+		if uint64(len(buf)) > 0xffffffff {
+			return ErrMdbCdbTooBig
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
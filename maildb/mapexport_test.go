@@ -0,0 +1,210 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// cdbGet
+// look up key in a CDB file the way a reader of the format does
+func cdbGet(data []byte, key string) (string, bool) {
+	word := func(pos uint32) uint32 {
+		return binary.LittleEndian.Uint32(data[pos:])
+	}
+	h := cdbHash([]byte(key))
+	tpos, tlen := word((h&0xff)*8), word((h&0xff)*8+4)
+	if tlen == 0 {
+		return "", false
+	}
+	for i, j := uint32(0), (h>>8)%tlen; i < tlen; i, j = i+1, (j+1)%tlen {
+		sh, rpos := word(tpos+j*8), word(tpos+j*8+4)
+		if rpos == 0 {
+			return "", false
+		}
+		if sh != h {
+			continue
+		}
+		klen, vlen := word(rpos), word(rpos+4)
+		if string(data[rpos+8:rpos+8+klen]) == key {
+			return string(data[rpos+8+klen : rpos+8+klen+vlen]), true
+		}
+	}
+	return "", false
+}
+
+// TestMapEntries
+func TestMapEntries(t *testing.T) {
+	var (
+		err     error
+		mdb     *MailDB
+		d       *Domain
+		a       *Address
+		tr      *Transport
+		mb      *VMailbox
+		dir     string
+		entries []*MapEntry
+	)
+
+	fmt.Printf("MapEntries Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	if tr, err = mdb.InsertTransport("relay"); err == nil {
+		err = tr.SetTransport("smtp")
+	}
+	if err == nil {
+		err = tr.SetNexthop("[mx.example.net]")
+	}
+	if err == nil {
+		d, err = mdb.InsertDomain("skywalker")
+	}
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		mb, err = mdb.InsertVMailbox("leia@skywalker")
+	}
+	if err == nil {
+		err = mb.SetHome("/home/leia")
+	}
+	if err == nil {
+		d, err = mdb.InsertDomain("tatooine")
+	}
+	if err == nil {
+		err = d.SetClass("relay")
+	}
+	if err == nil {
+		a, err = mdb.InsertAddress("owen@tatooine")
+	}
+	if err == nil {
+		err = a.SetTransport("relay")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup failed, %s", err)
+		return
+	}
+	if err = makeAlias(mdb, "jedi@skywalker", []string{"luke@skywalker", "leia@skywalker"}); err != nil {
+		t.Errorf("Alias jedi: %s", err)
+		return
+	}
+	if err = makeAlias(mdb, "postmaster", []string{"root"}); err != nil {
+		t.Errorf("Alias postmaster: %s", err)
+		return
+	}
+	if err = makeAlias(mdb, "@skywalker", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("Alias @skywalker: %s", err)
+		return
+	}
+
+	if _, err = mdb.MapEntries("relocated"); err != ErrMdbUnknownMap {
+		t.Errorf("MapEntries relocated: expected ErrMdbUnknownMap, got %v", err)
+	}
+	maps := []struct {
+		name    string
+		entries string
+	}{
+		{"virtual_alias", "jedi@skywalker=luke@skywalker,leia@skywalker " +
+			"leia@skywalker=leia@skywalker luke@skywalker=luke@skywalker"},
+		{"virtual_catchall", "@skywalker=luke@skywalker"},
+		{"alias_maps", "postmaster=root"},
+		{"transport_maps", "owen@tatooine=smtp:[mx.example.net]"},
+		{"vmailbox_domain", "skywalker=skywalker"},
+		{"relay_domain", "tatooine=tatooine"},
+		{"virtual_domain", ""},
+		{"virtual_mailbox", "leia@skywalker=/home/leia luke@skywalker=vmail/skywalker/luke/Mail"},
+	}
+	for _, m := range maps {
+		var got []string
+
+		if entries, err = mdb.MapEntries(m.name); err != nil {
+			t.Errorf("MapEntries %s: %s", m.name, err)
+			continue
+		}
+		for _, e := range entries {
+			got = append(got, e.Key()+"="+e.Value())
+		}
+		if strings.Join(got, " ") != m.entries {
+			t.Errorf("MapEntries %s: expected %q, got %q", m.name, m.entries, strings.Join(got, " "))
+		}
+	}
+
+	// and as a CDB file
+	var buf bytes.Buffer
+	if entries, err = mdb.MapEntries("virtual_mailbox"); err != nil {
+		t.Errorf("MapEntries virtual_mailbox: %s", err)
+		return
+	}
+	if err = WriteCDB(&buf, entries); err != nil {
+		t.Errorf("WriteCDB virtual_mailbox: %s", err)
+		return
+	}
+	for _, e := range entries {
+		if v, ok := cdbGet(buf.Bytes(), e.Key()); !ok || v != e.Value() {
+			t.Errorf("CDB %s: expected %s, got %s, %v", e.Key(), e.Value(), v, ok)
+		}
+	}
+	if _, ok := cdbGet(buf.Bytes(), "han@skywalker"); ok {
+		t.Errorf("CDB han@skywalker: should not be found")
+	}
+
+	// enough keys to fill the hash tables and collide
+	entries = nil
+	for i := 0; i < 2000; i++ {
+		entries = append(entries, &MapEntry{key: fmt.Sprintf("user%d@example.com", i), value: fmt.Sprintf("%d", i)})
+	}
+	buf.Reset()
+	if err = WriteCDB(&buf, entries); err != nil {
+		t.Errorf("WriteCDB 2000 keys: %s", err)
+		return
+	}
+	for _, e := range entries {
+		if v, ok := cdbGet(buf.Bytes(), e.Key()); !ok || v != e.Value() {
+			t.Errorf("CDB %s: expected %s, got %s, %v", e.Key(), e.Value(), v, ok)
+			break
+		}
+	}
+	buf.Reset()
+	if err = WriteCDB(&buf, nil); err != nil || buf.Len() != 2048 {
+		t.Errorf("WriteCDB empty: expected only the header, got %d bytes, %v", buf.Len(), err)
+	}
+}
@@ -0,0 +1,374 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// How postfix retries a key in a map when the whole key is not found.
+// See virtual(5), aliases(5), transport(5), access(5) and the
+// parent_domain_matches_subdomains parameter in postconf(5).
+const (
+	retryAddress   = iota // user+ext@domain, user@domain, @domain
+	retryLocal            // user+ext, user
+	retryTransport        // the address, then domain, .parent ... and *
+	retryAccess           // the address, domain, parent ... and user@
+	retryDomain           // domain, .parent ...
+	retryParent           // domain, parent ...
+)
+
+// postfixMaps are the maps postfix looks up through the query files
+// and how it retries each
+var postfixMaps = map[string]int{
+	"virtual_alias":    retryAddress,
+	"virtual_catchall": retryAddress,
+	"virtual_mailbox":  retryAddress,
+	"alias_maps":       retryLocal,
+	"transport_maps":   retryTransport,
+	"recipient_access": retryAccess,
+	"domain_access":    retryAccess,
+	"mydestination":    retryDomain,
+	"virtual_domain":   retryDomain,
+	"vmailbox_domain":  retryDomain,
+	"relay_domain":     retryParent,
+}
+
+// PostfixMaps
+// the names of the maps that can be looked up, sorted
+func PostfixMaps() []string {
+	var names []string
+
+	for n := range postfixMaps {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RetryKeys
+// The keys postfix tries in turn for key in the map until one is found.
+// Postfix folds the keys of sql tables to lower case. The extension
+// delimiter is '+'.
+func RetryKeys(mapName string, key string) ([]string, error) {
+	var keys []string
+
+	style, ok := postfixMaps[mapName]
+	if !ok {
+		return nil, ErrMdbUnknownMap
+	}
+	key = strings.ToLower(key)
+	user, domain := key, ""
+	if i := strings.LastIndex(key, "@"); i >= 0 {
+		user, domain = key[:i], key[i+1:]
+	}
+	base := user
+	if i := strings.Index(user, "+"); i > 0 {
+		base = user[:i]
+	}
+	addrs := []string{key}
+	if base != user {
+		if domain != "" || strings.Contains(key, "@") {
+			addrs = append(addrs, base+"@"+domain)
+		} else {
+			addrs = append(addrs, base)
+		}
+	}
+
+	switch style {
+	case retryAddress:
+		keys = addrs
+		if domain != "" && user != "" {
+			keys = append(keys, "@"+domain)
+		}
+	case retryLocal:
+		keys = addrs
+	case retryTransport:
+		if domain == "" && !strings.Contains(key, "@") {
+			keys = append(keys, parentKeys(key, ".")...)
+		} else {
+			keys = append(addrs, parentKeys(domain, ".")...)
+		}
+		keys = append(keys, "*")
+	case retryAccess:
+		if !strings.Contains(key, "@") {
+			keys = parentKeys(key, "")
+			break
+		}
+		keys = append(addrs, parentKeys(domain, "")...)
+		if user != "" {
+			keys = append(keys, user+"@")
+			if base != user {
+				keys = append(keys, base+"@")
+			}
+		}
+	case retryDomain, retryParent:
+		if domain == "" {
+			domain = key
+		}
+		dot := "."
+		if style == retryParent {
+			dot = ""
+		}
+		keys = parentKeys(domain, dot)
+	}
+	return keys, nil
+}
+
+// parentKeys
+// The domain and then each of its parent domains with dot in front
+func parentKeys(domain string, dot string) []string {
+	var keys []string
+
+	if domain == "" {
+		return nil
+	}
+	keys = append(keys, domain)
+	for d := domain; ; {
+		i := strings.Index(d, ".")
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+		if d == "" {
+			break
+		}
+		keys = append(keys, dot+d)
+	}
+	return keys
+}
+
+// PostfixQuery
+// The query and result_format of a sqlite_table(5) query file
+type PostfixQuery struct {
+	name         string
+	query        string
+	resultFormat string
+}
+
+// Name
+func (pq *PostfixQuery) Name() string {
+	return pq.name
+}
+
+// Query
+// the query with its "%" expansions
+func (pq *PostfixQuery) Query() string {
+	return pq.query
+}
+
+// ParsePostfixQuery
+// Parse the text of a query file the way postfix reads main.cf style
+// files. A line that starts with white space continues the one before.
+func ParsePostfixQuery(name string, text string) (*PostfixQuery, error) {
+	var params []string
+
+	for _, line := range strings.Split(text, "\n") {
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(params) == 0 {
+				return nil, fmt.Errorf("%s: continuation line without a parameter", name)
+			}
+			params[len(params)-1] += " " + t
+		} else {
+			params = append(params, t)
+		}
+	}
+	pq := &PostfixQuery{
+		name:         name,
+		resultFormat: "%s",
+	}
+	for _, p := range params {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s: %s is not a name = value parameter", name, p)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "query":
+			pq.query = strings.TrimSpace(kv[1])
+		case "result_format":
+			pq.resultFormat = strings.TrimSpace(kv[1])
+		}
+	}
+	if pq.query == "" {
+		return nil, fmt.Errorf("%s: no query parameter", name)
+	}
+	return pq, nil
+}
+
+// BuiltinQuery
+// The query file for the map that config generate would install
+func BuiltinQuery(mapName string) (*PostfixQuery, error) {
+	if _, ok := postfixMaps[mapName]; !ok {
+		return nil, ErrMdbUnknownMap
+	}
+	queries, err := BuiltinQueries()
+	if err != nil {
+		return nil, err
+	}
+	if pq, ok := queries[mapName]; ok {
+		return pq, nil
+	}
+	return nil, ErrMdbUnknownMap
+}
+
+// BuiltinQueries
+// All the query files config generate would install by map name
+func BuiltinQueries() (map[string]*PostfixQuery, error) {
+	cflist, err := ConfigFiles("", "")
+	if err != nil {
+		return nil, err
+	}
+	queries := make(map[string]*PostfixQuery)
+	for _, cf := range cflist {
+		name := strings.TrimPrefix(cf.Name(), "query/")
+		if cf.Dir() != "postfix" || name == cf.Name() || !strings.HasSuffix(name, ".query") {
+			continue
+		}
+		pq, err := ParsePostfixQuery(cf.Name(), cf.Content())
+		if err != nil {
+			return nil, err
+		}
+		queries[strings.TrimSuffix(name, ".query")] = pq
+	}
+	return queries, nil
+}
+
+// Expand
+// The SQL for key with the "%" expansions postfix makes. Postfix does
+// not run the query, and finds nothing, if it uses a part of the key
+// that is not there. That is suppressed.
+func (pq *PostfixQuery) Expand(key string) (q string, suppressed bool) {
+	return expandKey(pq.query, key, "", true)
+}
+
+// expandKey
+// Expand the format for the key, or for result in a result_format
+// where the upper case ones are the key. Quote for SQL if quote.
+func expandKey(format string, key string, result string, quote bool) (string, bool) {
+	var out strings.Builder
+
+	parts := func(s string) (string, string, bool) {
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			return s[:i], s[i+1:], true
+		}
+		return s, "", false
+	}
+	q := func(s string) string {
+		if quote {
+			return strings.ReplaceAll(s, "'", "''")
+		}
+		return s
+	}
+	value := key
+	if result != "" {
+		value = result
+	}
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 == len(format) {
+			out.WriteByte(c)
+			continue
+		}
+		i++
+		c = format[i]
+		v := value
+		if c >= 'A' && c <= 'Z' {
+			v = key
+			c = c - 'A' + 'a'
+		}
+		user, domain, hasAt := parts(v)
+		switch {
+		case c == '%':
+			out.WriteByte('%')
+		case c == 's':
+			out.WriteString(q(v))
+		case c == 'u':
+			if user == "" {
+				return "", true
+			}
+			out.WriteString(q(user))
+		case c == 'd':
+			if !hasAt || domain == "" {
+				return "", true
+			}
+			out.WriteString(q(domain))
+		case c >= '1' && c <= '9':
+			labels := strings.Split(domain, ".")
+			n := int(c - '0')
+			if !hasAt || domain == "" || n > len(labels) {
+				return "", true
+			}
+			out.WriteString(q(labels[len(labels)-n]))
+		default:
+			out.WriteByte('%')
+			out.WriteByte(format[i])
+		}
+	}
+	return out.String(), false
+}
+
+// PostfixLookup
+// Look up key with the query the way postfix does with a sqlite table.
+// The results are formatted with result_format and joined with commas.
+// The key is taken as it is, see RetryKeys for the ones postfix tries.
+func (mdb *MailDB) PostfixLookup(pq *PostfixQuery, key string) (string, bool, error) {
+	var (
+		rows *sql.Rows
+		vals []string
+		err  error
+	)
+
+	q, suppressed := pq.Expand(key)
+	if suppressed {
+		return "", false, nil
+	}
+	if rows, err = mdb.db.Query(q); err != nil {
+		return "", false, fmt.Errorf("%s: %s", pq.name, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v sql.NullString
+
+		if err = rows.Scan(&v); err != nil {
+			return "", false, err
+		}
+		if !v.Valid || v.String == "" {
+			continue
+		}
+		if r, skip := expandKey(pq.resultFormat, key, v.String, false); !skip {
+			vals = append(vals, r)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return "", false, err
+	}
+	if len(vals) == 0 {
+		return "", false, nil
+	}
+	return strings.Join(vals, ","), true, nil
+}
@@ -0,0 +1,200 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestRetryKeys
+func TestRetryKeys(t *testing.T) {
+	fmt.Printf("Retry Keys Test\n")
+
+	for _, r := range []struct {
+		mapName, key, keys string
+	}{
+		{"virtual_alias", "Sales+Orders@Example.COM",
+			"sales+orders@example.com sales@example.com @example.com"},
+		{"virtual_alias", "@example.com", "@example.com"},
+		{"virtual_mailbox", "jeff@example.com", "jeff@example.com @example.com"},
+		{"alias_maps", "root+cron", "root+cron root"},
+		{"transport_maps", "bill+x@mail.example.com",
+			"bill+x@mail.example.com bill@mail.example.com mail.example.com .example.com .com *"},
+		{"transport_maps", "example.com", "example.com .com *"},
+		{"recipient_access", "bill+x@mail.example.com",
+			"bill+x@mail.example.com bill@mail.example.com mail.example.com example.com com bill+x@ bill@"},
+		{"domain_access", "example.com", "example.com com"},
+		{"mydestination", "mail.example.com", "mail.example.com .example.com .com"},
+		{"relay_domain", "mail.example.com", "mail.example.com example.com com"},
+		{"vmailbox_domain", "jeff@example.com", "example.com .com"},
+	} {
+		keys, err := RetryKeys(r.mapName, r.key)
+		if err != nil {
+			t.Errorf("RetryKeys %s %s, %s", r.mapName, r.key, err)
+		} else if strings.Join(keys, " ") != r.keys {
+			t.Errorf("RetryKeys %s %s, expected %s, got %s", r.mapName, r.key, r.keys,
+				strings.Join(keys, " "))
+		}
+	}
+	if _, err := RetryKeys("aliases", "root"); err != ErrMdbUnknownMap {
+		t.Errorf("RetryKeys aliases, expected error, got %v", err)
+	}
+}
+
+// TestPostfixQuery
+func TestPostfixQuery(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		d     *Domain
+		a     *Address
+		dir   string
+		pq    *PostfixQuery
+		res   string
+		found bool
+	)
+
+	fmt.Printf("Postfix Query Test\n")
+
+	if _, err = ParsePostfixQuery("bad.query", "dbpath = /x\n"); err == nil {
+		t.Errorf("Parse without a query, should have failed")
+	}
+	if _, err = ParsePostfixQuery("bad.query", "  query = x\n"); err == nil {
+		t.Errorf("Parse starting with continuation, should have failed")
+	}
+	pq, err = ParsePostfixQuery("test.query", `# a test
+dbpath = /x
+
+query = SELECT '%s', '%u', '%d',
+	'%1', '%2', '%%'
+result_format = <%s> %U
+`)
+	if err != nil {
+		t.Errorf("Parse test.query, %s", err)
+		return
+	}
+	if pq.Query() != "SELECT '%s', '%u', '%d', '%1', '%2', '%%'" {
+		t.Errorf("Parse test.query, bad query %s", pq.Query())
+	}
+	for _, e := range []struct {
+		key, q string
+	}{
+		{"o'brien@example.com",
+			"SELECT 'o''brien@example.com', 'o''brien', 'example.com', 'com', 'example', '%'"},
+		{"@example.com", ""},
+		{"bill", ""},
+		{"bill@com", ""},
+	} {
+		q, suppressed := pq.Expand(e.key)
+		if e.q == "" && !suppressed {
+			t.Errorf("Expand %s, should have been suppressed, got %s", e.key, q)
+		} else if e.q != "" && q != e.q {
+			t.Errorf("Expand %s, expected %s, got %s", e.key, e.q, q)
+		}
+	}
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	if d, err = mdb.InsertDomain("example.com"); err == nil {
+		err = d.SetClass("virtual")
+	}
+	for _, al := range [][]string{
+		{"sales@example.com", "bill@example.com"},
+		{"sales@example.com", "dave@example.com"},
+		{"@example.com", "postmaster@example.com"},
+	} {
+		if err == nil {
+			if a, err = mdb.GetOrInsAddress(al[0]); err == nil {
+				err = a.AttachAlias(al[1])
+			}
+		}
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of example.com failed, %s", err)
+		return
+	}
+
+	if pq, err = BuiltinQuery("virtual_alias"); err != nil {
+		t.Errorf("BuiltinQuery virtual_alias, %s", err)
+		return
+	}
+	if _, err = BuiltinQuery("aliases"); err != ErrMdbUnknownMap {
+		t.Errorf("BuiltinQuery aliases, expected error, got %v", err)
+	}
+	for _, l := range []struct {
+		key, res string
+	}{
+		{"sales@example.com", "bill@example.com,dave@example.com"},
+		{"@example.com", ""}, // suppressed, empty %u
+		{"sales+x@example.com", ""},
+	} {
+		if res, found, err = mdb.PostfixLookup(pq, l.key); err != nil {
+			t.Errorf("PostfixLookup %s, %s", l.key, err)
+		} else if found != (l.res != "") || res != l.res {
+			t.Errorf("PostfixLookup %s, expected %q, got %q", l.key, l.res, res)
+		}
+	}
+	// the catch-all only answers for the @domain key itself
+	if pq, err = BuiltinQuery("virtual_catchall"); err != nil {
+		t.Errorf("BuiltinQuery virtual_catchall, %s", err)
+		return
+	}
+	for _, l := range []struct {
+		key, res string
+	}{
+		{"@example.com", "postmaster@example.com"},
+		{"nobody@example.com", ""},
+		{"@example.org", ""},
+	} {
+		if res, found, err = mdb.PostfixLookup(pq, l.key); err != nil {
+			t.Errorf("PostfixLookup catchall %s, %s", l.key, err)
+		} else if found != (l.res != "") || res != l.res {
+			t.Errorf("PostfixLookup catchall %s, expected %q, got %q", l.key, l.res, res)
+		}
+	}
+
+	// result_format
+	pq, _ = ParsePostfixQuery("format.query", `
+query = SELECT recipient FROM virt_alias WHERE mailbox || '@' || domain_name = '%s'
+result_format = %u at %d for %U
+`)
+	if res, _, err = mdb.PostfixLookup(pq, "sales@example.com"); err != nil {
+		t.Errorf("PostfixLookup with format, %s", err)
+	} else if res != "bill at example.com for sales,dave at example.com for sales" {
+		t.Errorf("PostfixLookup with format, got %s", res)
+	}
+}
@@ -0,0 +1,256 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// Relocated
+// A relocated(5) entry, the address, "user", "user@domain" or "@domain",
+// of a departed user and the new location to report in the bounce
+type Relocated struct {
+	mdb     *MailDB
+	id      int64
+	address string
+	target  string
+}
+
+// relocTarget
+// The new location is free text but it cannot be empty
+func relocTarget(target string) (string, error) {
+	t := strings.TrimSpace(target)
+	if t == "" {
+		return "", ErrMdbRelocatedNoTarget
+	}
+	return t, nil
+}
+
+// Address
+func (r *Relocated) Address() string {
+	return r.address
+}
+
+// Target
+func (r *Relocated) Target() string {
+	return r.target
+}
+
+// Export
+func (r *Relocated) Export() string {
+	var (
+		line strings.Builder
+	)
+
+	fmt.Fprintf(&line, "%s %s", r.address, r.target)
+	return line.String()
+}
+
+// LookupRelocated
+// Return the relocated entry for this key. No transaction
+func (mdb *MailDB) LookupRelocated(key string) (*Relocated, error) {
+	var (
+		k   string
+		err error
+	)
+
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	r := &Relocated{
+		mdb: mdb,
+	}
+	q := `SELECT id, address, target FROM relocated_map WHERE address = ?`
+	switch err = mdb.db.QueryRow(q, k).Scan(&r.id, &r.address, &r.target); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbRelocatedNotFound
+	case nil:
+		return r, nil
+	default:
+		return nil, err
+	}
+}
+
+// GetRelocated
+// Same as LookupRelocated but in the transaction
+func (mdb *MailDB) GetRelocated(key string) (*Relocated, error) {
+	var (
+		k   string
+		err error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	r := &Relocated{
+		mdb: mdb,
+	}
+	q := `SELECT id, address, target FROM relocated_map WHERE address = ?`
+	switch err = mdb.tx.QueryRow(q, k).Scan(&r.id, &r.address, &r.target); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbRelocatedNotFound
+	case nil:
+		return r, nil
+	default:
+		return nil, err
+	}
+}
+
+// FindRelocated
+// Return the relocated entries whose key matches the pattern
+// where '*' matches anything. No transaction
+func (mdb *MailDB) FindRelocated(pattern string) ([]*Relocated, error) {
+	var (
+		rows  *sql.Rows
+		rlist []*Relocated
+		err   error
+	)
+
+	q := `
+SELECT id, address, target FROM relocated_map
+ WHERE address LIKE ? ORDER BY address
+`
+	p := strings.ReplaceAll(pattern, "*", "%")
+	if rows, err = mdb.db.Query(q, p); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := &Relocated{
+			mdb: mdb,
+		}
+		if err = rows.Scan(&r.id, &r.address, &r.target); err != nil {
+			return nil, err
+		}
+		rlist = append(rlist, r)
+	}
+	if err = rows.Err(); err == nil && len(rlist) == 0 {
+		err = ErrMdbRelocatedNotFound
+	}
+	return rlist, err
+}
+
+// InsertRelocated
+// Report key as moved to target. The key address is created if needed
+// so this can follow the delete of its mailbox or alias.
+// Transaction required
+func (mdb *MailDB) InsertRelocated(key string, target string) (*Relocated, error) {
+	var (
+		k     string
+		t     string
+		a     *Address
+		res   sql.Result
+		count int64
+		err   error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if k, err = mapKey(key); err != nil {
+		return nil, err
+	}
+	if t, err = relocTarget(target); err != nil {
+		return nil, err
+	}
+	if a, err = mdb.GetOrInsAddress(k); err != nil {
+		return nil, err
+	}
+	qc := `SELECT count(*) FROM relocated WHERE address = ?`
+	if err = mdb.tx.QueryRow(qc, a.Id()).Scan(&count); err != nil {
+		return nil, err
+	} else if count > 0 {
+		return nil, ErrMdbDupRelocated
+	}
+	res, err = mdb.tx.Exec("INSERT INTO relocated (address, target) VALUES (?, ?)",
+		a.Id(), t)
+	if err != nil {
+		return nil, err
+	}
+	r := &Relocated{
+		mdb:     mdb,
+		address: k,
+		target:  t,
+	}
+	if r.id, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetTarget
+// Change the new location
+func (r *Relocated) SetTarget(target string) error {
+	var (
+		t   string
+		err error
+	)
+
+	if t, err = relocTarget(target); err != nil {
+		return err
+	}
+	res, err := r.mdb.tx.Exec("UPDATE relocated SET target = ? WHERE id = ?", t, r.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbBadUpdate
+	}
+	r.target = t
+	return nil
+}
+
+// DeleteRelocated
+// Remove the entry for this key. The key address goes too if nothing
+// else uses it. No transaction
+func (mdb *MailDB) DeleteRelocated(key string) error {
+	var (
+		k   string
+		res sql.Result
+		c   int64
+		err error
+	)
+
+	if k, err = mapKey(key); err != nil {
+		return err
+	}
+	qd := `
+DELETE FROM relocated WHERE id = (SELECT id FROM relocated_map WHERE address = ?)
+`
+	if res, err = mdb.db.Exec(qd, k); err != nil {
+		return err
+	}
+	if c, err = res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbRelocatedNotFound
+	}
+	return nil
+}
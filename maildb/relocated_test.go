@@ -0,0 +1,139 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestRelocated
+func TestRelocated(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		d     *Domain
+		dir   string
+		r     *Relocated
+		rlist []*Relocated
+	)
+
+	fmt.Printf("Relocated Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+
+	mdb.Begin()
+	_, err = mdb.InsertRelocated("anakin@skywalker", "  ")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Insert with empty location should have failed")
+	} else if err != ErrMdbRelocatedNoTarget {
+		t.Errorf("Insert with empty location, %s", err)
+	}
+
+	// the mailbox goes but the relocated entry stays
+	mdb.Begin()
+	r, err = mdb.InsertRelocated("luke@skywalker", "luke@rebels.org")
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert luke@skywalker, %s", err)
+	} else if r.Export() != "luke@skywalker luke@rebels.org" {
+		t.Errorf("Insert luke@skywalker: expected \"luke@skywalker luke@rebels.org\", got %s",
+			r.Export())
+	}
+	if err = mdb.DeleteVMailbox("luke@skywalker"); err != nil {
+		t.Errorf("Delete mailbox luke@skywalker, %s", err)
+	}
+	if r, err = mdb.LookupRelocated("luke@skywalker"); err != nil {
+		t.Errorf("Lookup luke@skywalker after mailbox delete, %s", err)
+	} else if r.Target() != "luke@rebels.org" {
+		t.Errorf("Lookup luke@skywalker: expected luke@rebels.org, got %s", r.Target())
+	}
+
+	mdb.Begin()
+	_, err = mdb.InsertRelocated("luke@skywalker", "luke@jedi.org")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Duplicate luke@skywalker should have failed")
+	} else if err != ErrMdbDupRelocated {
+		t.Errorf("Duplicate luke@skywalker, %s", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertRelocated("anakin", "Darth Vader, Death Star")
+	if err == nil {
+		r, err = mdb.GetRelocated("luke@skywalker")
+	}
+	if err == nil {
+		err = r.SetTarget("luke@jedi.org")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert anakin and update luke, %s", err)
+	}
+	if rlist, err = mdb.FindRelocated("*"); err != nil {
+		t.Errorf("Find *, %s", err)
+	} else if len(rlist) != 2 {
+		t.Errorf("Find *: expected 2, got %d", len(rlist))
+	} else if rlist[0].Export() != "anakin Darth Vader, Death Star" ||
+		rlist[1].Export() != "luke@skywalker luke@jedi.org" {
+		t.Errorf("Find *: unexpected results, %s, %s", rlist[0].Export(), rlist[1].Export())
+	}
+
+	if err = mdb.DeleteRelocated("luke@skywalker"); err != nil {
+		t.Errorf("Delete luke@skywalker, %s", err)
+	}
+	if _, err = mdb.LookupAddress("luke@skywalker"); err == nil {
+		t.Errorf("luke@skywalker should be gone")
+	} else if err != ErrMdbAddressNotFound {
+		t.Errorf("Lookup of luke@skywalker, %s", err)
+	}
+	if err = mdb.DeleteRelocated("luke@skywalker"); err == nil {
+		t.Errorf("Delete luke@skywalker again should have failed")
+	} else if err != ErrMdbRelocatedNotFound {
+		t.Errorf("Delete luke@skywalker again, %s", err)
+	}
+}
@@ -0,0 +1,310 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// RestrictionClass
+// A postfix smtpd_restriction_classes entry, the class name and the
+// restrictions it stands for, "strict = reject_unknown_sender_domain, ..."
+type RestrictionClass struct {
+	mdb          *MailDB
+	id           int64
+	name         string
+	restrictions string
+}
+
+// accessActions
+// the access(5) actions that are not restriction classes. Anything
+// starting with one of these is fine without a class definition
+var accessActions = []string{
+	"ok", "reject", "defer", "defer_if_reject", "defer_if_permit",
+	"bcc", "discard", "dunno", "filter", "hold", "prepend", "redirect",
+	"info", "warn", "permit",
+}
+
+// builtinRestrictions
+// prefixes of the restrictions postfix knows about. They can be used as
+// an action without a class definition too
+var builtinRestrictions = []string{
+	"permit_", "reject_", "defer_", "check_", "warn_if_reject", "sleep",
+}
+
+// IsBuiltinAction
+// The action does not need a restriction class definition because it is
+// an access(5) action, an SMTP reply code, or a postfix restriction
+func IsBuiltinAction(action string) bool {
+	f := strings.Fields(strings.ToLower(action))
+	if len(f) == 0 {
+		return false
+	}
+	if n, err := strconv.Atoi(f[0]); err == nil && n >= 200 && n < 600 {
+		return true
+	}
+	for _, a := range accessActions {
+		if f[0] == a {
+			return true
+		}
+	}
+	for _, r := range builtinRestrictions {
+		if strings.HasPrefix(f[0], r) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictClassName
+// A class name is a main.cf parameter name
+func restrictClassName(name string) (string, error) {
+	n := strings.TrimSpace(name)
+	if n == "" {
+		return "", ErrMdbBadName
+	}
+	for _, c := range n {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') &&
+			!(c >= '0' && c <= '9') && c != '_' && c != '-' {
+			return "", ErrMdbBadName
+		}
+	}
+	return n, nil
+}
+
+// classRestrictions
+// Tidy the restriction list into one line. The continuation lines of
+// main.cf are already joined by the import
+func classRestrictions(restrictions string) (string, error) {
+	r := strings.Join(strings.Fields(restrictions), " ")
+	r = strings.ReplaceAll(r, " ,", ",")
+	r = strings.Trim(r, ", ")
+	if r == "" {
+		return "", ErrMdbClassNoRestrict
+	}
+	return r, nil
+}
+
+// Name
+func (rc *RestrictionClass) Name() string {
+	return rc.name
+}
+
+// Restrictions
+func (rc *RestrictionClass) Restrictions() string {
+	return rc.restrictions
+}
+
+// Export
+// in main.cf form
+func (rc *RestrictionClass) Export() string {
+	var (
+		line strings.Builder
+	)
+
+	fmt.Fprintf(&line, "%s = %s", rc.name, rc.restrictions)
+	return line.String()
+}
+
+// LookupRestrictionClass
+// No transaction
+func (mdb *MailDB) LookupRestrictionClass(name string) (*RestrictionClass, error) {
+	rc := &RestrictionClass{
+		mdb:  mdb,
+		name: name,
+	}
+	row := mdb.db.QueryRow("SELECT id, restrictions FROM restrictionclass WHERE name = ?", name)
+	switch err := row.Scan(&rc.id, &rc.restrictions); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbClassNotFound
+	case nil:
+		return rc, nil
+	default:
+		return nil, err
+	}
+}
+
+// GetRestrictionClass
+// Same as LookupRestrictionClass but in the transaction
+func (mdb *MailDB) GetRestrictionClass(name string) (*RestrictionClass, error) {
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	rc := &RestrictionClass{
+		mdb:  mdb,
+		name: name,
+	}
+	row := mdb.tx.QueryRow("SELECT id, restrictions FROM restrictionclass WHERE name = ?", name)
+	switch err := row.Scan(&rc.id, &rc.restrictions); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbClassNotFound
+	case nil:
+		return rc, nil
+	default:
+		return nil, err
+	}
+}
+
+// FindRestrictionClass
+// '*' matches anything. No transaction
+func (mdb *MailDB) FindRestrictionClass(name string) ([]*RestrictionClass, error) {
+	var (
+		rows  *sql.Rows
+		rlist []*RestrictionClass
+		err   error
+	)
+
+	q := `SELECT id, name, restrictions FROM restrictionclass WHERE name LIKE ? ORDER BY name`
+	if rows, err = mdb.db.Query(q, strings.ReplaceAll(name, "*", "%")); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rc := &RestrictionClass{
+			mdb: mdb,
+		}
+		if err = rows.Scan(&rc.id, &rc.name, &rc.restrictions); err != nil {
+			return nil, err
+		}
+		rlist = append(rlist, rc)
+	}
+	if err = rows.Err(); err == nil && len(rlist) == 0 {
+		err = ErrMdbClassNotFound
+	}
+	return rlist, err
+}
+
+// InsertRestrictionClass
+// Transaction required
+func (mdb *MailDB) InsertRestrictionClass(name string, restrictions string) (*RestrictionClass, error) {
+	var (
+		n   string
+		r   string
+		res sql.Result
+		err error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if n, err = restrictClassName(name); err != nil {
+		return nil, err
+	}
+	if r, err = classRestrictions(restrictions); err != nil {
+		return nil, err
+	}
+	res, err = mdb.tx.Exec("INSERT INTO restrictionclass (name, restrictions) VALUES (?, ?)", n, r)
+	if err != nil {
+		if IsErrConstraintUnique(err) {
+			err = ErrMdbDupClass
+		}
+		return nil, err
+	}
+	rc := &RestrictionClass{
+		mdb:          mdb,
+		name:         n,
+		restrictions: r,
+	}
+	if rc.id, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// SetRestrictions
+// Transaction required
+func (rc *RestrictionClass) SetRestrictions(restrictions string) error {
+	var (
+		r   string
+		err error
+	)
+
+	if r, err = classRestrictions(restrictions); err != nil {
+		return err
+	}
+	if rc.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	res, err := rc.mdb.tx.Exec("UPDATE restrictionclass SET restrictions = ? WHERE id = ?", r, rc.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbClassNotFound
+	}
+	rc.restrictions = r
+	return nil
+}
+
+// DeleteRestrictionClass
+// An access rule can still name it, the check command finds those
+func (mdb *MailDB) DeleteRestrictionClass(name string) error {
+	res, err := mdb.db.Exec("DELETE FROM restrictionclass WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbClassNotFound
+	}
+	return nil
+}
+
+// UndefinedClasses
+// Return the access rules whose action is neither a builtin action nor
+// a defined restriction class. No transaction
+func (mdb *MailDB) UndefinedClasses() ([]*Access, error) {
+	var (
+		rows  *sql.Rows
+		alist []*Access
+		err   error
+	)
+
+	q := `
+SELECT a.id, a.name, a.action FROM access AS a
+ WHERE a.action NOT IN (SELECT name FROM restrictionclass)
+ ORDER BY a.name
+`
+	if rows, err = mdb.db.Query(q); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ac := &Access{
+			mdb: mdb,
+		}
+		if err = rows.Scan(&ac.id, &ac.name, &ac.action); err != nil {
+			return nil, err
+		}
+		if !IsBuiltinAction(ac.action) {
+			alist = append(alist, ac)
+		}
+	}
+	return alist, rows.Err()
+}
@@ -0,0 +1,167 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestRestrictionClass
+func TestRestrictionClass(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		dir   string
+		rc    *RestrictionClass
+		rlist []*RestrictionClass
+		alist []*Access
+	)
+
+	fmt.Printf("Restriction Class Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	for _, b := range []struct {
+		action  string
+		builtin bool
+	}{
+		{"OK", true},
+		{"REJECT no spam here", true},
+		{"550 5.7.1 go away", true},
+		{"defer_if_permit", true},
+		{"permit_mynetworks", true},
+		{"check_sender_access hash:/etc/postfix/senders", true},
+		{"strict", false},
+		{"x-dump", false},
+		{"999", false},
+		{"", false},
+	} {
+		if IsBuiltinAction(b.action) != b.builtin {
+			t.Errorf("IsBuiltinAction(%q): expected %v", b.action, b.builtin)
+		}
+	}
+
+	mdb.Begin()
+	_, err = mdb.InsertRestrictionClass("bad name", "permit")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Insert \"bad name\" should have failed")
+	} else if err != ErrMdbBadName {
+		t.Errorf("Insert \"bad name\", %s", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertRestrictionClass("empty", " , ")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Insert with no restrictions should have failed")
+	} else if err != ErrMdbClassNoRestrict {
+		t.Errorf("Insert with no restrictions, %s", err)
+	}
+
+	mdb.Begin()
+	_, err = mdb.InsertRestrictionClass("permissive", "permit")
+	if err == nil {
+		rc, err = mdb.InsertRestrictionClass("strict",
+			"reject_unknown_sender_domain,   reject_unverified_sender ,")
+	}
+	if err == nil {
+		_, err = mdb.InsertAccess("allow", "permissive")
+	}
+	if err == nil {
+		_, err = mdb.InsertAccess("block", "REJECT")
+	}
+	if err == nil {
+		_, err = mdb.InsertAccess("picky", "strict")
+	}
+	if err == nil {
+		_, err = mdb.InsertAccess("dump", "x-dump")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert classes and access rules, %s", err)
+		return
+	}
+	if rc.Export() != "strict = reject_unknown_sender_domain, reject_unverified_sender" {
+		t.Errorf("Insert strict: unexpected export, got %s", rc.Export())
+	}
+	mdb.Begin()
+	_, err = mdb.InsertRestrictionClass("strict", "permit")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Duplicate strict should have failed")
+	} else if err != ErrMdbDupClass {
+		t.Errorf("Duplicate strict, %s", err)
+	}
+
+	if alist, err = mdb.UndefinedClasses(); err != nil {
+		t.Errorf("UndefinedClasses, %s", err)
+	} else if len(alist) != 1 || alist[0].Name() != "dump" {
+		t.Errorf("UndefinedClasses: expected only dump, got %d", len(alist))
+	}
+
+	mdb.Begin()
+	rc, err = mdb.GetRestrictionClass("strict")
+	if err == nil {
+		err = rc.SetRestrictions("reject_unknown_sender_domain")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Set strict restrictions, %s", err)
+	}
+	if rc, err = mdb.LookupRestrictionClass("strict"); err != nil {
+		t.Errorf("Lookup strict, %s", err)
+	} else if rc.Restrictions() != "reject_unknown_sender_domain" {
+		t.Errorf("Lookup strict: unexpected restrictions, got %s", rc.Restrictions())
+	}
+	if rlist, err = mdb.FindRestrictionClass("*"); err != nil {
+		t.Errorf("Find *, %s", err)
+	} else if len(rlist) != 2 || rlist[0].Name() != "permissive" {
+		t.Errorf("Find *: expected permissive and strict, got %d", len(rlist))
+	}
+
+	// deleting a class in use leaves the rule for check to report
+	if err = mdb.DeleteRestrictionClass("strict"); err != nil {
+		t.Errorf("Delete strict, %s", err)
+	}
+	if err = mdb.DeleteRestrictionClass("strict"); err == nil {
+		t.Errorf("Delete strict again should have failed")
+	} else if err != ErrMdbClassNotFound {
+		t.Errorf("Delete strict again, %s", err)
+	}
+	if alist, err = mdb.UndefinedClasses(); err != nil {
+		t.Errorf("UndefinedClasses after delete, %s", err)
+	} else if len(alist) != 2 {
+		t.Errorf("UndefinedClasses after delete: expected 2, got %d", len(alist))
+	}
+}
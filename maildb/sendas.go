@@ -0,0 +1,199 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// SendAs
+// a sender address (or "@domain") and the SASL login allowed to use it
+type SendAs struct {
+	sender string
+	login  string
+}
+
+// Sender
+func (s *SendAs) Sender() string {
+	return s.sender
+}
+
+// Login
+func (s *SendAs) Login() string {
+	return s.login
+}
+
+// Export
+func (s *SendAs) Export() string {
+	var (
+		line strings.Builder
+	)
+
+	fmt.Fprintf(&line, "%s %s", s.sender, s.login)
+	return line.String()
+}
+
+// LookupSendAs
+// Return the logins that may use this sender. This includes the implied
+// ones, a mailbox's own address and its virtual aliases, as well as the
+// explicit grants. No transaction.
+func (mdb *MailDB) LookupSendAs(sender string) ([]*SendAs, error) {
+	var (
+		ap     *AddressParts
+		rows   *sql.Rows
+		slist  []*SendAs
+		err    error
+		rowCnt int
+	)
+
+	if ap, err = DecodeRFC822(sender); err != nil {
+		return nil, err
+	} else if ap.IsLocal() {
+		return nil, ErrMdbSendAsNoDomain
+	}
+	q := `SELECT sender, login FROM sender_login WHERE sender = ? ORDER BY login`
+	if rows, err = mdb.db.Query(q, ap.lpart+"@"+ap.domain); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := &SendAs{}
+		if err = rows.Scan(&s.sender, &s.login); err != nil {
+			return nil, err
+		}
+		slist = append(slist, s)
+		rowCnt++
+	}
+	if err = rows.Err(); err == nil && rowCnt == 0 {
+		err = ErrMdbSendAsNotFound
+	}
+	return slist, err
+}
+
+// InsertSendAs
+// Allow login, a mailbox, to use sender. The sender can be a full
+// address, which is created if needed, or "@domain" for an existing domain.
+// Transaction required
+func (mdb *MailDB) InsertSendAs(sender string, login string) (*SendAs, error) {
+	var (
+		ap    *AddressParts
+		a     *Address
+		d     *Domain
+		mb    *VMailbox
+		sa    sql.NullInt64
+		sd    sql.NullInt64
+		count int64
+		err   error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if ap, err = DecodeRFC822(sender); err != nil {
+		return nil, err
+	} else if ap.IsLocal() {
+		return nil, ErrMdbSendAsNoDomain
+	}
+	if mb, err = mdb.GetVMailbox(login); err != nil {
+		if err == ErrMdbAddressNotFound {
+			err = ErrMdbNotMbox
+		}
+		return nil, err
+	}
+	if ap.IsCatchall() {
+		if d, err = mdb.GetDomain(ap.domain); err != nil {
+			return nil, err
+		}
+		sd.Int64, sd.Valid = d.Id(), true
+	} else {
+		// any extension is dropped, the grant is for the whole address
+		if a, err = mdb.GetOrInsAddress(ap.lpart + "@" + ap.domain); err != nil {
+			return nil, err
+		}
+		sa.Int64, sa.Valid = a.Id(), true
+	}
+	qc := `
+SELECT count(*) FROM sendas WHERE address IS ? AND domain IS ? AND login = ?
+`
+	if err = mdb.tx.QueryRow(qc, sa, sd, mb.a.Id()).Scan(&count); err != nil {
+		return nil, err
+	} else if count > 0 {
+		return nil, ErrMdbDupSendAs
+	}
+	_, err = mdb.tx.Exec("INSERT INTO sendas (address, domain, login) VALUES (?, ?, ?)",
+		sa, sd, mb.a.Id())
+	if err != nil {
+		return nil, err
+	}
+	return &SendAs{
+		sender: ap.lpart + "@" + ap.domain,
+		login:  mb.a.Address(),
+	}, nil
+}
+
+// DeleteSendAs
+// Remove the grant for login to use sender. The implied ones cannot be
+// removed this way. No transaction
+func (mdb *MailDB) DeleteSendAs(sender string, login string) error {
+	var (
+		ap  *AddressParts
+		lp  *AddressParts
+		res sql.Result
+		c   int64
+		err error
+	)
+
+	if ap, err = DecodeRFC822(sender); err != nil {
+		return err
+	} else if ap.IsLocal() {
+		return ErrMdbSendAsNoDomain
+	}
+	if lp, err = DecodeRFC822(login); err != nil {
+		return err
+	}
+	qd := `
+DELETE FROM sendas WHERE login =
+  (SELECT a.id FROM address a, domain d
+     WHERE a.domain = d.id AND a.localpart = ? AND d.name = ?)
+`
+	if ap.IsCatchall() {
+		qd += `AND domain = (SELECT id FROM domain WHERE name = ?)`
+		res, err = mdb.db.Exec(qd, lp.lpart, lp.domain, ap.domain)
+	} else {
+		qd += `AND address =
+  (SELECT a.id FROM address a, domain d
+     WHERE a.domain = d.id AND a.localpart = ? AND d.name = ?)`
+		res, err = mdb.db.Exec(qd, lp.lpart, lp.domain, ap.lpart, ap.domain)
+	}
+	if err != nil {
+		return err
+	}
+	if c, err = res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbSendAsNotFound
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	//"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	//"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// checkSendAs
+// compare the logins for sender with what we expect
+func checkSendAs(mdb *MailDB, sender string, logins []string) error {
+	var (
+		err   error
+		slist []*SendAs
+	)
+
+	if slist, err = mdb.LookupSendAs(sender); err != nil {
+		return err
+	}
+	if len(slist) != len(logins) {
+		return fmt.Errorf("%s: expected %d logins, got %d", sender, len(logins), len(slist))
+	}
+	for i, s := range slist {
+		if s.Login() != logins[i] {
+			return fmt.Errorf("%s: expected login %s, got %s", sender, logins[i], s.Login())
+		}
+	}
+	return nil
+}
+
+// TestSendAs
+func TestSendAs(t *testing.T) {
+	var (
+		err error
+		mdb *MailDB
+		d   *Domain
+		dir string
+		s   *SendAs
+	)
+
+	fmt.Printf("Sender login Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	// a vmailbox domain with two users
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("leia@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+	if err = makeAlias(mdb, "jedi@skywalker", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("makeAlias of jedi@skywalker, %s", err)
+	}
+	if err = makeAlias(mdb, "@skywalker", []string{"leia@skywalker"}); err != nil {
+		t.Errorf("makeAlias of @skywalker, %s", err)
+	}
+
+	// the implied ones
+	if err = checkSendAs(mdb, "luke@skywalker", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("Implied luke@skywalker, %s", err)
+	}
+	if err = checkSendAs(mdb, "jedi+force@skywalker", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("Implied jedi@skywalker, %s", err)
+	}
+	// an alias of an alias still ends up at luke
+	if err = makeAlias(mdb, "padawan@skywalker", []string{"jedi@skywalker"}); err != nil {
+		t.Errorf("makeAlias of padawan@skywalker, %s", err)
+	}
+	if err = checkSendAs(mdb, "padawan@skywalker", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("Implied padawan@skywalker, %s", err)
+	}
+	// and so does the same address in an alias domain
+	mdb.Begin()
+	d, err = mdb.InsertDomain("tatooine")
+	if err == nil {
+		err = d.SetAliasOf("skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Alias domain tatooine, %s", err)
+	}
+	if err = checkSendAs(mdb, "luke@tatooine", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("Implied luke@tatooine, %s", err)
+	}
+	if err = checkSendAs(mdb, "padawan@tatooine", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("Implied padawan@tatooine, %s", err)
+	}
+	if _, err = mdb.LookupSendAs("@tatooine"); err != ErrMdbSendAsNotFound {
+		t.Errorf("Lookup of @tatooine, expected not found, got %v", err)
+	}
+
+	// nor is being on a list or owning it
+	mdb.Begin()
+	l, err := mdb.InsertList("council@skywalker", "luke@skywalker")
+	if err == nil {
+		err = l.AddMember("luke@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("InsertList council@skywalker, %s", err)
+	}
+	for _, sender := range []string{"council@skywalker", "owner-council@skywalker"} {
+		if _, err = mdb.LookupSendAs(sender); err != ErrMdbSendAsNotFound {
+			t.Errorf("Lookup of %s, expected not found, got %v", sender, err)
+		}
+	}
+	// and a catch-all is not a license to send as anybody
+	if _, err = mdb.LookupSendAs("@skywalker"); err == nil {
+		t.Errorf("Lookup of @skywalker should have failed")
+	} else if err != ErrMdbSendAsNotFound {
+		t.Errorf("Lookup of @skywalker, %s", err)
+	}
+	if _, err = mdb.LookupSendAs("luke"); err == nil {
+		t.Errorf("Lookup of luke should have failed")
+	} else if err != ErrMdbSendAsNoDomain {
+		t.Errorf("Lookup of luke, %s", err)
+	}
+
+	// grant some
+	mdb.Begin()
+	s, err = mdb.InsertSendAs("jedi@skywalker", "leia@skywalker")
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert jedi@skywalker for leia, %s", err)
+	} else if s.Export() != "jedi@skywalker leia@skywalker" {
+		t.Errorf("Insert jedi@skywalker for leia: expected \"jedi@skywalker leia@skywalker\", got %s",
+			s.Export())
+	}
+	if err = checkSendAs(mdb, "jedi@skywalker",
+		[]string{"leia@skywalker", "luke@skywalker"}); err != nil {
+		t.Errorf("Granted jedi@skywalker, %s", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertSendAs("jedi@skywalker", "leia@skywalker")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Duplicate jedi@skywalker for leia should have failed")
+	} else if err != ErrMdbDupSendAs {
+		t.Errorf("Duplicate jedi@skywalker for leia, %s", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertSendAs("rebel@alliance", "vader@skywalker")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("rebel@alliance for vader should have failed")
+	} else if err != ErrMdbNotMbox {
+		t.Errorf("rebel@alliance for vader, %s", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertSendAs("@alliance", "leia@skywalker")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("@alliance for leia should have failed")
+	} else if err != ErrMdbDomainNotFound {
+		t.Errorf("@alliance for leia, %s", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertSendAs("rebel@alliance", "leia@skywalker")
+	if err == nil {
+		_, err = mdb.InsertSendAs("@alliance", "leia@skywalker")
+	}
+	if err == nil {
+		_, err = mdb.InsertSendAs("@alliance", "luke@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Grants in alliance, %s", err)
+	}
+	if err = checkSendAs(mdb, "@alliance",
+		[]string{"leia@skywalker", "luke@skywalker"}); err != nil {
+		t.Errorf("Granted @alliance, %s", err)
+	}
+
+	// take them away
+	if err = mdb.DeleteSendAs("rebel@alliance", "luke@skywalker"); err == nil {
+		t.Errorf("Delete rebel@alliance for luke should have failed")
+	} else if err != ErrMdbSendAsNotFound {
+		t.Errorf("Delete rebel@alliance for luke, %s", err)
+	}
+	if err = mdb.DeleteSendAs("rebel@alliance", "leia@skywalker"); err != nil {
+		t.Errorf("Delete rebel@alliance for leia, %s", err)
+	}
+	// the address was only there for the grant
+	if _, err = mdb.LookupAddress("rebel@alliance"); err == nil {
+		t.Errorf("rebel@alliance should be gone")
+	} else if err != ErrMdbAddressNotFound {
+		t.Errorf("Lookup of rebel@alliance, %s", err)
+	}
+	// but the domain is still granted
+	if err = checkSendAs(mdb, "rebel@alliance", []string{}); err != ErrMdbSendAsNotFound {
+		t.Errorf("rebel@alliance after delete, %v", err)
+	}
+	if err = mdb.DeleteSendAs("@alliance", "luke@skywalker"); err != nil {
+		t.Errorf("Delete @alliance for luke, %s", err)
+	}
+	if err = checkSendAs(mdb, "@alliance", []string{"leia@skywalker"}); err != nil {
+		t.Errorf("@alliance after delete, %s", err)
+	}
+
+	// deleting a mailbox takes its grants with it
+	if err = mdb.RemoveAlias("@skywalker"); err != nil {
+		t.Errorf("RemoveAlias of @skywalker, %s", err)
+	}
+	if err = mdb.DeleteVMailbox("leia@skywalker"); err != nil {
+		t.Errorf("Delete of leia@skywalker, %s", err)
+	}
+	if _, err = mdb.LookupSendAs("@alliance"); err != ErrMdbSendAsNotFound {
+		t.Errorf("@alliance after leia deleted, %v", err)
+	}
+	if err = checkSendAs(mdb, "jedi@skywalker", []string{"luke@skywalker"}); err != nil {
+		t.Errorf("jedi@skywalker after leia deleted, %s", err)
+	}
+}
@@ -0,0 +1,459 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SendLimit
+// What a mailbox or each of the mailboxes of a domain may send
+type SendLimit struct {
+	mdb        *MailDB
+	id         int64
+	owner      string // user@domain or @domain
+	hourly     sql.NullInt64
+	recipients sql.NullInt64
+	daily      sql.NullInt64
+	action     string
+	disable    bool
+}
+
+// the limits of a mailbox
+const qLimitMbox = `
+SELECT l.id, um.username || '@' || um.domain, l.hourly, l.recipients, l.daily, l.action, l.disable
+  FROM sendlimit AS l JOIN user_mailbox AS um ON (um.id = l.mailbox)
+  WHERE um.username = ? AND um.domain = ?
+`
+
+// the limits of a domain
+const qLimitDomain = `
+SELECT l.id, '@' || d.name, l.hourly, l.recipients, l.daily, l.action, l.disable
+  FROM sendlimit AS l JOIN domain AS d ON (d.id = l.domain)
+  WHERE d.name = ?
+`
+
+// Owner
+func (l *SendLimit) Owner() string {
+	return l.owner
+}
+
+// IsDomain
+func (l *SendLimit) IsDomain() bool {
+	return strings.HasPrefix(l.owner, "@")
+}
+
+// limitString
+func limitString(n sql.NullInt64) string {
+	if n.Valid {
+		return fmt.Sprintf("%d", n.Int64)
+	}
+	return "--"
+}
+
+// Hourly
+// messages per hour
+func (l *SendLimit) Hourly() string {
+	return limitString(l.hourly)
+}
+
+// Recipients
+// recipients per message
+func (l *SendLimit) Recipients() string {
+	return limitString(l.recipients)
+}
+
+// Daily
+// recipients per day
+func (l *SendLimit) Daily() string {
+	return limitString(l.daily)
+}
+
+// Action
+// DEFER or REJECT
+func (l *SendLimit) Action() string {
+	return l.action
+}
+
+// Disables
+// is the mailbox disabled when it goes over?
+func (l *SendLimit) Disables() bool {
+	return l.disable
+}
+
+// limitQuery
+// The query and its args for the limits of owner, a mailbox or "@domain"
+func limitQuery(owner string) (string, []interface{}, error) {
+	var (
+		ap  *AddressParts
+		err error
+	)
+
+	if ap, err = DecodeRFC822(owner); err != nil {
+		return "", nil, err
+	}
+	if ap.IsCatchall() {
+		return qLimitDomain, []interface{}{ap.domain}, nil
+	}
+	return qLimitMbox, []interface{}{ap.lpart, ap.domain}, nil
+}
+
+// scanLimit
+func (mdb *MailDB) scanLimit(row *sql.Row) (*SendLimit, error) {
+	l := &SendLimit{
+		mdb: mdb,
+	}
+	switch err := row.Scan(&l.id, &l.owner, &l.hourly, &l.recipients, &l.daily,
+		&l.action, &l.disable); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbLimitNotFound
+	case nil:
+		return l, nil
+	default:
+		return nil, err
+	}
+}
+
+// LookupSendLimit
+// The limits of a mailbox or "@domain", no transaction
+func (mdb *MailDB) LookupSendLimit(owner string) (*SendLimit, error) {
+	q, args, err := limitQuery(owner)
+	if err != nil {
+		return nil, err
+	}
+	return mdb.scanLimit(mdb.db.QueryRow(q, args...))
+}
+
+// GetSendLimit
+// Same as LookupSendLimit but in the transaction
+func (mdb *MailDB) GetSendLimit(owner string) (*SendLimit, error) {
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	q, args, err := limitQuery(owner)
+	if err != nil {
+		return nil, err
+	}
+	return mdb.scanLimit(mdb.tx.QueryRow(q, args...))
+}
+
+// FindSendLimit
+// The limits of the mailboxes and "@domains" that match owner. A '*'
+// matches anything, "*" alone is all of them. No transaction
+func (mdb *MailDB) FindSendLimit(owner string) ([]*SendLimit, error) {
+	var (
+		rows  *sql.Rows
+		llist []*SendLimit
+		err   error
+	)
+
+	q := `
+SELECT l.id, '@' || d.name AS owner, l.hourly, l.recipients, l.daily, l.action, l.disable
+  FROM sendlimit AS l JOIN domain AS d ON (d.id = l.domain)
+UNION
+SELECT l.id, um.username || '@' || um.domain, l.hourly, l.recipients, l.daily, l.action, l.disable
+  FROM sendlimit AS l JOIN user_mailbox AS um ON (um.id = l.mailbox)
+`
+	q = "SELECT * FROM (" + q + ") WHERE owner LIKE ? ORDER BY owner"
+	if rows, err = mdb.db.Query(q, strings.ReplaceAll(owner, "*", "%")); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l := &SendLimit{
+			mdb: mdb,
+		}
+		if err = rows.Scan(&l.id, &l.owner, &l.hourly, &l.recipients, &l.daily,
+			&l.action, &l.disable); err != nil {
+			return nil, err
+		}
+		llist = append(llist, l)
+	}
+	if err = rows.Err(); err == nil && len(llist) == 0 {
+		err = ErrMdbLimitNotFound
+	}
+	return llist, err
+}
+
+// InsertSendLimit
+// Add limits, none set yet, to a mailbox or "@domain". Transaction required
+func (mdb *MailDB) InsertSendLimit(owner string) (*SendLimit, error) {
+	var (
+		ap   *AddressParts
+		d    *Domain
+		mb   *VMailbox
+		mbox sql.NullInt64
+		dom  sql.NullInt64
+		err  error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if ap, err = DecodeRFC822(owner); err != nil {
+		return nil, err
+	}
+	if ap.IsCatchall() {
+		if d, err = mdb.GetDomain(ap.domain); err != nil {
+			return nil, err
+		}
+		dom.Int64, dom.Valid = d.Id(), true
+	} else {
+		if mb, err = mdb.GetVMailbox(owner); err != nil {
+			if err == ErrMdbAddressNotFound {
+				err = ErrMdbNotMbox
+			}
+			return nil, err
+		}
+		mbox.Int64, mbox.Valid = mb.a.Id(), true
+	}
+	if _, err = mdb.tx.Exec("INSERT INTO sendlimit (mailbox, domain) VALUES (?, ?)", mbox, dom); err != nil {
+		if IsErrConstraintUnique(err) {
+			err = ErrMdbDupLimit
+		}
+		return nil, err
+	}
+	return mdb.GetSendLimit(owner)
+}
+
+// update
+// one column of the limits
+func (l *SendLimit) update(column string, value interface{}) error {
+	if l.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	res, err := l.mdb.tx.Exec("UPDATE sendlimit SET "+column+" = ? WHERE id = ?", value, l.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbLimitNotFound
+	}
+	return nil
+}
+
+// setLimit
+// zero or less is no limit
+func (l *SendLimit) setLimit(column string, n int64, limit *sql.NullInt64) error {
+	v := sql.NullInt64{Valid: n > 0, Int64: n}
+	if err := l.update(column, v); err != nil {
+		return err
+	}
+	*limit = v
+	return nil
+}
+
+// SetHourly
+func (l *SendLimit) SetHourly(n int64) error {
+	return l.setLimit("hourly", n, &l.hourly)
+}
+
+// SetRecipients
+func (l *SendLimit) SetRecipients(n int64) error {
+	return l.setLimit("recipients", n, &l.recipients)
+}
+
+// SetDaily
+func (l *SendLimit) SetDaily(n int64) error {
+	return l.setLimit("daily", n, &l.daily)
+}
+
+// SetAction
+// defer or reject
+func (l *SendLimit) SetAction(action string) error {
+	a := strings.ToUpper(action)
+	if a != "DEFER" && a != "REJECT" {
+		return ErrMdbLimitAction
+	}
+	if err := l.update("action", a); err != nil {
+		return err
+	}
+	l.action = a
+	return nil
+}
+
+// SetDisable
+func (l *SendLimit) SetDisable(disable bool) error {
+	if err := l.update("disable", disable); err != nil {
+		return err
+	}
+	l.disable = disable
+	return nil
+}
+
+// DeleteSendLimit
+// The counts of the mailbox are kept. Transaction required
+func (mdb *MailDB) DeleteSendLimit(owner string) error {
+	var (
+		l   *SendLimit
+		err error
+	)
+
+	if l, err = mdb.GetSendLimit(owner); err != nil {
+		return err
+	}
+	_, err = mdb.tx.Exec("DELETE FROM sendlimit WHERE id = ?", l.id)
+	return err
+}
+
+// SendCheck
+// What the policy server found for a message from a mailbox
+type SendCheck struct {
+	user   string
+	mbox   int64
+	limit  *SendLimit
+	reason string
+}
+
+// User
+func (sc *SendCheck) User() string {
+	return sc.user
+}
+
+// Limit
+// the limits that applied, nil if the mailbox has none
+func (sc *SendCheck) Limit() *SendLimit {
+	return sc.limit
+}
+
+// IsOver
+// the message goes over a limit
+func (sc *SendCheck) IsOver() bool {
+	return sc.reason != ""
+}
+
+// Reason
+// which limit the message goes over
+func (sc *SendCheck) Reason() string {
+	return sc.reason
+}
+
+// CheckSend
+// Check a message with rcpts recipients from the mailbox login at now
+// against its limits or those of its domain. Nothing is counted, that
+// is RecordSend. Transaction required
+func (mdb *MailDB) CheckSend(login string, rcpts int64, now time.Time) (*SendCheck, error) {
+	var (
+		ap   *AddressParts
+		err  error
+		sent int64
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if ap, err = DecodeRFC822(login); err != nil {
+		return nil, err
+	}
+	sc := &SendCheck{
+		user: ap.lpart + "@" + ap.domain,
+	}
+	q := `SELECT id FROM user_mailbox WHERE username = ? AND domain = ?`
+	switch err = mdb.tx.QueryRow(q, ap.lpart, ap.domain).Scan(&sc.mbox); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbNotMbox
+	case nil:
+	default:
+		return nil, err
+	}
+	if sc.limit, err = mdb.GetSendLimit(sc.user); err == ErrMdbLimitNotFound {
+		sc.limit, err = mdb.GetSendLimit("@" + ap.domain)
+	}
+	if err == ErrMdbLimitNotFound {
+		return sc, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	l := sc.limit
+	if l.recipients.Valid && rcpts > l.recipients.Int64 {
+		sc.reason = fmt.Sprintf("%d recipients per message", l.recipients.Int64)
+		return sc, nil
+	}
+	if l.hourly.Valid {
+		q = `SELECT count(*) FROM sendcount WHERE mailbox = ? AND sent > ?`
+		if err = mdb.tx.QueryRow(q, sc.mbox, now.Add(-time.Hour).Unix()).Scan(&sent); err != nil {
+			return nil, err
+		}
+		if sent+1 > l.hourly.Int64 {
+			sc.reason = fmt.Sprintf("%d messages per hour", l.hourly.Int64)
+			return sc, nil
+		}
+	}
+	if l.daily.Valid {
+		q = `SELECT COALESCE(sum(recipients), 0) FROM sendcount WHERE mailbox = ? AND sent > ?`
+		if err = mdb.tx.QueryRow(q, sc.mbox, now.Add(-24*time.Hour).Unix()).Scan(&sent); err != nil {
+			return nil, err
+		}
+		if sent+rcpts > l.daily.Int64 {
+			sc.reason = fmt.Sprintf("%d recipients per day", l.daily.Int64)
+			return sc, nil
+		}
+	}
+	return sc, nil
+}
+
+// RecordSend
+// Count the message that was checked. Counts older than a day are no
+// longer needed and are dropped. Transaction required
+func (mdb *MailDB) RecordSend(sc *SendCheck, rcpts int64, now time.Time) error {
+	var err error
+
+	if mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	q := `DELETE FROM sendcount WHERE mailbox = ? AND sent <= ?`
+	if _, err = mdb.tx.Exec(q, sc.mbox, now.Add(-24*time.Hour).Unix()); err != nil {
+		return err
+	}
+	q = `INSERT INTO sendcount (mailbox, sent, recipients) VALUES (?, ?, ?)`
+	_, err = mdb.tx.Exec(q, sc.mbox, now.Unix(), rcpts)
+	return err
+}
+
+// SendCounts
+// The messages and recipients the mailbox sent in the hour and the day
+// before now. No transaction
+func (mdb *MailDB) SendCounts(user string, now time.Time) (int64, int64, error) {
+	var (
+		ap            *AddressParts
+		hourly, daily int64
+		err           error
+	)
+
+	if ap, err = DecodeRFC822(user); err != nil {
+		return 0, 0, err
+	}
+	q := `
+SELECT COALESCE(sum(CASE WHEN sc.sent > ? THEN 1 ELSE 0 END), 0),
+       COALESCE(sum(CASE WHEN sc.sent > ? THEN sc.recipients ELSE 0 END), 0)
+  FROM sendcount AS sc JOIN user_mailbox AS um ON (um.id = sc.mailbox)
+  WHERE um.username = ? AND um.domain = ?
+`
+	err = mdb.db.QueryRow(q, now.Add(-time.Hour).Unix(), now.Add(-24*time.Hour).Unix(),
+		ap.lpart, ap.domain).Scan(&hourly, &daily)
+	return hourly, daily, err
+}
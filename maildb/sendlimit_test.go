@@ -0,0 +1,212 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestSendLimit
+func TestSendLimit(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		d     *Domain
+		dir   string
+		l     *SendLimit
+		llist []*SendLimit
+		sc    *SendCheck
+	)
+
+	fmt.Printf("SendLimit Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	for _, m := range []string{"luke@skywalker", "leia@skywalker", "han@skywalker"} {
+		if err == nil {
+			_, err = mdb.InsertVMailbox(m)
+		}
+	}
+	if err == nil {
+		_, err = mdb.InsertAddress("chewie@skywalker")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+
+	// limits for the domain and one of its mailboxes
+	mdb.Begin()
+	if l, err = mdb.InsertSendLimit("@skywalker"); err == nil {
+		err = l.SetHourly(2)
+	}
+	if err == nil {
+		err = l.SetDaily(10)
+	}
+	if err == nil {
+		l, err = mdb.InsertSendLimit("leia@skywalker")
+	}
+	if err == nil {
+		err = l.SetRecipients(3)
+	}
+	if err == nil {
+		err = l.SetAction("reject")
+	}
+	if err == nil {
+		err = l.SetDisable(true)
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert limits: %s", err)
+		return
+	}
+	mdb.Begin()
+	_, err = mdb.InsertSendLimit("@skywalker")
+	mdb.End(&err)
+	if err != ErrMdbDupLimit {
+		t.Errorf("Insert duplicate limit: expected ErrMdbDupLimit, got %v", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertSendLimit("chewie@skywalker")
+	mdb.End(&err)
+	if err != ErrMdbNotMbox {
+		t.Errorf("Insert limit for chewie: expected ErrMdbNotMbox, got %v", err)
+	}
+	mdb.Begin()
+	if l, err = mdb.GetSendLimit("luke@skywalker"); err == nil {
+		err = fmt.Errorf("found %s", l.Owner())
+	}
+	mdb.End(&err)
+	if err != ErrMdbLimitNotFound {
+		t.Errorf("Get limit for luke: expected ErrMdbLimitNotFound, got %v", err)
+	}
+	mdb.Begin()
+	if l, err = mdb.GetSendLimit("leia@skywalker"); err == nil {
+		err = l.SetAction("drop")
+	}
+	mdb.End(&err)
+	if err != ErrMdbLimitAction {
+		t.Errorf("Set action drop: expected ErrMdbLimitAction, got %v", err)
+	}
+	if llist, err = mdb.FindSendLimit("*"); err != nil {
+		t.Errorf("Find limits: %s", err)
+	} else if len(llist) != 2 {
+		t.Errorf("Find limits: expected 2, got %d", len(llist))
+	} else {
+		l = llist[0]
+		if l.Owner() != "@skywalker" || !l.IsDomain() || l.Hourly() != "2" ||
+			l.Recipients() != "--" || l.Daily() != "10" || l.Action() != "DEFER" || l.Disables() {
+			t.Errorf("Find limits: unexpected %s %s %s %s %s %v", l.Owner(), l.Hourly(),
+				l.Recipients(), l.Daily(), l.Action(), l.Disables())
+		}
+		l = llist[1]
+		if l.Owner() != "leia@skywalker" || l.IsDomain() || l.Hourly() != "--" ||
+			l.Recipients() != "3" || l.Action() != "REJECT" || !l.Disables() {
+			t.Errorf("Find limits: unexpected %s %s %s %s %s %v", l.Owner(), l.Hourly(),
+				l.Recipients(), l.Daily(), l.Action(), l.Disables())
+		}
+	}
+
+	// luke has the domain's limits, 2 an hour and 10 recipients a day
+	now := time.Date(2024, 5, 4, 12, 0, 0, 0, time.UTC)
+	send := func(user string, rcpts int64, at time.Time) string {
+		mdb.Begin()
+		defer mdb.End(&err)
+
+		if sc, err = mdb.CheckSend(user, rcpts, at); err != nil {
+			return err.Error()
+		}
+		if sc.IsOver() {
+			return sc.Reason()
+		}
+		err = mdb.RecordSend(sc, rcpts, at)
+		return ""
+	}
+	sends := []struct {
+		user   string
+		rcpts  int64
+		at     time.Duration
+		reason string
+	}{
+		{"luke@skywalker", 4, 0, ""},
+		{"luke@skywalker", 4, 10 * time.Minute, ""},
+		{"luke@skywalker", 1, 20 * time.Minute, "2 messages per hour"},
+		{"luke@skywalker", 3, 70 * time.Minute, "10 recipients per day"},
+		{"luke@skywalker", 2, 80 * time.Minute, ""},
+		{"luke@skywalker", 1, 24*time.Hour + 5*time.Minute, ""},
+		{"leia@skywalker", 4, 0, "3 recipients per message"},
+		{"leia@skywalker", 3, 0, ""},
+		{"leia@skywalker", 3, 0, ""},
+		{"leia@skywalker", 3, 0, ""},
+		{"chewie@skywalker", 1, 0, ErrMdbNotMbox.Error()},
+	}
+	for _, s := range sends {
+		if r := send(s.user, s.rcpts, now.Add(s.at)); r != s.reason {
+			t.Errorf("Send %s %d at %s: expected %q, got %q", s.user, s.rcpts, s.at, s.reason, r)
+		}
+	}
+	if hourly, daily, err := mdb.SendCounts("luke@skywalker", now.Add(24*time.Hour+10*time.Minute)); err != nil {
+		t.Errorf("SendCounts luke: %s", err)
+	} else if hourly != 1 || daily != 3 {
+		t.Errorf("SendCounts luke: expected 1 and 3, got %d and %d", hourly, daily)
+	}
+
+	// the limits go with the mailbox
+	mdb.Begin()
+	err = mdb.DeleteSendLimit("leia@skywalker")
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Delete limit for leia: %s", err)
+	}
+	mdb.Begin()
+	err = mdb.DeleteVMailbox("luke@skywalker")
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Delete luke: %s", err)
+	}
+	var c int64
+	if err = mdb.db.QueryRow("SELECT count(*) FROM sendcount").Scan(&c); err != nil || c != 3 {
+		t.Errorf("Counts after deleting luke: expected 3, got %d, %v", c, err)
+	}
+	if llist, err = mdb.FindSendLimit("*@skywalker"); err != nil || len(llist) != 1 {
+		t.Errorf("Find limits after delete: expected 1, got %d, %v", len(llist), err)
+	}
+}
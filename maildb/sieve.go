@@ -0,0 +1,372 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// SieveScript
+// A Sieve script of a mailbox or the before or after script of a domain
+type SieveScript struct {
+	mdb    *MailDB
+	id     int64
+	owner  string // user@domain or @domain
+	name   string
+	script string
+	active bool
+}
+
+// the scripts of a mailbox
+const qSieveMbox = `
+SELECT s.id, um.username || '@' || um.domain, s.name, s.script, s.active
+  FROM sievescript AS s JOIN user_mailbox AS um ON (um.id = s.mailbox)
+  WHERE um.username = ? AND um.domain = ? AND s.name LIKE ? ESCAPE '\'
+  ORDER BY s.name
+`
+
+// the scripts of a domain
+const qSieveDomain = `
+SELECT s.id, '@' || d.name, s.name, s.script, s.active
+  FROM sievescript AS s JOIN domain AS d ON (d.id = s.domain)
+  WHERE d.name = ? AND s.name LIKE ? ESCAPE '\'
+  ORDER BY s.name
+`
+
+// Owner
+func (s *SieveScript) Owner() string {
+	return s.owner
+}
+
+// Name
+func (s *SieveScript) Name() string {
+	return s.name
+}
+
+// Script
+func (s *SieveScript) Script() string {
+	return s.script
+}
+
+// IsActive
+// A domain script is always run so it is always active
+func (s *SieveScript) IsActive() bool {
+	return s.active || s.IsDomain()
+}
+
+// IsDomain
+func (s *SieveScript) IsDomain() bool {
+	return strings.HasPrefix(s.owner, "@")
+}
+
+// sieveName
+// A script name becomes a file name so it cannot have a '/' or start
+// with a '.'
+func sieveName(name string) error {
+	if name == "" || len(name) > 128 || name[0] == '.' || strings.Contains(name, "/") {
+		return ErrMdbSieveName
+	}
+	for _, c := range name {
+		if c < ' ' || c == 0x7f {
+			return ErrMdbSieveName
+		}
+	}
+	return nil
+}
+
+// sieveQuery
+// The query and its args for the scripts of owner, a mailbox or
+// "@domain". The name is escaped for LIKE and a '*' in it
+// matches anything if wild.
+func sieveQuery(owner string, name string, wild bool) (string, []interface{}, error) {
+	var (
+		ap  *AddressParts
+		err error
+	)
+
+	if ap, err = DecodeRFC822(owner); err != nil {
+		return "", nil, err
+	}
+	n := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(name)
+	if wild {
+		n = strings.ReplaceAll(n, "*", "%")
+	}
+	if ap.IsCatchall() {
+		return qSieveDomain, []interface{}{ap.domain, n}, nil
+	}
+	return qSieveMbox, []interface{}{ap.lpart, ap.domain, n}, nil
+}
+
+// scanSieve
+func (mdb *MailDB) scanSieve(rows *sql.Rows) ([]*SieveScript, error) {
+	var (
+		slist []*SieveScript
+		err   error
+	)
+
+	defer rows.Close()
+	for rows.Next() {
+		s := &SieveScript{
+			mdb: mdb,
+		}
+		if err = rows.Scan(&s.id, &s.owner, &s.name, &s.script, &s.active); err != nil {
+			return nil, err
+		}
+		slist = append(slist, s)
+	}
+	if err = rows.Err(); err == nil && len(slist) == 0 {
+		err = ErrMdbSieveNotFound
+	}
+	return slist, err
+}
+
+// LookupSieve
+// No transaction
+func (mdb *MailDB) LookupSieve(owner string, name string) (*SieveScript, error) {
+	var (
+		rows  *sql.Rows
+		slist []*SieveScript
+	)
+
+	q, args, err := sieveQuery(owner, name, false)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err = mdb.db.Query(q, args...); err != nil {
+		return nil, err
+	}
+	if slist, err = mdb.scanSieve(rows); err != nil {
+		return nil, err
+	}
+	return slist[0], nil
+}
+
+// GetSieve
+// Same as LookupSieve but in the transaction
+func (mdb *MailDB) GetSieve(owner string, name string) (*SieveScript, error) {
+	var (
+		rows  *sql.Rows
+		slist []*SieveScript
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	q, args, err := sieveQuery(owner, name, false)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err = mdb.tx.Query(q, args...); err != nil {
+		return nil, err
+	}
+	if slist, err = mdb.scanSieve(rows); err != nil {
+		return nil, err
+	}
+	return slist[0], nil
+}
+
+// FindSieve
+// The scripts of owner. A '*' in the name matches anything. No transaction
+func (mdb *MailDB) FindSieve(owner string, name string) ([]*SieveScript, error) {
+	var rows *sql.Rows
+
+	q, args, err := sieveQuery(owner, name, true)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err = mdb.db.Query(q, args...); err != nil {
+		return nil, err
+	}
+	return mdb.scanSieve(rows)
+}
+
+// SieveOwners
+// The mailboxes and "@domains" that have scripts. No transaction
+func (mdb *MailDB) SieveOwners() ([]string, error) {
+	var (
+		rows   *sql.Rows
+		owners []string
+		err    error
+	)
+
+	q := `
+SELECT DISTINCT '@' || d.name FROM sievescript AS s JOIN domain AS d ON (d.id = s.domain)
+UNION
+SELECT DISTINCT um.username || '@' || um.domain
+  FROM sievescript AS s JOIN user_mailbox AS um ON (um.id = s.mailbox)
+ORDER BY 1
+`
+	if rows, err = mdb.db.Query(q); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o string
+		if err = rows.Scan(&o); err != nil {
+			return nil, err
+		}
+		owners = append(owners, o)
+	}
+	return owners, rows.Err()
+}
+
+// InsertSieve
+// Add a script to a mailbox or, for "@domain", its before or after
+// script. The script must pass CheckSieve. Transaction required
+func (mdb *MailDB) InsertSieve(owner string, name string, script string) (*SieveScript, error) {
+	var (
+		ap   *AddressParts
+		d    *Domain
+		mb   *VMailbox
+		mbox sql.NullInt64
+		dom  sql.NullInt64
+		err  error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if err = sieveName(name); err != nil {
+		return nil, err
+	}
+	if ap, err = DecodeRFC822(owner); err != nil {
+		return nil, err
+	}
+	if ap.IsCatchall() {
+		if name != "before" && name != "after" {
+			return nil, ErrMdbSieveDomainName
+		}
+		if d, err = mdb.GetDomain(ap.domain); err != nil {
+			return nil, err
+		}
+		dom.Int64, dom.Valid = d.Id(), true
+	} else {
+		if mb, err = mdb.GetVMailbox(owner); err != nil {
+			if err == ErrMdbAddressNotFound {
+				err = ErrMdbNotMbox
+			}
+			return nil, err
+		}
+		mbox.Int64, mbox.Valid = mb.a.Id(), true
+	}
+	if err = CheckSieve(script); err != nil {
+		return nil, err
+	}
+	q := `INSERT INTO sievescript (mailbox, domain, name, script) VALUES (?, ?, ?, ?)`
+	if _, err = mdb.tx.Exec(q, mbox, dom, name, script); err != nil {
+		if IsErrConstraintUnique(err) {
+			err = ErrMdbDupSieve
+		}
+		return nil, err
+	}
+	return mdb.GetSieve(owner, name)
+}
+
+// SetScript
+// Replace the script. It must pass CheckSieve. Transaction required
+func (s *SieveScript) SetScript(script string) error {
+	if s.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if err := CheckSieve(script); err != nil {
+		return err
+	}
+	res, err := s.mdb.tx.Exec("UPDATE sievescript SET script = ? WHERE id = ?", script, s.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbSieveNotFound
+	}
+	s.script = script
+	return nil
+}
+
+// ActivateSieve
+// Make name the active script of the mailbox. An empty name leaves
+// none active. Transaction required
+func (mdb *MailDB) ActivateSieve(owner string, name string) error {
+	var (
+		ap  *AddressParts
+		mb  *VMailbox
+		s   *SieveScript
+		err error
+	)
+
+	if mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if ap, err = DecodeRFC822(owner); err != nil {
+		return err
+	} else if ap.IsCatchall() {
+		return ErrMdbSieveDomainActive
+	}
+	if mb, err = mdb.GetVMailbox(owner); err != nil {
+		if err == ErrMdbAddressNotFound {
+			err = ErrMdbNotMbox
+		}
+		return err
+	}
+	if name != "" {
+		if s, err = mdb.GetSieve(owner, name); err != nil {
+			return err
+		}
+	}
+	if _, err = mdb.tx.Exec("UPDATE sievescript SET active = 0 WHERE mailbox = ?", mb.a.Id()); err != nil {
+		return err
+	}
+	if s != nil {
+		_, err = mdb.tx.Exec("UPDATE sievescript SET active = 1 WHERE id = ?", s.id)
+	}
+	return err
+}
+
+// DeleteSieve
+// The active script of a mailbox must be deactivated first, the same as
+// for ManageSieve
+func (mdb *MailDB) DeleteSieve(owner string, name string) error {
+	var (
+		s   *SieveScript
+		err error
+	)
+
+	if s, err = mdb.LookupSieve(owner, name); err != nil {
+		return err
+	}
+	if s.active {
+		return ErrMdbSieveActive
+	}
+	res, err := mdb.db.Exec("DELETE FROM sievescript WHERE id = ?", s.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbSieveNotFound
+	}
+	return nil
+}
@@ -0,0 +1,545 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A subset of the RFC 5228 Sieve grammar, enough to catch the mistakes
+// that would otherwise only show up in the dovecot log when mail arrives.
+// The syntax is checked completely but only the names of the commands,
+// tests, tags and extensions are checked, not the arguments they take.
+
+// sieveCommands
+// the commands and the extension each needs, "" for the base language
+var sieveCommands = map[string]string{
+	"require":      "",
+	"if":           "",
+	"elsif":        "",
+	"else":         "",
+	"stop":         "",
+	"keep":         "",
+	"discard":      "",
+	"redirect":     "",
+	"fileinto":     "fileinto",
+	"reject":       "reject",
+	"ereject":      "ereject",
+	"vacation":     "vacation",
+	"setflag":      "imap4flags",
+	"addflag":      "imap4flags",
+	"removeflag":   "imap4flags",
+	"set":          "variables",
+	"addheader":    "editheader",
+	"deleteheader": "editheader",
+	"notify":       "enotify",
+	"include":      "include",
+	"return":       "include",
+	"global":       "include",
+}
+
+// sieveTests
+// the tests and the extension each needs
+var sieveTests = map[string]string{
+	"address":                  "",
+	"allof":                    "",
+	"anyof":                    "",
+	"exists":                   "",
+	"false":                    "",
+	"true":                     "",
+	"header":                   "",
+	"not":                      "",
+	"size":                     "",
+	"envelope":                 "envelope",
+	"body":                     "body",
+	"date":                     "date",
+	"currentdate":              "date",
+	"string":                   "variables",
+	"hasflag":                  "imap4flags",
+	"duplicate":                "duplicate",
+	"spamtest":                 "spamtest",
+	"virustest":                "virustest",
+	"mailboxexists":            "mailbox",
+	"environment":              "environment",
+	"valid_notify_method":      "enotify",
+	"notify_method_capability": "enotify",
+}
+
+// sieveTags
+// the tags that need an extension. Any other tag is allowed
+var sieveTags = map[string]string{
+	"copy":   "copy",
+	"value":  "relational",
+	"count":  "relational",
+	"regex":  "regex",
+	"create": "mailbox",
+	"flags":  "imap4flags",
+	"user":   "subaddress",
+	"detail": "subaddress",
+}
+
+// sieveExtensions
+// the capabilities a require can ask for. dovecot's own "vnd." ones are
+// allowed too
+var sieveExtensions = map[string]bool{
+	"fileinto": true, "reject": true, "ereject": true, "envelope": true,
+	"encoded-character": true, "vacation": true, "vacation-seconds": true,
+	"imap4flags": true, "variables": true, "editheader": true, "enotify": true,
+	"include": true, "body": true, "date": true, "relational": true,
+	"regex": true, "copy": true, "mailbox": true, "duplicate": true,
+	"spamtest": true, "spamtestplus": true, "virustest": true,
+	"subaddress": true, "environment": true, "mime": true,
+	"foreverypart": true, "extracttext": true, "imapsieve": true,
+	"comparator-i;octet": true, "comparator-i;ascii-casemap": true,
+	"comparator-i;ascii-numeric": true,
+}
+
+type sieveToken int
+
+const (
+	sieveEOF sieveToken = iota
+	sieveIdent
+	sieveTag
+	sieveNumber
+	sieveString
+	sievePunct
+)
+
+// sieveParser
+// the lexer state and what has been required so far
+type sieveParser struct {
+	src      string
+	pos      int
+	line     int
+	tok      sieveToken
+	text     string // the identifier, tag, punctuation or string value
+	tokLine  int
+	required map[string]bool
+	commands bool // a non-require command has been seen
+}
+
+// CheckSieve
+// Check the syntax of a Sieve script and that the extensions it uses
+// are required. The error has the line number of the problem.
+func CheckSieve(script string) error {
+	p := &sieveParser{
+		src:      script,
+		line:     1,
+		required: make(map[string]bool),
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.commandList(false); err != nil {
+		return err
+	}
+	if p.tok != sieveEOF {
+		return p.errorf("unexpected \"%s\"", p.text)
+	}
+	return nil
+}
+
+// errorf
+func (p *sieveParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("sieve line %d: %s", p.tokLine, fmt.Sprintf(format, args...))
+}
+
+// skip
+// white space and comments
+func (p *sieveParser) skip() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end == -1 {
+				p.tokLine = p.line
+				return p.errorf("unterminated comment")
+			}
+			p.line += strings.Count(p.src[p.pos:p.pos+2+end], "\n")
+			p.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// next
+// the next token
+func (p *sieveParser) next() error {
+	if err := p.skip(); err != nil {
+		return err
+	}
+	p.tokLine = p.line
+	if p.pos >= len(p.src) {
+		p.tok, p.text = sieveEOF, ""
+		return nil
+	}
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case isSieveAlpha(c):
+		for p.pos < len(p.src) && (isSieveAlpha(p.src[p.pos]) || isSieveDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok, p.text = sieveIdent, strings.ToLower(p.src[start:p.pos])
+		if p.text == "text" && p.pos < len(p.src) && p.src[p.pos] == ':' {
+			return p.multiLine()
+		}
+	case c == ':':
+		p.pos++
+		for p.pos < len(p.src) && (isSieveAlpha(p.src[p.pos]) || isSieveDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		if p.pos == start+1 {
+			return p.errorf("':' without a tag name")
+		}
+		p.tok, p.text = sieveTag, strings.ToLower(p.src[start+1:p.pos])
+	case isSieveDigit(c):
+		for p.pos < len(p.src) && isSieveDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		if p.pos < len(p.src) && strings.ContainsRune("KkMmGg", rune(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok, p.text = sieveNumber, p.src[start:p.pos]
+	case c == '"':
+		return p.quoted()
+	case strings.IndexByte(";,()[]{}", c) >= 0:
+		p.pos++
+		p.tok, p.text = sievePunct, string(c)
+	default:
+		return p.errorf("unexpected character '%c'", c)
+	}
+	return nil
+}
+
+// quoted
+// a "..." string. It can span lines, '\' quotes the next character
+func (p *sieveParser) quoted() error {
+	var s strings.Builder
+
+	p.pos++ // the opening '"'
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			p.tok, p.text = sieveString, s.String()
+			return nil
+		case '\\':
+			p.pos++
+			if p.pos >= len(p.src) {
+				break
+			}
+			c = p.src[p.pos]
+		case '\n':
+			p.line++
+		}
+		s.WriteByte(c)
+		p.pos++
+	}
+	return p.errorf("unterminated string")
+}
+
+// multiLine
+// "text:" to a line with only a '.'. A leading ".." is a '.'
+func (p *sieveParser) multiLine() error {
+	var s strings.Builder
+
+	p.pos++ // the ':'
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '#' {
+		for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != '\n' {
+		return p.errorf("\"text:\" must end its line")
+	}
+	p.pos++
+	p.line++
+	for p.pos < len(p.src) {
+		end := strings.IndexByte(p.src[p.pos:], '\n')
+		if end == -1 {
+			break
+		}
+		l := strings.TrimSuffix(p.src[p.pos:p.pos+end], "\r")
+		p.pos += end + 1
+		p.line++
+		if l == "." {
+			p.tok, p.text = sieveString, s.String()
+			return nil
+		}
+		if strings.HasPrefix(l, "..") {
+			l = l[1:]
+		}
+		s.WriteString(l + "\n")
+	}
+	return p.errorf("\"text:\" without a line with only '.' to end it")
+}
+
+// isSieveAlpha
+func isSieveAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+// isSieveDigit
+func isSieveDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// need
+// check an extension is required before it is used
+func (p *sieveParser) need(ext string, what string) error {
+	if ext != "" && !p.required[ext] {
+		return p.errorf("%s needs require \"%s\"", what, ext)
+	}
+	return nil
+}
+
+// commandList
+// commands up to the end of the script or of a block
+func (p *sieveParser) commandList(block bool) error {
+	prev := ""
+	for p.tok != sieveEOF && !(block && p.tok == sievePunct && p.text == "}") {
+		if p.tok != sieveIdent {
+			return p.errorf("expected a command, found \"%s\"", p.text)
+		}
+		name := p.text
+		ext, ok := sieveCommands[name]
+		if !ok {
+			return p.errorf("unknown command \"%s\"", name)
+		}
+		if err := p.need(ext, "command "+name); err != nil {
+			return err
+		}
+		switch name {
+		case "require":
+			if block || p.commands {
+				return p.errorf("require must come before any other command")
+			}
+		case "elsif", "else":
+			if prev != "if" && prev != "elsif" {
+				return p.errorf("%s without an if", name)
+			}
+			p.commands = true
+		default:
+			p.commands = true
+		}
+		if err := p.command(name); err != nil {
+			return err
+		}
+		prev = name
+	}
+	return nil
+}
+
+// command
+// the arguments and the ';' or the block
+func (p *sieveParser) command(name string) error {
+	var (
+		strs []string
+		err  error
+	)
+
+	line := p.tokLine
+	if err = p.next(); err != nil {
+		return err
+	}
+	if strs, err = p.arguments(); err != nil {
+		return err
+	}
+	hasTest := p.tok == sieveIdent || (p.tok == sievePunct && p.text == "(")
+	switch name {
+	case "if", "elsif":
+		if !hasTest {
+			return p.errorf("%s needs a test", name)
+		}
+		if err = p.test(); err != nil {
+			return err
+		}
+	default:
+		if hasTest {
+			return p.errorf("%s does not take a test", name)
+		}
+	}
+	if name == "require" {
+		if len(strs) == 0 {
+			p.tokLine = line
+			return p.errorf("require needs a capability")
+		}
+		for _, ext := range strs {
+			if !sieveExtensions[ext] && !strings.HasPrefix(ext, "vnd.") {
+				p.tokLine = line
+				return p.errorf("unknown extension \"%s\"", ext)
+			}
+			p.required[ext] = true
+		}
+	}
+	switch name {
+	case "if", "elsif", "else":
+		if p.tok != sievePunct || p.text != "{" {
+			return p.errorf("%s needs a block", name)
+		}
+		if err = p.next(); err != nil {
+			return err
+		}
+		if err = p.commandList(true); err != nil {
+			return err
+		}
+		if p.tok != sievePunct || p.text != "}" {
+			return p.errorf("missing '}'")
+		}
+		return p.next()
+	default:
+		if p.tok != sievePunct || p.text != ";" {
+			return p.errorf("missing ';' after %s", name)
+		}
+		return p.next()
+	}
+}
+
+// arguments
+// tags, numbers, strings and string lists. The strings are returned
+// for require
+func (p *sieveParser) arguments() ([]string, error) {
+	var strs []string
+
+	for {
+		switch {
+		case p.tok == sieveTag:
+			if err := p.need(sieveTags[p.text], "tag :"+p.text); err != nil {
+				return nil, err
+			}
+		case p.tok == sieveNumber:
+		case p.tok == sieveString:
+			strs = append(strs, p.text)
+		case p.tok == sievePunct && p.text == "[":
+			list, err := p.stringList()
+			if err != nil {
+				return nil, err
+			}
+			strs = append(strs, list...)
+			continue
+		default:
+			return strs, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// stringList
+// '[' string *(',' string) ']'
+func (p *sieveParser) stringList() ([]string, error) {
+	var strs []string
+
+	for {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok != sieveString {
+			return nil, p.errorf("expected a string in the list")
+		}
+		strs = append(strs, p.text)
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.tok == sievePunct && p.text == "]" {
+			return strs, p.next()
+		}
+		if p.tok != sievePunct || p.text != "," {
+			return nil, p.errorf("expected ',' or ']' in the string list")
+		}
+	}
+}
+
+// test
+// identifier arguments [test | test-list]
+func (p *sieveParser) test() error {
+	if p.tok == sievePunct && p.text == "(" {
+		return p.errorf("a test list must follow allof or anyof")
+	}
+	if p.tok != sieveIdent {
+		return p.errorf("expected a test")
+	}
+	name := p.text
+	ext, ok := sieveTests[name]
+	if !ok {
+		return p.errorf("unknown test \"%s\"", name)
+	}
+	if err := p.need(ext, "test "+name); err != nil {
+		return err
+	}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if _, err := p.arguments(); err != nil {
+		return err
+	}
+	switch name {
+	case "allof", "anyof":
+		return p.testList(name)
+	case "not":
+		return p.test()
+	}
+	return nil
+}
+
+// testList
+// '(' test *(',' test) ')'
+func (p *sieveParser) testList(name string) error {
+	if p.tok != sievePunct || p.text != "(" {
+		return p.errorf("%s needs a test list", name)
+	}
+	for {
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.test(); err != nil {
+			return err
+		}
+		if p.tok == sievePunct && p.text == ")" {
+			return p.next()
+		}
+		if p.tok != sievePunct || p.text != "," {
+			return p.errorf("expected ',' or ')' in the test list")
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestCheckSieve
+func TestCheckSieve(t *testing.T) {
+	fmt.Printf("Check Sieve Test\n")
+
+	goodScripts := []string{
+		"",
+		"keep;",
+		"# nothing but a comment\n",
+		`require ["fileinto", "imap4flags"];
+/* spam goes
+ * to its own folder */
+if header :contains "X-Spam-Flag" "YES" {
+	fileinto :flags "\\Seen" "Junk";
+	stop;
+} elsif anyof (address :is "from" "boss@example.com",
+               not size :under 10K) {
+	addflag "\\Flagged";
+} else {
+	keep;
+}
+`,
+		`require "vacation";
+vacation :days 3 :subject "Away" text:
+I am away.
+..with a leading dot
+.
+;
+`,
+		`if exists "X-Test" { redirect "luke@rebels.org"; }`,
+	}
+	for _, s := range goodScripts {
+		if err := CheckSieve(s); err != nil {
+			t.Errorf("Good script %q, %s", s, err)
+		}
+	}
+
+	badScripts := []struct {
+		script string
+		err    string
+	}{
+		{"keep", "sieve line 1: missing ';' after keep"},
+		{"frobnicate;", "sieve line 1: unknown command \"frobnicate\""},
+		{"fileinto \"Junk\";", "sieve line 1: command fileinto needs require \"fileinto\""},
+		{"keep;\nrequire \"fileinto\";", "sieve line 2: require must come before any other command"},
+		{"require \"teleport\";", "sieve line 1: unknown extension \"teleport\""},
+		{"else { keep; }", "sieve line 1: else without an if"},
+		{"if true keep;", "sieve line 1: if needs a block"},
+		{"if { keep; }", "sieve line 1: if needs a test"},
+		{"if frob { keep; }", "sieve line 1: unknown test \"frob\""},
+		{"if true {\nkeep;\n", "sieve line 3: missing '}'"},
+		{"keep;\n/* open", "sieve line 2: unterminated comment"},
+		{"redirect \"luke;", "sieve line 1: unterminated string"},
+		{"require \"vacation\";\nvacation text:\nno end\n", "sieve line 2: \"text:\" without a line with only '.' to end it"},
+	}
+	for _, b := range badScripts {
+		err := CheckSieve(b.script)
+		if err == nil {
+			t.Errorf("Bad script %q should have failed", b.script)
+		} else if err.Error() != b.err {
+			t.Errorf("Bad script %q, expected %s, got %s", b.script, b.err, err)
+		}
+	}
+}
+
+// TestSieveScript
+func TestSieveScript(t *testing.T) {
+	var (
+		err    error
+		mdb    *MailDB
+		d      *Domain
+		dir    string
+		s      *SieveScript
+		slist  []*SieveScript
+		owners []string
+		v      *Vacation
+	)
+
+	fmt.Printf("Sieve Script Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("leia@skywalker")
+	}
+	if err == nil {
+		_, err = mdb.InsertAddress("han@solo")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+
+	// bad ones first
+	for _, b := range []struct {
+		owner, name, script string
+		err                 error
+	}{
+		{"han@solo", "main", "keep;", ErrMdbNotMbox},
+		{"luke@skywalker", "", "keep;", ErrMdbSieveName},
+		{"luke@skywalker", "a/b", "keep;", ErrMdbSieveName},
+		{"@skywalker", "main", "keep;", ErrMdbSieveDomainName},
+		{"@tatooine", "before", "keep;", ErrMdbDomainNotFound},
+	} {
+		mdb.Begin()
+		_, err = mdb.InsertSieve(b.owner, b.name, b.script)
+		mdb.End(&err)
+		if err == nil {
+			t.Errorf("Insert %s %s should have failed", b.owner, b.name)
+		} else if err != b.err {
+			t.Errorf("Insert %s %s, %s", b.owner, b.name, err)
+		}
+	}
+	mdb.Begin()
+	_, err = mdb.InsertSieve("luke@skywalker", "main", "fileinto \"Junk\";")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Insert of bad script should have failed")
+	} else if !strings.Contains(err.Error(), "needs require") {
+		t.Errorf("Insert of bad script, %s", err)
+	}
+
+	// now some good ones
+	mdb.Begin()
+	s, err = mdb.InsertSieve("luke@skywalker", "main", "keep;\n")
+	if err == nil {
+		_, err = mdb.InsertSieve("luke@skywalker", "spam_filter", "discard;\n")
+	}
+	if err == nil {
+		_, err = mdb.InsertSieve("leia@skywalker", "main", "keep;\n")
+	}
+	if err == nil {
+		_, err = mdb.InsertSieve("@skywalker", "before", "keep;\n")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Insert of good scripts, %s", err)
+		return
+	}
+	if s.Owner() != "luke@skywalker" || s.Name() != "main" || s.Script() != "keep;\n" ||
+		s.IsActive() || s.IsDomain() {
+		t.Errorf("Insert of luke main: got %s %s %q %v %v",
+			s.Owner(), s.Name(), s.Script(), s.IsActive(), s.IsDomain())
+	}
+	mdb.Begin()
+	_, err = mdb.InsertSieve("luke@skywalker", "main", "stop;\n")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Duplicate insert should have failed")
+	} else if err != ErrMdbDupSieve {
+		t.Errorf("Duplicate insert, %s", err)
+	}
+
+	// the '_' must not be a LIKE wildcard
+	if _, err = mdb.LookupSieve("luke@skywalker", "spamXfilter"); err != ErrMdbSieveNotFound {
+		t.Errorf("Lookup of spamXfilter should not be found, %v", err)
+	}
+	if slist, err = mdb.FindSieve("luke@skywalker", "*"); err != nil {
+		t.Errorf("Find luke's scripts, %s", err)
+	} else if len(slist) != 2 || slist[0].Name() != "main" || slist[1].Name() != "spam_filter" {
+		t.Errorf("Find luke's scripts, got %d", len(slist))
+	}
+	if s, err = mdb.LookupSieve("@skywalker", "before"); err != nil {
+		t.Errorf("Lookup @skywalker before, %s", err)
+	} else if !s.IsDomain() || !s.IsActive() {
+		t.Errorf("Lookup @skywalker before: should be an active domain script")
+	}
+	if owners, err = mdb.SieveOwners(); err != nil {
+		t.Errorf("Sieve owners, %s", err)
+	} else if strings.Join(owners, " ") != "@skywalker leia@skywalker luke@skywalker" {
+		t.Errorf("Sieve owners, got %v", owners)
+	}
+
+	// activate and change
+	mdb.Begin()
+	err = mdb.ActivateSieve("luke@skywalker", "main")
+	if err == nil {
+		err = mdb.ActivateSieve("luke@skywalker", "spam_filter")
+	}
+	if err == nil {
+		s, err = mdb.GetSieve("luke@skywalker", "spam_filter")
+	}
+	if err == nil {
+		err = s.SetScript("require \"fileinto\";\nfileinto \"Junk\";\n")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Activate spam_filter, %s", err)
+	}
+	if slist, err = mdb.FindSieve("luke@skywalker", "*"); err != nil {
+		t.Errorf("Find luke's scripts, %s", err)
+	} else if slist[0].IsActive() || !slist[1].IsActive() {
+		t.Errorf("Only spam_filter should be active")
+	} else if slist[1].Script() != "require \"fileinto\";\nfileinto \"Junk\";\n" {
+		t.Errorf("spam_filter not changed, got %q", slist[1].Script())
+	}
+	mdb.Begin()
+	err = mdb.ActivateSieve("@skywalker", "before")
+	mdb.End(&err)
+	if err != ErrMdbSieveDomainActive {
+		t.Errorf("Activate domain script, expected error, got %v", err)
+	}
+	mdb.Begin()
+	if s, err = mdb.GetSieve("luke@skywalker", "main"); err == nil {
+		err = s.SetScript("frob;")
+	}
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Set of bad script should have failed")
+	}
+
+	// delete
+	if err = mdb.DeleteSieve("luke@skywalker", "spam_filter"); err != ErrMdbSieveActive {
+		t.Errorf("Delete of active script, expected error, got %v", err)
+	}
+	mdb.Begin()
+	err = mdb.ActivateSieve("luke@skywalker", "")
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Deactivate luke, %s", err)
+	}
+	if err = mdb.DeleteSieve("luke@skywalker", "spam_filter"); err != nil {
+		t.Errorf("Delete of spam_filter, %s", err)
+	}
+	if err = mdb.DeleteSieve("luke@skywalker", "spam_filter"); err != ErrMdbSieveNotFound {
+		t.Errorf("Delete of spam_filter again, expected error, got %v", err)
+	}
+
+	// the vacation script must pass our own check
+	mdb.Begin()
+	if v, err = mdb.InsertVacation("leia@skywalker", "Away.\n.\nBack soon"); err == nil {
+		err = v.SetDates("2030-01-01", "2030-01-10")
+	}
+	if err == nil {
+		err = v.SetSubject("Out \"of\" office")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Vacation for leia, %s", err)
+	} else if err = CheckSieve(v.Sieve()); err != nil {
+		t.Errorf("Vacation script for leia, %s", err)
+	}
+
+	// scripts go with their mailbox
+	if err = mdb.DeleteVMailbox("leia@skywalker"); err != nil {
+		t.Errorf("Delete leia, %s", err)
+	}
+	if _, err = mdb.FindSieve("leia@skywalker", "*"); err != ErrMdbSieveNotFound {
+		t.Errorf("Leia's scripts should be gone, got %v", err)
+	}
+}
@@ -1,12 +1,33 @@
 #! /usr/bin/bash
 
-go test -run=TestDecode
+go test -run=TestDecode$
+go test -run=TestDecodeCIDR
 go test -run=TestTarget
 go test -run=TestTransport
 go test -run=TestDBdefaults
-go test -run=TestAccess
+go test -run=TestAccess$
+go test -run=TestAccessCheck
 go test -run=Test_Transport
 go test -run=TestDomain
 go test -run=TestAddress
 go test -run=TestAliasOps
+go test -run=TestCatchall
+go test -run=TestSendAs
+go test -run=TestCanonical
+go test -run=TestRelocated
+go test -run=TestBcc
+go test -run=TestRestrictionClass
+go test -run=TestVacation
+go test -run=TestCheckSieve
+go test -run=TestSieveScript
+go test -run=TestDistList
+go test -run=TestExplain
+go test -run=TestConsistency
+go test -run=TestConfigFiles
+go test -run=TestRetryKeys
+go test -run=TestPostfixQuery
+go test -run=TestCrypt
+go test -run=TestAuthUser
+go test -run=TestSendLimit
+go test -run=TestMapEntries
 go test -run=TestMailbox
@@ -35,6 +35,17 @@ type Transport struct {
 	name      string
 	transport sql.NullString
 	nexthop   sql.NullString
+	saslUser  sql.NullString // the password stays in the database
+}
+
+// qtSasl
+// column for the SASL username, if any, in transport queries. There is
+// none without the credentials database.
+func (mdb *MailDB) qtSasl() string {
+	if !mdb.sasl {
+		return "NULL"
+	}
+	return "(SELECT username FROM saslpassword WHERE saslpassword.id = transport.id)"
 }
 
 // getTransportById
@@ -42,8 +53,8 @@ type Transport struct {
 func (mdb *MailDB) getTransportById(id int64) (*Transport, error) {
 	tr := &Transport{mdb: mdb, id: id}
 	row := mdb.db.QueryRow(
-		"SELECT name, transport, nexthop FROM transport WHERE id = ?", id)
-	switch err := row.Scan(&tr.name, &tr.transport, &tr.nexthop); err {
+		"SELECT name, transport, nexthop, "+mdb.qtSasl()+" FROM transport WHERE id = ?", id)
+	switch err := row.Scan(&tr.name, &tr.transport, &tr.nexthop, &tr.saslUser); err {
 	case sql.ErrNoRows:
 		return nil, ErrMdbTransNotFound
 	case nil:
@@ -58,8 +69,8 @@ func (mdb *MailDB) getTransportById(id int64) (*Transport, error) {
 func (mdb *MailDB) getTransportByIdTx(id int64) (*Transport, error) {
 	tr := &Transport{mdb: mdb, id: id}
 	row := mdb.tx.QueryRow(
-		"SELECT name, transport, nexthop FROM transport WHERE id = ?", id)
-	switch err := row.Scan(&tr.name, &tr.transport, &tr.nexthop); err {
+		"SELECT name, transport, nexthop, "+mdb.qtSasl()+" FROM transport WHERE id = ?", id)
+	switch err := row.Scan(&tr.name, &tr.transport, &tr.nexthop, &tr.saslUser); err {
 	case sql.ErrNoRows:
 		return nil, ErrMdbTransNotFound
 	case nil:
@@ -79,8 +90,8 @@ func (mdb *MailDB) LookupTransport(name string) (*Transport, error) {
 		name: name,
 		mdb:  mdb,
 	}
-	row := mdb.db.QueryRow("SELECT id, transport, nexthop FROM transport WHERE name = ?", name)
-	switch err := row.Scan(&tr.id, &tr.transport, &tr.nexthop); err {
+	row := mdb.db.QueryRow("SELECT id, transport, nexthop, "+mdb.qtSasl()+" FROM transport WHERE name = ?", name)
+	switch err := row.Scan(&tr.id, &tr.transport, &tr.nexthop, &tr.saslUser); err {
 	case sql.ErrNoRows:
 		return nil, ErrMdbTransNotFound
 	case nil:
@@ -102,11 +113,11 @@ func (mdb *MailDB) FindTransport(name string) ([]*Transport, error) {
 		q    string
 	)
 	if name == "*" {
-		q = `SELECT id, name, transport, nexthop FROM transport ORDER BY name`
+		q = `SELECT id, name, transport, nexthop, ` + mdb.qtSasl() + ` FROM transport ORDER BY name`
 		rows, err = mdb.db.Query(q)
 	} else {
 		name = strings.ReplaceAll(name, "*", "%")
-		q = `SELECT id, name, transport, nexthop FROM transport WHERE name LIKE ? ORDER BY name`
+		q = `SELECT id, name, transport, nexthop, ` + mdb.qtSasl() + ` FROM transport WHERE name LIKE ? ORDER BY name`
 		rows, err = mdb.db.Query(q, name)
 	}
 	if err != nil {
@@ -116,7 +127,7 @@ func (mdb *MailDB) FindTransport(name string) ([]*Transport, error) {
 		tr = &Transport{
 			mdb: mdb,
 		}
-		if err = rows.Scan(&tr.id, &tr.name, &tr.transport, &tr.nexthop); err != nil {
+		if err = rows.Scan(&tr.id, &tr.name, &tr.transport, &tr.nexthop, &tr.saslUser); err != nil {
 			break
 		}
 		tl = append(tl, tr)
@@ -150,8 +161,8 @@ func (mdb *MailDB) GetTransport(name string) (*Transport, error) {
 	if mdb.tx == nil {
 		return nil, ErrMdbTransaction
 	}
-	row := mdb.tx.QueryRow("SELECT id, transport, nexthop FROM transport WHERE name = ?", name)
-	switch err := row.Scan(&tr.id, &tr.transport, &tr.nexthop); err {
+	row := mdb.tx.QueryRow("SELECT id, transport, nexthop, "+mdb.qtSasl()+" FROM transport WHERE name = ?", name)
+	switch err := row.Scan(&tr.id, &tr.transport, &tr.nexthop, &tr.saslUser); err {
 	case sql.ErrNoRows:
 		return nil, ErrMdbTransNotFound
 	case nil:
@@ -214,6 +225,16 @@ func (tr *Transport) Nexthop() string {
 	}
 }
 
+// SaslUser
+// the SMTP client username for this transport's nexthop
+func (tr *Transport) SaslUser() string {
+	if tr.saslUser.Valid {
+		return tr.saslUser.String
+	} else {
+		return "--"
+	}
+}
+
 // Export
 func (tr *Transport) Export() string {
 	var (
@@ -296,17 +317,16 @@ func (tr *Transport) SetNexthop(hop string) error {
 		nexthop = sql.NullString{Valid: true, String: hop}
 	}
 	res, err := tr.mdb.tx.Exec("UPDATE transport SET nexthop = ? WHERE id = ?", nexthop, tr.id)
-	if err == nil {
-		c, err := res.RowsAffected()
-		if err == nil {
-			if c == 1 {
-				tr.nexthop = nexthop
-			} else {
-				err = ErrMdbTransNotFound
-			}
-		}
+	if err != nil {
+		return err
 	}
-	return err
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbTransNotFound
+	}
+	tr.nexthop = nexthop
+	return tr.saslNexthop()
 }
 
 // ClearNexthop
@@ -315,33 +335,121 @@ func (tr *Transport) ClearNexthop() error {
 		return ErrMdbTransaction
 	}
 	res, err := tr.mdb.tx.Exec("UPDATE transport SET nexthop = NULL WHERE id = ?", tr.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbTransNotFound
+	}
+	tr.nexthop = sql.NullString{Valid: false}
+	return tr.saslNexthop()
+}
+
+// saslNexthop
+// keep the credentials' copy of the nexthop the same as the transport's
+func (tr *Transport) saslNexthop() error {
+	if !tr.mdb.sasl {
+		return ErrMdbSaslNoDb
+	}
+	_, err := tr.mdb.tx.Exec("UPDATE saslpassword SET nexthop = ? WHERE id = ?",
+		tr.nexthop, tr.id)
+	return err
+}
+
+// SetSaslUser
+// set or replace the SMTP client username. A different username
+// drops the old password
+func (tr *Transport) SetSaslUser(user string) error {
+	if tr.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if user == "" {
+		return ErrMdbArgStringEmpty
+	}
+	if !tr.mdb.sasl {
+		return ErrMdbSaslNoDb
+	}
+	q := `
+INSERT INTO saslpassword (id, nexthop, username) VALUES (?, ?, ?)
+  ON CONFLICT(id) DO UPDATE SET username = excluded.username,
+    password = CASE WHEN username = excluded.username THEN password ELSE NULL END
+`
+	if _, err := tr.mdb.tx.Exec(q, tr.id, tr.nexthop, user); err != nil {
+		return err
+	}
+	tr.saslUser = sql.NullString{Valid: true, String: user}
+	return nil
+}
+
+// SetSaslPassword
+// the username must be set first
+func (tr *Transport) SetSaslPassword(pw string) error {
+	if tr.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if pw == "" {
+		return ErrMdbArgStringEmpty
+	}
+	if !tr.mdb.sasl {
+		return ErrMdbSaslNoDb
+	}
+	res, err := tr.mdb.tx.Exec("UPDATE saslpassword SET password = ? WHERE id = ?", pw, tr.id)
 	if err == nil {
 		c, err := res.RowsAffected()
-		if err == nil {
-			if c == 1 {
-				tr.nexthop = sql.NullString{Valid: false}
-			} else {
-				err = ErrMdbTransNotFound
-			}
+		if err == nil && c == 0 {
+			return ErrMdbSaslNoUser
 		}
 	}
 	return err
 }
 
+// ClearSasl
+// remove both username and password
+func (tr *Transport) ClearSasl() error {
+	if tr.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	if !tr.mdb.sasl {
+		return ErrMdbSaslNoDb
+	}
+	_, err := tr.mdb.tx.Exec("DELETE FROM saslpassword WHERE id = ?", tr.id)
+	if err == nil {
+		tr.saslUser = sql.NullString{Valid: false}
+	}
+	return err
+}
+
 // DeleteTransport
+// and its credentials along with it
 func (mdb *MailDB) DeleteTransport(name string) error {
-	res, err := mdb.db.Exec("DELETE FROM transport WHERE name = ?", name)
+	if !mdb.sasl {
+		return ErrMdbSaslNoDb
+	}
+	tx, err := mdb.db.Begin()
 	if err != nil {
-		if IsErrConstraintForeignKey(err) {
-			err = ErrMdbTransBusy
-		}
-	} else {
-		c, err := res.RowsAffected()
-		if err == nil {
-			if c == 0 {
-				return ErrMdbTransNotFound
+		return err
+	}
+	_, err = tx.Exec(`
+DELETE FROM saslpassword WHERE id IN (SELECT id FROM transport WHERE name = ?)`, name)
+	if err == nil {
+		var res sql.Result
+
+		res, err = tx.Exec("DELETE FROM transport WHERE name = ?", name)
+		if err != nil {
+			if IsErrConstraintForeignKey(err) {
+				err = ErrMdbTransBusy
 			}
+		} else if c, e := res.RowsAffected(); e != nil {
+			err = e
+		} else if c == 0 {
+			err = ErrMdbTransNotFound
 		}
 	}
-	return err
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
@@ -21,6 +21,7 @@ package maildb
  */
 
 import (
+	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -264,4 +265,186 @@ func Test_Transport(t *testing.T) {
 			}
 		}
 	}
+
+	// SASL credentials for the spam nexthop
+	var res []QueryRes
+	mdb.Begin()
+	if tr, err = mdb.GetTransport("spam"); err == nil {
+		if err = tr.SetSaslPassword("secret"); err != ErrMdbSaslNoUser {
+			t.Errorf("SetSaslPassword spam without user: expected no user error, got %v", err)
+		}
+		if err = tr.SetSaslUser("spammer"); err == nil {
+			err = tr.SetSaslPassword("secret")
+		}
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("SASL credentials for spam, unexpected error, %s", err)
+	}
+	if tr, err = mdb.LookupTransport("spam"); err != nil {
+		t.Errorf("LookupTransport spam, unexpected error, %s", err)
+	} else if tr.SaslUser() != "spammer" {
+		t.Errorf("LookupTransport spam: expected SASL user spammer, got %s", tr.SaslUser())
+	}
+	if res, err = mdb.Query("SELECT credentials FROM sasl_password WHERE nexthop = '/dev/null'"); err != nil {
+		t.Errorf("sasl_password query, unexpected error, %s", err)
+	} else if len(res) != 1 || res[0]["credentials"] != "spammer:secret" {
+		t.Errorf("sasl_password query: expected spammer:secret, got %v", res)
+	}
+	// the secret is only in the credentials database, which postfix
+	// reads by itself
+	saslPath := SaslDbPath(filepath.Join(dir, "test.db"))
+	if fi, err := os.Stat(saslPath); err != nil {
+		t.Errorf("Stat %s: %s", saslPath, err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("%s: expected mode 0600, got %o", saslPath, fi.Mode().Perm())
+	}
+	for _, f := range []struct {
+		path, query, expected string
+	}{
+		{filepath.Join(dir, "test.db"),
+			"SELECT count(*) FROM sqlite_master WHERE name LIKE '%sasl%'", "0"},
+		{saslPath,
+			"SELECT credentials FROM sasl_password WHERE nexthop = '/dev/null'", "spammer:secret"},
+	} {
+		var v string
+
+		db, err := sql.Open("sqlite3", f.path)
+		if err == nil {
+			err = db.QueryRow(f.query).Scan(&v)
+			db.Close()
+		}
+		if err != nil {
+			t.Errorf("%s: %s, unexpected error, %s", f.path, f.query, err)
+		} else if v != f.expected {
+			t.Errorf("%s: %s, expected %s, got %s", f.path, f.query, f.expected, v)
+		}
+	}
+
+	// a new user drops the old password so no credentials for postfix
+	mdb.Begin()
+	if tr, err = mdb.GetTransport("spam"); err == nil {
+		err = tr.SetSaslUser("ham")
+	}
+	mdb.End(&err)
+	if res, err = mdb.Query("SELECT credentials FROM sasl_password"); err != nil {
+		t.Errorf("sasl_password query, unexpected error, %s", err)
+	} else if len(res) != 0 {
+		t.Errorf("sasl_password query after user change: expected nothing, got %v", res)
+	}
+	mdb.Begin()
+	if tr, err = mdb.GetTransport("spam"); err == nil {
+		err = tr.ClearSasl()
+	}
+	mdb.End(&err)
+	if tr, err = mdb.LookupTransport("spam"); err != nil {
+		t.Errorf("LookupTransport spam, unexpected error, %s", err)
+	} else if tr.SaslUser() != "--" {
+		t.Errorf("LookupTransport spam: expected no SASL user, got %s", tr.SaslUser())
+	}
+
+	// the credentials follow the nexthop and go with the transport
+	mdb.Begin()
+	if tr, err = mdb.InsertTransport("provider"); err == nil {
+		err = tr.SetSaslUser("pobox")
+	}
+	if err == nil {
+		err = tr.SetSaslPassword("sekrit")
+	}
+	if err == nil {
+		err = tr.SetNexthop("[smtp.provider.net]:587")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("SASL credentials for provider, unexpected error, %s", err)
+	}
+	if res, err = mdb.Query("SELECT nexthop, credentials FROM sasl_password"); err != nil {
+		t.Errorf("sasl_password query, unexpected error, %s", err)
+	} else if len(res) != 1 || res[0]["nexthop"] != "[smtp.provider.net]:587" ||
+		res[0]["credentials"] != "pobox:sekrit" {
+		t.Errorf("sasl_password query for provider: got %v", res)
+	}
+	if err = mdb.DeleteTransport("provider"); err != nil {
+		t.Errorf("DeleteTransport provider, unexpected error, %s", err)
+	}
+	if res, err = mdb.Query("SELECT id FROM saslpassword"); err != nil {
+		t.Errorf("saslpassword query, unexpected error, %s", err)
+	} else if len(res) != 0 {
+		t.Errorf("saslpassword after DeleteTransport provider: expected nothing, got %v", res)
+	}
+}
+
+// Test_TransportNoSasl
+// a user that cannot read the credentials database, dovecot running
+// checkpassword, still sees the transports but cannot change credentials
+func Test_TransportNoSasl(t *testing.T) {
+	var (
+		err error
+		mdb *MailDB
+		tr  *Transport
+		tl  []*Transport
+	)
+
+	dir, err := ioutil.TempDir("", "TestDBLoad-*")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "test.db")
+	saslPath := SaslDbPath(dbPath)
+
+	// only create makes the credentials database
+	if mdb, err = NewMailDB(dbPath); err != nil {
+		t.Fatalf("NewMailDB, unexpected error, %s", err)
+	}
+	mdb.Close()
+	if _, err = os.Stat(saslPath); !os.IsNotExist(err) {
+		t.Errorf("NewMailDB: expected no %s, got %v", saslPath, err)
+	}
+	if mdb, err = makeTestDB(dbPath); err != nil {
+		t.Fatalf("Database load failed, %s", err)
+	}
+	mdb.Begin()
+	if tr, err = mdb.InsertTransport("relay"); err == nil {
+		if err = tr.SetNexthop("[smtp.provider.net]:587"); err == nil {
+			err = tr.SetSaslUser("pobox")
+		}
+	}
+	mdb.End(&err)
+	mdb.Close()
+	if err != nil {
+		t.Fatalf("Insert relay, unexpected error, %s", err)
+	}
+
+	if err = os.Remove(saslPath); err != nil {
+		t.Fatalf("Remove %s: %s", saslPath, err)
+	}
+	if mdb, err = NewMailDB(dbPath); err != nil {
+		t.Fatalf("NewMailDB without %s, unexpected error, %s", saslPath, err)
+	}
+	defer mdb.Close()
+	if tr, err = mdb.LookupTransport("relay"); err != nil {
+		t.Errorf("LookupTransport relay, unexpected error, %s", err)
+	} else if tr.SaslUser() != "--" {
+		t.Errorf("LookupTransport relay: expected no SASL user, got %s", tr.SaslUser())
+	}
+	if tl, err = mdb.FindTransport("*"); err != nil {
+		t.Errorf("FindTransport *, unexpected error, %s", err)
+	} else if len(tl) != 1 {
+		t.Errorf("FindTransport *, expected 1 entry, got %d", len(tl))
+	}
+	mdb.Begin()
+	if tr, err = mdb.GetTransport("relay"); err == nil {
+		err = tr.SetSaslUser("ham")
+	}
+	mdb.End(&err)
+	if err != ErrMdbSaslNoDb {
+		t.Errorf("SetSaslUser relay: expected no credentials database error, got %v", err)
+	}
+	if err = mdb.DeleteTransport("relay"); err != ErrMdbSaslNoDb {
+		t.Errorf("DeleteTransport relay: expected no credentials database error, got %v", err)
+	}
+	if _, err = os.Stat(saslPath); !os.IsNotExist(err) {
+		t.Errorf("after use: expected no %s, got %v", saslPath, err)
+	}
 }
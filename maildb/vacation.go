@@ -0,0 +1,490 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// Vacation
+// The out of office auto-reply settings of a mailbox
+type Vacation struct {
+	mdb       *MailDB
+	id        int64
+	user      string
+	subject   sql.NullString
+	body      string
+	startDate sql.NullString
+	endDate   sql.NullString
+	days      int64
+	addresses sql.NullString
+}
+
+// MailHome
+// Where dovecot keeps a mailbox and who owns it
+type MailHome struct {
+	user   string
+	lpart  string
+	domain string
+	home   string
+	uid    sql.NullInt64
+	gid    sql.NullInt64
+}
+
+// the date format of the Sieve date extension
+const vacationDate = "2006-01-02"
+
+// qVacation
+// join to user_mailbox for the user's address
+const qVacation = `
+SELECT v.id, v.subject, v.body, v.start_date, v.end_date, v.days, v.addresses
+  FROM vacation AS v JOIN user_mailbox AS um ON (um.id = v.id)
+  WHERE um.username = ? AND um.domain = ?
+`
+
+// User
+func (v *Vacation) User() string {
+	return v.user
+}
+
+// Subject
+func (v *Vacation) Subject() string {
+	if v.subject.Valid {
+		return v.subject.String
+	} else {
+		return "--"
+	}
+}
+
+// Body
+func (v *Vacation) Body() string {
+	return v.body
+}
+
+// Start
+func (v *Vacation) Start() string {
+	if v.startDate.Valid {
+		return v.startDate.String
+	} else {
+		return "--"
+	}
+}
+
+// End
+func (v *Vacation) End() string {
+	if v.endDate.Valid {
+		return v.endDate.String
+	} else {
+		return "--"
+	}
+}
+
+// Days
+func (v *Vacation) Days() int64 {
+	return v.days
+}
+
+// Addresses
+func (v *Vacation) Addresses() string {
+	if v.addresses.Valid {
+		return v.addresses.String
+	} else {
+		return "--"
+	}
+}
+
+// sieveQuote
+// a Sieve quoted string. Only '"' and '\' need escaping, line breaks
+// are allowed as is
+func sieveQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// Sieve
+// The Sieve script for this vacation. The dates are checked by the
+// script so it can be deployed ahead of time and left behind after.
+func (v *Vacation) Sieve() string {
+	var (
+		script strings.Builder
+		conds  []string
+	)
+
+	if v.startDate.Valid {
+		conds = append(conds, `currentdate :value "ge" "date" `+sieveQuote(v.startDate.String))
+	}
+	if v.endDate.Valid {
+		conds = append(conds, `currentdate :value "le" "date" `+sieveQuote(v.endDate.String))
+	}
+	fmt.Fprintf(&script, "# Generated by postdove for %s. Edits will be lost.\n", v.user)
+	if len(conds) > 0 {
+		fmt.Fprintf(&script, "require [\"vacation\", \"date\", \"relational\"];\n\n")
+	} else {
+		fmt.Fprintf(&script, "require [\"vacation\"];\n\n")
+	}
+	indent := ""
+	switch len(conds) {
+	case 1:
+		fmt.Fprintf(&script, "if %s {\n", conds[0])
+		indent = "  "
+	case 2:
+		fmt.Fprintf(&script, "if allof (%s,\n          %s) {\n", conds[0], conds[1])
+		indent = "  "
+	}
+	fmt.Fprintf(&script, "%svacation :days %d", indent, v.days)
+	if v.subject.Valid {
+		fmt.Fprintf(&script, " :subject %s", sieveQuote(v.subject.String))
+	}
+	if v.addresses.Valid {
+		var alist []string
+		for _, a := range strings.Split(v.addresses.String, ",") {
+			alist = append(alist, sieveQuote(strings.TrimSpace(a)))
+		}
+		fmt.Fprintf(&script, " :addresses [%s]", strings.Join(alist, ", "))
+	}
+	fmt.Fprintf(&script, "\n%s  %s;\n", indent, sieveQuote(v.body))
+	if indent != "" {
+		fmt.Fprintf(&script, "}\n")
+	}
+	return script.String()
+}
+
+// lookupVacation
+// common to Lookup and Get
+func (mdb *MailDB) lookupVacation(row *sql.Row, user string) (*Vacation, error) {
+	v := &Vacation{
+		mdb:  mdb,
+		user: user,
+	}
+	switch err := row.Scan(&v.id, &v.subject, &v.body, &v.startDate,
+		&v.endDate, &v.days, &v.addresses); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbVacationNotFound
+	case nil:
+		return v, nil
+	default:
+		return nil, err
+	}
+}
+
+// LookupVacation
+// No transaction
+func (mdb *MailDB) LookupVacation(user string) (*Vacation, error) {
+	var (
+		ap  *AddressParts
+		err error
+	)
+
+	if ap, err = DecodeRFC822(user); err != nil {
+		return nil, err
+	}
+	return mdb.lookupVacation(mdb.db.QueryRow(qVacation, ap.lpart, ap.domain),
+		ap.lpart+"@"+ap.domain)
+}
+
+// GetVacation
+// Same as LookupVacation but in the transaction
+func (mdb *MailDB) GetVacation(user string) (*Vacation, error) {
+	var (
+		ap  *AddressParts
+		err error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if ap, err = DecodeRFC822(user); err != nil {
+		return nil, err
+	}
+	return mdb.lookupVacation(mdb.tx.QueryRow(qVacation, ap.lpart, ap.domain),
+		ap.lpart+"@"+ap.domain)
+}
+
+// FindVacation
+// '*' matches anything in the user or the domain. No transaction
+func (mdb *MailDB) FindVacation(user string) ([]*Vacation, error) {
+	var (
+		rows  *sql.Rows
+		vlist []*Vacation
+		err   error
+	)
+
+	q := `
+SELECT v.id, um.username || '@' || um.domain, v.subject, v.body,
+       v.start_date, v.end_date, v.days, v.addresses
+  FROM vacation AS v JOIN user_mailbox AS um ON (um.id = v.id)
+  WHERE um.username || '@' || um.domain LIKE ?
+  ORDER BY um.domain, um.username
+`
+	if rows, err = mdb.db.Query(q, strings.ReplaceAll(user, "*", "%")); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := &Vacation{
+			mdb: mdb,
+		}
+		if err = rows.Scan(&v.id, &v.user, &v.subject, &v.body, &v.startDate,
+			&v.endDate, &v.days, &v.addresses); err != nil {
+			return nil, err
+		}
+		vlist = append(vlist, v)
+	}
+	if err = rows.Err(); err == nil && len(vlist) == 0 {
+		err = ErrMdbVacationNotFound
+	}
+	return vlist, err
+}
+
+// InsertVacation
+// Start a vacation for a mailbox with the default reply interval.
+// Transaction required
+func (mdb *MailDB) InsertVacation(user string, body string) (*Vacation, error) {
+	var (
+		mb  *VMailbox
+		err error
+	)
+
+	if mdb.tx == nil {
+		return nil, ErrMdbTransaction
+	}
+	if strings.TrimSpace(body) == "" {
+		return nil, ErrMdbVacationNoBody
+	}
+	if mb, err = mdb.GetVMailbox(user); err != nil {
+		if err == ErrMdbAddressNotFound {
+			err = ErrMdbNotMbox
+		}
+		return nil, err
+	}
+	// the mailbox id is the primary key, not a unique column
+	if _, err = mdb.GetVacation(mb.User()); err == nil {
+		return nil, ErrMdbDupVacation
+	} else if err != ErrMdbVacationNotFound {
+		return nil, err
+	}
+	if _, err = mdb.tx.Exec("INSERT INTO vacation (id, body) VALUES (?, ?)", mb.a.Id(), body); err != nil {
+		return nil, err
+	}
+	return mdb.GetVacation(mb.User())
+}
+
+// update
+// one column of the vacation
+func (v *Vacation) update(column string, value interface{}) error {
+	if v.mdb.tx == nil {
+		return ErrMdbTransaction
+	}
+	res, err := v.mdb.tx.Exec("UPDATE vacation SET "+column+" = ? WHERE id = ?", value, v.id)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return ErrMdbVacationNotFound
+	}
+	return nil
+}
+
+// SetSubject
+// An empty subject lets dovecot use "Auto: " and the original subject
+func (v *Vacation) SetSubject(subject string) error {
+	s := sql.NullString{Valid: subject != "", String: subject}
+	if err := v.update("subject", s); err != nil {
+		return err
+	}
+	v.subject = s
+	return nil
+}
+
+// SetBody
+func (v *Vacation) SetBody(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return ErrMdbVacationNoBody
+	}
+	if err := v.update("body", body); err != nil {
+		return err
+	}
+	v.body = body
+	return nil
+}
+
+// SetDates
+// Either date can be empty for no limit on that end
+func (v *Vacation) SetDates(start string, end string) error {
+	var (
+		s, e sql.NullString
+	)
+
+	for _, d := range []struct {
+		date string
+		col  *sql.NullString
+	}{{start, &s}, {end, &e}} {
+		if d.date == "" {
+			continue
+		}
+		t, err := time.Parse(vacationDate, d.date)
+		if err != nil {
+			return ErrMdbVacationDate
+		}
+		*d.col = sql.NullString{Valid: true, String: t.Format(vacationDate)}
+	}
+	if s.Valid && e.Valid && e.String < s.String {
+		return ErrMdbVacationDate
+	}
+	if err := v.update("start_date", s); err != nil {
+		return err
+	}
+	if err := v.update("end_date", e); err != nil {
+		return err
+	}
+	v.startDate, v.endDate = s, e
+	return nil
+}
+
+// SetDays
+// the minimum days between replies to the same sender
+func (v *Vacation) SetDays(days int64) error {
+	if days < 1 {
+		return ErrMdbVacationDays
+	}
+	if err := v.update("days", days); err != nil {
+		return err
+	}
+	v.days = days
+	return nil
+}
+
+// SetAddresses
+// The other addresses that deliver to this mailbox. Mail sent to one of
+// these is replied to as well as mail to the mailbox itself. Empty
+// clears the list.
+func (v *Vacation) SetAddresses(addresses string) error {
+	var (
+		ap    *AddressParts
+		alist []string
+		err   error
+	)
+
+	for _, a := range strings.FieldsFunc(addresses, func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\t'
+	}) {
+		if ap, err = DecodeRFC822(a); err != nil {
+			return err
+		} else if ap.IsLocal() || ap.IsCatchall() {
+			return ErrMdbVacationAddress
+		}
+		alist = append(alist, ap.String())
+	}
+	s := sql.NullString{Valid: len(alist) > 0, String: strings.Join(alist, ", ")}
+	if err = v.update("addresses", s); err != nil {
+		return err
+	}
+	v.addresses = s
+	return nil
+}
+
+// DeleteVacation
+// The mailbox is left alone
+func (mdb *MailDB) DeleteVacation(user string) error {
+	var (
+		ap  *AddressParts
+		err error
+	)
+
+	if ap, err = DecodeRFC822(user); err != nil {
+		return err
+	}
+	q := `
+DELETE FROM vacation WHERE id =
+  (SELECT id FROM user_mailbox WHERE username = ? AND domain = ?)
+`
+	res, err := mdb.db.Exec(q, ap.lpart, ap.domain)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return ErrMdbVacationNotFound
+	}
+	return nil
+}
+
+// LookupMailHome
+// The home, uid and gid dovecot uses for this mailbox with the domain
+// and localhost defaults filled in. No transaction
+func (mdb *MailDB) LookupMailHome(user string) (*MailHome, error) {
+	var (
+		ap  *AddressParts
+		err error
+	)
+
+	if ap, err = DecodeRFC822(user); err != nil {
+		return nil, err
+	}
+	mh := &MailHome{
+		user:   ap.lpart + "@" + ap.domain,
+		lpart:  ap.lpart,
+		domain: ap.domain,
+	}
+	q := `SELECT home, uid, gid FROM user_mailbox WHERE username = ? AND domain = ?`
+	switch err = mdb.db.QueryRow(q, ap.lpart, ap.domain).Scan(&mh.home, &mh.uid, &mh.gid); err {
+	case sql.ErrNoRows:
+		return nil, ErrMdbNotMbox
+	case nil:
+		return mh, nil
+	default:
+		return nil, err
+	}
+}
+
+// Path
+// The mailbox home directory. A mailbox without a home of its own uses
+// dovecot's mail_home which is passed in as mailHome. Its %u, %n and %d
+// are expanded.
+func (mh *MailHome) Path(mailHome string) string {
+	if mh.home != "" {
+		return mh.home
+	}
+	r := strings.NewReplacer("%u", mh.user, "%n", mh.lpart, "%d", mh.domain, "%%", "%")
+	return r.Replace(mailHome)
+}
+
+// Owner
+// The uid and gid of the mailbox files, -1 if not known which leaves
+// that one unchanged for os.Chown
+func (mh *MailHome) Owner() (int, int) {
+	uid, gid := -1, -1
+	if mh.uid.Valid {
+		uid = int(mh.uid.Int64)
+	}
+	if mh.gid.Valid {
+		gid = int(mh.gid.Int64)
+	}
+	return uid, gid
+}
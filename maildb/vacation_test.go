@@ -0,0 +1,204 @@
+package maildb
+
+/*
+ * Copyright (C) 2020, Jim Lieb <lieb@sea-troll.net>
+ *
+ * This program is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 3 of the License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ *
+ * -------------
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3" // do I really need this here?
+)
+
+// TestVacation
+func TestVacation(t *testing.T) {
+	var (
+		err   error
+		mdb   *MailDB
+		d     *Domain
+		mb    *VMailbox
+		dir   string
+		v     *Vacation
+		vlist []*Vacation
+		mh    *MailHome
+	)
+
+	fmt.Printf("Vacation Test\n")
+
+	dir, err = ioutil.TempDir("", "TestDBLoad-*")
+	defer os.RemoveAll(dir)
+	mdb, err = makeTestDB(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Errorf("Database load failed, %s", err)
+		return
+	}
+	defer mdb.Close()
+
+	mdb.Begin()
+	d, err = mdb.InsertDomain("skywalker")
+	if err == nil {
+		err = d.SetClass("vmailbox")
+	}
+	if err == nil {
+		_, err = mdb.InsertVMailbox("luke@skywalker")
+	}
+	if err == nil {
+		mb, err = mdb.InsertVMailbox("leia@skywalker")
+	}
+	if err == nil {
+		err = mb.SetHome("/home/leia")
+	}
+	if err == nil {
+		err = mb.SetUid(3000)
+	}
+	if err == nil {
+		_, err = mdb.InsertAddress("han@solo")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Setup of skywalker failed, %s", err)
+		return
+	}
+
+	mdb.Begin()
+	_, err = mdb.InsertVacation("han@solo", "Away")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Vacation for han@solo should have failed")
+	} else if err != ErrMdbNotMbox {
+		t.Errorf("Vacation for han@solo, %s", err)
+	}
+	mdb.Begin()
+	_, err = mdb.InsertVacation("luke@skywalker", " \n")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Vacation with no body should have failed")
+	} else if err != ErrMdbVacationNoBody {
+		t.Errorf("Vacation with no body, %s", err)
+	}
+
+	mdb.Begin()
+	v, err = mdb.InsertVacation("luke@skywalker", "Off to \"Dagobah\".\nBack soon.")
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Vacation for luke@skywalker, %s", err)
+		return
+	}
+	if v.Days() != 7 || v.Subject() != "--" || v.Start() != "--" {
+		t.Errorf("Vacation for luke@skywalker: unexpected defaults, %d, %s, %s",
+			v.Days(), v.Subject(), v.Start())
+	}
+	if v.Sieve() != `# Generated by postdove for luke@skywalker. Edits will be lost.
+require ["vacation"];
+
+vacation :days 7
+  "Off to \"Dagobah\".
+Back soon.";
+` {
+		t.Errorf("Vacation for luke@skywalker: unexpected script, got %s", v.Sieve())
+	}
+	mdb.Begin()
+	_, err = mdb.InsertVacation("luke@skywalker", "Again")
+	mdb.End(&err)
+	if err == nil {
+		t.Errorf("Second vacation for luke@skywalker should have failed")
+	} else if err != ErrMdbDupVacation {
+		t.Errorf("Second vacation for luke@skywalker, %s", err)
+	}
+
+	mdb.Begin()
+	v, err = mdb.GetVacation("luke@skywalker")
+	if err == nil {
+		err = v.SetDates("2026-10-15", "2026-10-01")
+		if err != ErrMdbVacationDate {
+			t.Errorf("End before start: expected date error, got %v", err)
+		}
+		err = v.SetDates("2026-10-01", "2026-10-15")
+	}
+	if err == nil {
+		err = v.SetSubject("Out of office")
+	}
+	if err == nil {
+		if err = v.SetDays(0); err != ErrMdbVacationDays {
+			t.Errorf("Zero days: expected days error, got %v", err)
+		}
+		err = v.SetDays(3)
+	}
+	if err == nil {
+		if err = v.SetAddresses("@skywalker"); err != ErrMdbVacationAddress {
+			t.Errorf("Address @skywalker: expected address error, got %v", err)
+		}
+		err = v.SetAddresses("luke@jedi.org,skywalker@rebels.org")
+	}
+	mdb.End(&err)
+	if err != nil {
+		t.Errorf("Update luke@skywalker vacation, %s", err)
+	}
+	if v, err = mdb.LookupVacation("luke@skywalker"); err != nil {
+		t.Errorf("Lookup luke@skywalker, %s", err)
+	} else if v.Sieve() != `# Generated by postdove for luke@skywalker. Edits will be lost.
+require ["vacation", "date", "relational"];
+
+if allof (currentdate :value "ge" "date" "2026-10-01",
+          currentdate :value "le" "date" "2026-10-15") {
+  vacation :days 3 :subject "Out of office" :addresses ["luke@jedi.org", "skywalker@rebels.org"]
+    "Off to \"Dagobah\".
+Back soon.";
+}
+` {
+		t.Errorf("Lookup luke@skywalker: unexpected script, got %s", v.Sieve())
+	}
+	if vlist, err = mdb.FindVacation("*@skywalker"); err != nil {
+		t.Errorf("Find *@skywalker, %s", err)
+	} else if len(vlist) != 1 || vlist[0].User() != "luke@skywalker" {
+		t.Errorf("Find *@skywalker: expected luke@skywalker, got %d", len(vlist))
+	}
+
+	// homes and owners
+	if mh, err = mdb.LookupMailHome("luke@skywalker"); err != nil {
+		t.Errorf("MailHome luke@skywalker, %s", err)
+	} else if mh.Path("/srv/%d/%n") != "/srv/skywalker/luke" {
+		t.Errorf("MailHome luke@skywalker: unexpected path %s", mh.Path("/srv/%d/%n"))
+	}
+	if mh, err = mdb.LookupMailHome("leia@skywalker"); err != nil {
+		t.Errorf("MailHome leia@skywalker, %s", err)
+	} else {
+		if mh.Path("/srv/%d/%n") != "/home/leia" {
+			t.Errorf("MailHome leia@skywalker: unexpected path %s", mh.Path("/srv/%d/%n"))
+		}
+		if uid, _ := mh.Owner(); uid != 3000 {
+			t.Errorf("MailHome leia@skywalker: expected uid 3000, got %d", uid)
+		}
+	}
+
+	// the vacation goes with the mailbox
+	if err = mdb.DeleteVacation("leia@skywalker"); err != ErrMdbVacationNotFound {
+		t.Errorf("Delete leia@skywalker: expected not found, got %v", err)
+	}
+	if err = mdb.DeleteVMailbox("luke@skywalker"); err != nil {
+		t.Errorf("Delete mailbox luke@skywalker, %s", err)
+	}
+	if _, err = mdb.LookupVacation("luke@skywalker"); err != ErrMdbVacationNotFound {
+		t.Errorf("Lookup luke@skywalker after mailbox delete: expected not found, got %v", err)
+	}
+}